@@ -1,15 +1,25 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // OutboundConfig 定义了单个代理节点的配置信息
 // 对应原项目 config.c 中 ParseNodeConfigToGlobal 解析的字段
 type OutboundConfig struct {
 	Tag        string `json:"tag"`
-	Type       string `json:"type"` // 协议类型: "mandala", "vless", "trojan", "shadowsocks", "socks"
+	Type       string `json:"type"` // 协议类型: "mandala", "vless", "vmess", "trojan", "shadowsocks", "socks", "tuic", "hysteria2"
 	Server     string `json:"server"`
 	ServerPort int    `json:"server_port"`
 
@@ -17,6 +27,11 @@ type OutboundConfig struct {
 	UUID     string `json:"uuid,omitempty"`     // VLESS/VMess 使用
 	Password string `json:"password,omitempty"` // Mandala/Trojan/Shadowsocks 使用
 	Username string `json:"username,omitempty"` // SOCKS5 使用
+	Flow     string `json:"flow,omitempty"`     // [新增] VLESS 流控: 为空或 "xtls-rprx-vision" (要求 TLS 1.3 / Reality)
+	// [新增] Shadowsocks 加密方法: aes-128-gcm / aes-256-gcm / chacha20-ietf-poly1305
+	// 为空或 "none" 时不加密 (仅依赖外层 TLS/WebSocket)
+	// VMess 复用该字段作为 security: auto(默认, aes-128-gcm) / chacha20-poly1305 / none
+	Method string `json:"method,omitempty"`
 
 	// 日志配置
 	LogPath string `json:"log_path,omitempty"` // 日志文件保存路径
@@ -25,12 +40,186 @@ type OutboundConfig struct {
 	Settings struct {
 		VpnMode  bool `json:"vpn_mode"`
 		Fragment bool `json:"fragment"` // TLS 分片开关
-		Noise    bool `json:"noise"`    // 随机填充开关
+		// [新增] TLS 分片参数，全部为 0 时保持默认行为 (仅首个记录切一刀)
+		FragmentOptions FragmentConfig `json:"fragment_options"`
+		Noise           bool           `json:"noise"` // 随机填充开关
+
+		// [新增] TCP 拨号超时 (毫秒，0 表示默认 5 秒)，高延迟移动网络或从休眠唤醒时可适当调大
+		DialTimeout int `json:"dial_timeout"`
+
+		// [新增] 连接节点失败 (TCP 建连被拒绝/超时) 时的重试次数 (0 表示不重试)
+		// 重试间隔从 dial_retry_backoff_ms (0 表示默认 200 毫秒) 开始逐次翻倍；握手与认证失败不重试
+		DialRetries        int `json:"dial_retries"`
+		DialRetryBackoffMs int `json:"dial_retry_backoff_ms"`
+
+		// [新增] 隧道保活间隔 (秒，0 表示默认 30 秒，负数表示关闭)
+		// 同时用于 TCP keepalive 与 WebSocket Ping，防止运营商 NAT 静默回收长时间空闲的连接
+		KeepAliveSec int `json:"keep_alive_sec"`
+
+		// [新增] 同一节点同时进行中的拨号/握手数量上限 (0 表示默认值)
+		DialConcurrency int `json:"dial_concurrency"`
+
+		// [新增] 本地入站抗探测：无效探测返回伪装的 HTTP 400 页面而非 SOCKS 响应
+		ProbeResistance bool `json:"probe_resistance"`
+
+		// [新增] 本地 SOCKS5 入站的用户名/密码认证 (RFC 1929)，均为空时无需认证
+		InboundUsername string `json:"inbound_username"`
+		InboundPassword string `json:"inbound_password"`
+
+		// [新增] 回复 SOCKS5 成功前等待服务端确认握手的宽限期 (毫秒，0 表示不等待)
+		ConnectGraceMs int `json:"connect_grace_ms"`
+
+		// [新增] 本地入站握手阶段的读超时 (毫秒，0 表示默认 5 秒，负数表示不限制)
+		// 客户端在此时间内未完成 SOCKS5 / HTTP 请求即断开，防止空闲的探测连接长期占用
+		HandshakeTimeoutMs int `json:"handshake_timeout_ms"`
+		// [新增] 本地入站转发阶段的空闲超时 (秒，0 表示不限制)，双向均无数据超过该时长即断开
+		IdleTimeoutSec int `json:"idle_timeout_sec"`
+		// [新增] 本地入站同时处理的最大连接数 (0 表示不限制)，达到上限后新连接在接受后立即关闭
+		MaxConnections int `json:"max_connections"`
+
+		// [新增] TUN 协议栈 TCP 转发器参数 (0 表示默认值)
+		// 接收窗口按连接分配内存：窗口越大吞吐越高，但并发连接多时内存占用也越高
+		TCPReceiveWindow int `json:"tcp_receive_window"` // 新连接的接收窗口 (字节)
+		TCPMaxInFlight   int `json:"tcp_max_in_flight"`  // 同时处理中的握手 (SYN) 数量，超出的 SYN 会被丢弃
+
+		// [新增] TUN 协议栈 TCP 连接的发送/接收缓冲区初始大小 (字节，0 表示 gVisor 默认 1MB)
+		// 默认缓冲区在高带宽高延迟的链路上会限制单连接吞吐，可按需调大；接收缓冲区仍会随吞吐自动增长
+		TCPSendBuffer int `json:"tcp_send_buffer"`
+		TCPRecvBuffer int `json:"tcp_recv_buffer"`
+		// [新增] TUN 协议栈的 TCP 拥塞控制算法: "reno" (默认) / "cubic"
+		TCPCongestionControl string `json:"tcp_congestion_control"`
+
+		// [新增] TUN 上 TCP 握手通告的 MSS 上限 (字节)，按隧道封装开销下调报文段大小
+		// 0 表示由 MTU 减去 IP/TCP 头与隧道开销自动计算，负数表示不限制
+		MSS int `json:"mss"`
+
+		// [新增] TUN 设备的 MTU (字节，0 表示默认 1500)，应与 Android 端为 TUN 配置的值一致
+		TunMTU int `json:"tun_mtu"`
+
+		// [新增] 网络栈一侧在 TUN 网段中的地址 (CIDR，如 "172.16.0.2/24"、"fdfe:dcba:9876::2/126")
+		// 应与 Android 端为 TUN 配置的地址同网段；为空时网络栈不持有地址，仅以混杂模式应答
+		TunIPv4 string `json:"tun_ipv4"`
+		TunIPv6 string `json:"tun_ipv6"`
+
+		// [新增] 各分帧层 (WebSocket 消息、UDP 数据报、DNS 响应) 允许的最大长度 (字节)
+		// 0 表示默认值；防止异常服务端通过超大长度字段造成大内存分配或挂起
+		MaxFrameSize int `json:"max_frame_size"`
+
+		// [新增] 节点域名定期重新解析的间隔 (秒，0 表示每次拨号时经系统 DNS 解析)
+		// 启用后新连接使用最新解析结果，已有连接保持原 IP 直到关闭；
+		// 配置了 tls.ech_doh_url 时经该 DoH 服务器 (及 ech_doh_bootstrap_ip) 解析，否则使用系统 DNS
+		ResolveIntervalSec int `json:"resolve_interval_sec"`
+
+		// [新增] 节点同时解析出 IPv4/IPv6 地址时优先尝试 IPv6 (Happy Eyeballs 的首选地址族)
+		PreferIPv6 bool `json:"prefer_ipv6"`
+
+		// [新增] 目标端口策略，格式如 "80,443,8000-9000"；拒绝列表优先
+		AllowedPorts string `json:"allowed_ports"`
+		BlockedPorts string `json:"blocked_ports"`
+
+		// [新增] 断网保护：当前节点被健康检查判定为不可用时，经代理的 TUN 连接直接丢弃 (不回复 RST)，
+		// 应用表现为等待而非回退到其他网络；直连路由不受影响
+		KillSwitch bool `json:"kill_switch"`
+
+		// [新增] 目标为 IP 的 TCP 连接 (TUN 与本地 SOCKS5 / HTTP CONNECT 入站) 从首包嗅探域名 (TLS SNI / 明文 HTTP 的 Host)，
+		// 使域名路由规则无需 FakeIP 也能生效。嗅探需先接受连接 (入站先回复成功) 并等待首包 (最多 300 毫秒)，
+		// 此类连接被拦截、断网保护生效或拨号失败时表现为建立后被关闭，而非拒绝握手
+		Sniff bool `json:"sniff"`
+
+		// [新增] 拒绝目标为私有、回环、链路本地及其他保留地址的连接 (先在本地解析域名再检查)，
+		// 作为公共代理运行时防止客户端借道访问本机或服务端所在的内部网络 (SSRF)；直连与经代理的路由均受限制
+		BlockPrivateIPs bool `json:"block_private_ips"`
+
+		// [新增] 经上游代理连接节点 (如企业网络的 HTTP 代理)，格式 "http://[user:pass@]host:port"、
+		// "https://..." 或 "socks5://..."；节点地址交由上游代理解析，TLS 与传输层在代理隧道内进行。
+		// 不适用于基于 QUIC 的节点 (TUIC / Hysteria2 / WebTransport)
+		UpstreamProxy string `json:"upstream_proxy"`
+
+		// [新增] 严格校验服务端响应头 (目前为 VLESS 的版本号)：不符时读取返回错误并断开，
+		// 避免继续按错位的数据流转发；关闭时仅记录警告 (兼容响应不规范的服务端)
+		StrictProtocol bool `json:"strict_protocol"`
+
+		// [新增] 按来源应用 UID 过滤 TUN 流量 (需由 Android 端设置 UID 查询回调)；拒绝列表优先
+		// 被过滤的连接按 uid_filter_action 处理: "block" (默认，丢弃) / "direct" (绕过代理直连)
+		AllowedUIDs     []int  `json:"allowed_uids"`
+		BlockedUIDs     []int  `json:"blocked_uids"`
+		UIDFilterAction string `json:"uid_filter_action"`
+
+		// [新增] SOCKS5 UDP 分片 (FRAG 非 0) 的重组超时 (毫秒，0 表示默认 5 秒，负数表示丢弃全部分片)
+		UDPFragmentTimeoutMs int `json:"udp_fragment_timeout_ms"`
+
+		// [新增] SOCKS5 UDP over TCP：UDP ASSOCIATE 不再绑定 UDP 端口，数据报在 TCP 控制连接上按
+		// [长度(2)][SOCKS5 地址][数据] 分帧传输 (用于封锁 UDP 的网络)；同时作用于本地入站与 socks 节点的 UDP 隧道，
+		// 两端须同时启用
+		UDPOverTCP bool `json:"udp_over_tcp"`
 	} `json:"settings"`
 
 	// 高级配置
 	TLS       *TLSConfig       `json:"tls,omitempty"`
 	Transport *TransportConfig `json:"transport,omitempty"`
+	Mux       *MuxConfig       `json:"mux,omitempty"`
+	DNS       *DNSConfig       `json:"dns,omitempty"`
+	TUIC      *TUICConfig      `json:"tuic,omitempty"`
+	Hysteria2 *Hysteria2Config `json:"hysteria2,omitempty"`
+	Routing   *RoutingConfig   `json:"routing,omitempty"`
+	UDP       *UDPConfig       `json:"udp,omitempty"`
+
+	// [新增] 备选节点：与顶层节点一起由健康检查择优使用，当前节点拨号连续失败时自动切换
+	// 备选节点沿用顶层的 settings、dns 与 routing，自身的这些字段不生效
+	Nodes       []OutboundConfig   `json:"nodes,omitempty"`
+	HealthCheck *HealthCheckConfig `json:"health_check,omitempty"`
+}
+
+// HealthCheckConfig 多节点健康检查参数 (0 表示默认值)
+type HealthCheckConfig struct {
+	// 检查间隔 (秒，默认 60)，负数表示不定期检查，仅在拨号失败时切换
+	IntervalSec int `json:"interval_sec,omitempty"`
+	// 单个节点测速超时 (毫秒，默认 5000)
+	TimeoutMs int `json:"timeout_ms,omitempty"`
+	// 连续拨号失败多少次后将节点标记为不可用 (默认 3)
+	MaxFailures int `json:"max_failures,omitempty"`
+	// 新节点延迟至少低出该值 (毫秒，默认 50) 才切换，避免在延迟相近的节点间来回切换
+	ToleranceMs int `json:"tolerance_ms,omitempty"`
+}
+
+// 健康检查默认参数
+const (
+	DefaultHealthCheckInterval  = 60 * time.Second
+	DefaultHealthCheckTimeout   = 5 * time.Second
+	DefaultHealthCheckFailures  = 3
+	DefaultHealthCheckTolerance = 50 * time.Millisecond
+)
+
+// Candidates 返回参与选择的全部节点：顶层节点 (配置了 server 时) 在前，其后为 nodes。
+// 备选节点为副本，settings、日志、dns 与 routing 取自顶层配置
+func (c *OutboundConfig) Candidates() []*OutboundConfig {
+	var nodes []*OutboundConfig
+	if c.Server != "" || len(c.Nodes) == 0 {
+		nodes = append(nodes, c)
+	}
+	for i := range c.Nodes {
+		node := c.Nodes[i]
+		node.Settings = c.Settings
+		node.LogPath = c.LogPath
+		node.DNS = c.DNS
+		node.Routing = c.Routing
+		node.Nodes = nil
+		node.HealthCheck = nil
+		nodes = append(nodes, &node)
+	}
+	return nodes
+}
+
+// FragmentConfig TLS 握手分片参数
+type FragmentConfig struct {
+	// 分片的握手记录数: 0 表示仅首个记录 (ClientHello)，-1 表示持续分片直到握手完成
+	Records int `json:"records"`
+	// 每个分片的长度范围 (字节)；MaxSize 为 0 时只在记录开头 5~14 字节处切一刀
+	MinSize int `json:"min_size"`
+	MaxSize int `json:"max_size"`
+	// 分片之间的等待时间范围 (毫秒)；均为 0 时使用 0~4ms
+	MinDelayMs int `json:"min_delay_ms"`
+	MaxDelayMs int `json:"max_delay_ms"`
 }
 
 // TLSConfig 定义 TLS 相关配置
@@ -38,20 +227,264 @@ type TLSConfig struct {
 	Enabled    bool   `json:"enabled"`
 	ServerName string `json:"server_name,omitempty"` // SNI
 	Insecure   bool   `json:"insecure,omitempty"`    // 是否跳过证书验证
+	// [新增] uTLS 指纹: chrome(默认)/firefox/safari/ios/edge/android/360/qq/randomized
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// [新增] TLS 会话复用：缓存服务端下发的会话票据，重连时以简化握手恢复会话
+	// 票据与 PSK 扩展本就是浏览器指纹的一部分，启用后 ClientHello 结构不变，仅扩展内容非空
+	EnableSessionResumption bool `json:"enable_session_resumption,omitempty"`
+	// [新增] 证书公钥固定：叶证书 SubjectPublicKeyInfo 的 SHA-256 (十六进制或 base64)
+	// 设置后只接受匹配其中之一的证书，不再校验 CA 链，同时忽略 insecure
+	PinnedSHA256 []string `json:"pinned_sha256,omitempty"`
+	// [新增] TLS ALPN，为空时沿用指纹模版 (gRPC / HTTP/2 传输默认只声明 h2)
+	ALPN []string `json:"alpn,omitempty"`
+	// [新增] 自定义根证书 (PEM 内容或文件路径)，设置后只信任其中的 CA，未设置时使用系统根证书
+	CACert string `json:"ca_cert,omitempty"`
 
 	// [新增] ECH 配置
 	// 注意：JSON tag 使用下划线风格以保持一致性
 	EnableECH     bool   `json:"enable_ech"`      // ECH 开关
 	ECHPublicName string `json:"ech_public_name"` // ECH 公示名称 (Public SNI)
-	ECHDoHURL     string `json:"ech_doh_url"`     // 用于查询 ECH 密钥的 DoH 地址
-	ECHConfig     []byte `json:"-"`               // 运行时存储解析到的密钥 (不参与 JSON 传输)
+	ECHDoHURL     string `json:"ech_doh_url"`     // 用于查询 ECH 密钥的 DoH 地址 (启用 resolve_interval_sec 时同时用于解析节点域名)
+	// [新增] DoH 服务器的引导 IP：设置后直接连接该地址，不经系统 DNS 解析 ech_doh_url 中的域名
+	ECHDoHBootstrapIP string `json:"ech_doh_bootstrap_ip"`
+	ECHTimeout        int    `json:"ech_timeout"` // DoH 查询超时 (毫秒，0 表示沿用拨号超时)
+	ECHConfig         []byte `json:"-"`           // 运行时存储解析到的密钥 (不参与 JSON 传输)
+
+	// [新增] Reality 配置，设置 public_key 后启用；SNI 与指纹沿用 server_name / fingerprint
+	Reality *RealityConfig `json:"reality,omitempty"`
+}
+
+// RealityConfig 定义 Reality 参数 (与服务端 privateKey / shortIds 对应)
+type RealityConfig struct {
+	PublicKey string `json:"public_key"`         // 服务端 x25519 公钥 (base64)
+	ShortID   string `json:"short_id,omitempty"` // 十六进制，最多 16 个字符
 }
 
 // TransportConfig 定义传输层配置 (如 WebSocket)
 type TransportConfig struct {
-	Type    string            `json:"type"` // "ws" 等
+	Type    string            `json:"type"` // "ws" / "grpc" / "http" (HTTP/2) / "webtransport" (HTTP/3)
 	Path    string            `json:"path,omitempty"`
 	Headers map[string]string `json:"headers,omitempty"`
+	// [新增] WebSocket 备选路径：非空时每条连接从中随机选用一个 (忽略 path)，
+	// 用于按路径负载均衡的 CDN 前置节点
+	Paths []string `json:"paths,omitempty"`
+	// [新增] 请求使用的 Host (为空时取 SNI，其次为节点地址)
+	// 可与 TLS 的 server_name 不同 (域前置：SNI 为前置域名，Host 为真实域名，需启用 TLS)
+	Host string `json:"host,omitempty"`
+	// [新增] WebSocket 早期数据 (0-RTT) 最大字节数，0 表示关闭
+	// 首包以 base64 形式放入升级请求的 Sec-WebSocket-Protocol 头，省去一次往返
+	// 也可在 path 中以 "?ed=2048" 的形式指定 (兼容常见分享链接)
+	EarlyDataSize int `json:"early_data_size,omitempty"`
+	// [新增] gRPC 服务名，请求路径为 /<ServiceName>/Tun (以 "/" 开头时视为完整路径)
+	ServiceName string `json:"service_name,omitempty"`
+}
+
+// 分帧长度默认上限
+const (
+	DefaultMaxPacketSize    = 64 * 1024   // UDP 数据报 / DNS 响应
+	DefaultMaxWSMessageSize = 1024 * 1024 // WebSocket 数据消息
+)
+
+// MaxPacketSize 返回 UDP 数据报与 DNS 响应的最大长度
+func (c *OutboundConfig) MaxPacketSize() int {
+	if c.Settings.MaxFrameSize > 0 {
+		return c.Settings.MaxFrameSize
+	}
+	return DefaultMaxPacketSize
+}
+
+// MaxWSMessageSize 返回 WebSocket 单条消息的最大长度
+func (c *OutboundConfig) MaxWSMessageSize() int {
+	if c.Settings.MaxFrameSize > 0 {
+		return c.Settings.MaxFrameSize
+	}
+	return DefaultMaxWSMessageSize
+}
+
+// 默认 TUN MTU
+const DefaultTunMTU = 1500
+
+// MTU 返回 TUN 设备的 MTU
+func (c *OutboundConfig) MTU() int {
+	if c.Settings.TunMTU > 0 {
+		return c.Settings.TunMTU
+	}
+	return DefaultTunMTU
+}
+
+// 默认拨号重试的首次等待时间
+const DefaultDialRetryBackoff = 200 * time.Millisecond
+
+// DialRetryBackoff 返回拨号重试的首次等待时间
+func (c *OutboundConfig) DialRetryBackoff() time.Duration {
+	if c.Settings.DialRetryBackoffMs > 0 {
+		return time.Duration(c.Settings.DialRetryBackoffMs) * time.Millisecond
+	}
+	return DefaultDialRetryBackoff
+}
+
+// 默认隧道保活间隔
+const DefaultKeepAlive = 30 * time.Second
+
+// KeepAliveInterval 返回隧道保活间隔，返回 0 表示关闭保活
+func (c *OutboundConfig) KeepAliveInterval() time.Duration {
+	if c.Settings.KeepAliveSec < 0 {
+		return 0
+	}
+	if c.Settings.KeepAliveSec > 0 {
+		return time.Duration(c.Settings.KeepAliveSec) * time.Second
+	}
+	return DefaultKeepAlive
+}
+
+// 默认 SOCKS5 UDP 分片重组超时 (RFC 1928 建议不少于 5 秒)
+const DefaultUDPFragmentTimeout = 5 * time.Second
+
+// UDPFragmentTimeout 返回 SOCKS5 UDP 分片重组超时，返回 0 表示不重组
+func (c *OutboundConfig) UDPFragmentTimeout() time.Duration {
+	if c.Settings.UDPFragmentTimeoutMs < 0 {
+		return 0
+	}
+	if c.Settings.UDPFragmentTimeoutMs > 0 {
+		return time.Duration(c.Settings.UDPFragmentTimeoutMs) * time.Millisecond
+	}
+	return DefaultUDPFragmentTimeout
+}
+
+// 默认本地入站握手超时
+const DefaultHandshakeTimeout = 5 * time.Second
+
+// HandshakeTimeout 返回本地入站握手阶段的读超时，返回 0 表示不限制
+func (c *OutboundConfig) HandshakeTimeout() time.Duration {
+	if c.Settings.HandshakeTimeoutMs < 0 {
+		return 0
+	}
+	if c.Settings.HandshakeTimeoutMs > 0 {
+		return time.Duration(c.Settings.HandshakeTimeoutMs) * time.Millisecond
+	}
+	return DefaultHandshakeTimeout
+}
+
+// IdleTimeout 返回本地入站转发阶段的空闲超时，返回 0 表示不限制
+func (c *OutboundConfig) IdleTimeout() time.Duration {
+	if c.Settings.IdleTimeoutSec <= 0 {
+		return 0
+	}
+	return time.Duration(c.Settings.IdleTimeoutSec) * time.Second
+}
+
+// MuxConfig 定义多路复用配置
+type MuxConfig struct {
+	Enabled bool `json:"enabled"`
+	// "trojan-go" (Trojan 默认) / "smux" (sing-mux 协议，其余协议默认)
+	Protocol    string `json:"protocol,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"` // 单个底层连接承载的最大流数量
+	// [新增] 同一节点最多保持的底层连接数，达到上限后新流分配到负载最低的连接 (0 表示不限)
+	MaxConnections int `json:"max_connections,omitempty"`
+}
+
+// UseTrojanGoMux 是否启用 Trojan-Go 兼容的多路复用 (smux over Trojan)
+func (c *OutboundConfig) UseTrojanGoMux() bool {
+	if c.Mux == nil || !c.Mux.Enabled || strings.ToLower(c.Type) != "trojan" {
+		return false
+	}
+	p := strings.ToLower(c.Mux.Protocol)
+	return p == "" || p == "trojan-go"
+}
+
+// UseSingMux 是否启用 sing-mux 多路复用 (smux，适用于所有协议)
+// 会话建立时外层协议握手的目标为 sing-mux 约定的特殊地址，每个流再携带实际目标
+func (c *OutboundConfig) UseSingMux() bool {
+	if c.Mux == nil || !c.Mux.Enabled || c.UsesQUIC() {
+		return false
+	}
+	switch strings.ToLower(c.Mux.Protocol) {
+	case "smux", "sing-mux":
+		return true
+	case "":
+		return strings.ToLower(c.Type) != "trojan"
+	}
+	return false
+}
+
+// [新增] UsesQUIC 是否为基于 QUIC 的协议：不经过 TCP 拨号与传输层，QUIC 自带多路复用
+func (c *OutboundConfig) UsesQUIC() bool {
+	switch strings.ToLower(c.Type) {
+	case "tuic", "hysteria2":
+		return true
+	}
+	return false
+}
+
+// TUICConfig 定义 TUIC v5 参数 (认证使用顶层的 uuid / password，SNI 与证书校验沿用 tls 配置)
+type TUICConfig struct {
+	// UDP 中继方式: "native" (QUIC 数据报，默认) / "quic" (每个数据报一个单向流，可靠但开销更大)
+	UDPRelayMode string `json:"udp_relay_mode,omitempty"`
+	// TLS ALPN，为空时使用 h3
+	ALPN []string `json:"alpn,omitempty"`
+}
+
+// Hysteria2Config 定义 Hysteria2 参数 (认证使用顶层的 password，SNI 与证书校验沿用 tls 配置)
+type Hysteria2Config struct {
+	// 下行带宽 (Mbps)，认证时告知服务端，服务端据此以固定速率发送 (Brutal)；0 表示由服务端自行探测。
+	// 上行始终使用 quic-go 自带的拥塞控制
+	DownMbps int `json:"down_mbps,omitempty"`
+}
+
+// UDPConfig 定义 TUN 模式下的 UDP 转发方式
+type UDPConfig struct {
+	// [新增] 全锥形 NAT：同一本地来源端点发往不同目标的数据报共用一条隧道，每个数据报携带目标地址，
+	// 服务端对外保持同一映射 (P2P、QUIC 连接迁移等场景需要)；任意对端的回包都会送回该端点。
+	// 仅 Trojan / Mandala / VLESS (XUDP) / SOCKS5 节点支持，其余协议、直连路由与 FakeIP 域名目标仍按目标分别建立隧道
+	FullCone bool `json:"full_cone,omitempty"`
+}
+
+// UDPFullCone 是否启用全锥形 NAT
+func (c *OutboundConfig) UDPFullCone() bool {
+	return c.UDP != nil && c.UDP.FullCone
+}
+
+// DNSConfig 定义 TUN 模式下的 DNS 设置
+type DNSConfig struct {
+	// 经隧道转发 DNS 查询的上游服务器 (host:port，省略端口时为 53)，为空时使用 8.8.8.8:53
+	RemoteServer string `json:"remote_server,omitempty"`
+
+	// [新增] FakeIP 模式：A/AAAA 查询在本地以保留网段中的地址应答，连接时再还原为域名交由服务端解析
+	FakeIP      bool   `json:"fake_ip,omitempty"`
+	FakeIPRange string `json:"fake_ip_range,omitempty"` // IPv4 CIDR，为空时使用 198.18.0.0/15
+
+	// [新增] 远程 DNS 应答缓存的最大条目数 (0 表示默认 1024，-1 表示关闭缓存)
+	CacheSize int `json:"cache_size,omitempty"`
+}
+
+// 默认远程 DNS 服务器与 FakeIP 网段
+const (
+	DefaultRemoteDNSHost = "8.8.8.8"
+	DefaultRemoteDNSPort = 53
+	DefaultFakeIPRange   = "198.18.0.0/15"
+)
+
+// RemoteDNS 返回经隧道转发 DNS 查询的上游地址
+// 未配置时返回默认值；配置无效时同样返回默认值，并附带错误供调用方记录
+func (c *OutboundConfig) RemoteDNS() (string, int, error) {
+	if c.DNS == nil || strings.TrimSpace(c.DNS.RemoteServer) == "" {
+		return DefaultRemoteDNSHost, DefaultRemoteDNSPort, nil
+	}
+	addr := strings.TrimSpace(c.DNS.RemoteServer)
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		// 未带端口 (含裸 IPv6 地址)
+		host, portStr = strings.Trim(addr, "[]"), strconv.Itoa(DefaultRemoteDNSPort)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return DefaultRemoteDNSHost, DefaultRemoteDNSPort, fmt.Errorf("invalid dns remote_server port: %q", addr)
+	}
+	if host == "" || (net.ParseIP(host) == nil && strings.ContainsAny(host, " /:")) {
+		return DefaultRemoteDNSHost, DefaultRemoteDNSPort, fmt.Errorf("invalid dns remote_server host: %q", addr)
+	}
+	return host, port, nil
 }
 
 // Config 是传递给核心启动函数的总配置结构
@@ -73,5 +506,248 @@ func ParseConfig(jsonStr string) (*OutboundConfig, error) {
 	if err != nil {
 		return nil, fmt.Errorf("config parse error: %v", err)
 	}
+	// [新增] 启动前校验，避免错误配置到拨号时才失败
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
+
+// Validate 检查节点配置是否完整；配置了 nodes 时逐个检查候选节点
+// 错误信息以字段名开头 (备选节点带 "nodes[i]." 前缀)，便于定位
+func (c *OutboundConfig) Validate() error {
+	if c.Settings.UpstreamProxy != "" {
+		if _, err := ParseUpstreamProxy(c.Settings.UpstreamProxy); err != nil {
+			return fmt.Errorf("settings.upstream_proxy: %v", err)
+		}
+	}
+	if c.Server != "" || len(c.Nodes) == 0 {
+		if err := c.validateNode(); err != nil {
+			return err
+		}
+	}
+	for i := range c.Nodes {
+		if err := c.Nodes[i].validateNode(); err != nil {
+			return fmt.Errorf("nodes[%d].%v", i, err)
+		}
+	}
+	return nil
+}
+
+// validateNode 检查单个节点的地址、协议与凭据
+func (c *OutboundConfig) validateNode() error {
+	if c.Server == "" {
+		return fmt.Errorf("server: required")
+	}
+	if c.ServerPort <= 0 || c.ServerPort > 65535 {
+		return fmt.Errorf("server_port: must be 1-65535, got %d", c.ServerPort)
+	}
+
+	typ := strings.ToLower(c.Type)
+	switch typ {
+	case "mandala", "trojan", "hysteria2":
+		if c.Password == "" {
+			return fmt.Errorf("password: required for %s", typ)
+		}
+	case "shadowsocks":
+		// method 为空或 none 时不加密，无需密码
+		if m := strings.ToLower(c.Method); m != "" && m != "none" && c.Password == "" {
+			return fmt.Errorf("password: required for shadowsocks method %s", m)
+		}
+	case "vless", "vmess":
+		if c.UUID == "" {
+			return fmt.Errorf("uuid: required for %s", typ)
+		}
+	case "tuic":
+		if c.UUID == "" {
+			return fmt.Errorf("uuid: required for tuic")
+		}
+		if c.Password == "" {
+			return fmt.Errorf("password: required for tuic")
+		}
+	case "socks", "socks5":
+	case "":
+		return fmt.Errorf("type: required")
+	default:
+		return fmt.Errorf("type: unknown protocol %q", c.Type)
+	}
+
+	if t := c.Transport; t != nil && (t.Type == "ws" || t.Type == "webtransport") {
+		if t.Path != "" && !strings.HasPrefix(t.Path, "/") {
+			return fmt.Errorf("transport.path: must start with \"/\", got %q", t.Path)
+		}
+		for i, p := range t.Paths {
+			if !strings.HasPrefix(p, "/") {
+				return fmt.Errorf("transport.paths[%d]: must start with \"/\", got %q", i, p)
+			}
+		}
+	}
+
+	// [新增] WebTransport 经 QUIC 使用标准 TLS 1.3 握手，无法伪装为 Reality
+	if t, tls := c.Transport, c.TLS; t != nil && t.Type == "webtransport" && tls != nil && tls.Reality != nil && tls.Reality.PublicKey != "" {
+		return fmt.Errorf("transport.type: webtransport does not support tls.reality")
+	}
+
+	if c.TLS != nil {
+		for i, pin := range c.TLS.PinnedSHA256 {
+			if _, err := ParsePinnedSHA256(pin); err != nil {
+				return fmt.Errorf("tls.pinned_sha256[%d]: %v", i, err)
+			}
+		}
+		if ip := c.TLS.ECHDoHBootstrapIP; ip != "" && net.ParseIP(ip) == nil {
+			return fmt.Errorf("tls.ech_doh_bootstrap_ip: invalid IP %q", ip)
+		}
+		if c.TLS.CACert != "" {
+			if _, err := LoadCACert(c.TLS.CACert); err != nil {
+				return fmt.Errorf("tls.ca_cert: %v", err)
+			}
+		}
+	}
+
+	// [新增] 域前置 (Host 与 SNI 不同) 依赖 TLS 隐藏真实 Host，明文时 SNI 不生效且 Host 直接暴露
+	if t, tls := c.Transport, c.TLS; t != nil && t.Host != "" && tls != nil && !tls.Enabled &&
+		tls.ServerName != "" && !strings.EqualFold(t.Host, tls.ServerName) {
+		return fmt.Errorf("transport.host: differs from tls.server_name (domain fronting) but tls is disabled")
+	}
+	return nil
+}
+
+// ParseUpstreamProxy 解析上游代理地址，scheme 为 http / https / socks5 (socks5h 视同 socks5)，未指定端口时使用默认端口
+func ParseUpstreamProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	defaultPort := ""
+	switch u.Scheme {
+	case "http":
+		defaultPort = "80"
+	case "https":
+		defaultPort = "443"
+	case "socks5", "socks5h":
+		u.Scheme = "socks5"
+		defaultPort = "1080"
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q (want http, https or socks5)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultPort)
+	} else if port, err := strconv.Atoi(u.Port()); err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %q", u.Port())
+	}
+	return u, nil
+}
+
+// ParsePinnedSHA256 解析一个证书公钥指纹
+// 支持十六进制 (可用 ":" 分隔，如 openssl 输出) 与 base64 (标准或 URL 编码，可带 "sha256/" 前缀，如 HPKP)
+func ParsePinnedSHA256(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if h := strings.ReplaceAll(s, ":", ""); len(h) == 2*sha256.Size {
+		if raw, err := hex.DecodeString(h); err == nil {
+			return raw, nil
+		}
+	}
+	b := strings.TrimPrefix(s, "sha256/")
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err := enc.DecodeString(b); err == nil && len(raw) == sha256.Size {
+			return raw, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid sha256 pin %q", s)
+}
+
+// LoadCACert 由 PEM 内容或 PEM 文件路径构建证书池
+func LoadCACert(s string) (*x509.CertPool, error) {
+	data := []byte(s)
+	if !strings.Contains(s, "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(s); err != nil {
+			return nil, err
+		}
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no valid PEM certificate found")
+	}
+	return pool, nil
+}
+
+// redactedValue 用于替换敏感字段
+const redactedValue = "<redacted>"
+
+// redactUpstreamProxy 隐去上游代理地址中的用户名与密码，无法解析时整体隐去
+func redactUpstreamProxy(v string) string {
+	if v == "" {
+		return ""
+	}
+	u, err := ParseUpstreamProxy(v)
+	if err != nil {
+		return redactedValue
+	}
+	if u.User == nil {
+		return u.String()
+	}
+	u.User = nil
+	// url.UserPassword 会转义尖括号，直接拼接占位符
+	return strings.Replace(u.String(), "://", "://"+redactedValue+"@", 1)
+}
+
+// Redacted 返回一份隐去凭据 (密码/UUID/用户名) 的配置副本，
+// 保留传输层和 TLS 设置，便于用户在问题反馈中安全地分享配置
+func (c *OutboundConfig) Redacted() *OutboundConfig {
+	out := *c
+
+	redact := func(v string) string {
+		if v == "" {
+			return ""
+		}
+		return redactedValue
+	}
+	out.UUID = redact(c.UUID)
+	out.Password = redact(c.Password)
+	out.Username = redact(c.Username)
+	out.Settings.InboundUsername = redact(c.Settings.InboundUsername)
+	out.Settings.InboundPassword = redact(c.Settings.InboundPassword)
+	out.Settings.UpstreamProxy = redactUpstreamProxy(c.Settings.UpstreamProxy)
+
+	if c.TLS != nil {
+		tlsCopy := *c.TLS
+		tlsCopy.ECHConfig = nil
+		if c.TLS.Reality != nil {
+			// shortId 相当于访问凭据
+			realityCopy := *c.TLS.Reality
+			realityCopy.ShortID = redact(realityCopy.ShortID)
+			tlsCopy.Reality = &realityCopy
+		}
+		out.TLS = &tlsCopy
+	}
+
+	if c.Transport != nil {
+		transportCopy := *c.Transport
+		if c.Transport.Headers != nil {
+			transportCopy.Headers = make(map[string]string, len(c.Transport.Headers))
+			for k, v := range c.Transport.Headers {
+				// 自定义头中可能携带令牌
+				switch strings.ToLower(k) {
+				case "authorization", "cookie", "proxy-authorization":
+					v = redact(v)
+				}
+				transportCopy.Headers[k] = v
+			}
+		}
+		out.Transport = &transportCopy
+	}
+
+	if c.Nodes != nil {
+		out.Nodes = make([]OutboundConfig, len(c.Nodes))
+		for i := range c.Nodes {
+			out.Nodes[i] = *c.Nodes[i].Redacted()
+		}
+	}
+
+	return &out
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"container/list"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIP 查询结果缓存容量
+const geoIPCacheSize = 4096
+
+// geoIPRecord 只解码国家代码，兼容 GeoLite2-Country / GeoIP2-Country 以及同结构的第三方数据库
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// 部分数据库 (如匿名代理、卫星网络) 只有注册国家
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+type geoIPEntry struct {
+	key     [16]byte
+	country string
+}
+
+// GeoIP MaxMind 格式 (mmdb) 的 IP 归属地数据库，查询结果按 LRU 缓存
+type GeoIP struct {
+	db *maxminddb.Reader
+
+	mu      sync.Mutex
+	lru     *list.List // 头部为最近使用
+	entries map[[16]byte]*list.Element
+}
+
+// OpenGeoIP 将数据库文件读入内存
+// 不使用内存映射：核心停止时仍可能有转发协程在查询，无需显式关闭即可安全释放
+func OpenGeoIP(path string) (*GeoIP, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIP{
+		db:      db,
+		lru:     list.New(),
+		entries: make(map[[16]byte]*list.Element),
+	}, nil
+}
+
+// Country 返回 IP 所属国家的小写 ISO 代码 (如 "cn")，未找到或数据库未加载时返回空字符串
+func (g *GeoIP) Country(ip net.IP) string {
+	if g == nil {
+		return ""
+	}
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return ""
+	}
+	var key [16]byte
+	copy(key[:], ip16)
+
+	g.mu.Lock()
+	if elem, ok := g.entries[key]; ok {
+		g.lru.MoveToFront(elem)
+		country := elem.Value.(*geoIPEntry).country
+		g.mu.Unlock()
+		return country
+	}
+	g.mu.Unlock()
+
+	var record geoIPRecord
+	country := ""
+	if err := g.db.Lookup(ip, &record); err == nil {
+		country = record.Country.ISOCode
+		if country == "" {
+			country = record.RegisteredCountry.ISOCode
+		}
+		country = strings.ToLower(country)
+	}
+
+	g.mu.Lock()
+	if _, ok := g.entries[key]; !ok {
+		g.entries[key] = g.lru.PushFront(&geoIPEntry{key: key, country: country})
+		if g.lru.Len() > geoIPCacheSize {
+			oldest := g.lru.Back()
+			g.lru.Remove(oldest)
+			delete(g.entries, oldest.Value.(*geoIPEntry).key)
+		}
+	}
+	g.mu.Unlock()
+	return country
+}
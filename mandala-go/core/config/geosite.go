@@ -0,0 +1,294 @@
+package config
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// geosite.dat (V2Ray / Xray 格式) 中的域名类型
+const (
+	geoSitePlain  = 0 // 关键字
+	geoSiteRegex  = 1 // 正则表达式
+	geoSiteDomain = 2 // 域名及其子域名
+	geoSiteFull   = 3 // 完整域名
+)
+
+// DomainMatcher 编译后的域名列表，支持完整匹配、后缀匹配 (按标签的字典树)、关键字与正则
+type DomainMatcher struct {
+	full     map[string]struct{}
+	suffix   *domainTrie
+	keywords []string
+	regexps  []*regexp.Regexp
+}
+
+// domainTrie 按域名标签从右向左建立的字典树，end 表示该节点对应的域名在列表中
+type domainTrie struct {
+	children map[string]*domainTrie
+	end      bool
+}
+
+func newDomainMatcher() *DomainMatcher {
+	return &DomainMatcher{
+		full:   make(map[string]struct{}),
+		suffix: &domainTrie{},
+	}
+}
+
+// AddFull 添加完整匹配的域名
+func (m *DomainMatcher) AddFull(domain string) {
+	m.full[normalizeDomain(domain)] = struct{}{}
+}
+
+// AddSuffix 添加域名后缀：匹配该域名本身及其所有子域名
+func (m *DomainMatcher) AddSuffix(domain string) {
+	node := m.suffix
+	labels := strings.Split(strings.TrimPrefix(normalizeDomain(domain), "."), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		if node.children == nil {
+			node.children = make(map[string]*domainTrie)
+		}
+		child, ok := node.children[labels[i]]
+		if !ok {
+			child = &domainTrie{}
+			node.children[labels[i]] = child
+		}
+		node = child
+	}
+	node.end = true
+}
+
+// AddKeyword 添加关键字：域名包含该字符串即匹配
+func (m *DomainMatcher) AddKeyword(keyword string) {
+	m.keywords = append(m.keywords, normalizeDomain(keyword))
+}
+
+// AddRegexp 添加正则表达式 (RE2 语法)
+func (m *DomainMatcher) AddRegexp(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	m.regexps = append(m.regexps, re)
+	return nil
+}
+
+// Match 判断域名是否在列表中，nil 匹配器不匹配任何域名
+func (m *DomainMatcher) Match(domain string) bool {
+	if m == nil {
+		return false
+	}
+	domain = normalizeDomain(domain)
+	if _, ok := m.full[domain]; ok {
+		return true
+	}
+
+	node := m.suffix
+	rest := domain
+	for node != nil && rest != "" {
+		label := rest
+		if i := strings.LastIndexByte(rest, '.'); i >= 0 {
+			label, rest = rest[i+1:], rest[:i]
+		} else {
+			rest = ""
+		}
+		if node = node.children[label]; node != nil && node.end {
+			return true
+		}
+	}
+
+	for _, k := range m.keywords {
+		if strings.Contains(domain, k) {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadGeoSite 读取 geosite.dat 并只编译 codes 中引用的分类
+// code 不区分大小写，可带属性过滤，如 "cn"、"category-ads-all"、"google@cn" (仅带 cn 属性的条目)。
+// 返回的映射以 codes 中的原始写法为键；文件中不存在的分类不出现在结果中
+func LoadGeoSite(path string, codes []string) (map[string]*DomainMatcher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// 分类名 -> 需要该分类的 code 列表 (同一分类可能以不同属性被引用)
+	wanted := make(map[string][]string)
+	for _, code := range codes {
+		name, _, _ := strings.Cut(strings.ToLower(code), "@")
+		wanted[name] = append(wanted[name], code)
+	}
+
+	result := make(map[string]*DomainMatcher)
+	// GeoSiteList { repeated GeoSite entry = 1; }
+	err = protoFields(data, func(field int, value []byte) error {
+		if field != 1 {
+			return nil
+		}
+		// 文件包含上千个分类，只解析被引用的分类
+		refs := wanted[strings.ToLower(geoSiteName(value))]
+		if len(refs) == 0 {
+			return nil
+		}
+		name, domains, err := parseGeoSite(value)
+		if err != nil {
+			return err
+		}
+		for _, code := range refs {
+			_, attr, _ := strings.Cut(strings.ToLower(code), "@")
+			m := newDomainMatcher()
+			for _, d := range domains {
+				if err := d.addTo(m, attr); err != nil {
+					return fmt.Errorf("geosite %s: %v", name, err)
+				}
+			}
+			result[code] = m
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// geoSiteEntry 一条域名规则 (未编译)
+type geoSiteEntry struct {
+	kind  uint64
+	value string
+	attrs []string
+}
+
+// addTo 将规则加入匹配器；attr 非空时只加入带有该属性的规则
+func (d *geoSiteEntry) addTo(m *DomainMatcher, attr string) error {
+	if attr != "" {
+		found := false
+		for _, a := range d.attrs {
+			if strings.EqualFold(a, attr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	switch d.kind {
+	case geoSitePlain:
+		m.AddKeyword(d.value)
+	case geoSiteRegex:
+		return m.AddRegexp(d.value)
+	case geoSiteDomain:
+		m.AddSuffix(d.value)
+	case geoSiteFull:
+		m.AddFull(d.value)
+	}
+	return nil
+}
+
+// geoSiteName 只读取 GeoSite 的分类名
+func geoSiteName(msg []byte) string {
+	var name string
+	protoFields(msg, func(field int, value []byte) error {
+		if field == 1 {
+			name = string(value)
+		}
+		return nil
+	})
+	return name
+}
+
+// parseGeoSite 解析 GeoSite { string country_code = 1; repeated Domain domain = 2; }
+func parseGeoSite(msg []byte) (string, []geoSiteEntry, error) {
+	var name string
+	var domains []geoSiteEntry
+	err := protoFields(msg, func(field int, value []byte) error {
+		switch field {
+		case 1:
+			name = string(value)
+		case 2:
+			// Domain { Type type = 1; string value = 2; repeated Attribute attribute = 3; }
+			var d geoSiteEntry
+			err := protoFields(value, func(field int, v []byte) error {
+				switch field {
+				case 1:
+					kind, n := binary.Uvarint(v)
+					if n <= 0 {
+						return errors.New("invalid domain type")
+					}
+					d.kind = kind
+				case 2:
+					d.value = string(v)
+				case 3:
+					// Attribute { string key = 1; ... }
+					return protoFields(v, func(field int, a []byte) error {
+						if field == 1 {
+							d.attrs = append(d.attrs, string(a))
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			domains = append(domains, d)
+		}
+		return nil
+	})
+	return name, domains, err
+}
+
+// protoFields 遍历 protobuf 消息的字段，value 为 varint 的原始编码或 length-delimited 的内容
+func protoFields(msg []byte, fn func(field int, value []byte) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("geosite: invalid protobuf tag")
+		}
+		msg = msg[n:]
+
+		var value []byte
+		switch tag & 0x7 {
+		case 0:
+			_, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return errors.New("geosite: invalid protobuf varint")
+			}
+			value, msg = msg[:n], msg[n:]
+		case 1:
+			if len(msg) < 8 {
+				return errors.New("geosite: truncated protobuf")
+			}
+			value, msg = msg[:8], msg[8:]
+		case 2:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return errors.New("geosite: invalid protobuf length")
+			}
+			value, msg = msg[n:n+int(l)], msg[n+int(l):]
+		case 5:
+			if len(msg) < 4 {
+				return errors.New("geosite: truncated protobuf")
+			}
+			value, msg = msg[:4], msg[4:]
+		default:
+			return fmt.Errorf("geosite: unsupported wire type %d", tag&0x7)
+		}
+		if err := fn(int(tag>>3), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// portRange 闭区间端口范围
+type portRange struct {
+	from, to int
+}
+
+// PortPolicy 目标端口的允许/拒绝列表
+// 拒绝列表优先；允许列表为空时表示允许所有未被拒绝的端口
+type PortPolicy struct {
+	allow []portRange
+	deny  []portRange
+}
+
+// ParsePortPolicy 解析形如 "80,443,8000-9000" 的端口列表
+// 两个列表均为空时返回 nil (不做限制)
+func ParsePortPolicy(allow, deny string) (*PortPolicy, error) {
+	if strings.TrimSpace(allow) == "" && strings.TrimSpace(deny) == "" {
+		return nil, nil
+	}
+
+	a, err := parsePortRanges(allow)
+	if err != nil {
+		return nil, fmt.Errorf("allowed_ports: %v", err)
+	}
+	d, err := parsePortRanges(deny)
+	if err != nil {
+		return nil, fmt.Errorf("blocked_ports: %v", err)
+	}
+	return &PortPolicy{allow: a, deny: d}, nil
+}
+
+func parsePortRanges(spec string) ([]portRange, error) {
+	var ranges []portRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := parsePort(lo)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			if to, err = parsePort(hi); err != nil {
+				return nil, err
+			}
+			if to < from {
+				return nil, fmt.Errorf("invalid port range: %s", part)
+			}
+		}
+		ranges = append(ranges, portRange{from: from, to: to})
+	}
+	return ranges, nil
+}
+
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || p < 0 || p > 65535 {
+		return 0, fmt.Errorf("invalid port: %q", s)
+	}
+	return p, nil
+}
+
+// Allowed 判断目标端口是否允许连接，nil 策略允许所有端口
+func (p *PortPolicy) Allowed(port int) bool {
+	if p == nil {
+		return true
+	}
+	for _, r := range p.deny {
+		if port >= r.from && port <= r.to {
+			return false
+		}
+	}
+	if len(p.allow) == 0 {
+		return true
+	}
+	for _, r := range p.allow {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+	return false
+}
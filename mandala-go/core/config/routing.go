@@ -0,0 +1,314 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"mandala/core/logger"
+)
+
+// 路由出站标签
+const (
+	OutboundProxy  = "proxy"  // 经当前节点代理 (默认)
+	OutboundDirect = "direct" // 绕过代理直接连接
+	OutboundBlock  = "block"  // 拒绝连接 / 丢弃数据
+)
+
+// RoutingConfig 定义按目标选择出站的路由规则，按顺序匹配，首个命中的规则生效
+type RoutingConfig struct {
+	Rules []RoutingRule `json:"rules,omitempty"`
+	// 未命中任何规则时使用的出站，为空时为 "proxy"
+	DefaultOutbound string `json:"default_outbound,omitempty"`
+	// [新增] GeoIP 数据库路径 (MaxMind mmdb 格式，如 GeoLite2-Country.mmdb)
+	// 未配置或无法加载时 geoip 条件不匹配任何目标
+	GeoIPPath string `json:"geoip_path,omitempty"`
+	// [新增] GeoSite 域名列表路径 (V2Ray / Xray 的 geosite.dat 格式)
+	// 未配置或无法加载时 geosite 条件不匹配任何目标
+	GeoSitePath string `json:"geosite_path,omitempty"`
+}
+
+// RoutingRule 单条路由规则
+// 目标条件 (域名后缀、关键字、GeoSite、IP 网段、私有地址、GeoIP) 之间为“或”，端口条件与其为“且”；
+// 只有端口条件的规则按端口匹配所有目标。
+// 域名条件只匹配域名目标，IP 条件只匹配 IP 目标 (不做 DNS 解析)；TUN 模式下需启用 FakeIP 或嗅探 (sniff) 才能按域名匹配
+type RoutingRule struct {
+	DomainSuffix  []string `json:"domain_suffix,omitempty"`  // "example.com" 匹配其自身及所有子域名
+	DomainKeyword []string `json:"domain_keyword,omitempty"` // 域名包含该字符串
+	GeoSite       []string `json:"geosite,omitempty"`        // [新增] 域名分类，如 "cn"、"geosite:category-ads-all"、"google@cn"
+	IPCIDR        []string `json:"ip_cidr,omitempty"`        // 如 "10.0.0.0/8"、"fd00::/8"，单个 IP 视为 /32 或 /128
+	IPIsPrivate   bool     `json:"ip_is_private,omitempty"`  // 私有、回环与链路本地地址 (局域网流量)
+	GeoIP         []string `json:"geoip,omitempty"`          // [新增] IP 所属国家代码，如 "cn" 或 "geoip:cn"
+	Port          string   `json:"port,omitempty"`           // 端口列表，格式同 allowed_ports，如 "80,443,8000-9000"
+	Outbound      string   `json:"outbound"`                 // "proxy" / "direct" / "block"
+}
+
+// routingRule 编译后的规则
+type routingRule struct {
+	suffixes  []string
+	keywords  []string
+	siteCodes []string
+	sites     []*DomainMatcher
+	nets      []*net.IPNet
+	private   bool
+	countries []string
+	ports     []portRange
+	outbound  string
+	matchHost bool // 是否包含目标条件
+}
+
+// Router 编译后的路由规则
+type Router struct {
+	rules    []routingRule
+	fallback string
+	geoip    *GeoIP // 为 nil 时 geoip 条件不匹配
+}
+
+// ParseRouter 校验并编译路由规则；未配置规则且默认出站为代理时返回 nil (全部经代理)
+func ParseRouter(cfg *RoutingConfig) (*Router, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	fallback, err := parseOutbound(cfg.DefaultOutbound)
+	if err != nil {
+		return nil, fmt.Errorf("routing.default_outbound: %v", err)
+	}
+	if len(cfg.Rules) == 0 && fallback == OutboundProxy {
+		return nil, nil
+	}
+
+	r := &Router{fallback: fallback}
+	usesGeoIP := false
+	var siteCodes []string
+	for i, rule := range cfg.Rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("routing.rules[%d]: %v", i, err)
+		}
+		r.rules = append(r.rules, compiled)
+		usesGeoIP = usesGeoIP || len(compiled.countries) > 0
+		siteCodes = append(siteCodes, compiled.siteCodes...)
+	}
+
+	// [新增] 只编译规则引用的分类；文件缺失时 geosite 条件失效
+	if len(siteCodes) > 0 {
+		r.loadGeoSite(cfg.GeoSitePath, siteCodes)
+	}
+
+	// [新增] 数据库缺失不影响启动，仅 geoip 条件失效
+	if usesGeoIP {
+		if cfg.GeoIPPath == "" {
+			logger.Warnf("Route", "规则使用了 geoip 但未配置 geoip_path，geoip 条件不会匹配")
+		} else if r.geoip, err = OpenGeoIP(cfg.GeoIPPath); err != nil {
+			logger.Warnf("Route", "GeoIP 数据库加载失败，geoip 条件不会匹配: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// loadGeoSite 加载域名列表并关联到各规则
+func (r *Router) loadGeoSite(path string, codes []string) {
+	if path == "" {
+		logger.Warnf("Route", "规则使用了 geosite 但未配置 geosite_path，geosite 条件不会匹配")
+		return
+	}
+	sites, err := LoadGeoSite(path, codes)
+	if err != nil {
+		logger.Warnf("Route", "GeoSite 加载失败，geosite 条件不会匹配: %v", err)
+		return
+	}
+	for i := range r.rules {
+		rule := &r.rules[i]
+		for _, code := range rule.siteCodes {
+			if m, ok := sites[code]; ok {
+				rule.sites = append(rule.sites, m)
+			} else {
+				logger.Warnf("Route", "GeoSite 中不存在分类 %q", code)
+			}
+		}
+	}
+}
+
+func parseOutbound(tag string) (string, error) {
+	switch tag = strings.ToLower(strings.TrimSpace(tag)); tag {
+	case "":
+		return OutboundProxy, nil
+	case OutboundProxy, OutboundDirect, OutboundBlock:
+		return tag, nil
+	}
+	return "", fmt.Errorf("unknown outbound %q (expected proxy, direct or block)", tag)
+}
+
+func compileRule(rule RoutingRule) (routingRule, error) {
+	var c routingRule
+	var err error
+	if rule.Outbound == "" {
+		return c, fmt.Errorf("outbound is required")
+	}
+	if c.outbound, err = parseOutbound(rule.Outbound); err != nil {
+		return c, err
+	}
+
+	for _, s := range rule.DomainSuffix {
+		if s = normalizeDomain(s); s != "" {
+			c.suffixes = append(c.suffixes, strings.TrimPrefix(s, "."))
+		}
+	}
+	for _, k := range rule.DomainKeyword {
+		if k = normalizeDomain(k); k != "" {
+			c.keywords = append(c.keywords, k)
+		}
+	}
+	for _, code := range rule.GeoSite {
+		code = strings.TrimSpace(code)
+		if len(code) >= len("geosite:") && strings.EqualFold(code[:len("geosite:")], "geosite:") {
+			code = code[len("geosite:"):]
+		}
+		if code = strings.ToLower(code); code != "" {
+			c.siteCodes = append(c.siteCodes, code)
+		}
+	}
+	for _, cidr := range rule.IPCIDR {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return c, fmt.Errorf("invalid ip_cidr: %q", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return c, fmt.Errorf("invalid ip_cidr: %q", cidr)
+		}
+		c.nets = append(c.nets, ipNet)
+	}
+	c.private = rule.IPIsPrivate
+	for _, cc := range rule.GeoIP {
+		cc = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(cc)), "geoip:")
+		if cc == "" {
+			continue
+		}
+		if len(cc) != 2 {
+			return c, fmt.Errorf("invalid geoip country code: %q", cc)
+		}
+		c.countries = append(c.countries, cc)
+	}
+	if c.ports, err = parsePortRanges(rule.Port); err != nil {
+		return c, fmt.Errorf("port: %v", err)
+	}
+
+	c.matchHost = len(c.suffixes) > 0 || len(c.keywords) > 0 || len(c.siteCodes) > 0 || len(c.nets) > 0 || c.private || len(c.countries) > 0
+	if !c.matchHost && len(c.ports) == 0 {
+		return c, fmt.Errorf("rule has no conditions")
+	}
+	return c, nil
+}
+
+// normalizeDomain 转为小写并去掉末尾的点
+func normalizeDomain(s string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".")
+}
+
+// Match 返回目标应使用的出站标签，nil 路由器始终返回 "proxy"
+func (r *Router) Match(host string, port int) string {
+	if r == nil {
+		return OutboundProxy
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	domain := ""
+	if ip == nil {
+		domain = normalizeDomain(host)
+	}
+	return r.match(domain, ip, port)
+}
+
+// [新增] MatchSniffed 按嗅探出的域名与连接的目标 IP 共同匹配：域名条件匹配 domain，IP 条件匹配 ip
+func (r *Router) MatchSniffed(domain string, ip net.IP, port int) string {
+	if r == nil {
+		return OutboundProxy
+	}
+	return r.match(normalizeDomain(domain), ip, port)
+}
+
+// match 依次匹配规则，domain 与 ip 均可为空
+func (r *Router) match(domain string, ip net.IP, port int) string {
+	// 国家代码在首次遇到 geoip 条件时查询一次 (结果已缓存)
+	country, looked := "", false
+	lookup := func() string {
+		if !looked {
+			country, looked = r.geoip.Country(ip), true
+		}
+		return country
+	}
+	for i := range r.rules {
+		if r.rules[i].match(domain, ip, port, lookup) {
+			return r.rules[i].outbound
+		}
+	}
+	return r.fallback
+}
+
+func (c *routingRule) match(domain string, ip net.IP, port int, country func() string) bool {
+	if len(c.ports) > 0 && !portInRanges(c.ports, port) {
+		return false
+	}
+	if !c.matchHost {
+		return true
+	}
+
+	if domain != "" {
+		for _, s := range c.suffixes {
+			if domain == s || strings.HasSuffix(domain, "."+s) {
+				return true
+			}
+		}
+		for _, k := range c.keywords {
+			if strings.Contains(domain, k) {
+				return true
+			}
+		}
+		for _, m := range c.sites {
+			if m.Match(domain) {
+				return true
+			}
+		}
+	}
+
+	if ip == nil {
+		return false
+	}
+	if c.private && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()) {
+		return true
+	}
+	for _, n := range c.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	if len(c.countries) > 0 {
+		cc := country()
+		for _, want := range c.countries {
+			if cc == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func portInRanges(ranges []portRange, port int) bool {
+	for _, r := range ranges {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+	return false
+}
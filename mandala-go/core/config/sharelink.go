@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ParseTrojanURL 解析 trojan:// 分享链接
+// 格式: trojan://password@host:port?sni=xx&fp=chrome&alpn=h2,http/1.1&type=ws&path=/xx&host=xx&allowInsecure=1#备注
+func ParseTrojanURL(link string) (*OutboundConfig, error) {
+	u, err := parseShareURL(link, "trojan")
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("trojan link: missing password")
+	}
+
+	cfg := &OutboundConfig{
+		Type:     "trojan",
+		Tag:      u.Fragment,
+		Password: u.User.Username(),
+	}
+	if cfg.Server, cfg.ServerPort, err = shareHostPort(u); err != nil {
+		return nil, fmt.Errorf("trojan link: %v", err)
+	}
+
+	q := u.Query()
+
+	// Trojan 默认启用 TLS，security=none 时关闭
+	if q.Get("security") != "none" {
+		cfg.TLS = shareTLSConfig(q)
+	}
+
+	if t := q.Get("type"); t != "" && t != "tcp" {
+		cfg.Transport = &TransportConfig{
+			Type:        t,
+			Path:        q.Get("path"),
+			Host:        q.Get("host"),
+			ServiceName: q.Get("serviceName"),
+		}
+	}
+	return cfg, nil
+}
+
+// ParseShadowsocksURL 解析 ss:// 分享链接，支持两种格式:
+// SIP002: ss://base64url(method:password)@host:port/?plugin=xx#备注 (userinfo 也可为明文)
+// 旧格式: ss://base64(method:password@host:port)#备注
+func ParseShadowsocksURL(link string) (*OutboundConfig, error) {
+	if !strings.HasPrefix(link, "ss://") {
+		return nil, fmt.Errorf("ss link: missing ss:// scheme")
+	}
+	body := strings.TrimPrefix(link, "ss://")
+
+	var tag string
+	if i := strings.Index(body, "#"); i >= 0 {
+		tag, _ = url.PathUnescape(body[i+1:])
+		body = body[:i]
+	}
+
+	// 旧格式整体经过 base64 编码，不包含 '@'
+	if !strings.Contains(body, "@") {
+		rest := body
+		if i := strings.IndexAny(rest, "/?"); i >= 0 {
+			rest = rest[:i]
+		}
+		decoded, err := decodeBase64(rest)
+		if err != nil {
+			return nil, fmt.Errorf("ss link: invalid base64: %v", err)
+		}
+		body = string(decoded)
+	}
+
+	u, err := url.Parse("ss://" + body)
+	if err != nil {
+		return nil, fmt.Errorf("ss link: %v", err)
+	}
+	if u.User == nil {
+		return nil, fmt.Errorf("ss link: missing method and password")
+	}
+
+	// SIP002 中 userinfo 可能经过 base64 编码，也可能为明文 method:password
+	var userinfo string
+	if pass, ok := u.User.Password(); ok {
+		userinfo = u.User.Username() + ":" + pass
+	} else {
+		decoded, err := decodeBase64(u.User.Username())
+		if err != nil {
+			return nil, fmt.Errorf("ss link: invalid base64 userinfo: %v", err)
+		}
+		userinfo = string(decoded)
+	}
+
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok || method == "" {
+		return nil, fmt.Errorf("ss link: userinfo must be method:password")
+	}
+
+	cfg := &OutboundConfig{
+		Type:     "shadowsocks",
+		Tag:      tag,
+		Method:   strings.ToLower(method),
+		Password: password,
+	}
+	if cfg.Server, cfg.ServerPort, err = shareHostPort(u); err != nil {
+		return nil, fmt.Errorf("ss link: %v", err)
+	}
+	return cfg, nil
+}
+
+// parseShareURL 校验 scheme 并解析链接
+func parseShareURL(link, scheme string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return nil, fmt.Errorf("%s link: %v", scheme, err)
+	}
+	if u.Scheme != scheme {
+		return nil, fmt.Errorf("%s link: unexpected scheme %q", scheme, u.Scheme)
+	}
+	return u, nil
+}
+
+// shareTLSConfig 按分享链接的通用参数构造 TLS 配置:
+// sni (或 peer) -> server_name，allowInsecure (或 insecure) -> insecure，fp -> fingerprint，
+// alpn (逗号分隔) -> alpn；未出现的参数保持零值，即沿用默认行为
+func shareTLSConfig(q url.Values) *TLSConfig {
+	tls := &TLSConfig{
+		Enabled:     true,
+		ServerName:  firstNonEmpty(q.Get("sni"), q.Get("peer")),
+		Insecure:    isTrue(q.Get("allowInsecure")) || isTrue(q.Get("insecure")),
+		Fingerprint: q.Get("fp"),
+	}
+	for _, p := range strings.Split(q.Get("alpn"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			tls.ALPN = append(tls.ALPN, p)
+		}
+	}
+	return tls
+}
+
+// shareHostPort 提取并校验节点地址与端口 (IPv6 地址的方括号由 Hostname 剥离)
+func shareHostPort(u *url.URL) (string, int, error) {
+	host := u.Hostname()
+	if host == "" {
+		return "", 0, fmt.Errorf("missing host")
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", u.Port())
+	}
+	return host, port, nil
+}
+
+// decodeBase64 兼容标准/URL 安全字符集，以及有无填充的写法
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		s = unescaped
+	}
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func isTrue(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
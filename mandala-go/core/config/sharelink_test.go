@@ -0,0 +1,98 @@
+package config
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+// 每种分享链接格式解析为对应的节点配置；格式错误的链接返回错误
+func TestParseShareLinks(t *testing.T) {
+	userinfo := base64.RawURLEncoding.EncodeToString([]byte("aes-256-gcm:pa ss"))
+	legacy := base64.StdEncoding.EncodeToString([]byte("chacha20-ietf-poly1305:secret@[2001:db8::1]:8388"))
+
+	for _, tc := range []struct {
+		link  string
+		parse func(string) (*OutboundConfig, error)
+		want  *OutboundConfig
+	}{
+		{"trojan://secret@node.example.com:443#My%20Node", ParseTrojanURL, &OutboundConfig{
+			Type: "trojan", Tag: "My Node", Server: "node.example.com", ServerPort: 443, Password: "secret",
+			TLS: &TLSConfig{Enabled: true},
+		}},
+		{"trojan://secret@[2001:db8::1]:8443?security=none&type=ws&path=%2Fws&host=cdn.example.com", ParseTrojanURL, &OutboundConfig{
+			Type: "trojan", Server: "2001:db8::1", ServerPort: 8443, Password: "secret",
+			Transport: &TransportConfig{Type: "ws", Path: "/ws", Host: "cdn.example.com"},
+		}},
+		{"trojan://secret@node.example.com:443?type=grpc&serviceName=tunnel", ParseTrojanURL, &OutboundConfig{
+			Type: "trojan", Server: "node.example.com", ServerPort: 443, Password: "secret",
+			TLS:       &TLSConfig{Enabled: true},
+			Transport: &TransportConfig{Type: "grpc", ServiceName: "tunnel"},
+		}},
+		// SIP002：userinfo 为 base64url 或明文
+		{"ss://" + userinfo + "@1.2.3.4:8388/?plugin=none#ss%20node", ParseShadowsocksURL, &OutboundConfig{
+			Type: "shadowsocks", Tag: "ss node", Server: "1.2.3.4", ServerPort: 8388, Method: "aes-256-gcm", Password: "pa ss",
+		}},
+		{"ss://AES-128-GCM:secret@node.example.com:8388", ParseShadowsocksURL, &OutboundConfig{
+			Type: "shadowsocks", Server: "node.example.com", ServerPort: 8388, Method: "aes-128-gcm", Password: "secret",
+		}},
+		// 旧格式：整体 base64
+		{"ss://" + legacy + "#legacy", ParseShadowsocksURL, &OutboundConfig{
+			Type: "shadowsocks", Tag: "legacy", Server: "2001:db8::1", ServerPort: 8388, Method: "chacha20-ietf-poly1305", Password: "secret",
+		}},
+	} {
+		got, err := tc.parse(tc.link)
+		if err != nil {
+			t.Errorf("parse(%q): %v", tc.link, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parse(%q) = %+v, want %+v", tc.link, got, tc.want)
+		}
+	}
+
+	for _, bad := range []struct {
+		link  string
+		parse func(string) (*OutboundConfig, error)
+	}{
+		{"vless://secret@node.example.com:443", ParseTrojanURL},
+		{"trojan://node.example.com:443", ParseTrojanURL},
+		{"trojan://secret@node.example.com", ParseTrojanURL},
+		{"trojan://secret@node.example.com:70000", ParseTrojanURL},
+		{"trojan://secret@:443", ParseTrojanURL},
+		{"trojan://secret@node.example.com:443", ParseShadowsocksURL},
+		{"ss://!!!", ParseShadowsocksURL},
+		{"ss://" + base64.RawURLEncoding.EncodeToString([]byte("no-colon")) + "@1.2.3.4:8388", ParseShadowsocksURL},
+		{"ss://aes-128-gcm:secret@1.2.3.4", ParseShadowsocksURL},
+	} {
+		if _, err := bad.parse(bad.link); err == nil {
+			t.Errorf("parse(%q) succeeded", bad.link)
+		}
+	}
+}
+
+// sni / allowInsecure / fp / alpn 等通用参数映射到 TLSConfig，未出现的参数保持零值
+func TestShareLinkTLSParams(t *testing.T) {
+	for query, want := range map[string]*TLSConfig{
+		"":                                     {Enabled: true},
+		"sni=front.example.com":                {Enabled: true, ServerName: "front.example.com"},
+		"peer=front.example.com":               {Enabled: true, ServerName: "front.example.com"},
+		"sni=a.example.com&peer=b.example.com": {Enabled: true, ServerName: "a.example.com"},
+		"allowInsecure=1":                      {Enabled: true, Insecure: true},
+		"allowInsecure=0":                      {Enabled: true},
+		"insecure=true":                        {Enabled: true, Insecure: true},
+		"fp=firefox":                           {Enabled: true, Fingerprint: "firefox"},
+		"alpn=h2,%20http/1.1,":                 {Enabled: true, ALPN: []string{"h2", "http/1.1"}},
+		"sni=s.example.com&fp=ios&alpn=h2&allowInsecure=yes": {
+			Enabled: true, ServerName: "s.example.com", Fingerprint: "ios", ALPN: []string{"h2"}, Insecure: true,
+		},
+	} {
+		cfg, err := ParseTrojanURL("trojan://secret@node.example.com:443?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cfg.TLS, want) {
+			t.Errorf("%q: tls = %+v, want %+v", query, cfg.TLS, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UIDPolicy 按来源应用 UID 过滤 TUN 流量 (Android 上每个应用对应一个 UID)
+// 拒绝列表优先；允许列表非空时只放行其中的 UID，其余按 action 处理
+type UIDPolicy struct {
+	allow  map[int]bool
+	deny   map[int]bool
+	action string // OutboundBlock 或 OutboundDirect
+}
+
+// ParseUIDPolicy 解析 UID 允许/拒绝列表与被过滤流量的处理方式 ("block" 默认 / "direct")
+// 两个列表均为空时返回 nil (不做限制)
+func ParseUIDPolicy(allow, deny []int, action string) (*UIDPolicy, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	switch action = strings.ToLower(strings.TrimSpace(action)); action {
+	case "":
+		action = OutboundBlock
+	case OutboundBlock, OutboundDirect:
+	default:
+		return nil, fmt.Errorf("uid_filter_action: unknown action %q", action)
+	}
+
+	p := &UIDPolicy{allow: make(map[int]bool), deny: make(map[int]bool), action: action}
+	for _, uid := range allow {
+		if uid < 0 {
+			return nil, fmt.Errorf("allowed_uids: invalid uid %d", uid)
+		}
+		p.allow[uid] = true
+	}
+	for _, uid := range deny {
+		if uid < 0 {
+			return nil, fmt.Errorf("blocked_uids: invalid uid %d", uid)
+		}
+		p.deny[uid] = true
+	}
+	return p, nil
+}
+
+// Filter 判断来源 UID 是否被过滤，被过滤时返回应使用的出站 (block / direct)
+// uid 为负数表示无法确定来源：仅配置拒绝列表时放行，配置了允许列表时视为不在列表内
+func (p *UIDPolicy) Filter(uid int) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	if uid >= 0 && p.deny[uid] {
+		return p.action, true
+	}
+	if len(p.allow) > 0 && (uid < 0 || !p.allow[uid]) {
+		return p.action, true
+	}
+	return "", false
+}
@@ -0,0 +1,163 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level 日志级别，低于当前级别的日志被丢弃
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel 解析级别名称 (不区分大小写，"warning" 等同 "warn")
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %q", name)
+}
+
+// Record 一条结构化日志，以 JSON 行的形式交给回调
+type Record struct {
+	Level  string `json:"level"`
+	Module string `json:"module"`
+	Msg    string `json:"msg"`
+	TS     int64  `json:"ts"` // Unix 毫秒
+}
+
+var (
+	level atomic.Int32 // 默认 LevelInfo，见 init
+
+	// 文本输出 (logcat / 日志文件) 与结构化回调，写入时加锁保证多协程下的行顺序
+	mu     sync.Mutex
+	output io.Writer = os.Stderr
+	sink   func(line string)
+)
+
+func init() {
+	level.Store(int32(LevelInfo))
+}
+
+// SetLevel 设置最低输出级别
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// GetLevel 返回当前级别
+func GetLevel() Level {
+	return Level(level.Load())
+}
+
+// SetOutput 设置文本日志的输出位置 (默认标准错误，Android 上即 logcat)
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	output = w
+	mu.Unlock()
+}
+
+// SetSink 设置结构化日志回调，每条日志以一行 JSON 传入；nil 表示关闭
+// 回调在持有内部锁时同步调用，不能在回调内再写日志
+func SetSink(fn func(line string)) {
+	mu.Lock()
+	sink = fn
+	mu.Unlock()
+}
+
+func Debugf(module, format string, args ...interface{}) { logf(LevelDebug, module, format, args...) }
+func Infof(module, format string, args ...interface{})  { logf(LevelInfo, module, format, args...) }
+func Warnf(module, format string, args ...interface{})  { logf(LevelWarn, module, format, args...) }
+func Errorf(module, format string, args ...interface{}) { logf(LevelError, module, format, args...) }
+
+func logf(l Level, module, format string, args ...interface{}) {
+	if l < GetLevel() {
+		return
+	}
+	emit(l, module, fmt.Sprintf(format, args...))
+}
+
+func emit(l Level, module, msg string) {
+	now := time.Now()
+
+	var text strings.Builder
+	text.WriteString(now.Format("2006/01/02 15:04:05 "))
+	text.WriteString(strings.ToUpper(l.String()))
+	if module != "" {
+		text.WriteString(" [")
+		text.WriteString(module)
+		text.WriteString("]")
+	}
+	text.WriteString(" ")
+	text.WriteString(msg)
+	text.WriteString("\n")
+
+	mu.Lock()
+	defer mu.Unlock()
+	io.WriteString(output, text.String())
+	if sink != nil {
+		line, err := json.Marshal(Record{Level: l.String(), Module: module, Msg: msg, TS: now.UnixMilli()})
+		if err == nil {
+			sink(string(line))
+		}
+	}
+}
+
+// CaptureStdLog 将标准库 log 的输出接入本包 (info 级别)
+// 尚未迁移的 log.Printf 调用同样会到达回调；消息开头的 "[模块]" 标签解析为 module
+func CaptureStdLog() {
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(stdLogWriter{})
+}
+
+// stdLogWriter 标准库 log 每次调用 Write 传入一整行
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	if LevelInfo < GetLevel() {
+		return len(p), nil
+	}
+	module, msg := splitModule(string(bytes.TrimRight(p, "\n")))
+	emit(LevelInfo, module, msg)
+	return len(p), nil
+}
+
+// splitModule 拆分 "[Proxy] xxx" 形式的消息，兼容旧代码中的 "GoLog: " 前缀
+func splitModule(line string) (string, string) {
+	line = strings.TrimPrefix(line, "GoLog: ")
+	if strings.HasPrefix(line, "[") {
+		if end := strings.IndexByte(line, ']'); end > 1 {
+			return line[1:end], strings.TrimSpace(line[end+1:])
+		}
+	}
+	return "", line
+}
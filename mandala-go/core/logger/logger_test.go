@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// capture 将文本输出与结构化回调接入测试，返回文本缓冲区与已收到的记录；测试结束时恢复
+func capture(t *testing.T, l Level) (*bytes.Buffer, *[]Record) {
+	t.Helper()
+	var text bytes.Buffer
+	records := new([]Record)
+	old := GetLevel()
+	SetLevel(l)
+	SetOutput(&text)
+	SetSink(func(line string) {
+		var r Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Errorf("sink line %q: %v", line, err)
+		}
+		*records = append(*records, r)
+	})
+	t.Cleanup(func() {
+		SetLevel(old)
+		SetOutput(os.Stderr)
+		SetSink(nil)
+	})
+	return &text, records
+}
+
+// 低于当前级别的日志既不写入文本输出也不交给回调
+func TestLevelFiltering(t *testing.T) {
+	text, records := capture(t, LevelWarn)
+	Debugf("Test", "debug %d", 1)
+	Infof("Test", "info %d", 2)
+	Warnf("Test", "warn %d", 3)
+	Errorf("Proxy", "error %d", 4)
+
+	want := []Record{{Level: "warn", Module: "Test", Msg: "warn 3"}, {Level: "error", Module: "Proxy", Msg: "error 4"}}
+	if len(*records) != len(want) {
+		t.Fatalf("records = %+v, want %+v", *records, want)
+	}
+	for i, r := range *records {
+		if r.Level != want[i].Level || r.Module != want[i].Module || r.Msg != want[i].Msg || r.TS == 0 {
+			t.Errorf("record %d = %+v, want %+v", i, r, want[i])
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(text.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "WARN [Test] warn 3") || !strings.HasSuffix(lines[1], "ERROR [Proxy] error 4") {
+		t.Fatalf("text output = %q", text.String())
+	}
+
+	// 降低级别后低级别日志恢复输出
+	SetLevel(LevelDebug)
+	Debugf("Test", "debug")
+	if n := len(*records); n != 3 || (*records)[2].Level != "debug" {
+		t.Fatalf("after SetLevel(debug): %+v", *records)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]Level{
+		"debug": LevelDebug, " INFO ": LevelInfo, "": LevelInfo, "warning": LevelWarn, "Warn": LevelWarn, "error": LevelError,
+	} {
+		if l, err := ParseLevel(name); err != nil || l != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", name, l, err, want)
+		}
+	}
+	if l, err := ParseLevel("verbose"); err == nil || l != LevelInfo {
+		t.Errorf("ParseLevel(verbose) = %v, %v; want info and an error", l, err)
+	}
+}
+
+// 标准库 log 的输出按 info 级别过滤，"[模块]" 标签与 "GoLog: " 前缀被解析
+func TestCaptureStdLog(t *testing.T) {
+	_, records := capture(t, LevelInfo)
+	CaptureStdLog()
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+
+	log.Printf("[Trojan] 握手包构造成功")
+	log.Printf("GoLog: [Vmess] 开始构造请求")
+	log.Printf("plain message")
+	SetLevel(LevelWarn)
+	log.Printf("[Trojan] dropped")
+
+	want := []Record{
+		{Level: "info", Module: "Trojan", Msg: "握手包构造成功"},
+		{Level: "info", Module: "Vmess", Msg: "开始构造请求"},
+		{Level: "info", Msg: "plain message"},
+	}
+	if len(*records) != len(want) {
+		t.Fatalf("records = %+v, want %+v", *records, want)
+	}
+	for i, r := range *records {
+		if r.Level != want[i].Level || r.Module != want[i].Module || r.Msg != want[i].Msg {
+			t.Errorf("record %d = %+v, want %+v", i, r, want[i])
+		}
+	}
+}
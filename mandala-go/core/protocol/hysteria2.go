@@ -0,0 +1,194 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// Hysteria2 认证: 经 HTTP/3 发送 POST https://hysteria/auth，服务端以状态码 233 表示成功
+const (
+	Hysteria2AuthURL      = "https://hysteria/auth"
+	Hysteria2StatusAuthOK = 233
+
+	Hysteria2HeaderAuth    = "Hysteria-Auth"
+	Hysteria2HeaderCCRX    = "Hysteria-CC-RX" // 接收方带宽 (字节/秒)，0 表示未知，由对端自行探测
+	Hysteria2HeaderUDP     = "Hysteria-UDP"
+	Hysteria2HeaderPadding = "Hysteria-Padding"
+)
+
+// Hysteria2 TCP 中继: 客户端在新的双向流上发送 TCPRequest，服务端回复 TCPResponse 后转发数据
+const (
+	hysteria2FrameTCPRequest = 0x401
+
+	hysteria2MaxAddrLen    = 2048
+	hysteria2MaxMessageLen = 2048
+	hysteria2MaxPaddingLen = 4096
+)
+
+// 各消息的随机填充长度范围
+var (
+	hysteria2AuthPadding       = [2]int{256, 2048}
+	hysteria2TCPRequestPadding = [2]int{64, 512}
+)
+
+// BuildHysteria2AuthRequest 构造认证请求
+// rxBytesPerSec 为客户端下行带宽，服务端据此设定发送速率 (Brutal 拥塞控制)，0 表示由服务端自行探测
+func BuildHysteria2AuthRequest(password string, rxBytesPerSec uint64) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, Hysteria2AuthURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(Hysteria2HeaderAuth, password)
+	req.Header.Set(Hysteria2HeaderCCRX, strconv.FormatUint(rxBytesPerSec, 10))
+	req.Header.Set(Hysteria2HeaderPadding, hysteria2Padding(hysteria2AuthPadding))
+	return req, nil
+}
+
+// BuildHysteria2TCPRequest 构造 TCP 中继请求
+// 格式: FrameType(varint 0x401) + AddrLen(varint) + Addr("host:port") + PaddingLen(varint) + Padding
+func BuildHysteria2TCPRequest(targetHost string, targetPort int) ([]byte, error) {
+	addr := net.JoinHostPort(targetHost, strconv.Itoa(targetPort))
+	if len(addr) > hysteria2MaxAddrLen {
+		return nil, fmt.Errorf("hysteria2 address too long: %d", len(addr))
+	}
+	padding := hysteria2Padding(hysteria2TCPRequestPadding)
+
+	buf := quicvarint.Append(nil, hysteria2FrameTCPRequest)
+	buf = quicvarint.Append(buf, uint64(len(addr)))
+	buf = append(buf, addr...)
+	buf = quicvarint.Append(buf, uint64(len(padding)))
+	return append(buf, padding...), nil
+}
+
+// Hysteria2Conn 包装 TCP 中继流，首次读取时解析服务端的 TCPResponse
+// 格式: Status(1，0 为成功) + MsgLen(varint) + Msg + PaddingLen(varint) + Padding
+type Hysteria2Conn struct {
+	net.Conn
+	reader       *bufio.Reader
+	responseRead bool
+}
+
+func NewHysteria2Conn(c net.Conn) *Hysteria2Conn {
+	return &Hysteria2Conn{Conn: c}
+}
+
+func (c *Hysteria2Conn) Read(b []byte) (int, error) {
+	if !c.responseRead {
+		c.reader = bufio.NewReader(c.Conn)
+		if err := c.readResponse(); err != nil {
+			return 0, err
+		}
+		c.responseRead = true
+	}
+	return c.reader.Read(b)
+}
+
+func (c *Hysteria2Conn) readResponse() error {
+	status, err := c.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	msgLen, err := quicvarint.Read(c.reader)
+	if err != nil {
+		return err
+	}
+	if msgLen > hysteria2MaxMessageLen {
+		return fmt.Errorf("hysteria2 response message too long: %d", msgLen)
+	}
+	msg := make([]byte, msgLen)
+	if _, err := io.ReadFull(c.reader, msg); err != nil {
+		return err
+	}
+	paddingLen, err := quicvarint.Read(c.reader)
+	if err != nil {
+		return err
+	}
+	if paddingLen > hysteria2MaxPaddingLen {
+		return fmt.Errorf("hysteria2 response padding too long: %d", paddingLen)
+	}
+	if _, err := c.reader.Discard(int(paddingLen)); err != nil {
+		return err
+	}
+	if status != 0 {
+		return fmt.Errorf("hysteria2 server rejected connection: %s", msg)
+	}
+	return nil
+}
+
+// Hysteria2UDPMessage 一个 UDP 数据报分片 (经 QUIC 数据报传输)
+// 格式: SessionID(4) + PacketID(2) + FragID(1) + FragCount(1) + AddrLen(varint) + Addr + Data
+type Hysteria2UDPMessage struct {
+	SessionID uint32
+	PacketID  uint16
+	FragID    uint8
+	FragCount uint8
+	Addr      string // "host:port"
+	Data      []byte
+}
+
+// HeaderSize 返回数据之前的长度
+func (m *Hysteria2UDPMessage) HeaderSize() int {
+	return 4 + 2 + 1 + 1 + quicvarint.Len(uint64(len(m.Addr))) + len(m.Addr)
+}
+
+// Marshal 编码数据报
+func (m *Hysteria2UDPMessage) Marshal() []byte {
+	buf := make([]byte, 0, m.HeaderSize()+len(m.Data))
+	buf = binary.BigEndian.AppendUint32(buf, m.SessionID)
+	buf = binary.BigEndian.AppendUint16(buf, m.PacketID)
+	buf = append(buf, m.FragID, m.FragCount)
+	buf = quicvarint.Append(buf, uint64(len(m.Addr)))
+	buf = append(buf, m.Addr...)
+	return append(buf, m.Data...)
+}
+
+// ParseHysteria2UDPMessage 解析数据报
+func ParseHysteria2UDPMessage(b []byte) (*Hysteria2UDPMessage, error) {
+	if len(b) < 8 {
+		return nil, errors.New("hysteria2 udp message too short")
+	}
+	m := &Hysteria2UDPMessage{
+		SessionID: binary.BigEndian.Uint32(b),
+		PacketID:  binary.BigEndian.Uint16(b[4:]),
+		FragID:    b[6],
+		FragCount: b[7],
+	}
+	r := bytes.NewReader(b[8:])
+	addrLen, err := quicvarint.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if addrLen == 0 || addrLen > hysteria2MaxAddrLen || int(addrLen) > r.Len() {
+		return nil, fmt.Errorf("invalid hysteria2 udp address length: %d", addrLen)
+	}
+	rest := b[len(b)-r.Len():]
+	m.Addr = string(rest[:addrLen])
+	m.Data = rest[addrLen:]
+	return m, nil
+}
+
+// hysteria2Padding 生成长度在 [min, max) 内的随机填充字符串
+func hysteria2Padding(lengthRange [2]int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	n := lengthRange[0]
+	if v, err := rand.Int(rand.Reader, big.NewInt(int64(lengthRange[1]-lengthRange[0]))); err == nil {
+		n += int(v.Int64())
+	}
+	buf := make([]byte, n)
+	rand.Read(buf)
+	for i := range buf {
+		buf[i] = letters[int(buf[i])%len(letters)]
+	}
+	return string(buf)
+}
@@ -0,0 +1,108 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+func TestBuildHysteria2AuthRequest(t *testing.T) {
+	req, err := BuildHysteria2AuthRequest("secret", 12500000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPost || req.URL.String() != Hysteria2AuthURL {
+		t.Fatalf("request = %s %s", req.Method, req.URL)
+	}
+	if got := req.Header.Get(Hysteria2HeaderAuth); got != "secret" {
+		t.Errorf("%s = %q", Hysteria2HeaderAuth, got)
+	}
+	if got := req.Header.Get(Hysteria2HeaderCCRX); got != "12500000" {
+		t.Errorf("%s = %q", Hysteria2HeaderCCRX, got)
+	}
+	if n := len(req.Header.Get(Hysteria2HeaderPadding)); n < hysteria2AuthPadding[0] || n >= hysteria2AuthPadding[1] {
+		t.Errorf("padding length %d out of range %v", n, hysteria2AuthPadding)
+	}
+}
+
+// 期望编码按 Hysteria2 协议文档手工展开: 0x401 的 varint 为 44 01
+func TestBuildHysteria2TCPRequest(t *testing.T) {
+	got, err := BuildHysteria2TCPRequest("example.com", 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := append(mustHex(t, "4401"+"0f"), "example.com:443"...)
+	if !bytes.HasPrefix(got, prefix) {
+		t.Fatalf("request = %x, want prefix %x", got, prefix)
+	}
+	r := bytes.NewReader(got[len(prefix):])
+	n, err := quicvarint.Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < uint64(hysteria2TCPRequestPadding[0]) || n >= uint64(hysteria2TCPRequestPadding[1]) || int(n) != r.Len() {
+		t.Fatalf("padding length %d, %d bytes left", n, r.Len())
+	}
+}
+
+func TestHysteria2UDPMessage(t *testing.T) {
+	m := &Hysteria2UDPMessage{SessionID: 0x01020304, PacketID: 5, FragID: 0, FragCount: 1, Addr: "1.2.3.4:53", Data: []byte("hi")}
+	b := m.Marshal()
+	want := append(mustHex(t, "01020304"+"0005"+"00"+"01"+"0a"), "1.2.3.4:53hi"...)
+	if !bytes.Equal(b, want) {
+		t.Fatalf("Marshal = %x, want %x", b, want)
+	}
+	if m.HeaderSize() != len(b)-len(m.Data) {
+		t.Fatalf("HeaderSize = %d, want %d", m.HeaderSize(), len(b)-len(m.Data))
+	}
+
+	out, err := ParseHysteria2UDPMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.SessionID != m.SessionID || out.PacketID != m.PacketID || out.FragID != m.FragID ||
+		out.FragCount != m.FragCount || out.Addr != m.Addr || !bytes.Equal(out.Data, m.Data) {
+		t.Fatalf("round trip = %+v, want %+v", out, m)
+	}
+
+	for _, bad := range [][]byte{b[:7], b[:8+1+3], append(mustHex(t, "0102030400050001"), 0)} {
+		if _, err := ParseHysteria2UDPMessage(bad); err == nil {
+			t.Errorf("ParseHysteria2UDPMessage(%x) succeeded", bad)
+		}
+	}
+}
+
+// hysteria2Response 构造 TCPResponse: Status(1) + MsgLen + Msg + PaddingLen + Padding
+func hysteria2Response(status byte, msg, padding string) []byte {
+	b := quicvarint.Append([]byte{status}, uint64(len(msg)))
+	b = append(b, msg...)
+	b = quicvarint.Append(b, uint64(len(padding)))
+	return append(b, padding...)
+}
+
+func TestHysteria2ConnResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := NewHysteria2Conn(client)
+	go func() {
+		server.Write(append(hysteria2Response(0, "ok", "abc"), "data"...))
+		server.Close()
+	}()
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "data" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	client, server = net.Pipe()
+	defer server.Close()
+	conn = NewHysteria2Conn(client)
+	go server.Write(hysteria2Response(1, "denied", ""))
+	if _, err := conn.Read(make([]byte, 16)); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("err = %v, want rejection", err)
+	}
+}
@@ -26,10 +26,42 @@ func NewMandalaClient(username, password string) *MandalaClient {
 	}
 }
 
+// Mandala 指令
+const (
+	MandalaCmdConnect   = 0x01
+	MandalaCmdAssociate = 0x03 // UDP，数据报分帧见 NewMandalaPacketConn
+)
+
+// NewMandalaPacketConn 在已发送 UDP 握手 (指令 0x03) 的连接上收发数据报
+//
+// 握手包之后的字节流不再加密，由连续的数据报帧组成，上下行格式相同:
+//
+//	+------+----------+----------+--------+---------+----------+
+//	| ATYP | DST.ADDR | DST.PORT | Length |  CRLF   | Payload  |
+//	+------+----------+----------+--------+---------+----------+
+//	|  1   | Variable |    2     |   2    | 0x0D0A  | Length   |
+//	+------+----------+----------+--------+---------+----------+
+//
+// ATYP/DST.ADDR 与 SOCKS5 相同 (0x01 IPv4 / 0x03 域名 / 0x04 IPv6)，端口与长度均为大端序。
+// 上行帧中为数据报目标地址，下行帧中为来源地址；每帧承载一个完整数据报，
+// 即与 Trojan UDP 的分帧一致，因此复用 TrojanPacketConn
+func NewMandalaPacketConn(c net.Conn, targetHost string, targetPort int) (*TrojanPacketConn, error) {
+	return NewTrojanPacketConn(c, targetHost, targetPort)
+}
+
 // BuildHandshakePayload 构造 Mandala 协议的握手包
 // [修改] 增加 useNoise 参数，用于控制是否启用长随机填充
 func (c *MandalaClient) BuildHandshakePayload(targetHost string, targetPort int, useNoise bool) ([]byte, error) {
-	log.Printf("[Mandala] 开始构造握手包 -> %s:%d", targetHost, targetPort)
+	return c.buildPayload(MandalaCmdConnect, targetHost, targetPort, useNoise)
+}
+
+// BuildUDPHandshakePayload 构造 Mandala UDP 握手包 (指令 0x03)
+func (c *MandalaClient) BuildUDPHandshakePayload(targetHost string, targetPort int, useNoise bool) ([]byte, error) {
+	return c.buildPayload(MandalaCmdAssociate, targetHost, targetPort, useNoise)
+}
+
+func (c *MandalaClient) buildPayload(cmd byte, targetHost string, targetPort int, useNoise bool) ([]byte, error) {
+	log.Printf("[Mandala] 开始构造握手包 -> %s:%d (CMD: %d)", targetHost, targetPort, cmd)
 
 	// 1. 生成随机 Salt (4 bytes)
 	salt := make([]byte, 4)
@@ -76,8 +108,8 @@ func (c *MandalaClient) BuildHandshakePayload(targetHost string, targetPort int,
 	}
 	log.Printf("[Mandala] 添加随机填充长度: %d (Noise: %v)", padLen, useNoise)
 
-	// 2.3 指令 CMD (0x01 Connect)
-	buf.WriteByte(0x01)
+	// 2.3 指令 CMD (0x01 Connect / 0x03 UDP)
+	buf.WriteByte(cmd)
 
 	// 2.4 目标地址 (SOCKS5 格式)
 	ip := net.ParseIP(targetHost)
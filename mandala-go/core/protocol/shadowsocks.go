@@ -1,19 +1,256 @@
 package protocol
 
-import "log"
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"mandala/core/logger"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
 
 // BuildShadowsocksPayload 构造 Shadowsocks 握手包
 // 在 Mandala 架构中，Shadowsocks over TLS/WebSocket 只需要发送标准 SOCKS5 格式的目标地址
 // 格式: [ATYP][ADDR][PORT]
+// 启用 AEAD 加密时，该地址作为 ShadowsocksConn 的首个明文写入
 func BuildShadowsocksPayload(targetHost string, targetPort int) ([]byte, error) {
-	log.Printf("[Shadowsocks] 构造地址 Payload: %s:%d", targetHost, targetPort)
-	
+	logger.Debugf("Shadowsocks", "构造地址 Payload: %s:%d", targetHost, targetPort)
+
 	// 直接复用 utils.go 中的 ToSocksAddr，它生成的正是 SS 需要的格式
 	addr, err := ToSocksAddr(targetHost, targetPort)
 	if err != nil {
-		log.Printf("[Shadowsocks] 地址转换失败: %v", err)
+		logger.Warnf("Shadowsocks", "地址转换失败: %v", err)
 		return nil, err
 	}
-	
+
 	return addr, nil
 }
+
+// ssMaxPayload AEAD 分块的最大明文长度 (规范要求 0x3FFF)
+const ssMaxPayload = 0x3FFF
+
+// ssCipher 描述一种 AEAD 加密方法
+type ssCipher struct {
+	keySize int
+	newAEAD func(key []byte) (cipher.AEAD, error)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var ssCiphers = map[string]ssCipher{
+	"aes-128-gcm":            {16, newAESGCM},
+	"aes-256-gcm":            {32, newAESGCM},
+	"chacha20-ietf-poly1305": {32, chacha20poly1305.New},
+	"chacha20-poly1305":      {32, chacha20poly1305.New},
+}
+
+// IsShadowsocksPlain 判断是否为不加密模式 (仅依赖外层 TLS/WebSocket 保护)
+func IsShadowsocksPlain(method string) bool {
+	switch strings.ToLower(method) {
+	case "", "none", "plain":
+		return true
+	}
+	return false
+}
+
+// ShadowsocksConn 实现 Shadowsocks AEAD 流加密
+// 每个方向: [salt][encrypted len + tag][encrypted payload + tag]...
+// 子密钥 = HKDF-SHA1(主密钥, salt, "ss-subkey")，nonce 为小端递增计数器
+type ShadowsocksConn struct {
+	net.Conn
+	cipher ssCipher
+	key    []byte
+
+	enc      cipher.AEAD
+	encNonce []byte
+
+	dec      cipher.AEAD
+	decNonce []byte
+	readBuf  []byte
+	leftover []byte
+}
+
+// NewShadowsocksConn 创建加密连接，method 为空/none 时应直接使用原连接
+func NewShadowsocksConn(c net.Conn, method, password string) (*ShadowsocksConn, error) {
+	ci, ok := ssCiphers[strings.ToLower(method)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported shadowsocks method: %s", method)
+	}
+	logger.Debugf("Shadowsocks", "启用 AEAD 加密: %s", method)
+	return &ShadowsocksConn{
+		Conn:   c,
+		cipher: ci,
+		key:    evpBytesToKey(password, ci.keySize),
+	}, nil
+}
+
+// WrapShadowsocks 按加密方法包装连接；不加密模式下原样返回
+// 出错时仍返回原连接，便于调用方统一关闭
+func WrapShadowsocks(c net.Conn, method, password string) (net.Conn, error) {
+	if IsShadowsocksPlain(method) {
+		return c, nil
+	}
+	// [新增] Shadowsocks 2022: 预共享密钥 + BLAKE3 子密钥，请求/响应头带时间戳
+	if IsShadowsocks2022(method) {
+		ss2022Conn, err := NewShadowsocks2022Conn(c, method, password)
+		if err != nil {
+			return c, err
+		}
+		return ss2022Conn, nil
+	}
+	ssConn, err := NewShadowsocksConn(c, method, password)
+	if err != nil {
+		return c, err
+	}
+	return ssConn, nil
+}
+
+// evpBytesToKey 兼容 OpenSSL EVP_BytesToKey (MD5) 的主密钥派生
+func evpBytesToKey(password string, keyLen int) []byte {
+	var key, prev []byte
+	for len(key) < keyLen {
+		h := md5.New()
+		h.Write(prev)
+		h.Write([]byte(password))
+		prev = h.Sum(nil)
+		key = append(key, prev...)
+	}
+	return key[:keyLen]
+}
+
+// ssSubkey 派生会话子密钥
+func ssSubkey(key, salt []byte) ([]byte, error) {
+	subkey := make([]byte, len(key))
+	r := hkdf.New(sha1.New, key, salt, []byte("ss-subkey"))
+	if _, err := io.ReadFull(r, subkey); err != nil {
+		return nil, err
+	}
+	return subkey, nil
+}
+
+// incNonce 小端递增 nonce
+func incNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+func (c *ShadowsocksConn) Write(b []byte) (int, error) {
+	var out []byte
+
+	if c.enc == nil {
+		salt := make([]byte, c.cipher.keySize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return 0, err
+		}
+		subkey, err := ssSubkey(c.key, salt)
+		if err != nil {
+			return 0, err
+		}
+		aead, err := c.cipher.newAEAD(subkey)
+		if err != nil {
+			return 0, err
+		}
+		c.enc = aead
+		c.encNonce = make([]byte, aead.NonceSize())
+		out = append(out, salt...)
+	}
+
+	for p := b; len(p) > 0; {
+		n := len(p)
+		if n > ssMaxPayload {
+			n = ssMaxPayload
+		}
+
+		lenBuf := []byte{byte(n >> 8), byte(n)}
+		out = c.enc.Seal(out, c.encNonce, lenBuf, nil)
+		incNonce(c.encNonce)
+
+		out = c.enc.Seal(out, c.encNonce, p[:n], nil)
+		incNonce(c.encNonce)
+
+		p = p[n:]
+	}
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *ShadowsocksConn) Read(b []byte) (int, error) {
+	if len(c.leftover) > 0 {
+		n := copy(b, c.leftover)
+		c.leftover = c.leftover[n:]
+		return n, nil
+	}
+
+	if c.dec == nil {
+		salt := make([]byte, c.cipher.keySize)
+		if _, err := io.ReadFull(c.Conn, salt); err != nil {
+			return 0, err
+		}
+		subkey, err := ssSubkey(c.key, salt)
+		if err != nil {
+			return 0, err
+		}
+		aead, err := c.cipher.newAEAD(subkey)
+		if err != nil {
+			return 0, err
+		}
+		c.dec = aead
+		c.decNonce = make([]byte, aead.NonceSize())
+		c.readBuf = make([]byte, ssMaxPayload+aead.Overhead())
+	}
+
+	overhead := c.dec.Overhead()
+
+	// 1. 读取并解密长度
+	lenBuf := c.readBuf[:2+overhead]
+	if _, err := io.ReadFull(c.Conn, lenBuf); err != nil {
+		return 0, err
+	}
+	plainLen, err := c.dec.Open(lenBuf[:0], c.decNonce, lenBuf, nil)
+	if err != nil {
+		return 0, errors.New("shadowsocks: length decryption failed")
+	}
+	incNonce(c.decNonce)
+
+	size := int(binary.BigEndian.Uint16(plainLen)) & ssMaxPayload
+
+	// 2. 读取并解密数据
+	payload := c.readBuf[:size+overhead]
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return 0, err
+	}
+	plain, err := c.dec.Open(payload[:0], c.decNonce, payload, nil)
+	if err != nil {
+		return 0, errors.New("shadowsocks: payload decryption failed")
+	}
+	incNonce(c.decNonce)
+
+	n := copy(b, plain)
+	if n < len(plain) {
+		c.leftover = plain[n:]
+	}
+	return n, nil
+}
@@ -0,0 +1,310 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"mandala/core/logger"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+// Shadowsocks 2022 (SIP022) TCP 流
+// 请求: [salt][AEAD(固定头)][AEAD(可变头)][AEAD 分块...]
+//   固定头: type(1)=0 | timestamp(8, Unix 秒) | 可变头长度(2)
+//   可变头: 目标地址 | padding 长度(2) | padding | 首包数据
+// 响应: [salt][AEAD(固定头)][AEAD(首个数据块)][AEAD 分块...]
+//   固定头: type(1)=1 | timestamp(8) | 请求 salt | 首个数据块长度(2)
+// 主密钥为 base64 编码的预共享密钥，会话子密钥 = BLAKE3-DeriveKey("shadowsocks 2022 session subkey", 主密钥 || salt)
+
+const (
+	ss2022SubkeyContext = "shadowsocks 2022 session subkey"
+
+	ss2022TypeRequest  = 0
+	ss2022TypeResponse = 1
+
+	// ss2022MaxPayload 分块最大明文长度
+	ss2022MaxPayload = 0xFFFF
+	// ss2022MaxPadding 无首包数据时请求头填充的最大长度
+	ss2022MaxPadding = 900
+	// ss2022TimeWindow 服务端响应时间戳与本地时间的最大允许偏差，超出视为重放
+	ss2022TimeWindow = 30 * time.Second
+)
+
+var ss2022Ciphers = map[string]ssCipher{
+	"2022-blake3-aes-128-gcm":       {16, newAESGCM},
+	"2022-blake3-aes-256-gcm":       {32, newAESGCM},
+	"2022-blake3-chacha20-poly1305": {32, chacha20poly1305.New},
+}
+
+// IsShadowsocks2022 判断是否为 Shadowsocks 2022 加密方法
+func IsShadowsocks2022(method string) bool {
+	_, ok := ss2022Ciphers[strings.ToLower(method)]
+	return ok
+}
+
+// ss2022Now 当前时间 (测试中可替换)
+var ss2022Now = time.Now
+
+// Shadowsocks2022Conn 实现 Shadowsocks 2022 的 TCP 客户端流
+// 首次写入的数据须以 SOCKS5 格式的目标地址开头 (BuildShadowsocksPayload)，其后的数据作为首包随请求头发送
+type Shadowsocks2022Conn struct {
+	net.Conn
+	cipher ssCipher
+	psk    []byte
+
+	enc       cipher.AEAD
+	encNonce  []byte
+	reqSalt   []byte
+	requested chan struct{} // 请求头生成后关闭，读取方据此安全地访问 reqSalt
+
+	dec      cipher.AEAD
+	decNonce []byte
+	readBuf  []byte
+	leftover []byte
+}
+
+// NewShadowsocks2022Conn 创建 Shadowsocks 2022 连接，password 为 base64 编码的预共享密钥
+func NewShadowsocks2022Conn(c net.Conn, method, password string) (*Shadowsocks2022Conn, error) {
+	ci, ok := ss2022Ciphers[strings.ToLower(method)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported shadowsocks method: %s", method)
+	}
+	psk, err := ss2022ParsePSK(password, ci.keySize)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Shadowsocks", "启用 2022 加密: %s", method)
+	return &Shadowsocks2022Conn{Conn: c, cipher: ci, psk: psk, requested: make(chan struct{})}, nil
+}
+
+// ss2022ParsePSK 解码预共享密钥，长度须与加密方法的密钥长度一致
+// 多用户服务端的 "iPSK:uPSK" 形式需要额外的身份头，暂不支持
+func ss2022ParsePSK(password string, keySize int) ([]byte, error) {
+	if strings.Contains(password, ":") {
+		return nil, errors.New("shadowsocks 2022: multi-user PSK (identity header) is not supported")
+	}
+	psk, err := base64.StdEncoding.DecodeString(password)
+	if err != nil {
+		return nil, fmt.Errorf("shadowsocks 2022: password must be a base64 key: %v", err)
+	}
+	if len(psk) != keySize {
+		return nil, fmt.Errorf("shadowsocks 2022: key must be %d bytes, got %d", keySize, len(psk))
+	}
+	return psk, nil
+}
+
+// ss2022Subkey 派生会话子密钥
+func ss2022Subkey(psk, salt []byte) []byte {
+	material := make([]byte, 0, len(psk)+len(salt))
+	material = append(material, psk...)
+	material = append(material, salt...)
+	subkey := make([]byte, len(psk))
+	blake3.DeriveKey(subkey, ss2022SubkeyContext, material)
+	return subkey
+}
+
+// socksAddrLen 返回 b 开头的 SOCKS5 格式地址的长度
+func socksAddrLen(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, errors.New("socks address truncated")
+	}
+	var n int
+	switch b[0] {
+	case 0x01:
+		n = 1 + 4 + 2
+	case 0x04:
+		n = 1 + 16 + 2
+	case 0x03:
+		if len(b) < 2 {
+			return 0, errors.New("socks address truncated")
+		}
+		n = 2 + int(b[1]) + 2
+	default:
+		return 0, fmt.Errorf("invalid socks address type: 0x%02x", b[0])
+	}
+	if len(b) < n {
+		return 0, errors.New("socks address truncated")
+	}
+	return n, nil
+}
+
+// seal 加密一个 AEAD 块并递增 nonce
+func (c *Shadowsocks2022Conn) seal(out, plain []byte) []byte {
+	out = c.enc.Seal(out, c.encNonce, plain, nil)
+	incNonce(c.encNonce)
+	return out
+}
+
+// open 读取并解密一个长度为 size 的 AEAD 块
+func (c *Shadowsocks2022Conn) open(size int) ([]byte, error) {
+	buf := c.readBuf[:size+c.dec.Overhead()]
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return nil, err
+	}
+	plain, err := c.dec.Open(buf[:0], c.decNonce, buf, nil)
+	if err != nil {
+		return nil, errors.New("shadowsocks 2022: decryption failed")
+	}
+	incNonce(c.decNonce)
+	return plain, nil
+}
+
+// writeRequestHeader 生成 salt 与请求头，b 开头为目标地址；返回已放入请求头的 b 的长度
+func (c *Shadowsocks2022Conn) writeRequestHeader(out, b []byte) ([]byte, int, error) {
+	addrLen, err := socksAddrLen(b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	salt := make([]byte, c.cipher.keySize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, 0, err
+	}
+	aead, err := c.cipher.newAEAD(ss2022Subkey(c.psk, salt))
+	if err != nil {
+		return nil, 0, err
+	}
+	c.enc = aead
+	c.encNonce = make([]byte, aead.NonceSize())
+	c.reqSalt = salt
+	out = append(out, salt...)
+
+	// 可变头: 地址 | padding 长度 | padding | 首包数据 (超出长度上限的部分作为后续分块发送)
+	payload := b[addrLen:]
+	padding := 0
+	if len(payload) == 0 {
+		n, err := rand.Int(rand.Reader, big.NewInt(ss2022MaxPadding))
+		if err != nil {
+			return nil, 0, err
+		}
+		padding = int(n.Int64()) + 1
+	}
+	if limit := ss2022MaxPayload - addrLen - 2 - padding; len(payload) > limit {
+		payload = payload[:limit]
+	}
+	variable := make([]byte, 0, addrLen+2+padding+len(payload))
+	variable = append(variable, b[:addrLen]...)
+	variable = binary.BigEndian.AppendUint16(variable, uint16(padding))
+	variable = append(variable, make([]byte, padding)...)
+	variable = append(variable, payload...)
+
+	fixed := make([]byte, 0, 1+8+2)
+	fixed = append(fixed, ss2022TypeRequest)
+	fixed = binary.BigEndian.AppendUint64(fixed, uint64(ss2022Now().Unix()))
+	fixed = binary.BigEndian.AppendUint16(fixed, uint16(len(variable)))
+
+	out = c.seal(out, fixed)
+	out = c.seal(out, variable)
+	return out, addrLen + len(payload), nil
+}
+
+func (c *Shadowsocks2022Conn) Write(b []byte) (int, error) {
+	var out []byte
+	p := b
+
+	if c.enc == nil {
+		var used int
+		var err error
+		if out, used, err = c.writeRequestHeader(out, b); err != nil {
+			return 0, err
+		}
+		p = b[used:]
+		close(c.requested)
+	}
+
+	for len(p) > 0 {
+		n := len(p)
+		if n > ss2022MaxPayload {
+			n = ss2022MaxPayload
+		}
+		out = c.seal(out, []byte{byte(n >> 8), byte(n)})
+		out = c.seal(out, p[:n])
+		p = p[n:]
+	}
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// readResponseHeader 读取 salt 与响应头，校验时间戳与请求 salt，返回首个数据块
+func (c *Shadowsocks2022Conn) readResponseHeader() ([]byte, error) {
+	salt := make([]byte, c.cipher.keySize)
+	if _, err := io.ReadFull(c.Conn, salt); err != nil {
+		return nil, err
+	}
+	aead, err := c.cipher.newAEAD(ss2022Subkey(c.psk, salt))
+	if err != nil {
+		return nil, err
+	}
+	c.dec = aead
+	c.decNonce = make([]byte, aead.NonceSize())
+	c.readBuf = make([]byte, ss2022MaxPayload+aead.Overhead())
+
+	fixed, err := c.open(1 + 8 + c.cipher.keySize + 2)
+	if err != nil {
+		return nil, err
+	}
+	if fixed[0] != ss2022TypeResponse {
+		return nil, fmt.Errorf("shadowsocks 2022: unexpected header type %d", fixed[0])
+	}
+	ts := time.Unix(int64(binary.BigEndian.Uint64(fixed[1:9])), 0)
+	if diff := ss2022Now().Sub(ts); diff > ss2022TimeWindow || diff < -ss2022TimeWindow {
+		return nil, fmt.Errorf("shadowsocks 2022: response timestamp out of window (%s)", diff.Round(time.Second))
+	}
+	select {
+	case <-c.requested:
+	default:
+		return nil, errors.New("shadowsocks 2022: response before request")
+	}
+	if !bytes.Equal(fixed[9:9+c.cipher.keySize], c.reqSalt) {
+		return nil, errors.New("shadowsocks 2022: request salt mismatch")
+	}
+	size := int(binary.BigEndian.Uint16(fixed[9+c.cipher.keySize:]))
+	return c.open(size)
+}
+
+func (c *Shadowsocks2022Conn) Read(b []byte) (int, error) {
+	if len(c.leftover) > 0 {
+		n := copy(b, c.leftover)
+		c.leftover = c.leftover[n:]
+		return n, nil
+	}
+
+	var plain []byte
+	var err error
+	if c.dec == nil {
+		if plain, err = c.readResponseHeader(); err != nil {
+			return 0, err
+		}
+	}
+	// 响应头后的首个数据块可能为空，继续读取下一块
+	for len(plain) == 0 {
+		lenBuf, err := c.open(2)
+		if err != nil {
+			return 0, err
+		}
+		if plain, err = c.open(int(binary.BigEndian.Uint16(lenBuf))); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, plain)
+	if n < len(plain) {
+		c.leftover = plain[n:]
+	}
+	return n, nil
+}
@@ -0,0 +1,190 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 期望值由独立的 BLAKE3 参考实现计算 (DeriveKey，context 为 "shadowsocks 2022 session subkey")
+func TestShadowsocks2022Subkey(t *testing.T) {
+	for _, tc := range []struct {
+		psk, salt []byte
+		want      string
+	}{
+		{seq(0, 16), seq(16, 16), "bc32fb8d5205f7b84f9691dfb9f04ff3"},
+		{seq(0, 32), seq(32, 32), "374fca03e4dae7f998fd7e59c1edfcc8e3197f4db1c19ca1671be3b66a92ddda"},
+	} {
+		if got := ss2022Subkey(tc.psk, tc.salt); !bytes.Equal(got, mustHex(t, tc.want)) {
+			t.Errorf("ss2022Subkey(%d) = %x, want %s", len(tc.psk), got, tc.want)
+		}
+	}
+}
+
+func TestShadowsocks2022ParsePSK(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(seq(0, 16))
+	if _, err := ss2022ParsePSK(key, 16); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{key + ":" + key, "not base64!", base64.StdEncoding.EncodeToString(seq(0, 8))} {
+		if _, err := ss2022ParsePSK(bad, 16); err == nil {
+			t.Errorf("ss2022ParsePSK(%q) succeeded", bad)
+		}
+	}
+}
+
+// ss2022Peer 按 SIP022 独立实现的单方向 AEAD 流，nonce 为小端递增计数器
+type ss2022Peer struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func newSS2022Peer(psk, salt []byte) *ss2022Peer {
+	block, _ := aes.NewCipher(ss2022Subkey(psk, salt))
+	aead, _ := cipher.NewGCM(block)
+	return &ss2022Peer{aead: aead, nonce: make([]byte, aead.NonceSize())}
+}
+
+func (p *ss2022Peer) open(t *testing.T, r io.Reader, size int) []byte {
+	t.Helper()
+	buf := make([]byte, size+p.aead.Overhead())
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := p.aead.Open(nil, p.nonce, buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	incNonce(p.nonce)
+	return plain
+}
+
+func (p *ss2022Peer) seal(out, plain []byte) []byte {
+	out = p.aead.Seal(out, p.nonce, plain, nil)
+	incNonce(p.nonce)
+	return out
+}
+
+// ss2022Request 读取并解析客户端的请求头，返回请求 salt 与可变头中地址之后的首包数据
+func ss2022Request(t *testing.T, r io.Reader, psk []byte, now time.Time) (salt []byte, up *ss2022Peer, addr, payload []byte) {
+	t.Helper()
+	salt = make([]byte, len(psk))
+	if _, err := io.ReadFull(r, salt); err != nil {
+		t.Fatal(err)
+	}
+	up = newSS2022Peer(psk, salt)
+	fixed := up.open(t, r, 1+8+2)
+	if fixed[0] != ss2022TypeRequest {
+		t.Fatalf("request type = %d", fixed[0])
+	}
+	if ts := int64(binary.BigEndian.Uint64(fixed[1:9])); ts != now.Unix() {
+		t.Fatalf("request timestamp = %d, want %d", ts, now.Unix())
+	}
+	variable := up.open(t, r, int(binary.BigEndian.Uint16(fixed[9:])))
+	addrLen, err := socksAddrLen(variable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, rest := variable[:addrLen], variable[addrLen:]
+	padding := int(binary.BigEndian.Uint16(rest))
+	return salt, up, addr, rest[2+padding:]
+}
+
+func TestShadowsocks2022Stream(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ss2022Now = func() time.Time { return now }
+	defer func() { ss2022Now = time.Now }()
+
+	psk := seq(0, 16)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn, err := NewShadowsocks2022Conn(client, "2022-blake3-aes-128-gcm", base64.StdEncoding.EncodeToString(psk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, _ := BuildShadowsocksPayload("example.com", 443)
+	go func() {
+		conn.Write(append(append([]byte{}, target...), "first"...))
+		conn.Write([]byte("second"))
+	}()
+
+	reqSalt, up, addr, payload := ss2022Request(t, server, psk, now)
+	if !bytes.Equal(addr, target) || string(payload) != "first" {
+		t.Fatalf("request addr %x payload %q", addr, payload)
+	}
+	if size := up.open(t, server, 2); binary.BigEndian.Uint16(size) != 6 {
+		t.Fatalf("chunk length = %x", size)
+	}
+	if got := up.open(t, server, 6); string(got) != "second" {
+		t.Fatalf("chunk = %q", got)
+	}
+
+	// 响应: salt | 固定头 (type, timestamp, 请求 salt, 首块长度) | 首块 | 后续分块
+	respSalt := seq(200, 16)
+	down := newSS2022Peer(psk, respSalt)
+	fixed := []byte{ss2022TypeResponse}
+	fixed = binary.BigEndian.AppendUint64(fixed, uint64(now.Unix()))
+	fixed = append(fixed, reqSalt...)
+	fixed = binary.BigEndian.AppendUint16(fixed, 5)
+	resp := down.seal(append([]byte{}, respSalt...), fixed)
+	resp = down.seal(resp, []byte("hello"))
+	resp = down.seal(resp, []byte{0, 6})
+	resp = down.seal(resp, []byte(" world"))
+	go server.Write(resp)
+
+	got := make([]byte, len("hello world"))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "hello world" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+}
+
+// 响应头的时间戳超出窗口或请求 salt 不符时拒绝
+func TestShadowsocks2022RejectsResponse(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ss2022Now = func() time.Time { return now }
+	defer func() { ss2022Now = time.Now }()
+	psk := seq(0, 16)
+
+	for _, tc := range []struct {
+		name    string
+		ts      time.Time
+		badSalt bool
+		want    string
+	}{
+		{"stale timestamp", now.Add(-time.Minute), false, "timestamp"},
+		{"salt mismatch", now, true, "salt mismatch"},
+	} {
+		client, server := net.Pipe()
+		conn, _ := NewShadowsocks2022Conn(client, "2022-blake3-aes-128-gcm", base64.StdEncoding.EncodeToString(psk))
+		target, _ := BuildShadowsocksPayload("example.com", 443)
+		go conn.Write(target)
+		reqSalt, _, _, _ := ss2022Request(t, server, psk, now)
+		if tc.badSalt {
+			reqSalt = seq(1, 16)
+		}
+
+		respSalt := seq(200, 16)
+		down := newSS2022Peer(psk, respSalt)
+		fixed := []byte{ss2022TypeResponse}
+		fixed = binary.BigEndian.AppendUint64(fixed, uint64(tc.ts.Unix()))
+		fixed = append(fixed, reqSalt...)
+		fixed = binary.BigEndian.AppendUint16(fixed, 0)
+		resp := down.seal(append([]byte{}, respSalt...), fixed)
+		go server.Write(down.seal(resp, nil))
+
+		if _, err := conn.Read(make([]byte, 16)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func seq(start, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(start + i)
+	}
+	return b
+}
+
+// 期望值由独立实现 (Python hashlib / hmac) 计算
+func TestShadowsocksKeyDerivation(t *testing.T) {
+	for _, tc := range []struct {
+		keyLen      int
+		key, subkey string
+	}{
+		{16, "5f4dcc3b5aa765d61d8327deb882cf99", "ed2a618d9490d1701de885d82aa80616"},
+		{32, "5f4dcc3b5aa765d61d8327deb882cf992b95990a9151374abd8ff8c5a7a0fe08",
+			"ee187aed3f87574907a39db98606f60a526114831288097cac66054b33a9464f"},
+	} {
+		key := evpBytesToKey("password", tc.keyLen)
+		if !bytes.Equal(key, mustHex(t, tc.key)) {
+			t.Errorf("evpBytesToKey(%d) = %x, want %s", tc.keyLen, key, tc.key)
+		}
+		subkey, err := ssSubkey(key, seq(0, tc.keyLen))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(subkey, mustHex(t, tc.subkey)) {
+			t.Errorf("ssSubkey(%d) = %x, want %s", tc.keyLen, subkey, tc.subkey)
+		}
+	}
+}
+
+// ssPeer 按规范独立实现的对端: [salt][AEAD(长度)][AEAD(数据)]...，nonce 为小端递增计数器
+type ssPeer struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func newSSPeer(t *testing.T, key, salt []byte) *ssPeer {
+	t.Helper()
+	subkey, err := ssSubkey(key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(subkey)
+	aead, _ := cipher.NewGCM(block)
+	return &ssPeer{aead: aead, nonce: make([]byte, aead.NonceSize())}
+}
+
+func (p *ssPeer) next() []byte {
+	n := append([]byte{}, p.nonce...)
+	incNonce(p.nonce)
+	return n
+}
+
+func (p *ssPeer) readChunk(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	lenBuf := make([]byte, 2+p.aead.Overhead())
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		t.Fatal(err)
+	}
+	plainLen, err := p.aead.Open(nil, p.next(), lenBuf, nil)
+	if err != nil {
+		t.Fatal("length chunk:", err)
+	}
+	payload := make([]byte, int(binary.BigEndian.Uint16(plainLen))+p.aead.Overhead())
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := p.aead.Open(nil, p.next(), payload, nil)
+	if err != nil {
+		t.Fatal("payload chunk:", err)
+	}
+	return plain
+}
+
+func (p *ssPeer) sealChunk(out, plain []byte) []byte {
+	out = p.aead.Seal(out, p.next(), []byte{byte(len(plain) >> 8), byte(len(plain))}, nil)
+	return p.aead.Seal(out, p.next(), plain, nil)
+}
+
+func TestShadowsocksConnFraming(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn, err := NewShadowsocksConn(client, "aes-128-gcm", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := evpBytesToKey("password", 16)
+
+	// 超过单块上限的写入拆分为多个块
+	big := bytes.Repeat([]byte("x"), ssMaxPayload+10)
+	go conn.Write(big)
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(server, salt); err != nil {
+		t.Fatal(err)
+	}
+	up := newSSPeer(t, key, salt)
+	if got := up.readChunk(t, server); len(got) != ssMaxPayload {
+		t.Fatalf("first chunk = %d bytes, want %d", len(got), ssMaxPayload)
+	}
+	if got := up.readChunk(t, server); len(got) != 10 {
+		t.Fatalf("second chunk = %d bytes, want 10", len(got))
+	}
+
+	respSalt := seq(100, 16)
+	down := newSSPeer(t, key, respSalt)
+	resp := down.sealChunk(append([]byte{}, respSalt...), []byte("hello "))
+	resp = down.sealChunk(resp, []byte("world"))
+	go server.Write(resp)
+
+	got := make([]byte, len("hello world"))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "hello world" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+}
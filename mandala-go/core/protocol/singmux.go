@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// sing-mux 会话目标地址：外层协议握手指向该地址时，服务端将连接作为多路复用会话处理
+const (
+	SingMuxHost = "sp.mux.sing-box.arpa"
+	SingMuxPort = 444
+)
+
+// sing-mux 会话头: Version(1) + Protocol(1)
+const (
+	singMuxVersion0      = 0x00
+	singMuxProtocolSmux  = 0x01
+	singMuxFlagUDP       = 0x01
+	singMuxStatusSuccess = 0x00
+)
+
+// BuildSingMuxSessionRequest 构造 sing-mux 会话头 (版本 0，smux)
+func BuildSingMuxSessionRequest() []byte {
+	return []byte{singMuxVersion0, singMuxProtocolSmux}
+}
+
+// BuildSingMuxStreamRequest 构造流请求头: Flags(2) + SOCKS5_ADDR
+func BuildSingMuxStreamRequest(udp bool, targetHost string, targetPort int) ([]byte, error) {
+	addr, err := ToSocksAddr(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	var flags uint16
+	if udp {
+		flags |= singMuxFlagUDP
+	}
+	buf := binary.BigEndian.AppendUint16(nil, flags)
+	return append(buf, addr...), nil
+}
+
+// SingMuxStreamConn 包装多路复用流，首次读取时校验服务端响应状态
+// 响应: Status(1)，失败时后跟 varint 长度的错误信息
+type SingMuxStreamConn struct {
+	net.Conn
+	statusRead bool
+}
+
+func NewSingMuxStreamConn(c net.Conn) *SingMuxStreamConn {
+	return &SingMuxStreamConn{Conn: c}
+}
+
+func (c *SingMuxStreamConn) Read(b []byte) (int, error) {
+	if !c.statusRead {
+		status := make([]byte, 1)
+		if _, err := io.ReadFull(c.Conn, status); err != nil {
+			return 0, err
+		}
+		if status[0] != singMuxStatusSuccess {
+			return 0, c.readError()
+		}
+		c.statusRead = true
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *SingMuxStreamConn) readError() error {
+	var msgLen uint64
+	buf := make([]byte, 1)
+	for shift := uint(0); shift < 64; shift += 7 {
+		if _, err := io.ReadFull(c.Conn, buf); err != nil {
+			return errors.New("sing-mux: stream rejected")
+		}
+		msgLen |= uint64(buf[0]&0x7f) << shift
+		if buf[0] < 0x80 {
+			break
+		}
+	}
+	if msgLen > 1024 {
+		return errors.New("sing-mux: stream rejected")
+	}
+	msg := make([]byte, msgLen)
+	io.ReadFull(c.Conn, msg)
+	return fmt.Errorf("sing-mux: stream rejected: %s", msg)
+}
+
+// SingMuxPacketConn 在 UDP 流上按 Len(2) + Data 分帧收发数据报
+type SingMuxPacketConn struct {
+	net.Conn
+	MaxPacketSize int
+}
+
+func NewSingMuxPacketConn(c net.Conn) *SingMuxPacketConn {
+	return &SingMuxPacketConn{Conn: c, MaxPacketSize: 65535}
+}
+
+func (c *SingMuxPacketConn) Write(b []byte) (int, error) {
+	if len(b) > 0xFFFF {
+		return 0, fmt.Errorf("sing-mux: packet too large: %d", len(b))
+	}
+	buf := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(b)), uint16(len(b)))
+	if _, err := c.Conn.Write(append(buf, b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *SingMuxPacketConn) Read(b []byte) (int, error) {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(c.Conn, lenBuf); err != nil {
+		return 0, err
+	}
+	length := int(binary.BigEndian.Uint16(lenBuf))
+	if length > c.MaxPacketSize {
+		return 0, fmt.Errorf("sing-mux: packet too large: %d", length)
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(c.Conn, packet); err != nil {
+		return 0, err
+	}
+	return copy(b, packet), nil
+}
@@ -3,15 +3,86 @@ package protocol
 import (
 	"fmt"
 	"io"
-	"log"
+	"net"
+	"strconv"
+
+	"mandala/core/logger"
+)
+
+// SOCKS5 请求命令
+const (
+	Socks5CmdConnect = 0x01
+	Socks5CmdBind    = 0x02
+	Socks5CmdUDP     = 0x03 // UDP ASSOCIATE
 )
 
 // HandshakeSocks5 执行 SOCKS5 客户端握手
 // 修改：强制密码认证模式（当存在用户名时，仅发送 0x02 方法，不发送 0x00）
 // [新增] 详细的流程日志记录
 func HandshakeSocks5(conn io.ReadWriter, username, password, targetHost string, targetPort int) error {
-	log.Printf("[Socks5] 开始握手: 目标=%s:%d, 用户名=%s", targetHost, targetPort, username)
+	logger.Debugf("Socks5", "开始握手: 目标=%s:%d, 用户名=%s", targetHost, targetPort, username)
+	if err := socks5Auth(conn, username, password); err != nil {
+		return err
+	}
+
+	// 4. 发送连接请求 (CONNECT CMD=0x01)
+	logger.Debugf("Socks5", "发送连接请求 (CMD=0x01) 到目标地址")
+	if err := writeSocks5Request(conn, Socks5CmdConnect, targetHost, targetPort); err != nil {
+		return err
+	}
+
+	// 5. 读取连接响应
+	if _, _, err := ReadSocks5Reply(conn); err != nil {
+		logger.Warnf("Socks5", "连接目标失败: %v", err)
+		return err
+	}
+
+	logger.Debugf("Socks5", "连接建立完成")
+	return nil
+}
+
+// [新增] Socks5Bind 请求上游服务端监听一个入站连接 (BIND CMD=0x02)
+// targetHost/targetPort 为预期连入的对端地址；返回第一次应答中服务端的监听地址，
+// 对端连入后的第二次应答由 ReadSocks5Reply 读取
+func Socks5Bind(conn io.ReadWriter, username, password, targetHost string, targetPort int) (string, int, error) {
+	logger.Debugf("Socks5", "开始 BIND: 对端=%s:%d, 用户名=%s", targetHost, targetPort, username)
+	if err := socks5Auth(conn, username, password); err != nil {
+		return "", 0, err
+	}
+	if err := writeSocks5Request(conn, Socks5CmdBind, targetHost, targetPort); err != nil {
+		return "", 0, err
+	}
+	return ReadSocks5Reply(conn)
+}
+
+// [新增] HandshakeSocks5UDP 请求上游建立 UDP 关联 (UDP ASSOCIATE CMD=0x03)，返回服务端的 UDP 中继地址 (host:port)
+// 关联在本控制连接关闭前有效；中继地址为未指定地址 (0.0.0.0 / ::) 时应改用服务端地址
+func HandshakeSocks5UDP(conn io.ReadWriter, username, password string) (string, error) {
+	logger.Debugf("Socks5", "开始 UDP 关联, 用户名=%s", username)
+	if err := socks5Auth(conn, username, password); err != nil {
+		return "", err
+	}
+	if err := writeSocks5Request(conn, Socks5CmdUDP, "0.0.0.0", 0); err != nil {
+		return "", err
+	}
+	host, port, err := ReadSocks5Reply(conn)
+	if err != nil {
+		logger.Warnf("Socks5", "UDP 关联失败: %v", err)
+		return "", err
+	}
+	logger.Debugf("Socks5", "UDP 中继地址: %s:%d", host, port)
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// [新增] Socks5UDPOverTCP 以 UDP over TCP 方式建立关联
+// 应答后数据报直接在本连接上按 Socks5UDPConn 格式分帧收发，需上游同样启用 udp_over_tcp
+func Socks5UDPOverTCP(conn io.ReadWriter, username, password string) error {
+	_, err := HandshakeSocks5UDP(conn, username, password)
+	return err
+}
 
+// socks5Auth 协商认证方法，需要时执行用户名/密码认证
+func socks5Auth(conn io.ReadWriter, username, password string) error {
 	// 1. 发送版本和支持的认证方法
 	var methods []byte
 	if username != "" {
@@ -20,7 +91,7 @@ func HandshakeSocks5(conn io.ReadWriter, username, password, targetHost string,
 		methods = []byte{0x00} // NO AUTHENTICATION REQUIRED
 	}
 	
-	log.Printf("[Socks5] 发送初始化包 (Methods: %v)", methods)
+	logger.Debugf("Socks5", "发送初始化包 (Methods: %v)", methods)
 	
 	initBuf := make([]byte, 2+len(methods))
 	initBuf[0] = 0x05 // Ver
@@ -42,11 +113,11 @@ func HandshakeSocks5(conn io.ReadWriter, username, password, targetHost string,
 	}
 
 	authMethod := resp[1]
-	log.Printf("[Socks5] 服务端选定认证方法: 0x%02x", authMethod)
+	logger.Debugf("Socks5", "服务端选定认证方法: 0x%02x", authMethod)
 
 	// 3. 根据选定的方法进行认证
 	if authMethod == 0x02 {
-		log.Printf("[Socks5] 执行用户名密码认证 (RFC 1929)...")
+		logger.Debugf("Socks5", "执行用户名密码认证 (RFC 1929)...")
 		uLen := len(username)
 		pLen := len(password)
 		if uLen > 255 || pLen > 255 {
@@ -73,63 +144,59 @@ func HandshakeSocks5(conn io.ReadWriter, username, password, targetHost string,
 		
 		// Status 0x00 表示成功
 		if authResp[1] != 0x00 {
-			log.Printf("[Socks5] 认证失败，状态码: 0x%02x", authResp[1])
+			logger.Warnf("Socks5", "认证失败，状态码: 0x%02x", authResp[1])
 			return fmt.Errorf("socks5 authentication failed (status: 0x%02x)", authResp[1])
 		}
-		log.Printf("[Socks5] 认证成功")
+		logger.Debugf("Socks5", "认证成功")
 
 	} else if authMethod == 0xFF {
-		log.Printf("[Socks5] 服务端拒绝了所有认证方法")
+		logger.Warnf("Socks5", "服务端拒绝了所有认证方法")
 		return fmt.Errorf("socks5 no acceptable methods (server rejected auth)")
 	} else if authMethod != 0x00 {
-		log.Printf("[Socks5] 不支持的认证方法: 0x%02x", authMethod)
+		logger.Warnf("Socks5", "不支持的认证方法: 0x%02x", authMethod)
 		return fmt.Errorf("socks5 unsupported auth method selected: 0x%02x", authMethod)
 	}
+	return nil
+}
 
-	// 4. 发送连接请求 (CONNECT CMD=0x01)
-	log.Printf("[Socks5] 发送连接请求 (CMD=0x01) 到目标地址")
-	head := []byte{0x05, 0x01, 0x00}
-	addr, err := ToSocksAddr(targetHost, targetPort) 
+// writeSocks5Request 发送请求: [Ver] [Cmd] [Rsv] [Addr...]
+func writeSocks5Request(conn io.Writer, cmd byte, targetHost string, targetPort int) error {
+	addr, err := ToSocksAddr(targetHost, targetPort)
 	if err != nil {
 		return err
 	}
-	
-	if _, err := conn.Write(append(head, addr...)); err != nil {
-		return fmt.Errorf("socks5 connect write failed: %v", err)
+	if _, err := conn.Write(append([]byte{0x05, cmd, 0x00}, addr...)); err != nil {
+		return fmt.Errorf("socks5 request write failed: %v", err)
 	}
+	return nil
+}
 
-	// 5. 读取连接响应
-	connRespHead := make([]byte, 4)
-	if _, err := io.ReadFull(conn, connRespHead); err != nil {
-		return fmt.Errorf("socks5 connect resp header read failed: %v", err)
+// ReadSocks5Reply 读取一个 SOCKS5 应答并返回其中的 BND.ADDR / BND.PORT
+// REP 非 0 时返回 *Socks5ReplyError
+func ReadSocks5Reply(conn io.Reader) (string, int, error) {
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return "", 0, fmt.Errorf("socks5 resp header read failed: %v", err)
+	}
+	if head[0] != 0x05 {
+		return "", 0, fmt.Errorf("socks5 invalid version: %d", head[0])
 	}
-
 	// REP 字段: 0x00 表示成功
-	if connRespHead[1] != 0x00 {
-		log.Printf("[Socks5] 连接目标失败，错误码: 0x%02x", connRespHead[1])
-		return fmt.Errorf("socks5 connect failed with error: 0x%02x", connRespHead[1])
-	}
-
-	// 读取剩余的 BND.ADDR 和 BND.PORT
-	var left int
-	switch connRespHead[3] {
-	case 0x01: left = 4 + 2 // IPv4
-	case 0x04: left = 16 + 2 // IPv6
-	case 0x03: // Domain
-		lenByte := make([]byte, 1)
-		if _, err := io.ReadFull(conn, lenByte); err != nil {
-			return fmt.Errorf("socks5 read domain len failed: %v", err)
-		}
-		left = int(lenByte[0]) + 2
-	default:
-		return fmt.Errorf("socks5 invalid address type in response: 0x%02x", connRespHead[3])
+	if head[1] != 0x00 {
+		return "", 0, &Socks5ReplyError{Rep: head[1]}
 	}
-
-	discard := make([]byte, left)
-	if _, err := io.ReadFull(conn, discard); err != nil {
-		return fmt.Errorf("socks5 connect resp body read failed: %v", err)
+	host, port, err := ReadSocksAddr(conn)
+	if err != nil {
+		return "", 0, fmt.Errorf("socks5 resp address read failed: %v", err)
 	}
+	return host, port, nil
+}
 
-	log.Printf("[Socks5] 连接建立完成")
-	return nil
+// Socks5ReplyError 服务端返回的失败应答
+type Socks5ReplyError struct {
+	Rep byte
+}
+
+func (e *Socks5ReplyError) Error() string {
+	return fmt.Sprintf("socks5 request failed with error: 0x%02x", e.Rep)
 }
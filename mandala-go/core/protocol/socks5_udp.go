@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Socks5UDPConn 在 SOCKS5 的 TCP 控制连接上收发 UDP 数据报 (UDP over TCP，用于封锁 UDP 的网络)
+// 每个数据报格式: [Length(2)][ATYP][ADDR][PORT][Payload]，Length 为地址与载荷的总长度
+// Read 每次返回一个完整数据报；Write 每次发送一个数据报，目标地址固定为创建时指定的地址
+// (WritePacket / ReadPacket 可逐包指定目标并获取来源地址，可并发调用)
+type Socks5UDPConn struct {
+	net.Conn
+	target  []byte
+	writeMu sync.Mutex
+
+	// MaxPacketSize 允许读取的最大数据报长度，0 表示不限制 (受 2 字节长度字段约束)
+	MaxPacketSize int
+}
+
+// NewSocks5UDPConn 创建 UDP over TCP 数据报连接
+func NewSocks5UDPConn(c net.Conn, targetHost string, targetPort int) (*Socks5UDPConn, error) {
+	addr, err := ToSocksAddr(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	return &Socks5UDPConn{Conn: c, target: addr}, nil
+}
+
+func (c *Socks5UDPConn) Write(b []byte) (int, error) {
+	return c.writePacket(b, c.target)
+}
+
+// WritePacket 发送一个数据报到指定目标
+func (c *Socks5UDPConn) WritePacket(b []byte, host string, port int) (int, error) {
+	addr, err := ToSocksAddr(host, port)
+	if err != nil {
+		return 0, err
+	}
+	return c.writePacket(b, addr)
+}
+
+func (c *Socks5UDPConn) writePacket(b, addr []byte) (int, error) {
+	if len(addr)+len(b) > 0xFFFF {
+		return 0, fmt.Errorf("socks5 udp packet too large: %d", len(b))
+	}
+
+	buf := make([]byte, 0, 2+len(addr)+len(b))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(addr)+len(b)))
+	buf = append(buf, addr...)
+	buf = append(buf, b...)
+
+	// 多个会话的回包可能同时写入，整帧一次写出
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *Socks5UDPConn) Read(b []byte) (int, error) {
+	n, _, _, err := c.ReadPacket(b)
+	return n, err
+}
+
+// ReadPacket 读取一个数据报并返回其地址 (入站为目标地址，出站为来源地址)；缓冲区不足时截断
+func (c *Socks5UDPConn) ReadPacket(b []byte) (int, string, int, error) {
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(c.Conn, lenBuf); err != nil {
+		return 0, "", 0, err
+	}
+	length := int(binary.BigEndian.Uint16(lenBuf))
+	// 地址最长 1+1+255+2 字节
+	if c.MaxPacketSize > 0 && length > c.MaxPacketSize+259 {
+		return 0, "", 0, fmt.Errorf("socks5 udp packet exceeds limit: %d > %d", length, c.MaxPacketSize)
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(c.Conn, frame); err != nil {
+		return 0, "", 0, err
+	}
+	r := bytes.NewReader(frame)
+	host, port, err := ReadSocksAddr(r)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	return copy(b, frame[length-r.Len():]), host, port, nil
+}
+
+// [新增] Socks5PacketConn 经 SOCKS5 服务端的 UDP 中继收发数据报 (RFC 1928 第 7 节)
+// 每个数据报格式: [RSV(2)][FRAG(1)][ATYP][ADDR][PORT][Payload]；不发送分片，收到的分片数据报直接丢弃。
+// 嵌入的连接为已连接到中继地址的 UDP 套接字；关联随 TCP 控制连接结束，Close 时一并关闭
+type Socks5PacketConn struct {
+	net.Conn
+	control net.Conn
+	target  []byte
+}
+
+// NewSocks5PacketConn 创建 SOCKS5 UDP 中继数据报连接；控制连接被服务端关闭时同时关闭 UDP 套接字
+func NewSocks5PacketConn(udpConn, control net.Conn, targetHost string, targetPort int) (*Socks5PacketConn, error) {
+	addr, err := ToSocksAddr(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	c := &Socks5PacketConn{Conn: udpConn, control: control, target: addr}
+	go func() {
+		io.Copy(io.Discard, control)
+		udpConn.Close()
+	}()
+	return c, nil
+}
+
+func (c *Socks5PacketConn) Write(b []byte) (int, error) {
+	return c.writePacket(b, c.target)
+}
+
+// WritePacket 发送一个数据报到指定目标
+func (c *Socks5PacketConn) WritePacket(b []byte, host string, port int) (int, error) {
+	addr, err := ToSocksAddr(host, port)
+	if err != nil {
+		return 0, err
+	}
+	return c.writePacket(b, addr)
+}
+
+func (c *Socks5PacketConn) writePacket(b, addr []byte) (int, error) {
+	buf := make([]byte, 0, 3+len(addr)+len(b))
+	buf = append(buf, 0x00, 0x00, 0x00)
+	buf = append(buf, addr...)
+	buf = append(buf, b...)
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *Socks5PacketConn) Read(b []byte) (int, error) {
+	n, _, _, err := c.ReadPacket(b)
+	return n, err
+}
+
+// ReadPacket 读取一个数据报并返回其来源地址；缓冲区需能容纳 SOCKS5 头与载荷，否则载荷被截断
+func (c *Socks5PacketConn) ReadPacket(b []byte) (int, string, int, error) {
+	for {
+		n, err := c.Conn.Read(b)
+		if err != nil {
+			return 0, "", 0, err
+		}
+		if n < 4 || b[2] != 0x00 {
+			continue
+		}
+		r := bytes.NewReader(b[3:n])
+		host, port, err := ReadSocksAddr(r)
+		if err != nil {
+			continue
+		}
+		return copy(b, b[n-r.Len():n]), host, port, nil
+	}
+}
+
+func (c *Socks5PacketConn) Close() error {
+	c.control.Close()
+	return c.Conn.Close()
+}
@@ -5,20 +5,48 @@ import (
 	"log"
 )
 
+// Trojan 指令
+const (
+	TrojanCmdConnect   = 0x01
+	TrojanCmdAssociate = 0x03 // UDP
+	TrojanCmdMux       = 0x7f // Trojan-Go 多路复用
+)
+
+// trojanMuxAddr Trojan-Go 多路复用连接使用的占位地址
+const trojanMuxAddr = "MUX_CONN"
+
 // BuildTrojanPayload 构造标准 Trojan 握手包
 // 结构: Hash(pass) + CRLF + CMD(1) + SOCKS5_ADDR + CRLF
 func BuildTrojanPayload(password, targetHost string, targetPort int) ([]byte, error) {
 	log.Printf("[Trojan] 正在构造握手包 -> %s:%d", targetHost, targetPort)
+	return buildTrojanRequest(password, TrojanCmdConnect, targetHost, targetPort)
+}
+
+// BuildTrojanUDPPayload 构造 Trojan UDP 握手包 (指令 0x03)
+// 之后的数据按 Trojan UDP 格式分帧，见 TrojanPacketConn
+func BuildTrojanUDPPayload(password, targetHost string, targetPort int) ([]byte, error) {
+	log.Printf("[Trojan] 正在构造 UDP 握手包 -> %s:%d", targetHost, targetPort)
+	return buildTrojanRequest(password, TrojanCmdAssociate, targetHost, targetPort)
+}
+
+// BuildTrojanMuxPayload 构造 Trojan-Go 多路复用握手包
+// 指令为 0x7f，地址固定为 MUX_CONN:0，之后的数据为 smux 帧
+func BuildTrojanMuxPayload(password string) ([]byte, error) {
+	log.Printf("[Trojan] 正在构造多路复用握手包")
+	return buildTrojanRequest(password, TrojanCmdMux, trojanMuxAddr, 0)
+}
+
+func buildTrojanRequest(password string, cmd byte, targetHost string, targetPort int) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// 1. 密码哈希
 	passHash := TrojanPasswordHash(password)
 	buf.WriteString(passHash)
-	buf.Write([]byte{0x0D, 0x0A}) 
+	buf.Write([]byte{0x0D, 0x0A})
 	log.Printf("[Trojan] 密码哈希已写入")
 
-	// 2. 指令 (0x01 Connect)
-	buf.WriteByte(0x01)
+	// 2. 指令
+	buf.WriteByte(cmd)
 
 	// 3. 目标地址
 	addr, err := ToSocksAddr(targetHost, targetPort)
@@ -34,3 +62,13 @@ func BuildTrojanPayload(password, targetHost string, targetPort int) ([]byte, er
 	log.Printf("[Trojan] 握手包构造成功")
 	return buf.Bytes(), nil
 }
+
+// BuildSimpleSocksPayload 构造 Trojan-Go 多路复用流内的请求头 (simplesocks)
+// 结构: CMD(1) + SOCKS5_ADDR，CMD 为 0x01(TCP) 或 0x03(UDP)
+func BuildSimpleSocksPayload(cmd byte, targetHost string, targetPort int) ([]byte, error) {
+	addr, err := ToSocksAddr(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{cmd}, addr...), nil
+}
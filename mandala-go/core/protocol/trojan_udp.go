@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// TrojanPacketConn 在流式连接上按 Trojan UDP 格式收发数据报，恢复包边界
+// 每个数据报格式: [ATYP][ADDR][PORT][Length(2)][CRLF][Payload]
+// Read 每次返回一个完整数据报；Write 每次发送一个数据报，目标地址固定为创建时指定的地址
+// (WritePacket / ReadPacket 可逐包指定目标并获取来源地址)
+type TrojanPacketConn struct {
+	net.Conn
+	target []byte
+
+	// MaxPacketSize 允许读取的最大数据报长度，0 表示不限制 (受 2 字节长度字段约束)
+	MaxPacketSize int
+}
+
+// NewTrojanPacketConn 创建 Trojan UDP 数据报连接
+func NewTrojanPacketConn(c net.Conn, targetHost string, targetPort int) (*TrojanPacketConn, error) {
+	addr, err := ToSocksAddr(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	return &TrojanPacketConn{Conn: c, target: addr}, nil
+}
+
+func (c *TrojanPacketConn) Write(b []byte) (int, error) {
+	return c.writePacket(b, c.target)
+}
+
+// [新增] WritePacket 发送一个数据报到指定目标 (全锥形 NAT: 同一连接承载多个目标)
+func (c *TrojanPacketConn) WritePacket(b []byte, host string, port int) (int, error) {
+	addr, err := ToSocksAddr(host, port)
+	if err != nil {
+		return 0, err
+	}
+	return c.writePacket(b, addr)
+}
+
+func (c *TrojanPacketConn) writePacket(b, addr []byte) (int, error) {
+	if len(b) > 0xFFFF {
+		return 0, fmt.Errorf("trojan udp packet too large: %d", len(b))
+	}
+
+	buf := make([]byte, 0, len(addr)+4+len(b))
+	buf = append(buf, addr...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(b)))
+	buf = append(buf, 0x0D, 0x0A)
+	buf = append(buf, b...)
+
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *TrojanPacketConn) Read(b []byte) (int, error) {
+	// 来源地址固定为会话目标，这里只需跳过
+	n, _, _, err := c.ReadPacket(b)
+	return n, err
+}
+
+// [新增] ReadPacket 读取一个数据报并返回其来源地址
+func (c *TrojanPacketConn) ReadPacket(b []byte) (int, string, int, error) {
+	host, port, err := ReadSocksAddr(c.Conn)
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(c.Conn, head); err != nil {
+		return 0, "", 0, err
+	}
+	length := int(binary.BigEndian.Uint16(head[:2]))
+	if c.MaxPacketSize > 0 && length > c.MaxPacketSize {
+		return 0, "", 0, fmt.Errorf("trojan udp packet exceeds limit: %d > %d", length, c.MaxPacketSize)
+	}
+
+	if length <= len(b) {
+		n, err := io.ReadFull(c.Conn, b[:length])
+		return n, host, port, err
+	}
+
+	// 缓冲区不足时截断，并丢弃剩余部分以保持流同步
+	n, err := io.ReadFull(c.Conn, b)
+	if err != nil {
+		return n, host, port, err
+	}
+	if _, err := io.CopyN(io.Discard, c.Conn, int64(length-n)); err != nil {
+		return n, host, port, err
+	}
+	return n, host, port, nil
+}
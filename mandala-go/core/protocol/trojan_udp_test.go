@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// 数据报格式: [ATYP][ADDR][PORT][Length(2)][CRLF][Payload]
+func TestTrojanPacketConnRoundTrip(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn, err := NewTrojanPacketConn(client, "1.2.3.4", 53)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn.Write([]byte("query"))
+		conn.WritePacket([]byte("hi"), "example.com", 443)
+	}()
+	want := append(mustHex(t, "01"+"01020304"+"0035"+"0005"+"0d0a"), "query"...)
+	want = append(want, mustHex(t, "03"+"0b"+"6578616d706c652e636f6d"+"01bb"+"0002"+"0d0a")...)
+	want = append(want, "hi"...)
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("frames = %x, want %x", got, want)
+	}
+
+	// 读取端恢复包边界与来源地址，缓冲区不足时截断并丢弃余下部分
+	go server.Write(want)
+	buf := make([]byte, 64)
+	n, host, port, err := conn.ReadPacket(buf)
+	if err != nil || string(buf[:n]) != "query" || host != "1.2.3.4" || port != 53 {
+		t.Fatalf("ReadPacket = %q %s:%d, %v", buf[:n], host, port, err)
+	}
+	n, err = conn.Read(buf[:1])
+	if err != nil || string(buf[:n]) != "h" {
+		t.Fatalf("truncated Read = %q, %v", buf[:n], err)
+	}
+
+	go server.Write(append(mustHex(t, "01"+"01020304"+"0035"+"0005"+"0d0a"), "query"...))
+	conn.MaxPacketSize = 4
+	if _, _, _, err := conn.ReadPacket(buf); err == nil {
+		t.Fatal("oversized packet accepted")
+	}
+}
@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// TUIC v5 指令 (每个指令以 Version(1) + Type(1) 开头)
+const (
+	TUICVersion = 0x05
+
+	TUICCmdAuthenticate = 0x00 // 单向流: UUID(16) + Token(32)
+	TUICCmdConnect      = 0x01 // 双向流: ADDR，之后为 TCP 数据
+	TUICCmdPacket       = 0x02 // 数据报或单向流: AssocID(2) + PktID(2) + FragTotal(1) + FragID(1) + Size(2) + ADDR + 数据
+	TUICCmdDissociate   = 0x03 // 单向流: AssocID(2)
+	TUICCmdHeartbeat    = 0x04 // 数据报，无附加数据
+)
+
+// TUIC 地址类型: ADDR = Type(1) + 地址 + Port(2)
+const (
+	tuicAddrDomain = 0x00
+	tuicAddrIPv4   = 0x01
+	tuicAddrIPv6   = 0x02
+	tuicAddrNone   = 0xFF // 仅用于分片数据报的后续分片
+)
+
+// TUICTokenLength 认证令牌长度
+const TUICTokenLength = 32
+
+// TUICPacketHeaderLen Packet 指令在地址之前的固定长度
+const TUICPacketHeaderLen = 2 + 2 + 2 + 1 + 1 + 2
+
+// BuildTUICAuthenticate 构造认证指令
+// token 由 TLS 导出密钥得到: ExportKeyingMaterial(label = UUID 原始字节, context = 密码, 32)
+func BuildTUICAuthenticate(uuidStr string, token []byte) ([]byte, error) {
+	uuid, err := ParseUUID(uuidStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(token) != TUICTokenLength {
+		return nil, fmt.Errorf("invalid tuic token length: %d", len(token))
+	}
+	buf := make([]byte, 0, 2+16+TUICTokenLength)
+	buf = append(buf, TUICVersion, TUICCmdAuthenticate)
+	buf = append(buf, uuid...)
+	return append(buf, token...), nil
+}
+
+// BuildTUICConnect 构造 TCP 中继指令，服务端不回复，随后直接转发数据
+func BuildTUICConnect(targetHost string, targetPort int) ([]byte, error) {
+	addr, err := TUICAddr(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{TUICVersion, TUICCmdConnect}, addr...), nil
+}
+
+// BuildTUICDissociate 构造释放 UDP 会话的指令
+func BuildTUICDissociate(assocID uint16) []byte {
+	return binary.BigEndian.AppendUint16([]byte{TUICVersion, TUICCmdDissociate}, assocID)
+}
+
+// BuildTUICHeartbeat 构造心跳指令
+func BuildTUICHeartbeat() []byte {
+	return []byte{TUICVersion, TUICCmdHeartbeat}
+}
+
+// TUICAddr 编码 TUIC 地址
+func TUICAddr(host string, port int) ([]byte, error) {
+	if port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port: %d", port)
+	}
+	var buf []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append([]byte{tuicAddrIPv4}, ip4...)
+		} else {
+			buf = append([]byte{tuicAddrIPv6}, ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("invalid domain name: %q", host)
+		}
+		buf = append([]byte{tuicAddrDomain, byte(len(host))}, host...)
+	}
+	return binary.BigEndian.AppendUint16(buf, uint16(port)), nil
+}
+
+// TUICPacket 一个 UDP 数据报分片
+type TUICPacket struct {
+	AssocID   uint16
+	PktID     uint16
+	FragTotal uint8
+	FragID    uint8
+	Addr      []byte // 已编码的地址，后续分片为 None
+	Data      []byte
+}
+
+// Marshal 编码为 Packet 指令
+func (p *TUICPacket) Marshal() []byte {
+	addr := p.Addr
+	if len(addr) == 0 {
+		addr = []byte{tuicAddrNone}
+	}
+	buf := make([]byte, 0, TUICPacketHeaderLen+len(addr)+len(p.Data))
+	buf = append(buf, TUICVersion, TUICCmdPacket)
+	buf = binary.BigEndian.AppendUint16(buf, p.AssocID)
+	buf = binary.BigEndian.AppendUint16(buf, p.PktID)
+	buf = append(buf, p.FragTotal, p.FragID)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(p.Data)))
+	buf = append(buf, addr...)
+	return append(buf, p.Data...)
+}
+
+// ParseTUICPacket 解析 Packet 指令 (含 Version/Type 头)
+func ParseTUICPacket(b []byte) (*TUICPacket, error) {
+	if len(b) < TUICPacketHeaderLen+1 || b[0] != TUICVersion || b[1] != TUICCmdPacket {
+		return nil, errors.New("invalid tuic packet")
+	}
+	p := &TUICPacket{
+		AssocID:   binary.BigEndian.Uint16(b[2:]),
+		PktID:     binary.BigEndian.Uint16(b[4:]),
+		FragTotal: b[6],
+		FragID:    b[7],
+	}
+	size := int(binary.BigEndian.Uint16(b[8:]))
+	rest := b[TUICPacketHeaderLen:]
+
+	addrLen, err := tuicAddrLen(rest)
+	if err != nil {
+		return nil, err
+	}
+	p.Addr = rest[:addrLen]
+	rest = rest[addrLen:]
+	if len(rest) < size {
+		return nil, fmt.Errorf("tuic packet truncated: %d < %d", len(rest), size)
+	}
+	p.Data = rest[:size]
+	return p, nil
+}
+
+// tuicAddrLen 返回编码地址的长度
+func tuicAddrLen(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, errors.New("tuic address truncated")
+	}
+	var n int
+	switch b[0] {
+	case tuicAddrNone:
+		return 1, nil
+	case tuicAddrIPv4:
+		n = 1 + 4 + 2
+	case tuicAddrIPv6:
+		n = 1 + 16 + 2
+	case tuicAddrDomain:
+		if len(b) < 2 {
+			return 0, errors.New("tuic address truncated")
+		}
+		n = 2 + int(b[1]) + 2
+	default:
+		return 0, fmt.Errorf("unknown tuic address type: %d", b[0])
+	}
+	if len(b) < n {
+		return 0, errors.New("tuic address truncated")
+	}
+	return n, nil
+}
@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 期望编码按 TUIC v5 规范手工展开: Type(1) + 地址 + Port(2，大端)
+func TestTUICAddr(t *testing.T) {
+	for _, tc := range []struct {
+		host string
+		port int
+		want string
+	}{
+		{"example.com", 443, "000b" + "6578616d706c652e636f6d" + "01bb"},
+		{"1.2.3.4", 53, "01" + "01020304" + "0035"},
+		{"::1", 80, "02" + "00000000000000000000000000000001" + "0050"},
+	} {
+		got, err := TUICAddr(tc.host, tc.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, mustHex(t, tc.want)) {
+			t.Errorf("TUICAddr(%s, %d) = %x, want %s", tc.host, tc.port, got, tc.want)
+		}
+		if n, err := tuicAddrLen(got); err != nil || n != len(got) {
+			t.Errorf("tuicAddrLen(%x) = %d, %v", got, n, err)
+		}
+	}
+	for _, bad := range []struct {
+		host string
+		port int
+	}{{"", 80}, {"example.com", 70000}, {string(make([]byte, 256)), 80}} {
+		if _, err := TUICAddr(bad.host, bad.port); err == nil {
+			t.Errorf("TUICAddr(%q, %d) succeeded", bad.host, bad.port)
+		}
+	}
+}
+
+// 认证指令: Version + Type + UUID 原始字节(16) + Token(32)
+func TestBuildTUICAuthenticate(t *testing.T) {
+	const uuid = "b831381d-6324-4d53-ad4f-8cda48b30811"
+	token := seq(0, TUICTokenLength)
+	got, err := BuildTUICAuthenticate(uuid, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(mustHex(t, "0500"+"b831381d63244d53ad4f8cda48b30811"), token...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("BuildTUICAuthenticate = %x, want %x", got, want)
+	}
+	if _, err := BuildTUICAuthenticate(uuid, token[:16]); err == nil {
+		t.Error("short token accepted")
+	}
+	if _, err := BuildTUICAuthenticate("not-a-uuid", token); err == nil {
+		t.Error("invalid uuid accepted")
+	}
+}
+
+func TestBuildTUICConnect(t *testing.T) {
+	got, err := BuildTUICConnect("1.2.3.4", 53)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mustHex(t, "0501"+"0101020304"+"0035"); !bytes.Equal(got, want) {
+		t.Fatalf("BuildTUICConnect = %x, want %x", got, want)
+	}
+}
+
+func TestTUICPacket(t *testing.T) {
+	addr, _ := TUICAddr("1.2.3.4", 53)
+	p := &TUICPacket{AssocID: 0x1234, PktID: 1, FragTotal: 2, FragID: 0, Addr: addr, Data: []byte("hi")}
+	b := p.Marshal()
+	want := mustHex(t, "0502"+"1234"+"0001"+"02"+"00"+"0002"+"0101020304"+"0035"+"6869")
+	if !bytes.Equal(b, want) {
+		t.Fatalf("Marshal = %x, want %x", b, want)
+	}
+
+	// 后续分片不携带地址，编码为 None
+	next := &TUICPacket{AssocID: 0x1234, PktID: 1, FragTotal: 2, FragID: 1, Data: []byte("there")}
+	for _, in := range []*TUICPacket{p, next} {
+		out, err := ParseTUICPacket(in.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantAddr := in.Addr
+		if len(wantAddr) == 0 {
+			wantAddr = []byte{tuicAddrNone}
+		}
+		if out.AssocID != in.AssocID || out.PktID != in.PktID || out.FragTotal != in.FragTotal ||
+			out.FragID != in.FragID || !bytes.Equal(out.Addr, wantAddr) || !bytes.Equal(out.Data, in.Data) {
+			t.Errorf("round trip = %+v, want %+v", out, in)
+		}
+	}
+
+	if _, err := ParseTUICPacket(b[:len(b)-1]); err == nil {
+		t.Error("truncated packet parsed")
+	}
+	if _, err := ParseTUICPacket(b[:TUICPacketHeaderLen+3]); err == nil {
+		t.Error("truncated address parsed")
+	}
+}
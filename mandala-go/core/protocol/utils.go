@@ -3,6 +3,7 @@ package protocol
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 )
@@ -43,6 +44,49 @@ func ToSocksAddr(host string, port int) ([]byte, error) {
 	return buf, nil
 }
 
+// ReadSocksAddr 从流中读取 SOCKS5 格式的地址
+// 格式: [Type][Addr...][PortHigh][PortLow]
+func ReadSocksAddr(r io.Reader) (string, int, error) {
+	head := make([]byte, 1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return "", 0, err
+	}
+
+	var host string
+	switch head[0] {
+	case 0x01: // IPv4
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(ip).String()
+	case 0x03: // Domain
+		lenBuf := make([]byte, 1)
+		if _, err := io.ReadFull(r, lenBuf); err != nil {
+			return "", 0, err
+		}
+		domain := make([]byte, int(lenBuf[0]))
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", 0, err
+		}
+		host = string(domain)
+	case 0x04: // IPv6
+		ip := make([]byte, 16)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(ip).String()
+	default:
+		return "", 0, fmt.Errorf("invalid socks address type: 0x%02x", head[0])
+	}
+
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, portBuf); err != nil {
+		return "", 0, err
+	}
+	return host, int(binary.BigEndian.Uint16(portBuf)), nil
+}
+
 // SplitHostPort 分离 host 和 port，处理可能的错误
 func SplitHostPort(address string) (string, int, error) {
 	host, portStr, err := net.SplitHostPort(address)
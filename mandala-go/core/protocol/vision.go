@@ -0,0 +1,405 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// VisionFlow XTLS Vision 流控
+const VisionFlow = "xtls-rprx-vision"
+
+// Vision 填充帧: [UUID(16，仅首帧)] + 命令(1) + 内容长度(2) + 填充长度(2) + 内容 + 填充
+const (
+	visionCmdContinue = 0x00 // 后续仍为填充帧
+	visionCmdEnd      = 0x01 // 填充结束，后续数据仍经外层 TLS
+	visionCmdDirect   = 0x02 // 填充结束，后续数据绕过外层 TLS 直接走底层 TCP
+
+	visionBufSize       = 8192
+	visionFilterPackets = 8 // 识别内层 TLS 握手时最多检查的包数
+)
+
+var (
+	tlsClientHandshakeStart = []byte{0x16, 0x03}
+	tlsServerHandshakeStart = []byte{0x16, 0x03, 0x03}
+	tlsApplicationDataStart = []byte{0x17, 0x03, 0x03}
+	tls13SupportedVersions  = []byte{0x00, 0x2b, 0x00, 0x02, 0x03, 0x04}
+)
+
+// visionState Vision 流控状态
+// 握手阶段对内层 TLS 的前几个记录做长度填充，内层为 TLS 1.3 时在首个应用数据记录后
+// 切换为直连：双方不再经外层 TLS 加密，直接在底层 TCP 上收发内层 TLS 记录
+type visionState struct {
+	uuid   []byte
+	tls    *utls.UConn // 外层 TLS，直连阶段绕过它读写底层连接
+	header []byte      // VLESS 请求头，随首个填充帧发送
+
+	mu sync.Mutex
+	// 内层流量识别 (读写方向共享)
+	filterLeft           int
+	isTLS                bool
+	isTLS12orAbove       bool
+	enableXTLS           bool
+	remainingServerHello int
+	cipher               uint16
+
+	// 写方向
+	uuidSent     bool
+	writePadding bool
+	writeDirect  bool
+
+	// 读方向
+	readPadding      bool
+	readDirect       bool
+	currentCommand   int
+	remainingCommand int
+	remainingContent int
+	remainingPadding int
+	pending          []byte
+	readBuf          []byte
+	readErr          error
+}
+
+// NewVlessVisionConn 创建启用 xtls-rprx-vision 流控的 VLESS 连接
+// header 为 BuildVlessFlowPayload 构造的请求头，在首次写入时与首个填充帧一起发送；
+// 直连阶段需要访问外层 TLS 下的原始连接，因此仅支持 TCP 上的 TLS 1.3 (含 Reality)
+func NewVlessVisionConn(conn net.Conn, uuidStr string, header []byte) (*VlessConn, error) {
+	uConn, ok := conn.(*utls.UConn)
+	if !ok {
+		return nil, errors.New("xtls-rprx-vision requires TLS over a raw TCP transport")
+	}
+	if v := uConn.ConnectionState().Version; v != utls.VersionTLS13 {
+		return nil, fmt.Errorf("xtls-rprx-vision requires outer TLS 1.3, got 0x%04x", v)
+	}
+	uuid, err := ParseUUID(uuidStr)
+	if err != nil {
+		return nil, err
+	}
+
+	vc := NewVlessConn(conn)
+	vc.vision = &visionState{
+		uuid:             uuid,
+		tls:              uConn,
+		header:           header,
+		filterLeft:       visionFilterPackets,
+		writePadding:     true,
+		readPadding:      true,
+		remainingCommand: -1,
+		remainingContent: -1,
+		remainingPadding: -1,
+	}
+	return vc, nil
+}
+
+func (v *visionState) write(conn net.Conn, b []byte) (int, error) {
+	v.mu.Lock()
+	if v.writeDirect {
+		v.mu.Unlock()
+		return v.tls.NetConn().Write(b)
+	}
+
+	if v.filterLeft > 0 && len(b) > 0 {
+		v.filterTLS(b)
+	}
+
+	out := v.header
+	v.header = nil
+	switchDirect := false
+	switch {
+	case !v.writePadding:
+		out = append(out, b...)
+	case len(b) == 0:
+		// 尚无应用数据时发送一个纯填充帧，以长填充掩盖请求头长度
+		out = v.appendPadding(out, nil, visionCmdContinue, true)
+	default:
+		chunks := reshapeVision(b)
+		longPadding := v.isTLS
+		for i, chunk := range chunks {
+			last := i == len(chunks)-1
+			if v.isTLS && len(chunk) >= 6 && bytes.HasPrefix(chunk, tlsApplicationDataStart) {
+				// 内层握手完成后的首个应用数据记录，填充到此为止
+				switchDirect = v.enableXTLS
+				cmd := byte(visionCmdContinue)
+				if last {
+					cmd = v.endCommand()
+				}
+				out = v.appendPadding(out, chunk, cmd, true)
+				v.writePadding = false
+				longPadding = false
+				continue
+			} else if !v.isTLS12orAbove && v.filterLeft <= 1 {
+				// 非 TLS 流量：提前一个包结束填充 (兼容早期服务端)，其余数据原样发送
+				v.writePadding = false
+				out = v.appendPadding(out, chunk, visionCmdEnd, longPadding)
+				for _, rest := range chunks[i+1:] {
+					out = append(out, rest...)
+				}
+				break
+			}
+			cmd := byte(visionCmdContinue)
+			if last && !v.writePadding {
+				cmd = v.endCommand()
+			}
+			out = v.appendPadding(out, chunk, cmd, longPadding)
+		}
+	}
+	v.mu.Unlock()
+
+	if _, err := conn.Write(out); err != nil {
+		return 0, err
+	}
+	if switchDirect {
+		v.mu.Lock()
+		v.writeDirect = true
+		v.mu.Unlock()
+		log.Printf("[Vless] Vision 上行切换为直连")
+	}
+	return len(b), nil
+}
+
+// endCommand 结束填充时使用的命令：内层为可直连的 TLS 1.3 时通知对端切换直连
+func (v *visionState) endCommand() byte {
+	if v.enableXTLS {
+		return visionCmdDirect
+	}
+	return visionCmdEnd
+}
+
+// appendPadding 将 content 封装为一个填充帧追加到 out
+func (v *visionState) appendPadding(out, content []byte, cmd byte, longPadding bool) []byte {
+	contentLen := len(content)
+	var paddingLen int
+	if contentLen < 900 && longPadding {
+		paddingLen = randIntn(500) + 900 - contentLen
+	} else {
+		paddingLen = randIntn(256)
+	}
+	if paddingLen > visionBufSize-21-contentLen {
+		paddingLen = visionBufSize - 21 - contentLen
+	}
+
+	if !v.uuidSent {
+		out = append(out, v.uuid...)
+		v.uuidSent = true
+	}
+	out = append(out, cmd, byte(contentLen>>8), byte(contentLen), byte(paddingLen>>8), byte(paddingLen))
+	out = append(out, content...)
+	padding := make([]byte, paddingLen)
+	rand.Read(padding)
+	return append(out, padding...)
+}
+
+// reshapeVision 将写入数据切分为可放入单个填充帧的块
+// 过长的块优先在最后一个应用数据记录头处切开，使其落在新块的开头
+func reshapeVision(b []byte) [][]byte {
+	var chunks [][]byte
+	for len(b) > 0 {
+		n := len(b)
+		if n > visionBufSize {
+			n = visionBufSize
+		}
+		chunk := b[:n]
+		b = b[n:]
+		if len(chunk) < visionBufSize-21 {
+			chunks = append(chunks, chunk)
+			continue
+		}
+		index := bytes.LastIndex(chunk, tlsApplicationDataStart)
+		if index < 21 || index > visionBufSize-21 {
+			index = visionBufSize / 2
+		}
+		chunks = append(chunks, chunk[:index], chunk[index:])
+	}
+	return chunks
+}
+
+func (v *visionState) read(conn net.Conn, b []byte) (int, error) {
+	for {
+		if len(v.pending) > 0 {
+			n := copy(b, v.pending)
+			v.pending = v.pending[n:]
+			return n, nil
+		}
+		if v.readErr != nil {
+			return 0, v.readErr
+		}
+		if v.readDirect {
+			return v.tls.NetConn().Read(b)
+		}
+
+		if v.readBuf == nil {
+			v.readBuf = make([]byte, visionBufSize)
+		}
+		n, err := conn.Read(v.readBuf)
+		if n > 0 {
+			data := v.readBuf[:n]
+			v.mu.Lock()
+			if v.readPadding || v.filterLeft > 0 {
+				data = v.unpad(data)
+				switch {
+				case v.remainingContent > 0 || v.remainingPadding > 0 || v.currentCommand == visionCmdContinue:
+					v.readPadding = true
+				case v.currentCommand == visionCmdEnd:
+					v.readPadding = false
+				case v.currentCommand == visionCmdDirect:
+					v.readPadding = false
+					v.readDirect = true
+				default:
+					v.mu.Unlock()
+					return 0, fmt.Errorf("vision: unknown padding command %d", v.currentCommand)
+				}
+			}
+			if v.filterLeft > 0 && len(data) > 0 {
+				v.filterTLS(data)
+			}
+			v.mu.Unlock()
+
+			v.pending = data
+			if v.readDirect {
+				// 外层 TLS 已读入但尚未交付的数据属于直连阶段，先于底层连接交付
+				v.pending = append(append([]byte(nil), data...), v.drainTLS()...)
+				log.Printf("[Vless] Vision 下行切换为直连")
+			}
+		}
+		if err != nil {
+			if len(v.pending) == 0 {
+				return 0, err
+			}
+			v.readErr = err
+		}
+	}
+}
+
+// unpad 从填充帧中取出内容，帧可跨越多次读取
+// 不以 UUID 开头的数据不是填充帧，原样返回
+func (v *visionState) unpad(b []byte) []byte {
+	if v.remainingCommand == -1 && v.remainingContent == -1 && v.remainingPadding == -1 {
+		if len(b) >= 21 && bytes.Equal(b[:16], v.uuid) {
+			b = b[16:]
+			v.remainingCommand = 5
+		} else {
+			return b
+		}
+	}
+
+	out := make([]byte, 0, len(b))
+	for len(b) > 0 {
+		switch {
+		case v.remainingCommand > 0:
+			c := int(b[0])
+			b = b[1:]
+			switch v.remainingCommand {
+			case 5:
+				v.currentCommand = c
+			case 4:
+				v.remainingContent = c << 8
+			case 3:
+				v.remainingContent |= c
+			case 2:
+				v.remainingPadding = c << 8
+			case 1:
+				v.remainingPadding |= c
+			}
+			v.remainingCommand--
+		case v.remainingContent > 0:
+			n := v.remainingContent
+			if n > len(b) {
+				n = len(b)
+			}
+			out = append(out, b[:n]...)
+			b = b[n:]
+			v.remainingContent -= n
+		default:
+			n := v.remainingPadding
+			if n > len(b) {
+				n = len(b)
+			}
+			b = b[n:]
+			v.remainingPadding -= n
+		}
+
+		if v.remainingCommand <= 0 && v.remainingContent <= 0 && v.remainingPadding <= 0 {
+			if v.currentCommand == visionCmdContinue {
+				v.remainingCommand = 5
+				continue
+			}
+			// 填充结束，帧之后的数据原样交付
+			v.remainingCommand, v.remainingContent, v.remainingPadding = -1, -1, -1
+			return append(out, b...)
+		}
+	}
+	return out
+}
+
+// filterTLS 识别内层 TLS 握手：ClientHello/ServerHello 判断是否为 TLS，
+// ServerHello 中协商出 TLS 1.3 且密码套件可直连时启用直连
+func (v *visionState) filterTLS(b []byte) {
+	v.filterLeft--
+	if len(b) >= 6 {
+		if bytes.HasPrefix(b, tlsServerHandshakeStart) && b[5] == 0x02 {
+			v.remainingServerHello = (int(b[3])<<8 | int(b[4])) + 5
+			v.isTLS12orAbove = true
+			v.isTLS = true
+			if len(b) >= 79 && v.remainingServerHello >= 79 {
+				sessionIDLen := int(b[43])
+				if 43+sessionIDLen+3 <= len(b) {
+					v.cipher = uint16(b[43+sessionIDLen+1])<<8 | uint16(b[43+sessionIDLen+2])
+				}
+			}
+		} else if bytes.HasPrefix(b, tlsClientHandshakeStart) && b[5] == 0x01 {
+			v.isTLS = true
+		}
+	}
+
+	if v.remainingServerHello > 0 {
+		end := v.remainingServerHello
+		if end > len(b) {
+			end = len(b)
+		}
+		v.remainingServerHello -= len(b)
+		if bytes.Contains(b[:end], tls13SupportedVersions) {
+			// TLS_AES_128_CCM_8_SHA256 (0x1305) 记录长度特征不同，不直连
+			if v.cipher >= 0x1301 && v.cipher <= 0x1304 {
+				v.enableXTLS = true
+			}
+			v.filterLeft = 0
+		} else if v.remainingServerHello <= 0 {
+			v.filterLeft = 0
+		}
+	}
+}
+
+// drainTLS 取出外层 TLS 内部缓冲中尚未交付的数据 (已解密的明文与尚未解析的原始字节)
+// uTLS 未导出这两个缓冲区，只能通过反射访问；切换直连后外层 TLS 不再被读取
+func (v *visionState) drainTLS() []byte {
+	c := reflect.ValueOf(v.tls.Conn).Elem()
+	inputField := c.FieldByName("input")
+	rawInputField := c.FieldByName("rawInput")
+	if !inputField.IsValid() || !rawInputField.IsValid() {
+		log.Printf("[Vless] Vision 无法访问 TLS 缓冲区")
+		return nil
+	}
+	input := (*bytes.Reader)(unsafe.Pointer(inputField.UnsafeAddr()))
+	rawInput := (*bytes.Buffer)(unsafe.Pointer(rawInputField.UnsafeAddr()))
+
+	out := make([]byte, input.Len(), input.Len()+rawInput.Len())
+	input.Read(out)
+	return append(out, rawInput.Next(rawInput.Len())...)
+}
+
+func randIntn(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return int(v.Int64())
+}
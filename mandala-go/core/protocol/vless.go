@@ -5,24 +5,41 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"net"
+
+	"mandala/core/logger"
 )
 
 // BuildVlessPayload 构造 VLESS 握手包 (Version 0)
 func BuildVlessPayload(uuidStr, targetHost string, targetPort int) ([]byte, error) {
-	log.Printf("[Vless] 开始构造请求 -> %s:%d (UUID: %s)", targetHost, targetPort, uuidStr)
+	return BuildVlessFlowPayload(uuidStr, "", targetHost, targetPort)
+}
+
+// [新增] BuildVlessFlowPayload 构造携带流控的 VLESS 握手包
+// flow 写入 Addons (protobuf: 字段 1，string)，为空时 Addons 长度为 0
+func BuildVlessFlowPayload(uuidStr, flow, targetHost string, targetPort int) ([]byte, error) {
+	logger.Debugf("Vless", "开始构造请求 -> %s:%d (UUID: %s)", targetHost, targetPort, uuidStr)
 	
 	uuid, err := ParseUUID(uuidStr) 
 	if err != nil {
-		log.Printf("[Vless] UUID 解析错误: %v", err)
+		logger.Warnf("Vless", "UUID 解析错误: %v", err)
 		return nil, err
 	}
+	if flow != "" && flow != VisionFlow {
+		return nil, fmt.Errorf("unsupported vless flow: %s", flow)
+	}
 
 	var buf bytes.Buffer
 	buf.WriteByte(0x00) // Version 0
 	buf.Write(uuid)     // UUID (16 bytes)
-	buf.WriteByte(0x00) // Addon Length (0)
+	if flow == "" {
+		buf.WriteByte(0x00) // Addon Length (0)
+	} else {
+		buf.WriteByte(byte(2 + len(flow))) // Addon Length
+		buf.WriteByte(0x0A)                // 字段 1 (Flow)，wire type 2
+		buf.WriteByte(byte(len(flow)))
+		buf.WriteString(flow)
+	}
 
 	buf.WriteByte(0x01) // Command (Connect TCP)
 
@@ -37,11 +54,11 @@ func BuildVlessPayload(uuidStr, targetHost string, targetPort int) ([]byte, erro
 		if ip4 := ip.To4(); ip4 != nil {
 			buf.WriteByte(0x01)
 			buf.Write(ip4)
-			log.Printf("[Vless] 地址类型: IPv4")
+			logger.Debugf("Vless", "地址类型: IPv4")
 		} else {
 			buf.WriteByte(0x03)
 			buf.Write(ip.To16())
-			log.Printf("[Vless] 地址类型: IPv6")
+			logger.Debugf("Vless", "地址类型: IPv6")
 		}
 	} else {
 		if len(targetHost) > 255 {
@@ -50,10 +67,10 @@ func BuildVlessPayload(uuidStr, targetHost string, targetPort int) ([]byte, erro
 		buf.WriteByte(0x02)
 		buf.WriteByte(byte(len(targetHost)))
 		buf.WriteString(targetHost)
-		log.Printf("[Vless] 地址类型: 域名 (%s)", targetHost)
+		logger.Debugf("Vless", "地址类型: 域名 (%s)", targetHost)
 	}
 
-	log.Printf("[Vless] 请求包构造完成")
+	logger.Debugf("Vless", "请求包构造完成")
 	return buf.Bytes(), nil
 }
 
@@ -62,14 +79,28 @@ type VlessConn struct {
 	net.Conn
 	headerStripped bool
 	reader         io.Reader
+	// [新增] xtls-rprx-vision 流控状态，为 nil 时按普通 VLESS 透传
+	vision *visionState
+	// [新增] 响应头版本号与请求 (Version 0) 不符时，首次读取返回错误而不是继续转发
+	Strict bool
 }
 
 func NewVlessConn(c net.Conn) *VlessConn {
 	return &VlessConn{Conn: c, headerStripped: false}
 }
 
+func (vc *VlessConn) Write(b []byte) (int, error) {
+	if vc.vision != nil {
+		return vc.vision.write(vc.Conn, b)
+	}
+	return vc.Conn.Write(b)
+}
+
 func (vc *VlessConn) Read(b []byte) (int, error) {
 	if vc.headerStripped {
+		if vc.vision != nil {
+			return vc.vision.read(vc.Conn, b)
+		}
 		return vc.Conn.Read(b)
 	}
 
@@ -77,17 +108,25 @@ func (vc *VlessConn) Read(b []byte) (int, error) {
 		vc.reader = vc.Conn
 	}
 
-	log.Printf("[Vless] 正在读取并剥离服务端响应头...")
+	logger.Debugf("Vless", "正在读取并剥离服务端响应头...")
 	head := make([]byte, 2)
 	n, err := io.ReadFull(vc.reader, head)
 	if err != nil {
-		log.Printf("[Vless] 读取响应头失败: %v", err)
+		logger.Warnf("Vless", "读取响应头失败: %v", err)
 		return n, err
 	}
 
+	// [新增] 版本号不符说明服务端不是 VLESS 或数据流已错位，继续剥离会把后续数据当作响应头
+	if head[0] != 0x00 {
+		if vc.Strict {
+			return 0, fmt.Errorf("vless: unexpected response version %d", head[0])
+		}
+		logger.Warnf("Vless", "警告: 响应头版本号为 %d (期望 0)，继续转发", head[0])
+	}
+
 	addonLen := int(head[1])
 	if addonLen > 0 {
-		log.Printf("[Vless] 发现 Addon 数据，长度: %d，正在丢弃", addonLen)
+		logger.Debugf("Vless", "发现 Addon 数据，长度: %d，正在丢弃", addonLen)
 		discard := make([]byte, addonLen)
 		if _, err := io.ReadFull(vc.reader, discard); err != nil {
 			return 0, err
@@ -95,11 +134,11 @@ func (vc *VlessConn) Read(b []byte) (int, error) {
 	}
 
 	vc.headerStripped = true
-	log.Printf("[Vless] 响应头剥离成功，进入数据传输阶段")
+	logger.Debugf("Vless", "响应头剥离成功，进入数据传输阶段")
 
 	if len(b) == 0 {
 		return 0, nil
 	}
 
-	return vc.Conn.Read(b)
+	return vc.Read(b)
 }
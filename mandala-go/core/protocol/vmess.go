@@ -0,0 +1,434 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"log"
+	mrand "math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/sha3"
+)
+
+// VMess 指令
+const (
+	VmessCmdTCP = 0x01
+	VmessCmdUDP = 0x02
+)
+
+// VMess 加密方式 (请求头 Sec 字段)
+const (
+	vmessSecAES128GCM = 0x03
+	vmessSecChacha20  = 0x04
+	vmessSecNone      = 0x05
+)
+
+// VMess 请求选项: 标准数据流 (S) + 长度混淆 (M)
+const (
+	vmessOptChunkStream  = 0x01
+	vmessOptChunkMasking = 0x04
+)
+
+// vmessMaxChunk 单个数据块的最大明文长度
+const vmessMaxChunk = 8192
+
+const vmessCmdKeySalt = "c48619fe-8f02-49e0-b9e9-edf763e17e21"
+
+// vmessSecurity 将配置的加密方式映射为协议常量，空值/auto 使用 AES-128-GCM
+func vmessSecurity(method string) (byte, error) {
+	switch strings.ToLower(method) {
+	case "", "auto", "aes-128-gcm":
+		return vmessSecAES128GCM, nil
+	case "chacha20-poly1305", "chacha20-ietf-poly1305":
+		return vmessSecChacha20, nil
+	case "none", "zero":
+		return vmessSecNone, nil
+	}
+	return 0, fmt.Errorf("unsupported vmess security: %s", method)
+}
+
+// vmessCmdKey 由 UUID 派生指令密钥: MD5(UUID + 固定盐)
+func vmessCmdKey(uuid []byte) []byte {
+	h := md5.New()
+	h.Write(uuid)
+	h.Write([]byte(vmessCmdKeySalt))
+	return h.Sum(nil)
+}
+
+// vmessKDF VMess AEAD 的嵌套 HMAC-SHA256 密钥派生
+func vmessKDF(key []byte, path ...string) []byte {
+	creator := func() hash.Hash { return hmac.New(sha256.New, []byte("VMess AEAD KDF")) }
+	for _, p := range path {
+		parent, salt := creator, []byte(p)
+		creator = func() hash.Hash { return hmac.New(parent, salt) }
+	}
+	h := creator()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func vmessKDF16(key []byte, path ...string) []byte {
+	return vmessKDF(key, path...)[:16]
+}
+
+func newVmessGCM(key []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// vmessAuthID 构造 16 字节认证 ID: AES(时间戳 + 随机数 + CRC32)
+func vmessAuthID(cmdKey []byte, now int64) ([]byte, error) {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, uint64(now))
+	if _, err := io.ReadFull(rand.Reader, buf[8:12]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(buf[12:], crc32.ChecksumIEEE(buf[:12]))
+
+	block, err := aes.NewCipher(vmessKDF16(cmdKey, "AES Auth ID Encryption"))
+	if err != nil {
+		return nil, err
+	}
+	block.Encrypt(buf, buf)
+	return buf, nil
+}
+
+// sealVmessHeader 以 AEAD 方式封装请求头
+// 结构: AuthID(16) + 加密长度(2+16) + ConnectionNonce(8) + 加密请求头(N+16)
+func sealVmessHeader(cmdKey, header []byte) ([]byte, error) {
+	authID, err := vmessAuthID(cmdKey, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(len(header)))
+
+	lenAEAD := newVmessGCM(vmessKDF16(cmdKey, "VMess Header AEAD Key_Length", string(authID), string(nonce)))
+	lenIV := vmessKDF(cmdKey, "VMess Header AEAD Nonce_Length", string(authID), string(nonce))[:12]
+
+	headAEAD := newVmessGCM(vmessKDF16(cmdKey, "VMess Header AEAD Key", string(authID), string(nonce)))
+	headIV := vmessKDF(cmdKey, "VMess Header AEAD Nonce", string(authID), string(nonce))[:12]
+
+	out := append([]byte{}, authID...)
+	out = lenAEAD.Seal(out, lenIV, lenBuf, authID)
+	out = append(out, nonce...)
+	out = headAEAD.Seal(out, headIV, header, authID)
+	return out, nil
+}
+
+// BuildVmessHeader 构造 VMess 请求头明文 (AEAD 封装前)
+// 结构: Ver(1) + IV(16) + Key(16) + V(1) + Opt(1) + P|Sec(1) + Rsv(1) + Cmd(1) + Port(2) + ATYP(1) + Addr + Padding(P) + FNV1a(4)
+func BuildVmessHeader(bodyIV, bodyKey []byte, respAuth, security, cmd byte, targetHost string, targetPort int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(0x01)
+	buf.Write(bodyIV)
+	buf.Write(bodyKey)
+	buf.WriteByte(respAuth)
+	buf.WriteByte(vmessOptChunkStream | vmessOptChunkMasking)
+
+	paddingLen := mrand.Intn(16)
+	buf.WriteByte(byte(paddingLen<<4) | security)
+	buf.WriteByte(0x00)
+	buf.WriteByte(cmd)
+
+	portBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBuf, uint16(targetPort))
+	buf.Write(portBuf)
+
+	// 地址格式与 VLESS 相同: 0x01=IPv4, 0x02=Domain, 0x03=IPv6
+	ip := net.ParseIP(targetHost)
+	if ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf.WriteByte(0x01)
+			buf.Write(ip4)
+		} else {
+			buf.WriteByte(0x03)
+			buf.Write(ip.To16())
+		}
+	} else {
+		if len(targetHost) > 255 {
+			return nil, fmt.Errorf("domain name too long: %s", targetHost)
+		}
+		buf.WriteByte(0x02)
+		buf.WriteByte(byte(len(targetHost)))
+		buf.WriteString(targetHost)
+	}
+
+	if paddingLen > 0 {
+		padding := make([]byte, paddingLen)
+		rand.Read(padding)
+		buf.Write(padding)
+	}
+
+	f := fnv.New32a()
+	f.Write(buf.Bytes())
+	buf.Write(f.Sum(nil))
+	return buf.Bytes(), nil
+}
+
+// vmessStream 单方向的数据块编解码状态
+type vmessStream struct {
+	aead  cipher.AEAD // 为 nil 时不加密 (security=none)
+	iv    []byte
+	count uint16
+	mask  sha3.ShakeHash
+}
+
+func newVmessStream(security byte, key, iv []byte) (*vmessStream, error) {
+	s := &vmessStream{iv: iv, mask: sha3.NewShake128()}
+	s.mask.Write(iv)
+
+	var err error
+	switch security {
+	case vmessSecAES128GCM:
+		s.aead = newVmessGCM(key)
+	case vmessSecChacha20:
+		// ChaCha20 密钥: MD5(key) + MD5(MD5(key))
+		k1 := md5.Sum(key)
+		k2 := md5.Sum(k1[:])
+		s.aead, err = chacha20poly1305.New(append(k1[:], k2[:]...))
+	}
+	return s, err
+}
+
+func (s *vmessStream) nextNonce() []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint16(nonce, s.count)
+	copy(nonce[2:], s.iv[2:12])
+	s.count++
+	return nonce
+}
+
+func (s *vmessStream) nextMask() uint16 {
+	m := make([]byte, 2)
+	s.mask.Read(m)
+	return binary.BigEndian.Uint16(m)
+}
+
+func (s *vmessStream) overhead() int {
+	if s.aead == nil {
+		return 0
+	}
+	return s.aead.Overhead()
+}
+
+// VmessConn 实现 VMess (AEAD, alterId=0) 数据流
+// 首次写入时发送封装后的请求头，首次读取时校验服务端响应头
+type VmessConn struct {
+	net.Conn
+
+	cmdKey   []byte
+	header   []byte
+	security byte
+	respAuth byte
+	reqKey   []byte
+	reqIV    []byte
+
+	writeMu   sync.Mutex
+	enc       *vmessStream
+	headerOut bool
+
+	dec      *vmessStream
+	readBuf  []byte
+	leftover []byte
+}
+
+// NewVmessConn 创建 VMess 连接包装，cmd 为 VmessCmdTCP 或 VmessCmdUDP
+// 写入空数据可单独发送请求头 (用于服务端先发数据的协议)
+func NewVmessConn(c net.Conn, uuidStr, security string, cmd byte, targetHost string, targetPort int) (*VmessConn, error) {
+	log.Printf("[Vmess] 开始构造请求 -> %s:%d", targetHost, targetPort)
+
+	uuid, err := ParseUUID(uuidStr)
+	if err != nil {
+		log.Printf("[Vmess] UUID 解析错误: %v", err)
+		return nil, err
+	}
+	sec, err := vmessSecurity(security)
+	if err != nil {
+		return nil, err
+	}
+
+	keyIV := make([]byte, 33)
+	if _, err := io.ReadFull(rand.Reader, keyIV); err != nil {
+		return nil, err
+	}
+
+	vc := &VmessConn{
+		Conn:     c,
+		cmdKey:   vmessCmdKey(uuid),
+		security: sec,
+		reqKey:   keyIV[:16],
+		reqIV:    keyIV[16:32],
+		respAuth: keyIV[32],
+	}
+
+	vc.header, err = BuildVmessHeader(vc.reqIV, vc.reqKey, vc.respAuth, sec, cmd, targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	if vc.enc, err = newVmessStream(sec, vc.reqKey, vc.reqIV); err != nil {
+		return nil, err
+	}
+	return vc, nil
+}
+
+func (vc *VmessConn) Write(b []byte) (int, error) {
+	vc.writeMu.Lock()
+	defer vc.writeMu.Unlock()
+
+	var out []byte
+	if !vc.headerOut {
+		sealed, err := sealVmessHeader(vc.cmdKey, vc.header)
+		if err != nil {
+			return 0, err
+		}
+		out = sealed
+		vc.headerOut = true
+	}
+
+	for p := b; len(p) > 0; {
+		n := len(p)
+		if n > vmessMaxChunk {
+			n = vmessMaxChunk
+		}
+		out = vc.sealChunk(out, p[:n])
+		p = p[n:]
+	}
+
+	if len(out) == 0 {
+		return 0, nil
+	}
+	if _, err := vc.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// sealChunk 追加一个数据块: [混淆后的长度(2)][密文]
+func (vc *VmessConn) sealChunk(out, plain []byte) []byte {
+	size := len(plain) + vc.enc.overhead()
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(size)^vc.enc.nextMask())
+	out = append(out, lenBuf...)
+
+	if vc.enc.aead == nil {
+		return append(out, plain...)
+	}
+	return vc.enc.aead.Seal(out, vc.enc.nextNonce(), plain, nil)
+}
+
+func (vc *VmessConn) Read(b []byte) (int, error) {
+	if len(vc.leftover) > 0 {
+		n := copy(b, vc.leftover)
+		vc.leftover = vc.leftover[n:]
+		return n, nil
+	}
+
+	if vc.dec == nil {
+		if err := vc.readResponseHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(vc.Conn, lenBuf); err != nil {
+		return 0, err
+	}
+	size := int(binary.BigEndian.Uint16(lenBuf) ^ vc.dec.nextMask())
+	overhead := vc.dec.overhead()
+	if size < overhead {
+		return 0, errors.New("vmess: invalid chunk length")
+	}
+	// 长度为 overhead 的空块表示对端结束发送
+	if size == overhead {
+		return 0, io.EOF
+	}
+
+	if cap(vc.readBuf) < size {
+		vc.readBuf = make([]byte, size)
+	}
+	chunk := vc.readBuf[:size]
+	if _, err := io.ReadFull(vc.Conn, chunk); err != nil {
+		return 0, err
+	}
+
+	plain := chunk
+	if vc.dec.aead != nil {
+		var err error
+		plain, err = vc.dec.aead.Open(chunk[:0], vc.dec.nextNonce(), chunk, nil)
+		if err != nil {
+			return 0, errors.New("vmess: chunk decryption failed")
+		}
+	}
+
+	n := copy(b, plain)
+	if n < len(plain) {
+		vc.leftover = append([]byte{}, plain[n:]...)
+	}
+	return n, nil
+}
+
+// readResponseHeader 读取并校验 AEAD 响应头 [V][Opt][Cmd][CmdLen][Cmd...]
+func (vc *VmessConn) readResponseHeader() error {
+	k := sha256.Sum256(vc.reqKey)
+	iv := sha256.Sum256(vc.reqIV)
+	respKey, respIV := k[:16], iv[:16]
+
+	lenAEAD := newVmessGCM(vmessKDF16(respKey, "AEAD Resp Header Len Key"))
+	lenIV := vmessKDF(respIV, "AEAD Resp Header Len IV")[:12]
+
+	lenBuf := make([]byte, 2+lenAEAD.Overhead())
+	if _, err := io.ReadFull(vc.Conn, lenBuf); err != nil {
+		log.Printf("[Vmess] 读取响应头失败: %v", err)
+		return err
+	}
+	plainLen, err := lenAEAD.Open(lenBuf[:0], lenIV, lenBuf, nil)
+	if err != nil {
+		return errors.New("vmess: response header length decryption failed")
+	}
+
+	headAEAD := newVmessGCM(vmessKDF16(respKey, "AEAD Resp Header Key"))
+	headIV := vmessKDF(respIV, "AEAD Resp Header IV")[:12]
+
+	head := make([]byte, int(binary.BigEndian.Uint16(plainLen))+headAEAD.Overhead())
+	if _, err := io.ReadFull(vc.Conn, head); err != nil {
+		return err
+	}
+	plain, err := headAEAD.Open(head[:0], headIV, head, nil)
+	if err != nil {
+		return errors.New("vmess: response header decryption failed")
+	}
+	if len(plain) < 4 || plain[0] != vc.respAuth {
+		return errors.New("vmess: response header verification failed")
+	}
+
+	dec, err := newVmessStream(vc.security, respKey, respIV)
+	if err != nil {
+		return err
+	}
+	vc.dec = dec
+	log.Printf("[Vmess] 响应头校验成功，进入数据传输阶段")
+	return nil
+}
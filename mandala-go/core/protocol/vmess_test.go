@@ -0,0 +1,202 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const testVmessUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// 期望值由独立实现 (Python hashlib / hmac，按嵌套 HMAC 定义逐层展开) 计算
+func TestVmessKeyDerivation(t *testing.T) {
+	uuid, err := ParseUUID(testVmessUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdKey := vmessCmdKey(uuid)
+	if !bytes.Equal(cmdKey, mustHex(t, "b50d916ac0cec067981af8e5f38a758f")) {
+		t.Fatalf("cmdKey = %x", cmdKey)
+	}
+
+	for _, tc := range []struct {
+		key  []byte
+		path []string
+		want string
+	}{
+		{cmdKey, []string{"AES Auth ID Encryption"},
+			"1415ba74ca8b3d041a8f583fb4116315c589ae7b6e81765b601aa166c62871f7"},
+		{cmdKey, []string{"VMess Header AEAD Key", string(seq(0, 16)), string(seq(0, 8))},
+			"90e3f2f5d3ceea8286168b4560dde3bcd97cf05887c775ca6aef8444a00c6646"},
+		{seq(0, 16), []string{"AEAD Resp Header Len IV"},
+			"c10dd09b55bbeca420a83f5800cd00bfe428065ce01a686e4e76094a254909f6"},
+	} {
+		if got := vmessKDF(tc.key, tc.path...); !bytes.Equal(got, mustHex(t, tc.want)) {
+			t.Errorf("vmessKDF(%q) = %x, want %s", tc.path[0], got, tc.want)
+		}
+	}
+}
+
+func newTestGCM(key []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// vmessPeerStream 按规范独立实现的数据块流: 长度与 SHAKE128(IV) 异或，nonce = count(2) + IV[2:12]
+type vmessPeerStream struct {
+	aead  cipher.AEAD
+	iv    []byte
+	count uint16
+	mask  sha3.ShakeHash
+}
+
+func newVmessPeerStream(key, iv []byte) *vmessPeerStream {
+	s := &vmessPeerStream{aead: newTestGCM(key), iv: iv, mask: sha3.NewShake128()}
+	s.mask.Write(iv)
+	return s
+}
+
+func (s *vmessPeerStream) nonce() []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint16(n, s.count)
+	copy(n[2:], s.iv[2:12])
+	s.count++
+	return n
+}
+
+func (s *vmessPeerStream) maskLen(n uint16) []byte {
+	m := make([]byte, 2)
+	s.mask.Read(m)
+	return binary.BigEndian.AppendUint16(nil, n^binary.BigEndian.Uint16(m))
+}
+
+func (s *vmessPeerStream) read(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		t.Fatal(err)
+	}
+	m := make([]byte, 2)
+	s.mask.Read(m)
+	chunk := make([]byte, binary.BigEndian.Uint16(lenBuf)^binary.BigEndian.Uint16(m))
+	if _, err := io.ReadFull(r, chunk); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := s.aead.Open(nil, s.nonce(), chunk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func (s *vmessPeerStream) seal(out, plain []byte) []byte {
+	out = append(out, s.maskLen(uint16(len(plain)+s.aead.Overhead()))...)
+	return s.aead.Seal(out, s.nonce(), plain, nil)
+}
+
+// openVmessRequest 按规范解开客户端发送的 AEAD 请求头，返回请求头明文
+func openVmessRequest(t *testing.T, r io.Reader, cmdKey []byte) []byte {
+	t.Helper()
+	authID := make([]byte, 16)
+	lenSealed := make([]byte, 2+16)
+	nonce := make([]byte, 8)
+	for _, b := range [][]byte{authID, lenSealed, nonce} {
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// AuthID = AES(时间戳(8) + 随机数(4) + CRC32(前 12 字节))
+	block, _ := aes.NewCipher(vmessKDF16(cmdKey, "AES Auth ID Encryption"))
+	plainID := make([]byte, 16)
+	block.Decrypt(plainID, authID)
+	if crc32.ChecksumIEEE(plainID[:12]) != binary.BigEndian.Uint32(plainID[12:]) {
+		t.Fatal("auth id checksum mismatch")
+	}
+	if d := time.Since(time.Unix(int64(binary.BigEndian.Uint64(plainID)), 0)); d < -time.Minute || d > time.Minute {
+		t.Fatalf("auth id timestamp off by %s", d)
+	}
+
+	id, n := string(authID), string(nonce)
+	lenAEAD := newTestGCM(vmessKDF16(cmdKey, "VMess Header AEAD Key_Length", id, n))
+	plainLen, err := lenAEAD.Open(nil, vmessKDF(cmdKey, "VMess Header AEAD Nonce_Length", id, n)[:12], lenSealed, authID)
+	if err != nil {
+		t.Fatal("header length:", err)
+	}
+	sealed := make([]byte, int(binary.BigEndian.Uint16(plainLen))+16)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		t.Fatal(err)
+	}
+	headAEAD := newTestGCM(vmessKDF16(cmdKey, "VMess Header AEAD Key", id, n))
+	header, err := headAEAD.Open(nil, vmessKDF(cmdKey, "VMess Header AEAD Nonce", id, n)[:12], sealed, authID)
+	if err != nil {
+		t.Fatal("header:", err)
+	}
+
+	f := fnv.New32a()
+	f.Write(header[:len(header)-4])
+	if !bytes.Equal(f.Sum(nil), header[len(header)-4:]) {
+		t.Fatal("header checksum mismatch")
+	}
+	return header
+}
+
+func TestVmessStream(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn, err := NewVmessConn(client, testVmessUUID, "aes-128-gcm", VmessCmdTCP, "example.com", 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go conn.Write([]byte("ping"))
+
+	uuid, _ := ParseUUID(testVmessUUID)
+	header := openVmessRequest(t, server, vmessCmdKey(uuid))
+	// Ver(1) IV(16) Key(16) V(1) Opt(1) P|Sec(1) Rsv(1) Cmd(1) Port(2) ATYP(1) Addr
+	reqIV, reqKey, respAuth := header[1:17], header[17:33], header[33]
+	if header[0] != 1 || header[35]&0x0F != vmessSecAES128GCM || header[37] != VmessCmdTCP {
+		t.Fatalf("header fields %x", header[:38])
+	}
+	if port := binary.BigEndian.Uint16(header[38:]); port != 443 {
+		t.Fatalf("port = %d", port)
+	}
+	if header[40] != 0x02 || string(header[42:42+header[41]]) != "example.com" {
+		t.Fatalf("address %x", header[40:])
+	}
+
+	up := newVmessPeerStream(reqKey, reqIV)
+	if got := up.read(t, server); string(got) != "ping" {
+		t.Fatalf("request chunk = %q", got)
+	}
+
+	// 响应密钥与 IV 为请求密钥 / IV 的 SHA256 前 16 字节
+	k, iv := sha256.Sum256(reqKey), sha256.Sum256(reqIV)
+	respKey, respIV := k[:16], iv[:16]
+	respHead := []byte{respAuth, 0, 0, 0}
+	lenAEAD := newTestGCM(vmessKDF16(respKey, "AEAD Resp Header Len Key"))
+	resp := lenAEAD.Seal(nil, vmessKDF(respIV, "AEAD Resp Header Len IV")[:12], []byte{0, byte(len(respHead))}, nil)
+	headAEAD := newTestGCM(vmessKDF16(respKey, "AEAD Resp Header Key"))
+	resp = headAEAD.Seal(resp, vmessKDF(respIV, "AEAD Resp Header IV")[:12], respHead, nil)
+	down := newVmessPeerStream(respKey, respIV)
+	resp = down.seal(resp, []byte("pong"))
+	resp = down.seal(resp, nil) // 空块表示结束
+	go server.Write(resp)
+
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != "pong" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+}
@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+// WebTransport over HTTP/3 (draft-ietf-webtrans-http3-02，与 webtransport-go 等常见实现兼容)
+// 会话: 在 HTTP/3 请求流上发送扩展 CONNECT (:protocol = webtransport)，2xx 响应后会话建立，请求流保持打开
+// 双向流: 客户端新开的 QUIC 双向流以 StreamType(varint 0x41) + 会话 ID(varint，即 CONNECT 请求流的流 ID) 开头，其后为应用数据
+const (
+	WebTransportProtocol = "webtransport"
+
+	// SETTINGS_ENABLE_WEBTRANSPORT (draft-02)，双方均须声明为 1
+	WebTransportSettingEnable = 0x2b603742
+	// 旧版服务端据此头识别 draft-02 客户端
+	WebTransportHeaderDraft02 = "Sec-Webtransport-Http3-Draft02"
+
+	webTransportFrameBidiStream = 0x41
+)
+
+// BuildWebTransportRequest 构造建立会话的扩展 CONNECT 请求，host 为 :authority，path 须以 "/" 开头
+func BuildWebTransportRequest(host, path string, headers map[string]string) (*http.Request, error) {
+	if path == "" {
+		path = "/"
+	}
+	u, err := url.Parse("https://" + host + path)
+	if err != nil {
+		return nil, fmt.Errorf("webtransport url: %v", err)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    u,
+		Proto:  WebTransportProtocol,
+		Host:   host,
+		Header: make(http.Header),
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	// Host 由 :authority 承载
+	req.Header.Del("Host")
+	req.Header.Set(WebTransportHeaderDraft02, "1")
+	return req, nil
+}
+
+// BuildWebTransportStreamHeader 构造双向流开头的流类型与会话 ID
+func BuildWebTransportStreamHeader(sessionID uint64) []byte {
+	buf := quicvarint.Append(nil, webTransportFrameBidiStream)
+	return quicvarint.Append(buf, sessionID)
+}
@@ -0,0 +1,220 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// VLESS 指令
+const (
+	VlessCmdTCP = 0x01
+	VlessCmdUDP = 0x02
+	VlessCmdMux = 0x03 // Mux.Cool / XUDP
+)
+
+// Mux.Cool 帧字段
+const (
+	muxStatusNew       = 0x01
+	muxStatusKeep      = 0x02
+	muxStatusEnd       = 0x03
+	muxOptionData      = 0x01
+	muxNetworkUDP      = 0x02
+	xudpGlobalIDLength = 8
+)
+
+// BuildVlessMuxPayload 构造 VLESS Mux 指令握手包，Mux 指令不携带目标地址
+// 结构: Version(1) + UUID(16) + AddonLen(1) + CMD(1)
+func BuildVlessMuxPayload(uuidStr string) ([]byte, error) {
+	uuid, err := ParseUUID(uuidStr)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 19)
+	buf = append(buf, 0x00)
+	buf = append(buf, uuid...)
+	buf = append(buf, 0x00, VlessCmdMux)
+	return buf, nil
+}
+
+// XUDPConn 在 VLESS Mux 连接上按 XUDP 格式收发数据报，恢复包边界
+// 每个数据报为一帧: MetaLen(2) + Meta + DataLen(2) + Payload
+// Meta: SessionID(2) + Status(1) + Option(1) + Network(1) + Port(2) + AddrType(1) + Addr [+ GlobalID(8)，仅首帧]
+type XUDPConn struct {
+	net.Conn
+	target  []byte
+	started bool
+
+	// MaxPacketSize 允许读取的最大数据报长度，0 表示不限制 (受 2 字节长度字段约束)
+	MaxPacketSize int
+}
+
+// NewXUDPConn 创建 XUDP 数据报连接，目标地址固定为创建时指定的地址
+func NewXUDPConn(c net.Conn, targetHost string, targetPort int) (*XUDPConn, error) {
+	addr, err := vlessAddrPort(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	return &XUDPConn{Conn: c, target: addr}, nil
+}
+
+func (c *XUDPConn) Write(b []byte) (int, error) {
+	return c.writePacket(b, c.target)
+}
+
+// [新增] WritePacket 发送一个数据报到指定目标 (全锥形 NAT: 同一会话承载多个目标)
+func (c *XUDPConn) WritePacket(b []byte, host string, port int) (int, error) {
+	addr, err := vlessAddrPort(host, port)
+	if err != nil {
+		return 0, err
+	}
+	return c.writePacket(b, addr)
+}
+
+func (c *XUDPConn) writePacket(b, addr []byte) (int, error) {
+	if len(b) > 0xFFFF {
+		return 0, fmt.Errorf("xudp packet too large: %d", len(b))
+	}
+
+	status := byte(muxStatusKeep)
+	if !c.started {
+		status = muxStatusNew
+	}
+
+	meta := make([]byte, 0, 5+len(addr)+xudpGlobalIDLength)
+	meta = append(meta, 0x00, 0x00) // XUDP 会话 ID 固定为 0
+	meta = append(meta, status, muxOptionData, muxNetworkUDP)
+	meta = append(meta, addr...)
+	if !c.started {
+		// GlobalID 全零表示不启用 Full Cone 会话复用
+		meta = append(meta, make([]byte, xudpGlobalIDLength)...)
+	}
+
+	buf := make([]byte, 0, 4+len(meta)+len(b))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(meta)))
+	buf = append(buf, meta...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(b)))
+	buf = append(buf, b...)
+
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	c.started = true
+	return len(b), nil
+}
+
+func (c *XUDPConn) Read(b []byte) (int, error) {
+	// 来源地址固定为会话目标，这里只需关注数据
+	n, _, _, err := c.ReadPacket(b)
+	return n, err
+}
+
+// [新增] ReadPacket 读取一个数据报并返回其来源地址 (帧中未携带地址时为会话目标)
+func (c *XUDPConn) ReadPacket(b []byte) (int, string, int, error) {
+	for {
+		lenBuf := make([]byte, 2)
+		if _, err := io.ReadFull(c.Conn, lenBuf); err != nil {
+			return 0, "", 0, err
+		}
+		meta := make([]byte, binary.BigEndian.Uint16(lenBuf))
+		if _, err := io.ReadFull(c.Conn, meta); err != nil {
+			return 0, "", 0, err
+		}
+		if len(meta) < 4 {
+			return 0, "", 0, fmt.Errorf("xudp: invalid frame metadata length %d", len(meta))
+		}
+		status, option := meta[2], meta[3]
+
+		if option&muxOptionData == 0 {
+			if status == muxStatusEnd {
+				return 0, "", 0, io.EOF
+			}
+			continue
+		}
+
+		if _, err := io.ReadFull(c.Conn, lenBuf); err != nil {
+			return 0, "", 0, err
+		}
+		length := int(binary.BigEndian.Uint16(lenBuf))
+		if c.MaxPacketSize > 0 && length > c.MaxPacketSize {
+			return 0, "", 0, fmt.Errorf("xudp packet exceeds limit: %d > %d", length, c.MaxPacketSize)
+		}
+
+		packet := make([]byte, length)
+		if _, err := io.ReadFull(c.Conn, packet); err != nil {
+			return 0, "", 0, err
+		}
+		if status == muxStatusEnd {
+			return 0, "", 0, io.EOF
+		}
+		// 心跳等空数据帧不构成数据报
+		if length == 0 {
+			continue
+		}
+
+		addr := c.target
+		if len(meta) > 5 && meta[4] == muxNetworkUDP {
+			addr = meta[5:]
+		}
+		host, port, err := parseVlessAddrPort(addr)
+		if err != nil {
+			return 0, "", 0, err
+		}
+		return copy(b, packet), host, port, nil
+	}
+}
+
+// vlessAddrPort 构造 VLESS/Mux.Cool 格式地址: Port(2) + AddrType(1) + Addr
+// AddrType: 0x01(IPv4), 0x02(Domain), 0x03(IPv6)
+func vlessAddrPort(host string, port int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(binary.BigEndian.AppendUint16(nil, uint16(port)))
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf.WriteByte(0x01)
+			buf.Write(ip4)
+		} else {
+			buf.WriteByte(0x03)
+			buf.Write(ip.To16())
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("domain name too long: %s", host)
+		}
+		buf.WriteByte(0x02)
+		buf.WriteByte(byte(len(host)))
+		buf.WriteString(host)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseVlessAddrPort 解析 VLESS/Mux.Cool 格式地址，忽略其后的数据 (如 GlobalID)
+func parseVlessAddrPort(b []byte) (string, int, error) {
+	if len(b) < 4 {
+		return "", 0, errors.New("xudp: address truncated")
+	}
+	port := int(binary.BigEndian.Uint16(b))
+	addr := b[3:]
+	switch b[2] {
+	case 0x01:
+		if len(addr) < 4 {
+			return "", 0, errors.New("xudp: address truncated")
+		}
+		return net.IP(addr[:4]).String(), port, nil
+	case 0x03:
+		if len(addr) < 16 {
+			return "", 0, errors.New("xudp: address truncated")
+		}
+		return net.IP(addr[:16]).String(), port, nil
+	case 0x02:
+		if len(addr) < 1+int(addr[0]) {
+			return "", 0, errors.New("xudp: address truncated")
+		}
+		return string(addr[1 : 1+int(addr[0])]), port, nil
+	}
+	return "", 0, fmt.Errorf("xudp: invalid address type 0x%02x", b[2])
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// decoyBody 模拟 nginx 默认的 400 错误页
+const decoyBody = "<html>\r\n<head><title>400 Bad Request</title></head>\r\n" +
+	"<body>\r\n<center><h1>400 Bad Request</h1></center>\r\n" +
+	"<hr><center>nginx</center>\r\n</body>\r\n</html>\r\n"
+
+// writeDecoyResponse 向无效探测返回一个看似普通 Web 服务器的 HTTP 400 响应，
+// 避免本地入站在暴露到局域网时被扫描器直接识别为 SOCKS 代理
+func writeDecoyResponse(conn net.Conn) {
+	resp := fmt.Sprintf("HTTP/1.1 400 Bad Request\r\n"+
+		"Server: nginx\r\n"+
+		"Date: %s\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Content-Length: %d\r\n"+
+		"Connection: close\r\n\r\n%s",
+		time.Now().UTC().Format(http.TimeFormat), len(decoyBody), decoyBody)
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(resp))
+}
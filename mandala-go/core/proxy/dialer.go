@@ -4,16 +4,17 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
 	"math/rand"
 	"net"
 	"net/http"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
 	"mandala/core/config"
+	"mandala/core/logger"
 
 	"github.com/coder/websocket"
 	"github.com/miekg/dns"
@@ -24,61 +25,224 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-// ECH 缓存
+// ECH 缓存 (按查询域名，遵循 DNS 记录 TTL)
 var (
-	echCache      = make(map[string][]byte)
+	echCache      = make(map[string]echCacheEntry)
 	echCacheMutex sync.RWMutex
 )
 
+type echCacheEntry struct {
+	configs []byte
+	expires time.Time
+}
+
+// ECH 缓存有效期范围：TTL 过短时避免频繁查询，过长时限制密钥轮换的感知延迟
+const (
+	echCacheMinTTL = 5 * time.Minute
+	echCacheMaxTTL = 6 * time.Hour
+)
+
 type Dialer struct {
 	Config *config.OutboundConfig
+
+	// probe 为 true 时仅用于测速，不记录到 LastConnInfo
+	probe bool
 }
 
 func NewDialer(cfg *config.OutboundConfig) *Dialer {
 	return &Dialer{Config: cfg}
 }
 
-// Dial 主入口：实现了 H2 -> H1 的退回机制
+// Dial 等同于 DialContext(context.Background())
 func (d *Dialer) Dial() (net.Conn, error) {
+	return d.DialContext(context.Background())
+}
+
+// DialContext 主入口：启用多路复用时返回复用会话中的逻辑流，QUIC 协议返回 QUIC 流，否则建立新的隧道连接
+// [新增] ctx 取消时中止进行中的排队、TCP 拨号、ECH 查询与 TLS/WebSocket 握手；
+// 多路复用会话的新建同样受 ctx 控制，但已建立的会话由多个连接共享，不随 ctx 关闭；
+// QUIC 的底层会话建立过程不受单个调用方的 ctx 控制
+func (d *Dialer) DialContext(ctx context.Context) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// [新增] 节点短暂不可达 (如 CDN 节点切换) 时按退避间隔重试
+	retries := d.Config.Settings.DialRetries
+	if d.probe {
+		retries = 0
+	}
+	for attempt := 0; ; attempt++ {
+		conn, err := d.dialOnce(ctx)
+		if err == nil || attempt >= retries || !isRetryableDialError(err) {
+			return conn, err
+		}
+		delay := dialRetryDelay(d.Config.DialRetryBackoff(), attempt)
+		logger.Debugf("Dial", "%s 连接失败，%s 后重试 (%d/%d): %v", d.serverAddr(), delay, attempt+1, retries, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// dialOnce 按协议选择一次拨号方式
+func (d *Dialer) dialOnce(ctx context.Context) (net.Conn, error) {
+	// [新增] TUIC / Hysteria2: 在共享 QUIC 连接上打开流
+	if d.Config.UsesQUIC() {
+		return d.dialQUICStream()
+	}
+	if d.Config.UseTrojanGoMux() || d.Config.UseSingMux() {
+		return d.dialMuxStream(ctx)
+	}
+	return d.dialTunnel(ctx)
+}
+
+// dialTunnel 建立一条完整的隧道连接：实现了 H2 -> H1 的退回机制
+func (d *Dialer) dialTunnel(ctx context.Context) (net.Conn, error) {
+	// [新增] 限制同一节点并发中的拨号与握手，平滑突发连接
+	release, err := acquireDialSlot(ctx, d.serverAddr(), d.Config.Settings.DialConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// [新增] 记录各阶段耗时 (调用方未通过 DialWithStats 提供时仅用于日志与状态页)
+	st := dialStatsFrom(ctx)
+	if st == nil {
+		st = &DialStats{}
+		ctx = withDialStats(ctx, st)
+	}
+	start := time.Now()
+
+	// [新增] WebTransport: 在 QUIC 会话上打开流，不经过 TCP 拨号与 TLS 握手
+	if d.usesWebTransport() {
+		return d.dialWebTransport(ctx, st, start)
+	}
+
 	// 尝试 1: 默认模式 (允许 h2，指纹最真实)
 	// false 表示不强制移除 h2
-	conn, negotiated, err := d.handshake(false)
+	conn, negotiated, err := d.handshake(ctx, false)
 	if err != nil {
 		return nil, err
 	}
 
 	// 检查协商结果
-	if negotiated == "h2" {
+	// [修改] gRPC 传输本身基于 HTTP/2，无需退回
+	// [修改] Reality 握手后即为代理协议数据，ALPN 结果仅为模仿目标网站，同样无需退回
+	if negotiated == "h2" && !d.usesH2Transport() && !d.usesReality() {
 		// 如果服务端选择了 h2，我们的 WebSocket 库无法处理
 		// 因此关闭连接，触发退回机制
-		fmt.Println("[Handshake] 协商结果为 h2，WebSocket 不支持，正在退回 http/1.1 重试...")
+		logger.Infof("Handshake", "协商结果为 h2，WebSocket 不支持，正在退回 http/1.1 重试...")
 		conn.Close()
 
 		// 尝试 2: 退回模式 (强制 http/1.1)
 		// true 表示强制移除 h2
-		conn, negotiated, err = d.handshake(true)
+		conn, negotiated, err = d.handshake(ctx, true)
 		if err != nil {
-			return nil, fmt.Errorf("fallback handshake failed: %v", err)
+			return nil, fmt.Errorf("fallback handshake failed: %w", err)
 		}
-		// fmt.Printf("[Handshake] 重试协商结果: %s\n", negotiated)
+		logger.Debugf("Handshake", "重试协商结果: %q", negotiated)
 	}
 
+	// [新增] 记录握手信息供状态页展示
+	info := d.newConnInfo(conn)
+
 	// 握手完成，conn 已经准备好（可能是 TCP 或 uTLS 连接）
 	// 接下来处理 WebSocket 升级
 	if d.Config.Transport != nil && d.Config.Transport.Type == "ws" {
-		return d.upgradeWebsocket(conn)
+		info.Transport = "ws"
+
+		// [新增] 早期数据：延迟到首次写入时再升级，首包随升级请求发出
+		path, edSize := d.wsPathAndEarlyData(d.wsConfigPath())
+		if edSize > 0 {
+			d.recordDial(info, st, start)
+			return newWSEarlyConn(d, conn, path, edSize), nil
+		}
+
+		upgradeStart := time.Now()
+		wsConn, err := d.upgradeWebsocket(ctx, conn, path, nil)
+		st.Upgrade += time.Since(upgradeStart)
+		if err != nil {
+			return nil, err
+		}
+		d.recordDial(info, st, start)
+		return wsConn, nil
+	}
+
+	// [新增] gRPC / HTTP/2 传输：TLS 下要求协商出 h2，明文时直接使用 h2c
+	if d.usesH2Transport() {
+		transportType := d.Config.Transport.Type
+		if d.Config.TLS != nil && d.Config.TLS.Enabled && negotiated != "h2" {
+			conn.Close()
+			return nil, fmt.Errorf("%s transport requires h2, server negotiated %q", transportType, negotiated)
+		}
+
+		var streamConn net.Conn
+		upgradeStart := time.Now()
+		if transportType == "grpc" {
+			streamConn, err = d.dialGRPC(conn)
+		} else {
+			streamConn, err = d.dialHTTP2(conn)
+		}
+		st.Upgrade += time.Since(upgradeStart)
+		if err != nil {
+			return nil, err
+		}
+		info.Transport = transportType
+		d.recordDial(info, st, start)
+		return streamConn, nil
 	}
 
+	d.recordDial(info, st, start)
 	return conn, nil
 }
 
+// serverAddr 返回节点的 host:port 地址 (兼容 IPv6)
+func (d *Dialer) serverAddr() string {
+	return net.JoinHostPort(d.Config.Server, strconv.Itoa(d.Config.ServerPort))
+}
+
+// 默认 TCP 拨号超时
+const defaultDialTimeout = 5 * time.Second
+
+// dialTimeout 返回配置的 TCP 拨号超时
+func (d *Dialer) dialTimeout() time.Duration {
+	if d.Config.Settings.DialTimeout > 0 {
+		return time.Duration(d.Config.Settings.DialTimeout) * time.Millisecond
+	}
+	return defaultDialTimeout
+}
+
 // handshake 执行底层的 TCP 连接和 TLS 握手
 // forceH1: 是否强制只使用 http/1.1 (剔除 h2)
 // 返回: 连接对象, 协商出的协议(ALPN), 错误
-func (d *Dialer) handshake(forceH1 bool) (net.Conn, string, error) {
+func (d *Dialer) handshake(ctx context.Context, forceH1 bool) (net.Conn, string, error) {
+	conn, negotiated, err := d.handshakeOnce(ctx, forceH1, nil)
+
+	// [新增] 服务端拒绝 ECH 并在握手中下发新密钥 (retry_configs) 时，以新密钥重新连接一次
+	var rejection *utls.ECHRejectionError
+	if err != nil && errors.As(err, &rejection) && len(rejection.RetryConfigList) > 0 && ctx.Err() == nil {
+		logger.Infof("ECH", "%s 拒绝 ECH 并下发新密钥，使用新密钥重试握手", d.serverAddr())
+		d.storeECHConfig(rejection.RetryConfigList)
+		return d.handshakeOnce(ctx, forceH1, rejection.RetryConfigList)
+	}
+	return conn, negotiated, err
+}
+
+// handshakeOnce 执行一次 TCP 连接和 TLS 握手；echRetry 非空时以其作为 ECH 密钥，不再查询缓存
+func (d *Dialer) handshakeOnce(ctx context.Context, forceH1 bool, echRetry []byte) (net.Conn, string, error) {
+	st := dialStatsFrom(ctx)
+	if st == nil {
+		st = &DialStats{}
+	}
+
 	// 1. 基础 TCP 连接
-	targetAddr := fmt.Sprintf("%s:%d", d.Config.Server, d.Config.ServerPort)
-	conn, err := net.DialTimeout("tcp", targetAddr, 5*time.Second)
+	phaseStart := time.Now()
+	conn, err := d.dialServer(ctx, d.dialTimeout())
+	st.TCP += time.Since(phaseStart)
 	if err != nil {
 		return nil, "", err
 	}
@@ -89,9 +253,16 @@ func (d *Dialer) handshake(forceH1 bool) (net.Conn, string, error) {
 	}
 
 	// 2. TLS/ECH 逻辑
+	// [新增] Reality 依靠 SessionId 中的认证数据，不与 ECH 同时使用
+	reality := d.usesReality()
 	var echConfigList []byte
-	if d.Config.TLS.EnableECH {
-		echConfigList = d.getECHConfig()
+	if d.Config.TLS.EnableECH && !reality {
+		echConfigList = echRetry
+		if echConfigList == nil {
+			phaseStart = time.Now()
+			echConfigList = d.getECHConfig(ctx)
+			st.ECH += time.Since(phaseStart)
+		}
 	}
 
 	// ECH 必须配合 TLS 1.3
@@ -113,69 +284,149 @@ func (d *Dialer) handshake(forceH1 bool) (net.Conn, string, error) {
 		uTlsConfig.ServerName = d.Config.Server
 	}
 
+	// [新增] 自定义根证书：私有 CA 签发的节点证书仍做完整校验
+	if uTlsConfig.RootCAs, err = d.rootCAs(); err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("tls.ca_cert: %w", err)
+	}
+
+	// [新增] Reality：仅支持 TLS 1.3，证书由认证密钥校验而非 CA
+	var rs *realityState
+	if reality {
+		rs = &realityState{}
+		uTlsConfig.MinVersion = tls.VersionTLS13
+		uTlsConfig.InsecureSkipVerify = true
+		uTlsConfig.SessionTicketsDisabled = true
+		uTlsConfig.VerifyPeerCertificate = rs.verifyRealityCert
+	}
+
+	// [新增] 证书公钥固定：以指纹代替 CA 链校验，insecure 不再生效
+	if d.usesCertPinning() {
+		verify, err := pinnedCertVerifier(d.Config.TLS.PinnedSHA256)
+		if err != nil {
+			conn.Close()
+			return nil, "", err
+		}
+		uTlsConfig.InsecureSkipVerify = true
+		uTlsConfig.VerifyPeerCertificate = verify
+	}
+
+	// [新增] 会话复用：同一节点的拨号共享会话缓存
+	// 与浏览器一致，没有可用票据时不发送空的 PSK 扩展；指纹模版缺少对应扩展时跳过复用而非报错
+	if d.useSessionResumption() {
+		uTlsConfig.ClientSessionCache = tlsSessionCache(d.serverAddr())
+		uTlsConfig.OmitEmptyPsk = true
+		uTlsConfig.PreferSkipResumptionOnNilExtension = true
+	}
+
 	// 处理 Fragment
+	var fragConn *FragmentConn
 	if d.Config.Settings.Fragment {
-		conn = &FragmentConn{Conn: conn, active: true}
+		fragConn = NewFragmentConn(conn, d.Config.Settings.FragmentOptions)
+		conn = fragConn
 	}
 
-	// 使用 HelloCustom 以便修改指纹
-	uConn := utls.UClient(conn, uTlsConfig, utls.HelloCustom)
-	
-	// 加载 Chrome 模版
-	spec, err := utls.UTLSIdToSpec(utls.HelloChrome_Auto)
-	if err != nil {
-		conn.Close()
-		return nil, "", fmt.Errorf("spec error: %v", err)
-	}
+	// [修改] 指纹可配置 (chrome/firefox/safari/ios/edge/randomized)
+	helloID, _ := d.clientHelloID()
 
-	// [关键逻辑] 根据 forceH1 参数调整 ALPN
+	// [修改] ALPN 可配置；退回模式下强制只留 http/1.1
+	alpn := d.tlsALPN()
 	if forceH1 {
-		// 强制剔除 h2，只留 http/1.1
-		foundALPN := false
-		for i, ext := range spec.Extensions {
-			if alpn, ok := ext.(*utls.ALPNExtension); ok {
-				alpn.AlpnProtocols = []string{"http/1.1"}
-				spec.Extensions[i] = alpn
-				foundALPN = true
-				break
-			}
-		}
-		if !foundALPN {
-			spec.Extensions = append(spec.Extensions, &utls.ALPNExtension{AlpnProtocols: []string{"http/1.1"}})
+		alpn = []string{"http/1.1"}
+	}
+
+	var uConn *utls.UConn
+	spec, err := utls.UTLSIdToSpec(helloID)
+	if err != nil {
+		// 随机化指纹没有固定模版，由 uTLS 按配置生成 ClientHello，ALPN 取自 NextProtos
+		if alpn != nil {
+			uTlsConfig.NextProtos = alpn
 		}
+		uConn = utls.UClient(conn, uTlsConfig, helloID)
 	} else {
-		// 默认模式：保持 spec 原样 (通常包含 h2 和 http/1.1)
-		// 这让指纹看起来最像真实的 Chrome
+		// 使用 HelloCustom 以便修改指纹模版
+		uConn = utls.UClient(conn, uTlsConfig, utls.HelloCustom)
+
+		// [关键逻辑] 模版中的 ALPN 扩展会覆盖 NextProtos，需直接改写扩展
+		// 未指定时保持 spec 原样 (通常包含 h2 和 http/1.1)，这让指纹看起来最像真实的浏览器
+		if alpn != nil {
+			setSpecALPN(&spec, alpn)
+		}
+
+		// [新增] 部分模版不含 PSK 扩展 (浏览器只在恢复会话时发送)，启用复用时补在末尾
+		if uTlsConfig.ClientSessionCache != nil && !hasPSKExtension(spec.Extensions) {
+			spec.Extensions = append(spec.Extensions, &utls.UtlsPreSharedKeyExtension{})
+		}
+
+		if err := uConn.ApplyPreset(&spec); err != nil {
+			conn.Close()
+			return nil, "", fmt.Errorf("preset error: %v", err)
+		}
 	}
 
-	if err := uConn.ApplyPreset(&spec); err != nil {
-		conn.Close()
-		return nil, "", fmt.Errorf("preset error: %v", err)
+	if reality {
+		if err := d.sealRealityHello(uConn, rs); err != nil {
+			conn.Close()
+			return nil, "", err
+		}
 	}
 
-	if err := uConn.Handshake(); err != nil {
+	phaseStart = time.Now()
+	err = uConn.HandshakeContext(ctx)
+	st.TLS += time.Since(phaseStart)
+	if err != nil {
 		conn.Close()
-		return nil, "", fmt.Errorf("handshake failed: %v", err)
+		// [新增] 携带 ECH 握手失败时密钥可能已轮换，丢弃缓存以便下次拨号重新获取
+		if len(echConfigList) > 0 && ctx.Err() == nil {
+			d.invalidateECHConfig()
+		}
+		return nil, "", fmt.Errorf("handshake failed: %w", err)
+	}
+	if fragConn != nil {
+		fragConn.Stop()
 	}
 
 	// 返回协商出的协议 (例如 "h2" 或 "http/1.1")
-	return uConn, uConn.ConnectionState().NegotiatedProtocol, nil
+	negotiated := uConn.ConnectionState().NegotiatedProtocol
+	logger.Debugf("TLS", "%s ALPN 协商结果: %q", d.serverAddr(), negotiated)
+	return uConn, negotiated, nil
 }
 
-// getECHConfig 封装 ECH 获取与缓存逻辑
-func (d *Dialer) getECHConfig() []byte {
-	queryDomain := d.Config.TLS.ECHPublicName
-	if queryDomain == "" {
-		queryDomain = d.Config.TLS.ServerName
+// echQueryDomain 返回查询 ECH 密钥使用的域名
+func (d *Dialer) echQueryDomain() string {
+	if d.Config.TLS.ECHPublicName != "" {
+		return d.Config.TLS.ECHPublicName
 	}
+	return d.Config.TLS.ServerName
+}
+
+// invalidateECHConfig 丢弃当前节点的 ECH 缓存，强制下次拨号重新查询
+func (d *Dialer) invalidateECHConfig() {
+	queryDomain := d.echQueryDomain()
+	echCacheMutex.Lock()
+	delete(echCache, queryDomain)
+	echCacheMutex.Unlock()
+	logger.Warnf("ECH", "握手失败，已清除缓存密钥: %s", queryDomain)
+}
+
+// storeECHConfig 缓存服务端在握手中下发的 ECH 密钥 (按最短有效期，到期后仍以 DNS 记录为准)
+func (d *Dialer) storeECHConfig(configs []byte) {
+	echCacheMutex.Lock()
+	echCache[d.echQueryDomain()] = echCacheEntry{configs: configs, expires: time.Now().Add(echCacheMinTTL)}
+	echCacheMutex.Unlock()
+}
+
+// getECHConfig 封装 ECH 获取与缓存逻辑
+func (d *Dialer) getECHConfig(ctx context.Context) []byte {
+	queryDomain := d.echQueryDomain()
 
 	echCacheMutex.RLock()
 	cached, ok := echCache[queryDomain]
 	echCacheMutex.RUnlock()
 
-	if ok {
-		fmt.Printf("[ECH] 使用缓存密钥: %s\n", queryDomain)
-		return cached
+	if ok && time.Now().Before(cached.expires) {
+		logger.Debugf("ECH", "使用缓存密钥: %s", queryDomain)
+		return cached.configs
 	}
 
 	dohURL := d.Config.TLS.ECHDoHURL
@@ -183,24 +434,42 @@ func (d *Dialer) getECHConfig() []byte {
 		dohURL = "https://1.1.1.1/dns-query"
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	echTimeout := d.dialTimeout()
+	if d.Config.TLS.ECHTimeout > 0 {
+		echTimeout = time.Duration(d.Config.TLS.ECHTimeout) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, echTimeout)
 	defer cancel()
-	
-	configs, err := resolveECHConfig(ctx, dohURL, queryDomain)
+
+	configs, ttl, err := resolveECHConfig(ctx, dohURL, d.Config.TLS.ECHDoHBootstrapIP, queryDomain)
 	if err == nil && len(configs) > 0 {
+		lifetime := time.Duration(ttl) * time.Second
+		if lifetime < echCacheMinTTL {
+			lifetime = echCacheMinTTL
+		} else if lifetime > echCacheMaxTTL {
+			lifetime = echCacheMaxTTL
+		}
 		echCacheMutex.Lock()
-		echCache[queryDomain] = configs
+		echCache[queryDomain] = echCacheEntry{configs: configs, expires: time.Now().Add(lifetime)}
 		echCacheMutex.Unlock()
-		fmt.Printf("[ECH] 密钥获取成功\n")
+		logger.Infof("ECH", "密钥获取成功 (缓存 %v)", lifetime)
 		return configs
 	}
-	
-	fmt.Printf("[ECH] 警告: 获取失败: %v\n", err)
+
+	// 查询失败时继续使用已过期的缓存，好过完全不使用 ECH
+	if ok {
+		logger.Warnf("ECH", "警告: 刷新失败，沿用过期密钥: %v", err)
+		return cached.configs
+	}
+
+	logger.Warnf("ECH", "警告: 获取失败: %v", err)
 	return nil
 }
 
 // upgradeWebsocket 封装 WebSocket 握手逻辑
-func (d *Dialer) upgradeWebsocket(conn net.Conn) (net.Conn, error) {
+// path 为实际请求路径 (见 wsPathAndEarlyData)；early 非空时作为早期数据编码进升级请求
+// ctx 取消时中止升级请求
+func (d *Dialer) upgradeWebsocket(ctx context.Context, conn net.Conn, path string, early []byte) (net.Conn, error) {
 	scheme := "ws"
 	// 如果是 TLS 连接，scheme 需用 wss 标记逻辑（虽然底层已加密，但库行为需要）
 	// 修正：由于我们是自己 dial 的 TLS conn，对于 websocket 库来说，这就是一个普通的 RWC (ReadWriteCloser)。
@@ -210,15 +479,7 @@ func (d *Dialer) upgradeWebsocket(conn net.Conn) (net.Conn, error) {
 	// 注意：Scheme 必须匹配，如果底层是 TLS，通常 url 看起来是 wss://，但这里我们欺骗库
 	// 让他只发 HTTP Upgrade 包。
 	
-	path := d.Config.Transport.Path
-	if path == "" {
-		path = "/"
-	}
-	
-	host := d.Config.TLS.ServerName
-	if host == "" {
-		host = d.Config.Server
-	}
+	host := d.transportHost()
 	
 	wsURL := fmt.Sprintf("%s://%s%s", scheme, host, path)
 	
@@ -238,7 +499,7 @@ func (d *Dialer) upgradeWebsocket(conn net.Conn) (net.Conn, error) {
 		},
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	opts := &websocket.DialOptions{
@@ -246,98 +507,153 @@ func (d *Dialer) upgradeWebsocket(conn net.Conn) (net.Conn, error) {
 		HTTPHeader: headers,
 		CompressionMode: websocket.CompressionDisabled,
 	}
+	// [新增] 早期数据走 Subprotocols，库会校验服务端回显的协议头与之一致
+	if len(early) > 0 {
+		opts.Subprotocols = []string{base64.RawURLEncoding.EncodeToString(early)}
+	}
 
 	wsConn, _, err := websocket.Dial(ctx, wsURL, opts)
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("websocket dial failed: %v", err)
-	}
-
-	return websocket.NetConn(context.Background(), wsConn, websocket.MessageBinary), nil
-}
-
-// resolveECHConfig (保持不变)
-func resolveECHConfig(ctx context.Context, dohURL string, domain string) ([]byte, error) {
-	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(domain), dns.TypeHTTPS)
-	data, err := msg.Pack()
-	if err != nil {
-		return nil, fmt.Errorf("pack: %v", err)
+		return nil, fmt.Errorf("websocket dial failed: %w", err)
 	}
 
-	b64Query := base64.RawURLEncoding.EncodeToString(data)
-	
-	var reqURL string
-	if strings.Contains(dohURL, "?") {
-		reqURL = fmt.Sprintf("%s&dns=%s", dohURL, b64Query)
-	} else {
-		reqURL = fmt.Sprintf("%s?dns=%s", dohURL, b64Query)
-	}
+	// [新增] 限制单条消息长度 (库默认仅 32KB)
+	wsConn.SetReadLimit(int64(d.Config.MaxWSMessageSize()))
 
-	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
-	if err != nil {
-		return nil, err
+	// [新增] 定期发送 Ping，保持中间设备的连接状态并检测失效的隧道
+	if interval := d.Config.KeepAliveInterval(); interval > 0 {
+		go wsKeepAlive(wsConn, interval)
 	}
-	req.Header.Set("Accept", "application/dns-message")
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			ResponseHeaderTimeout: 5 * time.Second,
-		},
-	}
-	
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	return newWSConn(wsConn), nil
+}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("status: %d", resp.StatusCode)
+// wsKeepAlive 每隔 interval 发送一次 Ping，一个间隔内未收到 Pong 时关闭连接，
+// 使阻塞在读取上的转发循环立即返回，而不是等到下一次写入失败才发现隧道已断开。
+// Pong 由转发循环的读取过程处理；连接关闭后 Ping 返回错误，协程随之退出
+func wsKeepAlive(conn *websocket.Conn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := conn.Ping(ctx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Warnf("WebSocket", "Ping 超时，关闭空闲隧道")
+			}
+			conn.CloseNow()
+			return
+		}
 	}
+}
 
-	body, err := io.ReadAll(resp.Body)
+// resolveECHConfig 通过 DoH 查询 HTTPS 记录中的 ECH 配置，同时返回记录的 TTL (秒)
+// bootstrapIP 非空时直接连接该地址，不解析 DoH 服务器的域名
+func resolveECHConfig(ctx context.Context, dohURL, bootstrapIP, domain string) ([]byte, uint32, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(domain), dns.TypeHTTPS)
+	respMsg, err := dohExchange(ctx, dohURL, bootstrapIP, msg)
 	if err != nil {
-		return nil, err
-	}
-
-	respMsg := new(dns.Msg)
-	if err := respMsg.Unpack(body); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	for _, ans := range respMsg.Answer {
 		if https, ok := ans.(*dns.HTTPS); ok {
 			for _, val := range https.Value {
 				if ech, ok := val.(*dns.SVCBECHConfig); ok {
-					return ech.ECH, nil
+					return ech.ECH, https.Hdr.Ttl, nil
 				}
 			}
 		}
 	}
 
-	return nil, fmt.Errorf("no ech found")
+	return nil, 0, fmt.Errorf("no ech found")
 }
 
-// FragmentConn 保持不变
+// FragmentConn 将 TLS 握手记录拆分为多次写入，干扰基于单包匹配 SNI 的 DPI
 type FragmentConn struct {
 	net.Conn
-	active bool
+	active  bool
+	opts    config.FragmentConfig
+	records int // 已分片的记录数
+}
+
+// NewFragmentConn 按配置创建分片连接
+func NewFragmentConn(c net.Conn, opts config.FragmentConfig) *FragmentConn {
+	return &FragmentConn{Conn: c, active: true, opts: opts}
+}
+
+// Stop 握手完成后停止分片
+func (f *FragmentConn) Stop() {
+	f.active = false
 }
 
 func (f *FragmentConn) Write(b []byte) (int, error) {
-	if f.active && len(b) > 50 && b[0] == 0x16 {
+	if !f.active || len(b) <= 50 || b[0] != 0x16 {
+		return f.Conn.Write(b)
+	}
+
+	f.records++
+	limit := f.opts.Records
+	if limit == 0 {
+		limit = 1
+	}
+	if limit > 0 && f.records >= limit {
 		f.active = false
-		cut := 5 + rand.Intn(10)
-		n1, err := f.Conn.Write(b[:cut])
+	}
+
+	written := 0
+	pieces := f.splitSizes(len(b))
+	for i, size := range pieces {
+		n, err := f.Conn.Write(b[written : written+size])
+		written += n
 		if err != nil {
-			return n1, err
+			return written, err
+		}
+		if i < len(pieces)-1 {
+			time.Sleep(f.delay())
+		}
+	}
+	return written, nil
+}
+
+// splitSizes 计算每个分片的长度，最后一片包含剩余全部数据
+func (f *FragmentConn) splitSizes(total int) []int {
+	minSize, maxSize := f.opts.MinSize, f.opts.MaxSize
+	if maxSize <= 0 {
+		// 默认：仅在记录头附近切一刀
+		cut := 5 + rand.Intn(10)
+		return []int{cut, total - cut}
+	}
+	if minSize <= 0 {
+		minSize = 1
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+
+	var sizes []int
+	for remaining := total; remaining > 0; {
+		size := minSize + rand.Intn(maxSize-minSize+1)
+		if size > remaining {
+			size = remaining
 		}
-		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
-		n2, err := f.Conn.Write(b[cut:])
-		return n1 + n2, err
+		sizes = append(sizes, size)
+		remaining -= size
+	}
+	return sizes
+}
+
+// delay 返回分片之间的随机等待时间
+func (f *FragmentConn) delay() time.Duration {
+	minDelay, maxDelay := f.opts.MinDelayMs, f.opts.MaxDelayMs
+	if minDelay <= 0 && maxDelay <= 0 {
+		return time.Duration(rand.Intn(5)) * time.Millisecond
+	}
+	if maxDelay < minDelay {
+		maxDelay = minDelay
 	}
-	return f.Conn.Write(b)
+	return time.Duration(minDelay+rand.Intn(maxDelay-minDelay+1)) * time.Millisecond
 }
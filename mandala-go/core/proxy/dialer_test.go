@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+
+	"github.com/miekg/dns"
+)
+
+// 取消 ctx 时中止进行中的拨号：TLS 握手与 SOCKS5 UDP 关联在服务端无响应时立即返回
+func TestDialContextCancel(t *testing.T) {
+	host, port, _ := startSilentServer(t)
+
+	for _, tc := range []struct {
+		name string
+		cfg  *config.OutboundConfig
+		dial func(d *Dialer, ctx context.Context) error
+	}{
+		{"tls handshake", &config.OutboundConfig{
+			Type: "trojan", Server: host, ServerPort: port, Password: "secret",
+			TLS: &config.TLSConfig{Enabled: true, ServerName: "example.com"},
+		}, func(d *Dialer, ctx context.Context) error {
+			_, err := d.DialContext(ctx)
+			return err
+		}},
+		{"socks5 udp associate", &config.OutboundConfig{
+			Type: "socks", Server: host, ServerPort: port,
+		}, func(d *Dialer, ctx context.Context) error {
+			_, err := d.DialUDP(ctx, "1.1.1.1", 53)
+			return err
+		}},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(200*time.Millisecond, cancel)
+		start := time.Now()
+		err := tc.dial(NewDialer(tc.cfg), ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: err = %v, want context.Canceled", tc.name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: dial returned after %s, cancel was not honoured", tc.name, elapsed)
+		}
+		cancel()
+	}
+}
+
+// fragmentWrites 经管道执行 write 对 FragmentConn 的写入，返回对端观察到的每次写入
+func fragmentWrites(t *testing.T, opts config.FragmentConfig, write func(f *FragmentConn)) [][]byte {
+	t.Helper()
+	conn, peer := net.Pipe()
+	done := make(chan [][]byte)
+	go func() {
+		var writes [][]byte
+		buf := make([]byte, 4096)
+		for {
+			n, err := peer.Read(buf)
+			if err != nil {
+				done <- writes
+				return
+			}
+			writes = append(writes, append([]byte(nil), buf[:n]...))
+		}
+	}()
+	f := NewFragmentConn(conn, opts)
+	write(f)
+	f.Close()
+	return <-done
+}
+
+// tlsRecord 构造长度为 n 的握手记录 (首字节 0x16)
+func tlsRecord(n int) []byte {
+	b := bytes.Repeat([]byte{0xAB}, n)
+	b[0] = 0x16
+	return b
+}
+
+// writeAll 依次写入 data，任一写入不完整时失败
+func writeAll(t *testing.T, f *FragmentConn, data ...[]byte) {
+	t.Helper()
+	for _, b := range data {
+		if n, err := f.Write(b); err != nil || n != len(b) {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+}
+
+// 握手记录按配置拆成多次写入，拼接后与原数据一致；超出记录数或 Stop 后原样写入
+func TestFragmentConn(t *testing.T) {
+	record := tlsRecord(200)
+
+	t.Run("default first record", func(t *testing.T) {
+		writes := fragmentWrites(t, config.FragmentConfig{}, func(f *FragmentConn) {
+			writeAll(t, f, record, record)
+		})
+		if len(writes) != 3 || len(writes[0]) < 5 || len(writes[0]) > 14 {
+			t.Fatalf("%d writes (first %d bytes), want a 5-14 byte cut then one whole record", len(writes), len(writes[0]))
+		}
+		if !bytes.Equal(bytes.Join(writes[:2], nil), record) || !bytes.Equal(writes[2], record) {
+			t.Fatal("fragments do not reassemble the records")
+		}
+	})
+
+	t.Run("size range", func(t *testing.T) {
+		opts := config.FragmentConfig{MinSize: 10, MaxSize: 20}
+		writes := fragmentWrites(t, opts, func(f *FragmentConn) {
+			writeAll(t, f, record)
+		})
+		for i, w := range writes {
+			if len(w) > opts.MaxSize || (i < len(writes)-1 && len(w) < opts.MinSize) {
+				t.Errorf("fragment %d is %d bytes, want %d-%d", i, len(w), opts.MinSize, opts.MaxSize)
+			}
+		}
+		if !bytes.Equal(bytes.Join(writes, nil), record) {
+			t.Fatal("fragments do not reassemble the record")
+		}
+	})
+
+	// Records 为 -1 时持续分片直到 Stop；非握手记录与不超过 50 字节的记录不分片
+	t.Run("until stop", func(t *testing.T) {
+		writes := fragmentWrites(t, config.FragmentConfig{Records: -1, MinSize: 100, MaxSize: 100}, func(f *FragmentConn) {
+			writeAll(t, f, record, bytes.Repeat([]byte{0x17}, 200), tlsRecord(50), record)
+			f.Stop()
+			writeAll(t, f, record)
+		})
+		want := []int{100, 100, 200, 50, 100, 100, 200}
+		if len(writes) != len(want) {
+			t.Fatalf("%d writes, want %d", len(writes), len(want))
+		}
+		for i, w := range writes {
+			if len(w) != want[i] {
+				t.Errorf("write %d is %d bytes, want %d", i, len(w), want[i])
+			}
+		}
+	})
+}
+
+// newECHDoHServer 启动 DoH 服务，对任意域名的 HTTPS 查询应答以域名为内容的 ECH 配置，记录 TTL 取自 ttls[域名]
+func newECHDoHServer(t *testing.T, ttls map[string]uint32) (srv *httptest.Server, queries *atomic.Int32) {
+	t.Helper()
+	queries = new(atomic.Int32)
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		data, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		req := new(dns.Msg)
+		if err != nil || req.Unpack(data) != nil || len(req.Question) != 1 || req.Question[0].Qtype != dns.TypeHTTPS {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		q := req.Question[0]
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &dns.HTTPS{SVCB: dns.SVCB{
+			Hdr:      dns.RR_Header{Name: q.Name, Rrtype: dns.TypeHTTPS, Class: dns.ClassINET, Ttl: ttls[q.Name]},
+			Priority: 1,
+			Target:   ".",
+			Value:    []dns.SVCBKeyValue{&dns.SVCBECHConfig{ECH: []byte(q.Name)}},
+		}})
+		out, _ := resp.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(out)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, queries
+}
+
+// ECH 配置按记录 TTL 缓存，TTL 限制在 5 分钟至 6 小时之间；缓存有效期内再次拨号不重新查询
+func TestECHConfigCache(t *testing.T) {
+	ttls := map[string]uint32{"short.ech.test.": 60, "medium.ech.test.": 3600, "long.ech.test.": 86400}
+	srv, queries := newECHDoHServer(t, ttls)
+	trustDoHServer(t, srv)
+	t.Cleanup(func() {
+		echCacheMutex.Lock()
+		for name := range ttls {
+			delete(echCache, strings.TrimSuffix(name, "."))
+		}
+		echCacheMutex.Unlock()
+	})
+
+	for _, tc := range []struct {
+		name string
+		want time.Duration
+	}{
+		{"short.ech.test", echCacheMinTTL},
+		{"medium.ech.test", time.Hour},
+		{"long.ech.test", echCacheMaxTTL},
+	} {
+		d := NewDialer(&config.OutboundConfig{TLS: &config.TLSConfig{
+			EnableECH:         true,
+			ECHPublicName:     tc.name,
+			ECHDoHURL:         dohURL(srv, "example.com"),
+			ECHDoHBootstrapIP: "127.0.0.1",
+		}})
+		before := queries.Load()
+		for i := 0; i < 2; i++ {
+			if got := d.getECHConfig(context.Background()); string(got) != tc.name+"." {
+				t.Fatalf("%s: ECH config = %q", tc.name, got)
+			}
+		}
+		if n := queries.Load() - before; n != 1 {
+			t.Errorf("%s: %d DoH queries for two dials, want 1", tc.name, n)
+		}
+		echCacheMutex.RLock()
+		lifetime := time.Until(echCache[tc.name].expires)
+		echCacheMutex.RUnlock()
+		if lifetime > tc.want || lifetime < tc.want-time.Minute {
+			t.Errorf("%s: cached for %s, want %s", tc.name, lifetime, tc.want)
+		}
+	}
+}
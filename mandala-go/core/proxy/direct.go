@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// DialDirect 绕过代理直接连接目标 (路由规则为 direct 时使用)
+// network 为 "tcp" 或 "udp"；UDP 返回已连接的套接字，每次 Read/Write 对应一个数据报。
+// Android 端已将本应用排除在 VPN 之外，或由套接字保护回调排除，直连流量不会回流到 TUN
+// [新增] TCP 目标为域名时在本地解析全部地址，以 Happy Eyeballs 方式错开尝试，
+// 部分地址被阻断 (常见于 CDN) 时不必等待完整超时
+func (d *Dialer) DialDirect(network, host string, port int) (net.Conn, error) {
+	if network == "tcp" && net.ParseIP(host) == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout())
+		defer cancel()
+		ips, err := lookupServerIPs(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		return d.dialDirectIPs(ctx, ips, port)
+	}
+
+	dialer := net.Dialer{Timeout: d.dialTimeout(), KeepAlive: -1, Control: protectControl}
+	if keepAlive := d.Config.KeepAliveInterval(); keepAlive > 0 {
+		dialer.KeepAlive = keepAlive
+	}
+	return dialer.Dial(network, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// dialDirectIPs 按地址族交替排列目标的候选 IP，错开发起连接并返回最先成功的连接
+func (d *Dialer) dialDirectIPs(ctx context.Context, ips []net.IP, port int) (net.Conn, error) {
+	ips = interleaveFamilies(ips, d.Config.Settings.PreferIPv6)
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	}
+	return raceDial(ctx, addrs, d.Config.KeepAliveInterval())
+}
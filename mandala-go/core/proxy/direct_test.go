@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+)
+
+// 目标的部分地址不可达 (拒绝连接或无响应) 时，错开尝试其余地址而不等待完整超时
+func TestDialDirectIPsSkipsUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	d := NewDialer(&config.OutboundConfig{})
+	for _, first := range []string{"127.0.0.2", "192.0.2.1"} {
+		ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout())
+		start := time.Now()
+		conn, err := d.dialDirectIPs(ctx, []net.IP{net.ParseIP(first), net.ParseIP("127.0.0.1")}, port)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s first: %v", first, err)
+		}
+		if got := conn.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+			t.Fatalf("%s first: connected to %s", first, got)
+		}
+		conn.Close()
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("%s first: took %s", first, elapsed)
+		}
+	}
+}
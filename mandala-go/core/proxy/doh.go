@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ECH 密钥查询使用的 DoH 客户端 (按引导 IP 区分)，跨查询复用连接
+var (
+	dohClients   = make(map[string]*http.Client)
+	dohClientsMu sync.Mutex
+)
+
+// dohRootCAs 校验 DoH 服务器证书的根证书池，nil 表示系统根证书 (测试中替换)
+var dohRootCAs *x509.CertPool
+
+// dohClient 返回 DoH 客户端，不存在时创建
+// bootstrapIP 非空时直接连接该地址，不经系统 DNS 解析 DoH 服务器的域名 (SNI 与 Host 仍为原域名)；
+// 系统 DNS 可能被污染，或在 VPN 建立后回流到 TUN
+// [修改] 查询结果决定节点的拨号地址，服务器证书始终按 DoH URL 中的域名校验 (经引导 IP 连接时也是如此)，
+// 防止链路上的攻击者伪造应答
+func dohClient(bootstrapIP string) *http.Client {
+	dohClientsMu.Lock()
+	defer dohClientsMu.Unlock()
+	if client, ok := dohClients[bootstrapIP]; ok {
+		return client
+	}
+
+	// [新增] DoH 请求直接发往公共解析服务，套接字同样需要排除在 VPN 之外
+	dialer := &net.Dialer{Control: protectControl}
+	dial := dialer.DialContext
+	if bootstrapIP != "" {
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(bootstrapIP, port))
+		}
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dial,
+			TLSClientConfig:       &tls.Config{RootCAs: dohRootCAs},
+			ResponseHeaderTimeout: 5 * time.Second,
+			IdleConnTimeout:       30 * time.Second,
+			MaxIdleConnsPerHost:   1,
+		},
+	}
+	dohClients[bootstrapIP] = client
+	return client
+}
+
+// dohExchange 经 DoH (RFC 8484 GET) 发送一次 DNS 查询并返回应答
+// bootstrapIP 非空时直接连接该地址，不解析 DoH 服务器的域名
+func dohExchange(ctx context.Context, dohURL, bootstrapIP string, msg *dns.Msg) (*dns.Msg, error) {
+	data, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("pack: %v", err)
+	}
+
+	b64Query := base64.RawURLEncoding.EncodeToString(data)
+
+	var reqURL string
+	if strings.Contains(dohURL, "?") {
+		reqURL = fmt.Sprintf("%s&dns=%s", dohURL, b64Query)
+	} else {
+		reqURL = fmt.Sprintf("%s?dns=%s", dohURL, b64Query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := dohClient(bootstrapIP).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	respMsg := new(dns.Msg)
+	if err := respMsg.Unpack(body); err != nil {
+		return nil, err
+	}
+	return respMsg, nil
+}
+
+// lookupDoH 经 DoH 查询域名的 A 与 AAAA 记录，IPv4 地址排在前面
+// 任一记录类型查询成功即返回结果，两者均失败时返回 A 记录的错误
+func lookupDoH(ctx context.Context, dohURL, bootstrapIP, host string) ([]net.IP, error) {
+	type result struct {
+		ips []net.IP
+		err error
+	}
+	query := func(qtype uint16, out chan<- result) {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(host), qtype)
+		resp, err := dohExchange(ctx, dohURL, bootstrapIP, msg)
+		if err != nil {
+			out <- result{err: err}
+			return
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			out <- result{err: fmt.Errorf("%s: %s", dns.TypeToString[qtype], dns.RcodeToString[resp.Rcode])}
+			return
+		}
+		var ips []net.IP
+		for _, ans := range resp.Answer {
+			switch rr := ans.(type) {
+			case *dns.A:
+				ips = append(ips, rr.A)
+			case *dns.AAAA:
+				ips = append(ips, rr.AAAA)
+			}
+		}
+		out <- result{ips: ips}
+	}
+
+	v4, v6 := make(chan result, 1), make(chan result, 1)
+	go query(dns.TypeA, v4)
+	go query(dns.TypeAAAA, v6)
+	r4, r6 := <-v4, <-v6
+	if r4.err != nil && r6.err != nil {
+		return nil, r4.err
+	}
+
+	ips := append(r4.ips, r6.ips...)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address found for %s", host)
+	}
+	return ips, nil
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnTracker 跟踪进行中的转发，用于优雅停止时等待其自然结束
+type ConnTracker struct {
+	wg     sync.WaitGroup
+	active atomic.Int64
+}
+
+// Begin 登记一个转发，返回的 done 在转发结束时调用 (可重复调用)
+func (t *ConnTracker) Begin() (done func()) {
+	t.wg.Add(1)
+	t.active.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.active.Add(-1)
+			t.wg.Done()
+		})
+	}
+}
+
+// Active 返回进行中的转发数量
+func (t *ConnTracker) Active() int {
+	return int(t.active.Load())
+}
+
+// Wait 最多等待 timeout，返回超时后仍未结束的转发数量 (全部结束时为 0)
+func (t *ConnTracker) Wait(timeout time.Duration) int {
+	if t.Active() == 0 {
+		return 0
+	}
+	if timeout <= 0 {
+		return t.Active()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return 0
+	case <-timer.C:
+		return t.Active()
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// earlyDataTimeout 等待客户端首包的时间窗口
+	// 请求优先的协议 (HTTP/TLS) 会在此窗口内发出首包；服务端优先的协议 (SSH/SMTP) 将超时并单独发送握手
+	earlyDataTimeout = 100 * time.Millisecond
+	// earlyDataSize 与握手包合并的首包最大长度
+	earlyDataSize = 16 * 1024
+)
+
+// ReadEarlyData 在短时间窗口内读取客户端首包，用于与协议握手包合并为一次写入
+// 超时不视为错误，返回空数据
+func ReadEarlyData(conn net.Conn) ([]byte, error) {
+	buf := make([]byte, earlyDataSize)
+	conn.SetReadDeadline(time.Now().Add(earlyDataTimeout))
+	n, err := conn.Read(buf)
+	conn.SetReadDeadline(time.Time{})
+
+	if err != nil && n == 0 {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// confirmConn 在后台发起对远程连接的首次读取，使调用方可以在宽限期内
+// 观察服务端是否已接受握手 (返回数据) 或已断开 (返回错误)。
+// 不使用读超时实现，因为 WebSocket NetConn 的读超时会直接关闭底层连接。
+type confirmConn struct {
+	net.Conn
+	done    chan struct{}
+	buf     []byte
+	err     error
+	drained bool
+}
+
+func newConfirmConn(c net.Conn) *confirmConn {
+	cc := &confirmConn{Conn: c, done: make(chan struct{})}
+	go func() {
+		buf := make([]byte, earlyDataSize)
+		n, err := c.Read(buf)
+		cc.buf, cc.err = buf[:n], err
+		close(cc.done)
+	}()
+	return cc
+}
+
+// wait 最多等待 grace 时长；若服务端在此期间断开则返回错误
+// 超时视为握手已被接受 (服务端可能在等待客户端先发数据)
+func (c *confirmConn) wait(grace time.Duration) error {
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-c.done:
+		if len(c.buf) == 0 && c.err != nil {
+			return c.err
+		}
+	case <-timer.C:
+	}
+	return nil
+}
+
+func (c *confirmConn) Read(b []byte) (int, error) {
+	if !c.drained {
+		<-c.done
+		if len(c.buf) > 0 {
+			n := copy(b, c.buf)
+			c.buf = c.buf[n:]
+			return n, nil
+		}
+		c.drained = true
+		if c.err != nil {
+			return 0, c.err
+		}
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"strings"
+	"sync"
+
+	"mandala/core/logger"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// 已输出过日志的指纹，避免每次拨号重复打印
+var loggedFingerprints sync.Map
+
+// fingerprintID 将配置中的指纹名称映射为 uTLS ClientHelloID
+// 空值或未知名称回退到 Chrome；返回值中的名称为实际使用的规范化名称
+func fingerprintID(name string) (utls.ClientHelloID, string) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "firefox":
+		return utls.HelloFirefox_Auto, "firefox"
+	case "safari":
+		return utls.HelloSafari_Auto, "safari"
+	case "ios":
+		return utls.HelloIOS_Auto, "ios"
+	case "edge":
+		return utls.HelloEdge_Auto, "edge"
+	case "android":
+		return utls.HelloAndroid_11_OkHttp, "android"
+	case "360":
+		return utls.Hello360_Auto, "360"
+	case "qq":
+		return utls.HelloQQ_Auto, "qq"
+	case "random", "randomized":
+		return utls.HelloRandomized, "randomized"
+	default:
+		return utls.HelloChrome_Auto, "chrome"
+	}
+}
+
+// clientHelloID 返回当前节点使用的指纹，首次使用时输出一次日志
+func (d *Dialer) clientHelloID() (utls.ClientHelloID, string) {
+	var name string
+	if d.Config.TLS != nil {
+		name = d.Config.TLS.Fingerprint
+	}
+	id, chosen := fingerprintID(name)
+
+	if _, logged := loggedFingerprints.LoadOrStore(chosen, true); !logged {
+		if name != "" && !strings.EqualFold(name, chosen) {
+			logger.Warnf("TLS", "未知指纹 %q，使用默认值: %s", name, chosen)
+		} else {
+			logger.Infof("TLS", "使用指纹: %s", chosen)
+		}
+	}
+	return id, chosen
+}
+
+// tlsALPN 返回本次握手声明的 ALPN，nil 表示沿用指纹模版 (通常为 h2 与 http/1.1)
+// 优先使用配置的 alpn；未配置时 gRPC / HTTP/2 传输只声明 h2
+func (d *Dialer) tlsALPN() []string {
+	if d.Config.TLS != nil && len(d.Config.TLS.ALPN) > 0 {
+		return d.Config.TLS.ALPN
+	}
+	if d.usesH2Transport() {
+		return []string{"h2"}
+	}
+	return nil
+}
+
+// setSpecALPN 替换模版中 ALPN 扩展的协议列表，模版不含该扩展时追加
+func setSpecALPN(spec *utls.ClientHelloSpec, protos []string) {
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = protos
+			return
+		}
+	}
+	spec.Extensions = append(spec.Extensions, &utls.ALPNExtension{AlpnProtocols: protos})
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"testing"
+
+	"mandala/core/config"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// 指纹名称不区分大小写并忽略首尾空白；空值与未知名称回退到 Chrome
+func TestFingerprintID(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		id     utls.ClientHelloID
+		chosen string
+	}{
+		{"", utls.HelloChrome_Auto, "chrome"},
+		{"chrome", utls.HelloChrome_Auto, "chrome"},
+		{" Firefox ", utls.HelloFirefox_Auto, "firefox"},
+		{"safari", utls.HelloSafari_Auto, "safari"},
+		{"IOS", utls.HelloIOS_Auto, "ios"},
+		{"edge", utls.HelloEdge_Auto, "edge"},
+		{"android", utls.HelloAndroid_11_OkHttp, "android"},
+		{"360", utls.Hello360_Auto, "360"},
+		{"qq", utls.HelloQQ_Auto, "qq"},
+		{"random", utls.HelloRandomized, "randomized"},
+		{"randomized", utls.HelloRandomized, "randomized"},
+		{"netscape", utls.HelloChrome_Auto, "chrome"},
+	} {
+		id, chosen := fingerprintID(tc.name)
+		if id != tc.id || chosen != tc.chosen {
+			t.Errorf("fingerprintID(%q) = %v, %s; want %v, %s", tc.name, id, chosen, tc.id, tc.chosen)
+		}
+		// 除随机化指纹外都有可修改 ALPN 的固定模版
+		if _, err := utls.UTLSIdToSpec(id); err != nil && id != utls.HelloRandomized {
+			t.Errorf("%s: no ClientHello spec: %v", chosen, err)
+		}
+	}
+
+	// 未配置 TLS 时同样使用默认指纹
+	if _, chosen := NewDialer(&config.OutboundConfig{}).clientHelloID(); chosen != "chrome" {
+		t.Errorf("clientHelloID without tls = %s, want chrome", chosen)
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+)
+
+// dialGRPC 在已握手的连接上建立 gRPC (GunService/Tun) 双向流
+func (d *Dialer) dialGRPC(conn net.Conn) (net.Conn, error) {
+	serviceName := d.Config.Transport.ServiceName
+	if serviceName == "" {
+		serviceName = "GunService"
+	}
+	path := serviceName
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + serviceName + "/Tun"
+	}
+
+	req, err := d.newH2Request(path)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("User-Agent", "grpc-go/1.60.0")
+
+	stream, err := newH2StreamConn(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	return &gunConn{
+		h2StreamConn: stream,
+		reader:       bufio.NewReader(stream),
+		maxSize:      d.Config.MaxWSMessageSize(),
+	}, nil
+}
+
+// gunConn 按 gRPC 消息分帧收发数据
+// 每条消息: Flag(1) + Len(4) + protobuf Hunk{ bytes data = 1; }
+type gunConn struct {
+	*h2StreamConn
+	reader   *bufio.Reader
+	maxSize  int
+	leftover []byte
+}
+
+func (c *gunConn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var varint [binary.MaxVarintLen64]byte
+	vn := binary.PutUvarint(varint[:], uint64(len(b)))
+	protoLen := 1 + vn + len(b)
+
+	buf := make([]byte, 0, 5+protoLen)
+	buf = append(buf, 0x00)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(protoLen))
+	buf = append(buf, 0x0A) // field 1, wire type 2 (bytes)
+	buf = append(buf, varint[:vn]...)
+	buf = append(buf, b...)
+
+	if _, err := c.h2StreamConn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *gunConn) Read(b []byte) (int, error) {
+	if len(c.leftover) > 0 {
+		n := copy(b, c.leftover)
+		c.leftover = c.leftover[n:]
+		return n, nil
+	}
+
+	for {
+		head := make([]byte, 5)
+		if _, err := io.ReadFull(c.reader, head); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return 0, c.endOfStream()
+			}
+			return 0, err
+		}
+		if head[0] != 0x00 {
+			return 0, errors.New("grpc: compressed message not supported")
+		}
+		msgLen := int(binary.BigEndian.Uint32(head[1:]))
+		if msgLen > c.maxSize {
+			return 0, fmt.Errorf("grpc: message too large: %d", msgLen)
+		}
+
+		msg := make([]byte, msgLen)
+		if _, err := io.ReadFull(c.reader, msg); err != nil {
+			return 0, err
+		}
+
+		data, err := parseGunHunk(msg)
+		if err != nil {
+			return 0, err
+		}
+		// 空消息不携带数据，继续读取下一条
+		if len(data) == 0 {
+			continue
+		}
+
+		n := copy(b, data)
+		if n < len(data) {
+			c.leftover = data[n:]
+		}
+		return n, nil
+	}
+}
+
+// endOfStream 流结束时检查 grpc-status，非 0 仅记录日志，转发循环统一看到 EOF
+func (c *gunConn) endOfStream() error {
+	if status := c.trailer("Grpc-Status"); status != "" && status != "0" {
+		log.Printf("[gRPC] 服务端结束流: status=%s message=%s", status, c.trailer("Grpc-Message"))
+	}
+	return io.EOF
+}
+
+// parseGunHunk 解析 Hunk 消息，跳过未知字段
+func parseGunHunk(msg []byte) ([]byte, error) {
+	var data []byte
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errors.New("grpc: invalid protobuf tag")
+		}
+		msg = msg[n:]
+
+		switch tag & 0x7 {
+		case 2:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return nil, errors.New("grpc: invalid protobuf length")
+			}
+			if tag>>3 == 1 {
+				data = append(data, msg[n:n+int(l)]...)
+			}
+			msg = msg[n+int(l):]
+		case 0:
+			_, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, errors.New("grpc: invalid protobuf varint")
+			}
+			msg = msg[n:]
+		default:
+			return nil, fmt.Errorf("grpc: unsupported wire type %d", tag&0x7)
+		}
+	}
+	return data, nil
+}
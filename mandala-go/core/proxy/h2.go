@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// transportHost 返回传输层请求使用的 Host (显式配置优先，其次为 SNI、节点地址)
+func (d *Dialer) transportHost() string {
+	if d.Config.Transport != nil && d.Config.Transport.Host != "" {
+		return d.Config.Transport.Host
+	}
+	if d.Config.TLS != nil && d.Config.TLS.ServerName != "" {
+		return d.Config.TLS.ServerName
+	}
+	return d.Config.Server
+}
+
+// usesH2Transport 传输层本身基于 HTTP/2，此时协商出 h2 不应退回 http/1.1
+func (d *Dialer) usesH2Transport() bool {
+	if d.Config.Transport == nil {
+		return false
+	}
+	switch d.Config.Transport.Type {
+	case "grpc", "http", "h2":
+		return true
+	}
+	return false
+}
+
+// dialHTTP2 在已握手的连接上发起 HTTP/2 POST 流，请求体与响应体组成双向隧道
+func (d *Dialer) dialHTTP2(conn net.Conn) (net.Conn, error) {
+	path := d.Config.Transport.Path
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := d.newH2Request(path)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	stream, err := newH2StreamConn(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// newH2Request 构造 HTTP/2 传输使用的 POST 请求，附带自定义请求头与 Host
+func (d *Dialer) newH2Request(path string) (*http.Request, error) {
+	scheme := "http"
+	if d.Config.TLS != nil && d.Config.TLS.Enabled {
+		scheme = "https"
+	}
+	host := d.transportHost()
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s://%s%s", scheme, host, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range d.Config.Transport.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Host = host
+	return req, nil
+}
+
+// h2StreamConn 将单条 HTTP/2 双向流包装为 net.Conn
+// 请求体 (管道) 作为上行，响应体作为下行；每条流独占一个底层连接
+type h2StreamConn struct {
+	net.Conn // 底层 TCP/TLS 连接，用于地址信息与超时设置
+
+	cc *http2.ClientConn
+	pw *io.PipeWriter
+
+	respReady chan struct{}
+	resp      *http.Response
+	respErr   error
+
+	closeOnce sync.Once
+}
+
+// newH2StreamConn 在已握手的连接上发起 HTTP/2 请求
+// 请求在后台发出，服务端可能在收到首包后才返回响应头，因此 Read 会等待响应就绪
+func newH2StreamConn(conn net.Conn, req *http.Request) (*h2StreamConn, error) {
+	t := &http2.Transport{
+		AllowHTTP:       true,
+		ReadIdleTimeout: 30 * time.Second, // 空闲时发送 PING 检测连接存活
+	}
+	cc, err := t.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http2 client conn failed: %v", err)
+	}
+
+	pr, pw := io.Pipe()
+	req.Body = pr
+	req.ContentLength = -1
+
+	c := &h2StreamConn{
+		Conn:      conn,
+		cc:        cc,
+		pw:        pw,
+		respReady: make(chan struct{}),
+	}
+
+	go func() {
+		resp, err := cc.RoundTrip(req)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		if err != nil {
+			pr.CloseWithError(err)
+		}
+		c.resp, c.respErr = resp, err
+		close(c.respReady)
+	}()
+
+	return c, nil
+}
+
+// Write 每次写入都会被 http2 传输层立即作为 DATA 帧发出并刷新
+func (c *h2StreamConn) Write(b []byte) (int, error) {
+	return c.pw.Write(b)
+}
+
+func (c *h2StreamConn) Read(b []byte) (int, error) {
+	<-c.respReady
+	if c.respErr != nil {
+		return 0, c.respErr
+	}
+	n, err := c.resp.Body.Read(b)
+	if err != nil && isStreamClosed(err) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// trailer 返回响应的 Header 与 Trailer 中指定字段 (流结束后 Trailer 才可用)
+func (c *h2StreamConn) trailer(key string) string {
+	select {
+	case <-c.respReady:
+	default:
+		return ""
+	}
+	if c.resp == nil {
+		return ""
+	}
+	if v := c.resp.Trailer.Get(key); v != "" {
+		return v
+	}
+	return c.resp.Header.Get(key)
+}
+
+func (c *h2StreamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.pw.Close()
+		go func() {
+			<-c.respReady
+			if c.resp != nil {
+				c.resp.Body.Close()
+			}
+		}()
+		c.cc.Close()
+		c.Conn.Close()
+	})
+	return nil
+}
+
+// isStreamClosed 判断是否为服务端正常结束或重置流，转发循环将其视为 EOF
+func isStreamClosed(err error) bool {
+	var se http2.StreamError
+	if errors.As(err, &se) {
+		return true
+	}
+	var ge http2.GoAwayError
+	return errors.As(err, &ge)
+}
@@ -1,34 +1,91 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
 	"io"
-	"log"
 	"net"
-	"strings"
 	"time"
 
 	"mandala/core/config"
-	"mandala/core/protocol"
+	"mandala/core/logger"
+	"mandala/core/stats"
 )
 
 // Handler 处理单个本地连接
 type Handler struct {
 	Config *config.OutboundConfig
+	Ports  *config.PortPolicy // 目标端口策略，nil 表示不限制
+	Router *config.Router     // [新增] 路由规则，nil 表示全部经代理
+	// [新增] 多节点选择器 (nil 表示单节点)，Config 为其为本连接选出的节点，拨号结果回报给它
+	Selector *Selector
 }
 
-// HandleConnection 处理 SOCKS5 请求并转发
-func (h *Handler) HandleConnection(localConn net.Conn) {
+// HandleConnection 处理本地入站连接并转发
+// [修改] 根据首字节区分 SOCKS5 (0x05) 与 HTTP 代理请求
+// ctx 取消时 (服务停止) 关闭本地连接，使握手与转发循环立即退出
+func (h *Handler) HandleConnection(ctx context.Context, localConn net.Conn) {
 	defer localConn.Close()
 
-	// 1. SOCKS5 握手 (无需认证)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			localConn.Close()
+		case <-done:
+		}
+	}()
+
+	// [新增] 握手阶段读超时，进入转发阶段 (pipe) 或 UDP 关联后清除
+	if timeout := h.Config.HandshakeTimeout(); timeout > 0 {
+		localConn.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	conn := newBufferedConn(localConn)
+	first, err := conn.r.Peek(1)
+	if err != nil {
+		return
+	}
+	switch {
+	case first[0] == 0x05:
+		h.handleSocks(ctx, conn)
+	case first[0] >= 'A' && first[0] <= 'Z':
+		h.handleHTTP(ctx, conn)
+	default:
+		// [新增] 抗探测模式下伪装成普通 Web 服务器
+		if h.Config.Settings.ProbeResistance {
+			writeDecoyResponse(localConn)
+		}
+	}
+}
+
+// handleSocks 处理 SOCKS5 握手与请求
+func (h *Handler) handleSocks(ctx context.Context, localConn net.Conn) {
+	// 1. SOCKS5 握手
 	buf := make([]byte, 262)
+	// [修改] 读取完整的问候包 VER(1) + NMETHODS(1) + METHODS，避免残留的方法字节被当作请求解析
 	if _, err := io.ReadFull(localConn, buf[:2]); err != nil {
 		return
 	}
-	if buf[0] != 0x05 {
+	methods := buf[2 : 2+int(buf[1])]
+	if _, err := io.ReadFull(localConn, methods); err != nil {
 		return
 	}
-	localConn.Write([]byte{0x05, 0x00})
+	// [新增] 配置了入站凭据时要求用户名/密码认证
+	if h.authRequired() {
+		if !h.authenticate(localConn, methods) {
+			return
+		}
+	} else if bytes.IndexByte(methods, 0x00) < 0 {
+		// 客户端只提供 GSSAPI 等不支持的方法
+		h.rejectSocks(localConn, 0x05, 0xFF)
+		return
+	} else {
+		localConn.Write([]byte{0x05, 0x00})
+	}
 
 	// 2. 读取客户端请求
 	n, err := io.ReadFull(localConn, buf[:4])
@@ -41,7 +98,9 @@ func (h *Handler) HandleConnection(localConn net.Conn) {
 	var targetHost string
 	var targetPort int
 
-	if cmd != 0x01 {
+	// [新增] 支持 CONNECT (0x01)、BIND (0x02) 与 UDP ASSOCIATE (0x03)，其余命令回复不支持 (0x07)
+	if cmd != 0x01 && cmd != 0x02 && cmd != 0x03 {
+		localConn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
 		return
 	}
 
@@ -80,107 +139,282 @@ func (h *Handler) HandleConnection(localConn net.Conn) {
 	}
 	targetPort = int(portBuf[0])<<8 | int(portBuf[1])
 
-	// 3. 连接远程代理服务器
-	dialer := NewDialer(h.Config)
-	remoteConn, err := dialer.Dial()
-	if err != nil {
-		log.Printf("[Proxy] Dial remote failed: %v", err)
-		localConn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	// UDP ASSOCIATE 的请求地址表示客户端预期的来源地址，端口非 0 时只接受来自该端口的数据报
+	if cmd == 0x03 {
+		h.handleUDPAssociate(ctx, localConn, targetPort)
+		return
+	}
+
+	// [新增] BIND 的请求地址为预期连入的对端，不受端口策略限制
+	if cmd == 0x02 {
+		h.handleBind(ctx, localConn, targetHost, targetPort)
+		return
+	}
+
+	// [新增] 端口策略检查 (REP 0x02: 规则不允许)
+	if !h.Ports.Allowed(targetPort) {
+		logger.Infof("Policy", "拒绝连接 %s:%d: 目标端口不在允许范围内", targetHost, targetPort)
+		localConn.Write([]byte{0x05, 0x02, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	h.relay(ctx, localConn, targetHost, targetPort, nil, func(rep byte) error {
+		_, err := localConn.Write([]byte{0x05, rep, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return err
+	})
+}
+
+// SOCKS5 应答码，HTTP 入站按同样的语义映射为状态码
+const (
+	repSucceeded      = 0x00
+	repGeneralFailure = 0x01
+	repNotAllowed     = 0x02
+	repHostUnreach    = 0x04
+	repConnRefused    = 0x05
+)
+
+// handshakeRejectRep 宽限期内远程断开时的应答码：服务端正常关闭 (TCP FIN、WebSocket Close 帧)
+// 或协议层拒绝视为拒绝连接；隧道在传输层异常中断 (连接重置、WebSocket 未收到 Close 帧即断开) 视为一般故障
+func handshakeRejectRep(err error) byte {
+	if err == io.EOF || errors.Is(err, ErrWSClosed) {
+		return repConnRefused
+	}
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return repGeneralFailure
+	}
+	return repConnRefused
+}
+
+// relay 连接远程代理、完成协议握手并双向转发
+// initial 为需随握手发送的客户端数据 (nil 表示在短时间窗口内读取首包)；
+// reply 按入站协议向本地客户端回复连接结果
+func (h *Handler) relay(ctx context.Context, localConn net.Conn, targetHost string, targetPort int, initial []byte, reply func(rep byte) error) {
+	remoteConn := h.connect(ctx, localConn, targetHost, targetPort, initial, reply)
+	if remoteConn == nil {
 		return
 	}
 	defer remoteConn.Close()
 
-	// 4. 发送协议头 (握手)
-	proxyType := strings.ToLower(h.Config.Type)
-	isVless := false
+	// 6. 双向转发
+	pipe(ctx, localConn, remoteConn, h.Config.IdleTimeout())
+}
+
+// connect 按路由连接目标 (经代理时完成协议握手)，回复本地客户端并随握手发出 initial；
+// 返回可直接转发的远程连接 (已计入流量统计)，失败时已通过 reply 回复客户端并返回 nil
+func (h *Handler) connect(ctx context.Context, localConn net.Conn, targetHost string, targetPort int, initial []byte, reply func(rep byte) error) net.Conn {
+	// [新增] 按路由规则选择出站
+	route := h.Router.Match(targetHost, targetPort)
+	// [新增] 按目标统计流量时使用的主机名，嗅探到域名时以域名代替 IP
+	statsHost := targetHost
 
-	switch proxyType {
-	case "mandala":
-		client := protocol.NewMandalaClient(h.Config.Username, h.Config.Password)
-		// [修改] 传入 Noise 配置
-		payload, err := client.BuildHandshakePayload(targetHost, targetPort, h.Config.Settings.Noise)
-		if err != nil {
-			log.Printf("[Mandala] Build payload failed: %v", err)
-			return
+	// [新增] 目标为 IP 时先回复成功，按客户端首包中的域名 (TLS SNI / HTTP Host) 重新匹配路由；
+	// 此后的失败只能直接断开，已读取的首包随握手发给目标
+	if initial == nil && h.Config.Settings.Sniff && h.Router != nil && net.ParseIP(targetHost) != nil {
+		if err := reply(repSucceeded); err != nil {
+			return nil
 		}
-		if _, err := remoteConn.Write(payload); err != nil {
-			log.Printf("[Mandala] Handshake write failed: %v", err)
-			return
-		}
-
-	case "trojan":
-		payload, err := protocol.BuildTrojanPayload(h.Config.Password, targetHost, targetPort)
+		reply = func(byte) error { return nil }
+		sniffed, domain, err := SniffConn(localConn)
 		if err != nil {
-			log.Printf("[Trojan] Build payload failed: %v", err)
-			return
+			return nil
 		}
-		if _, err := remoteConn.Write(payload); err != nil {
-			log.Printf("[Trojan] Handshake write failed: %v", err)
-			return
+		initial = sniffed
+		if domain != "" {
+			statsHost = domain
+			route = h.Router.MatchSniffed(domain, net.ParseIP(targetHost), targetPort)
+			logger.Debugf("Route", "嗅探到 %s:%d 的域名 %s，出站: %s", targetHost, targetPort, domain, route)
 		}
+	}
 
-	case "vless":
-		payload, err := protocol.BuildVlessPayload(h.Config.UUID, targetHost, targetPort)
-		if err != nil {
-			log.Printf("[Vless] Build payload failed: %v", err)
-			return
-		}
-		if _, err := remoteConn.Write(payload); err != nil {
-			log.Printf("[Vless] Handshake write failed: %v", err)
-			return
+	if route == config.OutboundBlock {
+		logger.Debugf("Route", "拦截连接 %s:%d", targetHost, targetPort)
+		reply(repNotAllowed)
+		return nil
+	}
+
+	// 3. 连接远程代理服务器 (直连时连接目标本身)
+	dialer := NewDialer(h.Config)
+	// [新增] block_private_ips 与路由无关，经代理的目标同样先解析检查
+	if err := dialer.CheckTarget(ctx, targetHost); err != nil {
+		logger.Infof("Policy", "拒绝连接 %s:%d: 目标为私有或保留地址", targetHost, targetPort)
+		reply(repNotAllowed)
+		return nil
+	}
+	var remoteConn net.Conn
+	var err error
+	if route == config.OutboundDirect {
+		remoteConn, err = dialer.DialDirectTarget("tcp", targetHost, targetPort)
+	} else {
+		remoteConn, err = dialer.DialContext(ctx)
+		h.Selector.ReportDial(h.Config, err)
+	}
+	// [新增] 目标为私有 / 保留地址 (block_private_ips)
+	if errors.Is(err, ErrReservedTarget) {
+		logger.Infof("Policy", "拒绝连接 %s:%d: 目标为私有或保留地址", targetHost, targetPort)
+		reply(repNotAllowed)
+		return nil
+	}
+	if err != nil {
+		logger.Errorf("Proxy", "Dial remote failed (%s): %v", route, err)
+		reply(repHostUnreach)
+		return nil
+	}
+	// 失败时关闭，成功时由调用方关闭返回的连接
+	raw, connected := remoteConn, false
+	defer func() {
+		if !connected {
+			raw.Close()
 		}
-		isVless = true
+	}()
 
-	// [新增] Shadowsocks 支持
-	case "shadowsocks":
-		payload, err := protocol.BuildShadowsocksPayload(targetHost, targetPort)
+	// 4. 构造协议头 (握手)
+	// [修改] 各协议的握手统一由 TunnelHandshake 构造，与 TUN 模式共用
+	var payload []byte
+	if route != config.OutboundDirect {
+		payload, remoteConn, err = TunnelHandshake(h.Config, remoteConn, targetHost, targetPort)
 		if err != nil {
-			log.Printf("[Shadowsocks] Build payload failed: %v", err)
-			return
+			logger.Errorf("Proxy", "Handshake failed: %v", err)
+			return nil
 		}
+	}
+
+	// [新增] 宽限期：先发送握手，确认服务端未立即拒绝后再回复成功，
+	// 使失败表现为连接被拒绝，而不是客户端向已失效的隧道发送数据
+	if grace := h.Config.Settings.ConnectGraceMs; grace > 0 && payload != nil {
 		if _, err := remoteConn.Write(payload); err != nil {
-			log.Printf("[Shadowsocks] Handshake write failed: %v", err)
-			return
+			logger.Warnf("Proxy", "Handshake write failed (%s): %v", h.Config.Type, err)
+			reply(repConnRefused)
+			return nil
 		}
+		payload = nil
 
-	// [新增] SOCKS5 支持 (含认证)
-	case "socks", "socks5":
-		err := protocol.HandshakeSocks5(remoteConn, h.Config.Username, h.Config.Password, targetHost, targetPort)
-		if err != nil {
-			log.Printf("[Socks5] Handshake failed: %v", err)
-			return
+		cc := newConfirmConn(remoteConn)
+		if err := cc.wait(time.Duration(grace) * time.Millisecond); err != nil {
+			logger.Warnf("Proxy", "Server rejected handshake (%s): %v", h.Config.Type, err)
+			reply(handshakeRejectRep(err))
+			return nil
 		}
-
-	default:
-		log.Println("[Proxy] Protocol not implemented:", proxyType)
-		return
+		remoteConn = cc
 	}
 
-	// 如果是 VLESS，包装连接以剥离响应头
-	if isVless {
-		remoteConn = protocol.NewVlessConn(remoteConn)
+	// 5. 告知本地客户端连接成功
+	if err := reply(repSucceeded); err != nil {
+		return nil
 	}
 
-	// 5. 告知本地客户端连接成功
-	if _, err := localConn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
-		return
+	// [新增] 握手包与客户端首包合并为一次写入，节省一个往返并减少特征包数量
+	if payload != nil || len(initial) > 0 {
+		early := initial
+		if early == nil {
+			if early, err = ReadEarlyData(localConn); err != nil {
+				return nil
+			}
+		}
+		if _, err := remoteConn.Write(append(payload, early...)); err != nil {
+			logger.Warnf("Proxy", "Handshake write failed (%s): %v", h.Config.Type, err)
+			return nil
+		}
 	}
 
-	// 6. 双向转发
+	// [新增] 按目标域名统计流量，计入全局上下行字节与活跃连接数，并登记到活跃连接列表
+	connected = true
+	return stats.WrapConn(remoteConn, false, statsHost, targetPort)
+}
+
+// pipe 双向转发，任一方向结束、ctx 取消或空闲超过 idleTimeout (0 表示不限制) 时返回；
+// 调用方负责关闭两端连接
+func pipe(ctx context.Context, localConn, remoteConn net.Conn, idleTimeout time.Duration) {
 	localConn.SetDeadline(time.Time{})
 	remoteConn.SetDeadline(time.Time{})
 
+	// [新增] 空闲检测：定期检查远程一端最近的读写时间
+	var activity *activityConn
+	var idleCheck <-chan time.Time
+	if idleTimeout > 0 {
+		activity = newActivityConn(remoteConn)
+		remoteConn = activity
+		ticker := time.NewTicker(idleTimeout / 4)
+		defer ticker.Stop()
+		idleCheck = ticker.C
+	}
+
 	errChan := make(chan error, 2)
 
 	go func() {
-		_, err := io.Copy(remoteConn, localConn)
+		_, err := CopyBuffered(remoteConn, localConn)
 		errChan <- err
 	}()
 
 	go func() {
-		_, err := io.Copy(localConn, remoteConn)
+		_, err := CopyBuffered(localConn, remoteConn)
 		errChan <- err
 	}()
 
-	<-errChan
+	// 任一方向结束或服务停止即返回，defer 关闭两端连接以终止另一方向
+	for {
+		select {
+		case <-errChan:
+			return
+		case <-ctx.Done():
+			return
+		case now := <-idleCheck:
+			if activity.idleFor(now) >= idleTimeout {
+				logger.Debugf("Proxy", "连接空闲超过 %s，断开", idleTimeout)
+				return
+			}
+		}
+	}
+}
+
+// authRequired 是否为本地入站启用了用户名/密码认证
+func (h *Handler) authRequired() bool {
+	return h.Config.Settings.InboundUsername != "" || h.Config.Settings.InboundPassword != ""
+}
+
+// authenticate 选择用户名/密码认证方法 (0x02) 并完成 RFC 1929 子协商
+// 客户端不支持该方法时回复 0xFF；凭据错误时回复 0x01 0x01
+func (h *Handler) authenticate(conn net.Conn, methods []byte) bool {
+	if bytes.IndexByte(methods, 0x02) < 0 {
+		h.rejectSocks(conn, 0x05, 0xFF)
+		return false
+	}
+	conn.Write([]byte{0x05, 0x02})
+
+	// VER(1) + ULEN(1) + UNAME + PLEN(1) + PASSWD
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 0x01 {
+		return false
+	}
+	username := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return false
+	}
+	password := make([]byte, buf[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return false
+	}
+
+	userOK := subtle.ConstantTimeCompare(username, []byte(h.Config.Settings.InboundUsername))
+	passOK := subtle.ConstantTimeCompare(password, []byte(h.Config.Settings.InboundPassword))
+	if userOK&passOK != 1 {
+		logger.Warnf("Proxy", "本地入站认证失败: %s", conn.RemoteAddr())
+		h.rejectSocks(conn, 0x01, 0x01)
+		return false
+	}
+	conn.Write([]byte{0x01, 0x00})
+	return true
+}
+
+// rejectSocks 拒绝未通过认证的 SOCKS5 握手
+// [新增] 抗探测模式下以伪装的 HTTP 400 页面代替 SOCKS 拒绝应答，避免扫描器据此识别出代理
+func (h *Handler) rejectSocks(conn net.Conn, reply ...byte) {
+	if h.Config.Settings.ProbeResistance {
+		writeDecoyResponse(conn)
+		return
+	}
+	conn.Write(reply)
 }
@@ -0,0 +1,334 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+	"mandala/core/protocol"
+	"mandala/core/stats"
+)
+
+// newDirectHandler 返回全部目标直连的本地入站处理器 (不经代理节点)
+func newDirectHandler(t *testing.T) *Handler {
+	t.Helper()
+	router, err := config.ParseRouter(&config.RoutingConfig{DefaultOutbound: config.OutboundDirect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{Config: &config.OutboundConfig{Type: "socks"}, Router: router}
+}
+
+// serveInbound 在随机端口上以 h 处理入站连接，返回监听地址；测试结束时停止
+func serveInbound(t *testing.T, h *Handler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		l.Close()
+	})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go h.HandleConnection(ctx, c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// socksConnect 发起 SOCKS5 CONNECT，返回应答码
+func socksConnect(t *testing.T, addr, host string, port int) byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	req, err := protocol.ToSocksAddr(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write(append([]byte{0x05, 0x01, 0x00}, req...))
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp[1]
+}
+
+func TestBlockPrivateIPs(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	port := target.Addr().(*net.TCPAddr).Port
+
+	direct := newDirectHandler(t)
+	direct.Config.Settings.BlockPrivateIPs = true
+	// 经代理的路由：节点不可达，但检查发生在拨号之前
+	proxied := &Handler{Config: &config.OutboundConfig{Type: "socks", Server: "127.0.0.1", ServerPort: 1}}
+	proxied.Config.Settings.BlockPrivateIPs = true
+
+	for _, tc := range []struct {
+		name string
+		h    *Handler
+		host string
+	}{
+		{"direct domain", direct, "localhost"},
+		{"direct ip", direct, "127.0.0.1"},
+		{"proxy domain", proxied, "localhost"},
+		{"proxy metadata ip", proxied, "169.254.169.254"},
+	} {
+		if rep := socksConnect(t, serveInbound(t, tc.h), tc.host, port); rep != repNotAllowed {
+			t.Errorf("%s: reply = %#x, want %#x", tc.name, rep, repNotAllowed)
+		}
+	}
+
+	// 未启用时直连本机目标不受影响
+	if rep := socksConnect(t, serveInbound(t, newDirectHandler(t)), "127.0.0.1", port); rep != repSucceeded {
+		t.Errorf("flag off: reply = %#x, want %#x", rep, repSucceeded)
+	}
+}
+
+// 嗅探到域名时按域名而非 IP 统计目标流量
+func TestSniffedDomainStats(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		c, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	stats.ResetDestinations()
+	h := newDirectHandler(t)
+	h.Config.Settings.Sniff = true
+	conn, err := net.Dial("tcp", serveInbound(t, h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := protocol.ToSocksAddr("127.0.0.1", echo.Addr().(*net.TCPAddr).Port)
+	conn.Write(append([]byte{0x05, 0x01, 0x00}, req...))
+	resp := make([]byte, 3)
+	if _, err := io.ReadFull(conn, resp); err != nil || resp[1] != repSucceeded {
+		t.Fatalf("CONNECT reply %v: %v", resp, err)
+	}
+	if _, _, err := protocol.ReadSocksAddr(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	const request = "GET / HTTP/1.1\r\nHost: sniffed.example\r\n\r\n"
+	io.WriteString(conn, request)
+	if _, err := io.ReadFull(conn, make([]byte, len(request))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range stats.TopDestinations(0) {
+		if d.Host == "127.0.0.1" {
+			t.Fatalf("traffic counted under the IP: %+v", stats.TopDestinations(0))
+		}
+		if d.Host == "sniffed.example" && d.Bytes >= int64(len(request)) {
+			return
+		}
+	}
+	t.Fatalf("sniffed domain missing from destinations: %+v", stats.TopDestinations(0))
+}
+
+// socksGreeting 发送 SOCKS5 问候包，需要用户名/密码认证时随后发送凭据，返回服务端此后写出的全部数据
+func socksGreeting(t *testing.T, addr string, methods []byte, user, pass string) []byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(append([]byte{0x05, byte(len(methods))}, methods...))
+	if user != "" || pass != "" {
+		reply := make([]byte, 2)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply[1] != 0x02 {
+			t.Fatalf("method reply = %x, want username/password", reply)
+		}
+		auth := append([]byte{0x01, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(pass))), pass...)
+		conn.Write(auth)
+	}
+	out, _ := io.ReadAll(conn)
+	return out
+}
+
+// 抗探测模式下未通过认证的 SOCKS5 握手收到伪装的 HTTP 400 页面而非 SOCKS 拒绝应答
+func TestProbeResistanceDecoy(t *testing.T) {
+	open := newDirectHandler(t)
+	open.Config.Settings.ProbeResistance = true
+	authed := newDirectHandler(t)
+	authed.Config.Settings.ProbeResistance = true
+	authed.Config.Settings.InboundUsername = "user"
+	authed.Config.Settings.InboundPassword = "pass"
+
+	for _, tc := range []struct {
+		name       string
+		h          *Handler
+		methods    []byte
+		user, pass string
+	}{
+		{"no acceptable method", open, []byte{0x01}, "", ""},
+		{"auth method not offered", authed, []byte{0x00}, "", ""},
+		{"wrong password", authed, []byte{0x00, 0x02}, "user", "wrong"},
+	} {
+		out := socksGreeting(t, serveInbound(t, tc.h), tc.methods, tc.user, tc.pass)
+		if !bytes.HasPrefix(out, []byte("HTTP/1.1 400 Bad Request\r\n")) {
+			t.Errorf("%s: got %q, want decoy response", tc.name, out)
+		}
+	}
+
+	// 非 SOCKS / HTTP 的探测
+	conn, err := net.Dial("tcp", serveInbound(t, open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{0x16, 0x03, 0x01})
+	if out, _ := io.ReadAll(conn); !bytes.HasPrefix(out, []byte("HTTP/1.1 400")) {
+		t.Errorf("binary probe: got %q, want decoy response", out)
+	}
+
+	// 未启用时仍回复 SOCKS 拒绝应答
+	if out := socksGreeting(t, serveInbound(t, newDirectHandler(t)), []byte{0x01}, "", ""); !bytes.Equal(out, []byte{0x05, 0xFF}) {
+		t.Errorf("flag off: got %x, want 05ff", out)
+	}
+}
+
+// 配置入站凭据时正确的用户名/密码通过认证，错误的凭据收到 01 01
+func TestSocksInboundAuth(t *testing.T) {
+	h := newDirectHandler(t)
+	h.Config.Settings.InboundUsername = "user"
+	h.Config.Settings.InboundPassword = "pass"
+	addr := serveInbound(t, h)
+
+	for _, tc := range []struct {
+		user, pass string
+		want       byte
+	}{
+		{"user", "pass", 0x00},
+		{"user", "wrong", 0x01},
+		{"other", "pass", 0x01},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte{0x05, 0x02, 0x00, 0x02})
+		reply := make([]byte, 2)
+		if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0x02 {
+			t.Fatalf("method reply = %x, %v", reply, err)
+		}
+		auth := append([]byte{0x01, byte(len(tc.user))}, tc.user...)
+		conn.Write(append(append(auth, byte(len(tc.pass))), tc.pass...))
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply[0] != 0x01 || reply[1] != tc.want {
+			t.Errorf("%s/%s: auth reply = %x, want 01%02x", tc.user, tc.pass, reply, tc.want)
+		}
+		conn.Close()
+	}
+
+	// 客户端未提供用户名/密码方法
+	if out := socksGreeting(t, addr, []byte{0x00}, "", ""); !bytes.Equal(out, []byte{0x05, 0xFF}) {
+		t.Errorf("no-auth greeting: got %x, want 05ff", out)
+	}
+}
+
+// 问候包、CONNECT 请求与首包数据在一次 Write 中到达时各阶段只消费各自的字节，首包完整转发给目标
+func TestSocksCoalescedHandshake(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		c, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 64)
+		n, _ := c.Read(buf)
+		received <- buf[:n]
+		c.Write(buf[:n])
+	}()
+
+	conn, err := net.Dial("tcp", serveInbound(t, newDirectHandler(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	const initial = "initial data"
+	req, _ := protocol.ToSocksAddr("127.0.0.1", target.Addr().(*net.TCPAddr).Port)
+	packet := []byte{0x05, 0x01, 0x00}
+	packet = append(append(packet, 0x05, 0x01, 0x00), req...)
+	conn.Write(append(packet, initial...))
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil || !bytes.Equal(reply, []byte{0x05, 0x00}) {
+		t.Fatalf("method reply = %x, %v", reply, err)
+	}
+	resp := make([]byte, 3)
+	if _, err := io.ReadFull(conn, resp); err != nil || resp[1] != repSucceeded {
+		t.Fatalf("CONNECT reply %x: %v", resp, err)
+	}
+	if _, _, err := protocol.ReadSocksAddr(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received:
+		if string(got) != initial {
+			t.Fatalf("target received %q, want %q", got, initial)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("initial data was not forwarded")
+	}
+	echoed := make([]byte, len(initial))
+	if _, err := io.ReadFull(conn, echoed); err != nil || string(echoed) != initial {
+		t.Fatalf("echo = %q, %v", echoed, err)
+	}
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"mandala/core/config"
+	"mandala/core/protocol"
+)
+
+// TunnelHandshake 按出站协议构造经代理连接 host:port 的握手 (SOCKS5 握手直接在 conn 上完成)；
+// 返回随首包发送的协议头，以及后续读写应使用的连接 (VLESS 响应头剥离、加密、VMess 请求头等包装层)。
+// 协议头由包装层在首次写入时发送 (VMess、VLESS Vision) 时 payload 为空但非 nil，
+// 调用方以 payload != nil 判断是否需要触发一次写入。失败时不关闭 conn
+func TunnelHandshake(cfg *config.OutboundConfig, conn net.Conn, host string, port int) (payload []byte, wrapped net.Conn, err error) {
+	proxyType := strings.ToLower(cfg.Type)
+	// sing-mux 流：外层协议握手已在建立会话时完成，流内只需携带目标地址
+	if cfg.UseSingMux() {
+		proxyType = "sing-mux"
+	}
+
+	switch proxyType {
+	case "sing-mux":
+		if payload, err = protocol.BuildSingMuxStreamRequest(false, host, port); err != nil {
+			return nil, nil, fmt.Errorf("sing-mux: build stream request: %w", err)
+		}
+		return payload, protocol.NewSingMuxStreamConn(conn), nil
+
+	case "mandala":
+		client := protocol.NewMandalaClient(cfg.Username, cfg.Password)
+		if payload, err = client.BuildHandshakePayload(host, port, cfg.Settings.Noise); err != nil {
+			return nil, nil, fmt.Errorf("mandala: build payload: %w", err)
+		}
+		return payload, conn, nil
+
+	case "trojan":
+		if cfg.UseTrojanGoMux() {
+			// Trojan-Go 多路复用流内只需 simplesocks 请求头
+			payload, err = protocol.BuildSimpleSocksPayload(protocol.TrojanCmdConnect, host, port)
+		} else {
+			payload, err = protocol.BuildTrojanPayload(cfg.Password, host, port)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("trojan: build payload: %w", err)
+		}
+		return payload, conn, nil
+
+	case "vless":
+		if payload, err = protocol.BuildVlessFlowPayload(cfg.UUID, cfg.Flow, host, port); err != nil {
+			return nil, nil, fmt.Errorf("vless: build payload: %w", err)
+		}
+		// Vision 流控：请求头随首个填充帧发送
+		if cfg.Flow != "" {
+			vc, err := protocol.NewVlessVisionConn(conn, cfg.UUID, payload)
+			if err != nil {
+				return nil, nil, fmt.Errorf("vless: vision init: %w", err)
+			}
+			vc.Strict = cfg.Settings.StrictProtocol
+			return []byte{}, vc, nil
+		}
+		// 写入直接透传，读取时剥离响应头
+		vc := protocol.NewVlessConn(conn)
+		vc.Strict = cfg.Settings.StrictProtocol
+		return payload, vc, nil
+
+	// TUIC: 流内首先发送 Connect 指令，服务端不回复
+	case "tuic":
+		if payload, err = protocol.BuildTUICConnect(host, port); err != nil {
+			return nil, nil, fmt.Errorf("tuic: build payload: %w", err)
+		}
+		return payload, conn, nil
+
+	// Hysteria2: 流内首先发送 TCPRequest，服务端的 TCPResponse 在首次读取时解析
+	case "hysteria2":
+		if payload, err = protocol.BuildHysteria2TCPRequest(host, port); err != nil {
+			return nil, nil, fmt.Errorf("hysteria2: build payload: %w", err)
+		}
+		return payload, protocol.NewHysteria2Conn(conn), nil
+
+	// Shadowsocks AEAD：目标地址作为首个加密块发送
+	case "shadowsocks":
+		if payload, err = protocol.BuildShadowsocksPayload(host, port); err != nil {
+			return nil, nil, fmt.Errorf("shadowsocks: build payload: %w", err)
+		}
+		if wrapped, err = protocol.WrapShadowsocks(conn, cfg.Method, cfg.Password); err != nil {
+			return nil, nil, fmt.Errorf("shadowsocks: cipher init: %w", err)
+		}
+		return payload, wrapped, nil
+
+	// VMess AEAD：请求头在首次写入时发送
+	case "vmess":
+		vc, err := protocol.NewVmessConn(conn, cfg.UUID, cfg.Method, protocol.VmessCmdTCP, host, port)
+		if err != nil {
+			return nil, nil, fmt.Errorf("vmess: build request: %w", err)
+		}
+		return []byte{}, vc, nil
+
+	case "socks", "socks5":
+		if err := protocol.HandshakeSocks5(conn, cfg.Username, cfg.Password, host, port); err != nil {
+			return nil, nil, fmt.Errorf("socks5: handshake: %w", err)
+		}
+		return nil, conn, nil
+	}
+	return nil, nil, fmt.Errorf("protocol not implemented: %s", proxyType)
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"mandala/core/config"
+	"mandala/core/protocol"
+)
+
+// 各协议的握手：协议头与包装层，VMess 请求头由包装层发送时 payload 为空但非 nil；构造失败时返回错误
+func TestTunnelHandshake(t *testing.T) {
+	const uuid = "b831381d-6324-4d53-ad4f-8cda48b30811"
+	trojan, err := protocol.BuildTrojanPayload("secret", "example.com", 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuic, err := protocol.BuildTUICConnect("example.com", 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		cfg      config.OutboundConfig
+		payload  []byte // nil 表示只检查是否为空
+		deferred bool
+		wrapper  string // 为空表示不包装
+	}{
+		{name: "trojan", cfg: config.OutboundConfig{Type: "Trojan", Password: "secret"}, payload: trojan},
+		{name: "tuic", cfg: config.OutboundConfig{Type: "tuic"}, payload: tuic},
+		{name: "mandala", cfg: config.OutboundConfig{Type: "mandala", Password: "secret"}},
+		{name: "vless", cfg: config.OutboundConfig{Type: "vless", UUID: uuid}, wrapper: "*protocol.VlessConn"},
+		{name: "vmess", cfg: config.OutboundConfig{Type: "vmess", UUID: uuid, Method: "auto"}, deferred: true, wrapper: "*protocol.VmessConn"},
+		{name: "hysteria2", cfg: config.OutboundConfig{Type: "hysteria2", Password: "secret"}, wrapper: "*protocol.Hysteria2Conn"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, _ := net.Pipe()
+			defer conn.Close()
+			payload, wrapped, err := TunnelHandshake(&tc.cfg, conn, "example.com", 443)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tc.deferred:
+				if payload == nil || len(payload) != 0 {
+					t.Errorf("payload = %x, want empty non-nil", payload)
+				}
+			case tc.payload != nil:
+				if !bytes.Equal(payload, tc.payload) {
+					t.Errorf("payload = %x, want %x", payload, tc.payload)
+				}
+			case len(payload) == 0:
+				t.Error("empty payload")
+			}
+			if tc.wrapper == "" && wrapped != conn {
+				t.Errorf("conn wrapped as %T", wrapped)
+			} else if got := fmt.Sprintf("%T", wrapped); tc.wrapper != "" && got != tc.wrapper {
+				t.Errorf("wrapped = %s, want %s", got, tc.wrapper)
+			}
+		})
+	}
+
+	for _, cfg := range []config.OutboundConfig{
+		{Type: "vless", UUID: "not-a-uuid"},
+		// Vision 需要外层 TLS 1.3
+		{Type: "vless", UUID: uuid, Flow: "xtls-rprx-vision"},
+		{Type: "vmess", UUID: uuid, Method: "rc4"},
+		{Type: "shadowsocks", Method: "unknown-cipher", Password: "secret"},
+		{Type: "http"},
+	} {
+		conn, _ := net.Pipe()
+		if _, _, err := TunnelHandshake(&cfg, conn, "example.com", 443); err == nil {
+			t.Errorf("%s (%q, %q) succeeded", cfg.Type, cfg.UUID, cfg.Method)
+		}
+		conn.Close()
+	}
+	if _, _, err := TunnelHandshake(&config.OutboundConfig{Type: "trojan"}, nil, strings.Repeat("a", 256), 443); err == nil {
+		t.Error("oversized domain accepted")
+	}
+}
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"mandala/core/logger"
+)
+
+// bufferedConn 带读缓冲的本地连接，用于预读首字节判断入站协议
+// 之后的读取均经过缓冲区，已预读的数据不会丢失
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newBufferedConn(c net.Conn) *bufferedConn {
+	return &bufferedConn{Conn: c, r: bufio.NewReader(c)}
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// handleHTTP 处理 HTTP 代理请求
+// CONNECT 建立隧道；绝对地址形式的普通请求 (GET http://host/path) 改写为相对路径后转发。
+// [修改] 普通请求支持 keep-alive 与管线化：逐个读取请求，每个请求按自己的目标路由并拨号，
+// 转发请求头与按原分帧方式的请求体，回传一个完整的响应后再读取下一个请求
+func (h *Handler) handleHTTP(ctx context.Context, conn *bufferedConn) {
+	for first := true; ; first = false {
+		// 首个请求沿用握手超时，之后等待下一个请求的时间按空闲超时计算 (0 表示不限制)
+		if !first {
+			var deadline time.Time
+			if idle := h.Config.IdleTimeout(); idle > 0 {
+				deadline = time.Now().Add(idle)
+			}
+			conn.SetReadDeadline(deadline)
+		}
+
+		req, err := http.ReadRequest(conn.r)
+		if err != nil {
+			if first && h.Config.Settings.ProbeResistance {
+				writeDecoyResponse(conn)
+			}
+			return
+		}
+
+		isConnect := req.Method == http.MethodConnect
+		if !isConnect && !req.URL.IsAbs() {
+			// 非代理请求 (如直接访问本地端口的探测)
+			if first && h.Config.Settings.ProbeResistance {
+				writeDecoyResponse(conn)
+			} else {
+				writeHTTPStatus(conn, http.StatusBadRequest)
+			}
+			return
+		}
+
+		if h.authRequired() && !h.checkProxyAuth(req) {
+			logger.Warnf("Proxy", "本地入站认证失败: %s", conn.RemoteAddr())
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n" +
+				"Proxy-Authenticate: Basic realm=\"proxy\"\r\n" +
+				"Content-Length: 0\r\nConnection: close\r\n\r\n"))
+			return
+		}
+
+		host := req.Host
+		if !isConnect {
+			host = req.URL.Host
+		}
+		defaultPort := 80
+		if isConnect || req.URL.Scheme == "https" {
+			defaultPort = 443
+		}
+		targetHost, targetPort, err := splitTarget(host, defaultPort)
+		if err != nil {
+			writeHTTPStatus(conn, http.StatusBadRequest)
+			return
+		}
+
+		if !h.Ports.Allowed(targetPort) {
+			logger.Infof("Policy", "拒绝连接 %s:%d: 目标端口不在允许范围内", targetHost, targetPort)
+			writeHTTPStatus(conn, http.StatusForbidden)
+			return
+		}
+
+		if isConnect {
+			h.relay(ctx, conn, targetHost, targetPort, nil, func(rep byte) error {
+				if rep != repSucceeded {
+					writeHTTPStatus(conn, httpStatusForRep(rep))
+					return nil
+				}
+				_, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+				return err
+			})
+			return
+		}
+
+		if !h.forwardHTTP(ctx, conn, req, targetHost, targetPort) {
+			return
+		}
+	}
+}
+
+// forwardHTTP 经新建的隧道转发一个普通请求，并把源站的一个完整响应 (含之前的 1xx 响应) 写回客户端；
+// 返回 true 表示请求体已读完且双方都未要求关闭，客户端连接可以继续读取下一个请求。
+// 源站返回 101 (如明文 WebSocket) 时改为双向转发，结束后关闭客户端连接
+func (h *Handler) forwardHTTP(ctx context.Context, conn *bufferedConn, req *http.Request, targetHost string, targetPort int) bool {
+	// 清除等待请求时设置的读超时，请求体可能需要较长时间上传
+	conn.SetReadDeadline(time.Time{})
+
+	remote := h.connect(ctx, conn, targetHost, targetPort, rewriteProxyRequest(req), func(rep byte) error {
+		if rep != repSucceeded {
+			writeHTTPStatus(conn, httpStatusForRep(rep))
+		}
+		return nil
+	})
+	if remote == nil {
+		return false
+	}
+	defer remote.Close()
+
+	// 服务停止时关闭隧道，解除阻塞在读取响应上的循环 (客户端连接由 HandleConnection 关闭)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			remote.Close()
+		case <-stop:
+		}
+	}()
+
+	// 请求体与读取响应并行：客户端可能在收到 100 Continue 之后才发送请求体
+	bodyDone := make(chan error, 1)
+	go func() {
+		bodyDone <- writeRequestBody(remote, req)
+	}()
+
+	br := bufio.NewReader(remote)
+	for {
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			logger.Debugf("Proxy", "读取 %s:%d 的响应失败: %v", targetHost, targetPort, err)
+			writeHTTPStatus(conn, http.StatusBadGateway)
+			return false
+		}
+
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			if err := resp.Write(conn); err != nil {
+				return false
+			}
+			pipe(ctx, conn, &bufferedConn{Conn: remote, r: br}, h.Config.IdleTimeout())
+			return false
+		}
+
+		err = resp.Write(conn)
+		resp.Body.Close()
+		if err != nil {
+			return false
+		}
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 {
+			continue
+		}
+
+		if err := <-bodyDone; err != nil {
+			return false
+		}
+		return !req.Close && !resp.Close
+	}
+}
+
+// writeRequestBody 按原分帧方式发送请求体：分块编码的请求体重新分块 (含尾部字段)，否则按 Content-Length 原样发送
+func writeRequestBody(w io.Writer, req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if len(req.TransferEncoding) == 0 {
+		_, err := CopyBuffered(w, req.Body)
+		return err
+	}
+
+	cw := httputil.NewChunkedWriter(w)
+	if _, err := CopyBuffered(cw, req.Body); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	// 终止块之后为尾部字段与结束空行
+	var buf bytes.Buffer
+	req.Trailer.Write(&buf)
+	buf.WriteString("\r\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// httpStatusForRep 将 SOCKS5 应答码映射为 HTTP 状态码
+func httpStatusForRep(rep byte) int {
+	if rep == repNotAllowed {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// checkProxyAuth 校验 Proxy-Authorization: Basic 凭据
+func (h *Handler) checkProxyAuth(req *http.Request) bool {
+	auth := req.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[len(prefix):]))
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(h.Config.Settings.InboundUsername))
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(h.Config.Settings.InboundPassword))
+	return userOK&passOK == 1
+}
+
+// rewriteProxyRequest 将绝对地址形式的请求头改写为发往源站的相对路径形式
+// 请求体不在此处读取，由 writeRequestBody 按同样的分帧方式发送；Connection 等头部保持客户端的原值
+func rewriteProxyRequest(req *http.Request) []byte {
+	header := req.Header.Clone()
+	for k := range header {
+		if strings.HasPrefix(strings.ToLower(k), "proxy-") {
+			header.Del(k)
+		}
+	}
+	if len(req.TransferEncoding) > 0 {
+		header.Set("Transfer-Encoding", strings.Join(req.TransferEncoding, ", "))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/%d.%d\r\n", req.Method, req.URL.RequestURI(), req.ProtoMajor, req.ProtoMinor)
+	fmt.Fprintf(&buf, "Host: %s\r\n", req.URL.Host)
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// splitTarget 解析 host[:port]，未指定端口时使用 defaultPort
+func splitTarget(hostport string, defaultPort int) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		// 未带端口
+		host = strings.Trim(hostport, "[]")
+		portStr = strconv.Itoa(defaultPort)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 || host == "" {
+		return "", 0, fmt.Errorf("invalid target: %q", hostport)
+	}
+	return host, port, nil
+}
+
+func writeHTTPStatus(conn net.Conn, code int) {
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", code, http.StatusText(code))
+}
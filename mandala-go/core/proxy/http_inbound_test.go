@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newOrigin 启动一个回显请求信息的源站，响应体为 "<name> <method> <path> <body>"
+func newOrigin(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Proxy-Authorization") != "" || r.Header.Get("Proxy-Connection") != "" {
+			http.Error(w, "proxy header leaked", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%s %s %s %s", name, r.Method, r.URL.Path, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dialProxy(t *testing.T) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", serveInbound(t, newDirectHandler(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func readBody(t *testing.T, br *bufio.Reader, method string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(br, &http.Request{Method: method})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestHTTPInboundGet(t *testing.T) {
+	origin := newOrigin(t, "a")
+	conn, br := dialProxy(t)
+
+	fmt.Fprintf(conn, "GET %s/hello HTTP/1.1\r\nHost: %s\r\nProxy-Connection: keep-alive\r\n\r\n",
+		origin.URL, strings.TrimPrefix(origin.URL, "http://"))
+	resp, body := readBody(t, br, http.MethodGet)
+	if resp.StatusCode != http.StatusOK || body != "a GET /hello " {
+		t.Fatalf("got %d %q", resp.StatusCode, body)
+	}
+}
+
+// 同一客户端连接上的连续请求按各自的目标转发，而不是全部发往第一个源站
+func TestHTTPInboundKeepAliveDifferentHosts(t *testing.T) {
+	a, b := newOrigin(t, "a"), newOrigin(t, "b")
+	conn, br := dialProxy(t)
+
+	for i, tc := range []struct {
+		origin *httptest.Server
+		want   string
+	}{
+		{a, "a GET /1 "},
+		{b, "b GET /2 "},
+		{a, "a GET /3 "},
+	} {
+		fmt.Fprintf(conn, "GET %s/%d HTTP/1.1\r\nHost: %s\r\n\r\n",
+			tc.origin.URL, i+1, strings.TrimPrefix(tc.origin.URL, "http://"))
+		resp, body := readBody(t, br, http.MethodGet)
+		if resp.StatusCode != http.StatusOK || body != tc.want {
+			t.Fatalf("request %d: got %d %q, want %q", i+1, resp.StatusCode, body, tc.want)
+		}
+		if resp.Close {
+			t.Fatalf("request %d: response closes the client connection", i+1)
+		}
+	}
+}
+
+// 管线化的请求 (含分块与定长请求体) 逐个转发，请求体不会被当作下一个请求
+func TestHTTPInboundPipelinedBodies(t *testing.T) {
+	a, b := newOrigin(t, "a"), newOrigin(t, "b")
+	conn, br := dialProxy(t)
+
+	hostA, hostB := strings.TrimPrefix(a.URL, "http://"), strings.TrimPrefix(b.URL, "http://")
+	pipelined := fmt.Sprintf("POST %s/chunked HTTP/1.1\r\nHost: %s\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", a.URL, hostA) +
+		fmt.Sprintf("POST %s/fixed HTTP/1.1\r\nHost: %s\r\nContent-Length: 3\r\n\r\nabc", b.URL, hostB) +
+		fmt.Sprintf("HEAD %s/head HTTP/1.1\r\nHost: %s\r\n\r\n", a.URL, hostA) +
+		fmt.Sprintf("GET %s/last HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", b.URL, hostB)
+	if _, err := io.WriteString(conn, pipelined); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct{ method, body string }{
+		{http.MethodPost, "a POST /chunked hello world"},
+		{http.MethodPost, "b POST /fixed abc"},
+		{http.MethodHead, ""},
+		{http.MethodGet, "b GET /last "},
+	} {
+		resp, body := readBody(t, br, want.method)
+		if resp.StatusCode != http.StatusOK || body != want.body {
+			t.Fatalf("%s: got %d %q, want %q", want.method, resp.StatusCode, body, want.body)
+		}
+	}
+
+	// Connection: close 的请求之后关闭客户端连接
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("connection not closed after Connection: close, err = %v", err)
+	}
+}
+
+func TestHTTPInboundConnect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		c, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	conn, br := dialProxy(t)
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", echo.Addr(), echo.Addr())
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT: %v %v", resp, err)
+	}
+
+	io.WriteString(conn, "ping")
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(br, got); err != nil || string(got) != "ping" {
+		t.Fatalf("tunnel echo = %q, %v", got, err)
+	}
+}
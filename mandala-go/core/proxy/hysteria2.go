@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mandala/core/protocol"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+const (
+	// Hysteria2 QUIC 空闲超时与保活间隔 (与官方客户端默认值一致)
+	hysteria2IdleTimeout     = 30 * time.Second
+	hysteria2KeepAlivePeriod = 10 * time.Second
+)
+
+// hysteria2Session 一条已认证的 Hysteria2 QUIC 连接，承载多个 TCP 流与 UDP 会话
+type hysteria2Session struct {
+	conn          *quic.Conn
+	h3            *http3.ClientConn // 认证使用的 HTTP/3 连接，之后的中继流直接使用 QUIC
+	udpEnabled    bool
+	maxPacketSize int
+
+	mu        sync.Mutex
+	udpConns  map[uint32]*quicPacketConn
+	nextUDPID uint32
+}
+
+var (
+	hysteria2Sessions   = make(map[string]*hysteria2Session)
+	hysteria2SessionsMu sync.Mutex
+)
+
+func closeHysteria2Sessions() {
+	hysteria2SessionsMu.Lock()
+	sessions := hysteria2Sessions
+	hysteria2Sessions = make(map[string]*hysteria2Session)
+	hysteria2SessionsMu.Unlock()
+
+	for _, s := range sessions {
+		s.conn.CloseWithError(0, "")
+	}
+}
+
+// getHysteria2Session 返回节点的共享 Hysteria2 连接，不存在或已断开时新建
+func (d *Dialer) getHysteria2Session() (*hysteria2Session, error) {
+	key := d.tuicKey()
+
+	hysteria2SessionsMu.Lock()
+	defer hysteria2SessionsMu.Unlock()
+	if s, ok := hysteria2Sessions[key]; ok && s.conn.Context().Err() == nil {
+		return s, nil
+	}
+	s, err := d.newHysteria2Session()
+	if err != nil {
+		return nil, err
+	}
+	hysteria2Sessions[key] = s
+	return s, nil
+}
+
+// dropHysteria2Session 从共享池中移除已失效的连接
+func (d *Dialer) dropHysteria2Session(s *hysteria2Session) {
+	key := d.tuicKey()
+	hysteria2SessionsMu.Lock()
+	if hysteria2Sessions[key] == s {
+		delete(hysteria2Sessions, key)
+	}
+	hysteria2SessionsMu.Unlock()
+	s.conn.CloseWithError(0, "")
+}
+
+// newHysteria2Session 建立 QUIC 连接并经 HTTP/3 完成认证
+// 认证请求携带下行带宽，服务端据此以固定速率发送 (Brutal)；上行使用 quic-go 默认的拥塞控制
+func (d *Dialer) newHysteria2Session() (*hysteria2Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout())
+	defer cancel()
+
+	conn, err := d.dialQUIC(ctx, []string{http3.NextProtoH3}, &quic.Config{
+		EnableDatagrams: true,
+		MaxIdleTimeout:  hysteria2IdleTimeout,
+		KeepAlivePeriod: hysteria2KeepAlivePeriod,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rxBytesPerSec uint64
+	if cfg := d.Config.Hysteria2; cfg != nil && cfg.DownMbps > 0 {
+		rxBytesPerSec = uint64(cfg.DownMbps) * 1000000 / 8
+	}
+	req, err := protocol.BuildHysteria2AuthRequest(d.Config.Password, rxBytesPerSec)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+
+	h3 := (&http3.Transport{}).NewClientConn(conn)
+	resp, err := h3.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, fmt.Errorf("hysteria2 auth request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != protocol.Hysteria2StatusAuthOK {
+		conn.CloseWithError(0, "")
+		return nil, fmt.Errorf("hysteria2 authentication failed: status %d", resp.StatusCode)
+	}
+
+	s := &hysteria2Session{
+		conn:          conn,
+		h3:            h3,
+		udpEnabled:    resp.Header.Get(protocol.Hysteria2HeaderUDP) == "true",
+		maxPacketSize: d.Config.MaxPacketSize(),
+		udpConns:      make(map[uint32]*quicPacketConn),
+	}
+	go s.receiveDatagrams()
+
+	d.recordConnInfo(d.newQUICConnInfo(conn))
+	if !d.probe {
+		log.Printf("[Hysteria2] 新建连接 -> %s (UDP: %v, 服务端接收带宽: %s)",
+			d.serverAddr(), s.udpEnabled, resp.Header.Get(protocol.Hysteria2HeaderCCRX))
+	}
+	return s, nil
+}
+
+// dialHysteria2Stream 在共享 Hysteria2 连接上打开一个双向流，由调用方随后写入 TCPRequest
+func (d *Dialer) dialHysteria2Stream() (net.Conn, error) {
+	// 共享连接可能恰好在此时断开，重试一次
+	var lastErr error
+	for i := 0; i < 2; i++ {
+		s, err := d.getHysteria2Session()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout())
+		stream, err := s.conn.OpenStreamSync(ctx)
+		cancel()
+		if err == nil {
+			return &quicStreamConn{Stream: stream, conn: s.conn}, nil
+		}
+		d.dropHysteria2Session(s)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("hysteria2 open stream failed: %v", lastErr)
+}
+
+// dialHysteria2Probe 为测速建立独立的 Hysteria2 连接 (不进入共享池)，关闭流时一并关闭连接
+func (d *Dialer) dialHysteria2Probe() (net.Conn, error) {
+	s, err := d.newHysteria2Session()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout())
+	defer cancel()
+	stream, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		s.conn.CloseWithError(0, "")
+		return nil, err
+	}
+	return &quicStreamConn{Stream: stream, conn: s.conn, owned: true}, nil
+}
+
+// receiveDatagrams 接收服务端返回的 UDP 数据报并交给对应的会话
+func (s *hysteria2Session) receiveDatagrams() {
+	ctx := s.conn.Context()
+	for {
+		data, err := s.conn.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		msg, err := protocol.ParseHysteria2UDPMessage(data)
+		if err != nil {
+			log.Printf("[Hysteria2] 无效的 UDP 数据报: %v", err)
+			continue
+		}
+		s.mu.Lock()
+		pc := s.udpConns[msg.SessionID]
+		s.mu.Unlock()
+		if pc != nil {
+			pc.deliver(msg.PacketID, msg.FragID, msg.FragCount, msg.Data)
+		}
+	}
+}
+
+// dialHysteria2UDP 在共享 Hysteria2 连接上新建一个 UDP 会话
+// 会话无需握手：服务端收到未知会话 ID 的数据报时自动创建，空闲超时后回收
+func (d *Dialer) dialHysteria2UDP(targetHost string, targetPort int) (net.Conn, error) {
+	s, err := d.getHysteria2Session()
+	if err != nil {
+		return nil, err
+	}
+	if !s.udpEnabled || !s.conn.ConnectionState().SupportsDatagrams {
+		return nil, errors.New("hysteria2 server does not allow udp relay")
+	}
+	addr := net.JoinHostPort(targetHost, fmt.Sprint(targetPort))
+
+	s.mu.Lock()
+	var sessionID uint32
+	for {
+		s.nextUDPID++
+		sessionID = s.nextUDPID
+		if _, used := s.udpConns[sessionID]; !used {
+			break
+		}
+	}
+	pc := newQUICPacketConn(s.conn, s.maxPacketSize)
+	s.udpConns[sessionID] = pc
+	s.mu.Unlock()
+
+	var nextPkt atomic.Uint32
+	pc.send = func(b []byte) error {
+		msg := &protocol.Hysteria2UDPMessage{
+			SessionID: sessionID,
+			FragCount: 1,
+			Addr:      addr,
+			Data:      b,
+		}
+		if msg.HeaderSize()+len(b) <= quicMaxDatagramSize {
+			return s.conn.SendDatagram(msg.Marshal())
+		}
+
+		// 超出单个数据报的长度时分片发送，每个分片都携带目标地址
+		size := quicMaxDatagramSize - msg.HeaderSize()
+		chunks, err := splitDatagram(b, size, size)
+		if err != nil {
+			return err
+		}
+		msg.PacketID = uint16(nextPkt.Add(1))
+		msg.FragCount = uint8(len(chunks))
+		for i, chunk := range chunks {
+			frag := *msg
+			frag.FragID = uint8(i)
+			frag.Data = chunk
+			if err := s.conn.SendDatagram(frag.Marshal()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	pc.onClose = func() {
+		s.mu.Lock()
+		delete(s.udpConns, sessionID)
+		s.mu.Unlock()
+	}
+	return pc, nil
+}
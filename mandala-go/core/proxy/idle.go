@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// activityConn 记录最近一次成功读写的时间，用于转发阶段的空闲超时
+// 只需包装远程一端：读取对应下行，写入对应上行
+type activityConn struct {
+	net.Conn
+	last atomic.Int64 // Unix 纳秒
+}
+
+func newActivityConn(c net.Conn) *activityConn {
+	ac := &activityConn{Conn: c}
+	ac.last.Store(time.Now().UnixNano())
+	return ac
+}
+
+func (c *activityConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *activityConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// idleFor 返回截至 now 已空闲的时长
+func (c *activityConn) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, c.last.Load()))
+}
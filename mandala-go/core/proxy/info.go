@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// ConnInfo 最近一次成功拨号的握手与传输信息，供 UI 状态页展示
+type ConnInfo struct {
+	Protocol    string `json:"protocol"`
+	Server      string `json:"server"`
+	RemoteAddr  string `json:"remote_addr"`
+	Transport   string `json:"transport"` // tcp / ws / grpc / http / quic / webtransport
+	TLS         bool   `json:"tls"`
+	TLSVersion  string `json:"tls_version,omitempty"`
+	CipherSuite string `json:"cipher_suite,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`
+	ECHOffered  bool   `json:"ech_offered"`
+	ECHAccepted bool   `json:"ech_accepted"`
+	Reality     bool   `json:"reality"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Resumed     bool   `json:"resumed"` // [新增] TLS 会话由缓存的票据恢复
+	Fragment    bool   `json:"fragment"`
+	ConnectedAt int64  `json:"connected_at"` // Unix 毫秒
+
+	// [新增] 拨号各阶段耗时；多路复用与 QUIC 连接为 nil
+	Timing *DialStats `json:"timing,omitempty"`
+}
+
+var (
+	lastConnInfo   *ConnInfo
+	lastConnInfoMu sync.RWMutex
+)
+
+// LastConnInfo 返回最近一次成功拨号的信息，尚未拨号时返回 nil
+func LastConnInfo() *ConnInfo {
+	lastConnInfoMu.RLock()
+	defer lastConnInfoMu.RUnlock()
+	if lastConnInfo == nil {
+		return nil
+	}
+	info := *lastConnInfo
+	return &info
+}
+
+// ResetConnInfo 清除记录 (核心停止时调用)
+func ResetConnInfo() {
+	lastConnInfoMu.Lock()
+	lastConnInfo = nil
+	lastConnInfoMu.Unlock()
+}
+
+func setLastConnInfo(info *ConnInfo) {
+	lastConnInfoMu.Lock()
+	lastConnInfo = info
+	lastConnInfoMu.Unlock()
+}
+
+// recordConnInfo 记录握手信息；测速拨号不覆盖当前连接的状态
+func (d *Dialer) recordConnInfo(info *ConnInfo) {
+	if !d.probe {
+		setLastConnInfo(info)
+	}
+}
+
+// newConnInfo 根据握手完成的连接 (传输层升级之前) 采集信息
+func (d *Dialer) newConnInfo(conn net.Conn) *ConnInfo {
+	info := &ConnInfo{
+		Protocol:    strings.ToLower(d.Config.Type),
+		Server:      d.serverAddr(),
+		Transport:   "tcp",
+		Fragment:    d.Config.Settings.Fragment,
+		ConnectedAt: time.Now().UnixMilli(),
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+
+	if uConn, ok := conn.(*utls.UConn); ok {
+		state := uConn.ConnectionState()
+		info.TLS = true
+		info.TLSVersion = utls.VersionName(state.Version)
+		info.CipherSuite = utls.CipherSuiteName(state.CipherSuite)
+		info.ALPN = state.NegotiatedProtocol
+		info.ECHOffered = d.Config.TLS.EnableECH && !d.usesReality()
+		info.Reality = d.usesReality()
+		info.ECHAccepted = state.ECHAccepted
+		info.Resumed = state.DidResume
+		_, info.Fingerprint = d.clientHelloID()
+	}
+	return info
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"mandala/core/config"
+)
+
+// 测速时协议握手指向的目标
+const (
+	latencyProbeHost = "1.1.1.1"
+	latencyProbePort = 443
+)
+
+// TestLatency 对节点执行一次完整的拨号 (TCP、TLS、传输层) 与外层协议握手，返回耗时
+// 测速连接不经过多路复用连接池，也不记录连接信息，不影响正在运行的核心
+func TestLatency(cfg *config.OutboundConfig, timeout time.Duration) (time.Duration, error) {
+	probeCfg := *cfg
+	if timeout > 0 && (probeCfg.Settings.DialTimeout <= 0 || time.Duration(probeCfg.Settings.DialTimeout)*time.Millisecond > timeout) {
+		probeCfg.Settings.DialTimeout = int(timeout / time.Millisecond)
+	}
+	d := &Dialer{Config: &probeCfg, probe: true}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	start := time.Now()
+
+	// 超时后中止仍在进行的拨号，不让后台任务继续占用拨号名额
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	go func() {
+		var conn net.Conn
+		var err error
+		if d.Config.UsesQUIC() {
+			conn, err = d.dialQUICProbe()
+		} else {
+			conn, err = d.dialTunnel(ctx)
+		}
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		if timeout > 0 {
+			conn.SetDeadline(start.Add(timeout))
+		}
+		conn, err = d.handshakeTunnel(conn, latencyProbeHost, latencyProbePort, nil)
+		done <- result{conn: conn, err: err}
+	}()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case r := <-done:
+		elapsed := time.Since(start)
+		if r.conn != nil {
+			r.conn.Close()
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+		return elapsed, nil
+	case <-timer:
+		// 超时后台任务仍可能完成，完成时关闭其连接
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return 0, errors.New("latency test timed out")
+	}
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 默认每个节点同时进行中的拨号/握手数量
+const defaultDialConcurrency = 16
+
+// 排队等待拨号名额的最长时间
+const dialQueueTimeout = 5 * time.Second
+
+// 节点拨号限流器 (按 server:port 区分)
+var (
+	dialLimiters   = make(map[string]chan struct{})
+	dialLimitersMu sync.Mutex
+)
+
+// acquireDialSlot 获取一个拨号名额，超出上限时短暂排队
+// 返回的 release 必须在拨号+握手结束后调用 (无论成功与否)
+func acquireDialSlot(ctx context.Context, key string, limit int) (func(), error) {
+	if limit <= 0 {
+		limit = defaultDialConcurrency
+	}
+
+	dialLimitersMu.Lock()
+	sem, ok := dialLimiters[key]
+	if !ok || cap(sem) != limit {
+		sem = make(chan struct{}, limit)
+		dialLimiters[key] = sem
+	}
+	dialLimitersMu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	default:
+	}
+
+	timer := time.NewTimer(dialQueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("dial queue timeout: too many concurrent dials to %s", key)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,352 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"mandala/core/protocol"
+
+	"github.com/xtaci/smux"
+)
+
+// 默认每个多路复用连接承载的最大流数量
+const defaultMuxConcurrency = 8
+
+// errMuxPoolClosed 会话建立期间核心已停止
+var errMuxPoolClosed = errors.New("mux pool closed")
+
+// 空闲多路复用会话的检查间隔，连续两次检查无活跃流则关闭
+const muxIdleCheckInterval = 30 * time.Second
+
+// muxPool 管理同一节点的多路复用会话
+type muxPool struct {
+	mu       sync.Mutex
+	sessions []*smux.Session
+	dialing  *muxDial // [新增] 进行中的会话拨号，同一节点同时只建立一个新会话
+	closed   bool     // [新增] 已由 CloseMuxSessions 关闭，之后建立的会话直接丢弃
+}
+
+// muxDial 一次进行中的会话拨号，done 关闭后 sess / err 可读
+type muxDial struct {
+	done chan struct{}
+	sess *smux.Session
+	err  error
+}
+
+var (
+	muxPools   = make(map[string]*muxPool)
+	muxPoolsMu sync.Mutex
+)
+
+func getMuxPool(key string) *muxPool {
+	muxPoolsMu.Lock()
+	defer muxPoolsMu.Unlock()
+	p, ok := muxPools[key]
+	if !ok {
+		p = &muxPool{}
+		muxPools[key] = p
+	}
+	return p
+}
+
+// CloseMuxSessions 关闭所有多路复用会话 (核心停止时调用)
+func CloseMuxSessions() {
+	muxPoolsMu.Lock()
+	pools := muxPools
+	muxPools = make(map[string]*muxPool)
+	muxPoolsMu.Unlock()
+
+	for _, p := range pools {
+		p.mu.Lock()
+		for _, s := range p.sessions {
+			s.Close()
+		}
+		p.sessions = nil
+		p.closed = true
+		p.mu.Unlock()
+	}
+}
+
+func (d *Dialer) muxKey() string {
+	return d.Config.Type + "|" + d.Config.Mux.Protocol + "|" + d.serverAddr() + "|" +
+		protocol.TrojanPasswordHash(d.Config.Password+"|"+d.Config.UUID)
+}
+
+// dialMuxStream 从多路复用会话中打开一个逻辑流，替代一次完整的 TCP+TLS+传输层握手
+// ctx 取消时中止新会话的拨号与握手；已建立的会话由多个连接共享，不随 ctx 关闭
+func (d *Dialer) dialMuxStream(ctx context.Context) (net.Conn, error) {
+	pool := getMuxPool(d.muxKey())
+
+	// 选中的会话可能恰好在此时关闭，重试一次
+	var lastErr error
+	for i := 0; i < 2; i++ {
+		stream, err := pool.open(ctx, d)
+		if err == nil {
+			return stream, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("mux open stream failed: %v", lastErr)
+}
+
+// open 在选中的会话上打开流；选择与打开在同一把锁内完成，
+// 避免并发拨号同时看到空余容量而超出单会话流数量上限
+// [修改] 需要新建会话时在锁外拨号，拨号期间其他调用方仍可在已有会话上打开流或各自取消；并发的调用方共享同一次拨号，完成后重新选择
+func (p *muxPool) open(ctx context.Context, d *Dialer) (*smux.Stream, error) {
+	for {
+		p.mu.Lock()
+		if sess := p.pickLocked(d); sess != nil {
+			stream, err := sess.OpenStream()
+			p.mu.Unlock()
+			// 会话已断开时由下次选择清理；仅打开流失败 (如达到对端限制) 不影响会话上的其他流
+			return stream, err
+		}
+
+		call := p.dialing
+		leader := call == nil
+		if leader {
+			call = &muxDial{done: make(chan struct{})}
+			p.dialing = call
+		}
+		p.mu.Unlock()
+
+		if leader {
+			p.dial(ctx, d, call)
+			if call.err != nil {
+				return nil, call.err
+			}
+			continue
+		}
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// 发起拨号的调用方取消时由其余调用方重新拨号，其他错误直接返回
+		if call.err != nil && !errors.Is(call.err, context.Canceled) && !errors.Is(call.err, context.DeadlineExceeded) {
+			return nil, call.err
+		}
+	}
+}
+
+// dial 在锁外建立新会话，完成后加入会话池并通知等待中的调用方
+func (p *muxPool) dial(ctx context.Context, d *Dialer, call *muxDial) {
+	if d.Config.UseTrojanGoMux() {
+		call.sess, call.err = d.newTrojanGoMuxSession(ctx)
+	} else {
+		call.sess, call.err = d.newSingMuxSession(ctx)
+	}
+	if call.err != nil && ctx.Err() != nil {
+		call.err = ctx.Err()
+	}
+
+	p.mu.Lock()
+	if call.err == nil {
+		if p.closed {
+			call.sess.Close()
+			call.sess, call.err = nil, errMuxPoolClosed
+		} else {
+			p.sessions = append(p.sessions, call.sess)
+		}
+	}
+	p.dialing = nil
+	p.mu.Unlock()
+	close(call.done)
+}
+
+// pickLocked 返回一个尚未达到流数量上限的会话，需要新建时返回 nil；调用方需持有 p.mu
+func (p *muxPool) pickLocked(d *Dialer) *smux.Session {
+	limit := defaultMuxConcurrency
+	if d.Config.Mux.Concurrency > 0 {
+		limit = d.Config.Mux.Concurrency
+	}
+
+	alive := p.sessions[:0]
+	for _, s := range p.sessions {
+		if !s.IsClosed() {
+			alive = append(alive, s)
+		}
+	}
+	p.sessions = alive
+
+	for _, s := range p.sessions {
+		if s.NumStreams() < limit {
+			return s
+		}
+	}
+
+	// [新增] 底层连接数达到上限时，分配到负载最低的会话
+	if maxConns := d.Config.Mux.MaxConnections; maxConns > 0 && len(p.sessions) >= maxConns {
+		least := p.sessions[0]
+		for _, s := range p.sessions[1:] {
+			if s.NumStreams() < least.NumStreams() {
+				least = s
+			}
+		}
+		return least
+	}
+	return nil
+}
+
+// newTrojanGoMuxSession 建立底层隧道并发送 Trojan-Go 多路复用握手，之后在其上运行 smux
+func (d *Dialer) newTrojanGoMuxSession(ctx context.Context) (*smux.Session, error) {
+	conn, err := d.dialTunnel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeOnCancel(ctx, conn)()
+
+	payload, err := protocol.BuildTrojanMuxPayload(d.Config.Password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Write(payload); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sess, err := smux.Client(conn, smux.DefaultConfig())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	log.Printf("[Mux] 新建 Trojan-Go 多路复用会话 -> %s", d.serverAddr())
+	go reapIdleMuxSession(sess)
+	return sess, nil
+}
+
+// newSingMuxSession 建立底层隧道，以 sing-mux 特殊地址完成外层协议握手后运行 smux
+func (d *Dialer) newSingMuxSession(ctx context.Context) (*smux.Session, error) {
+	conn, err := d.dialTunnel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeOnCancel(ctx, conn)()
+
+	conn, err = d.handshakeTunnel(conn, protocol.SingMuxHost, protocol.SingMuxPort, protocol.BuildSingMuxSessionRequest())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	sess, err := smux.Client(conn, smux.DefaultConfig())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	log.Printf("[Mux] 新建 sing-mux 多路复用会话 (%s) -> %s", d.Config.Type, d.serverAddr())
+	go reapIdleMuxSession(sess)
+	return sess, nil
+}
+
+// handshakeTunnel 在隧道上完成外层协议的 TCP 握手，extra 与握手包合并为一次写入
+// 返回的连接可能经过协议层包装 (VLESS/VMess/Shadowsocks)；出错时返回的连接仍需由调用方关闭
+func (d *Dialer) handshakeTunnel(conn net.Conn, targetHost string, targetPort int, extra []byte) (net.Conn, error) {
+	var payload []byte
+	var err error
+	isVless := false
+
+	switch strings.ToLower(d.Config.Type) {
+	case "mandala":
+		client := protocol.NewMandalaClient(d.Config.Username, d.Config.Password)
+		payload, err = client.BuildHandshakePayload(targetHost, targetPort, d.Config.Settings.Noise)
+	case "trojan":
+		payload, err = protocol.BuildTrojanPayload(d.Config.Password, targetHost, targetPort)
+	case "vless":
+		payload, err = protocol.BuildVlessFlowPayload(d.Config.UUID, d.Config.Flow, targetHost, targetPort)
+		if err == nil && d.Config.Flow != "" {
+			var visionConn *protocol.VlessConn
+			visionConn, err = protocol.NewVlessVisionConn(conn, d.Config.UUID, payload)
+			if err == nil {
+				visionConn.Strict = d.Config.Settings.StrictProtocol
+				conn = visionConn
+				payload = nil
+			}
+		} else {
+			isVless = true
+		}
+	case "tuic":
+		payload, err = protocol.BuildTUICConnect(targetHost, targetPort)
+	case "hysteria2":
+		payload, err = protocol.BuildHysteria2TCPRequest(targetHost, targetPort)
+		if err == nil {
+			conn = protocol.NewHysteria2Conn(conn)
+		}
+	case "vmess":
+		var vmessConn *protocol.VmessConn
+		vmessConn, err = protocol.NewVmessConn(conn, d.Config.UUID, d.Config.Method, protocol.VmessCmdTCP, targetHost, targetPort)
+		if err == nil {
+			conn = vmessConn
+		}
+	case "shadowsocks":
+		payload, err = protocol.BuildShadowsocksPayload(targetHost, targetPort)
+		if err == nil {
+			conn, err = protocol.WrapShadowsocks(conn, d.Config.Method, d.Config.Password)
+		}
+	case "socks", "socks5":
+		err = protocol.HandshakeSocks5(conn, d.Config.Username, d.Config.Password, targetHost, targetPort)
+	default:
+		err = fmt.Errorf("protocol not implemented: %s", d.Config.Type)
+	}
+	if err != nil {
+		return conn, err
+	}
+
+	if _, err := conn.Write(append(payload, extra...)); err != nil {
+		return conn, err
+	}
+	if isVless {
+		vc := protocol.NewVlessConn(conn)
+		vc.Strict = d.Config.Settings.StrictProtocol
+		conn = vc
+	}
+	return conn, nil
+}
+
+// closeOnCancel 在返回的 stop 被调用前 ctx 取消时关闭 conn，使阻塞中的握手读写立即返回
+func closeOnCancel(ctx context.Context, conn net.Conn) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+func reapIdleMuxSession(sess *smux.Session) {
+	ticker := time.NewTicker(muxIdleCheckInterval)
+	defer ticker.Stop()
+
+	idle := false
+	for range ticker.C {
+		if sess.IsClosed() {
+			return
+		}
+		if sess.NumStreams() > 0 {
+			idle = false
+			continue
+		}
+		if idle {
+			log.Printf("[Mux] 会话空闲，关闭")
+			sess.Close()
+			return
+		}
+		idle = true
+	}
+}
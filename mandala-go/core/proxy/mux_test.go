@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+)
+
+// startSilentServer 启动只接受连接、从不响应的节点，模拟握手卡住的服务端 (3 秒后关闭连接，避免测试挂起)
+// accepted 记录已接受的连接数
+func startSilentServer(t *testing.T) (host string, port int, accepted *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	accepted = new(atomic.Int32)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			time.AfterFunc(3*time.Second, func() { c.Close() })
+		}
+	}()
+	host, portStr, _ := net.SplitHostPort(l.Addr().String())
+	port, _ = strconv.Atoi(portStr)
+	return host, port, accepted
+}
+
+func newMuxDialer(host string, port int) *Dialer {
+	return NewDialer(&config.OutboundConfig{
+		Type:       "socks",
+		Server:     host,
+		ServerPort: port,
+		Mux:        &config.MuxConfig{Enabled: true, Protocol: "smux"},
+	})
+}
+
+// 新建多路复用会话的握手在调用方 ctx 取消时中止
+func TestMuxDialHonorsContext(t *testing.T) {
+	host, port, _ := startSilentServer(t)
+	d := newMuxDialer(host, port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	conn, err := d.DialContext(ctx)
+	if err == nil {
+		conn.Close()
+		t.Fatal("dial to a silent server succeeded")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("dial returned after %s, ctx was not honoured", elapsed)
+	}
+}
+
+// 并发的调用方共享同一次会话拨号；拨号进行中不持有会话池的锁
+func TestMuxDialSingleflight(t *testing.T) {
+	host, port, accepted := startSilentServer(t)
+	d := newMuxDialer(host, port)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			conn, err := d.DialContext(ctx)
+			if conn != nil {
+				conn.Close()
+			}
+			errs <- err
+		}()
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := accepted.Load(); n != 1 {
+		t.Fatalf("concurrent mux dials opened %d connections, want 1", n)
+	}
+
+	// 等待中的调用方可以各自取消，且不影响进行中的拨号
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer waitCancel()
+	start := time.Now()
+	if _, err := d.DialContext(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiting dial err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("waiting dial returned after %s", elapsed)
+	}
+
+	// 会话池的锁未被拨号占用
+	closed := make(chan struct{})
+	go func() {
+		CloseMuxSessions()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("CloseMuxSessions blocked behind an in-flight dial")
+	}
+
+	cancel()
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("dial err = %v, want context.Canceled", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("dial did not return after cancel")
+		}
+	}
+}
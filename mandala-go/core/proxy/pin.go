@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+
+	"mandala/core/config"
+)
+
+// usesCertPinning 是否启用证书公钥固定 (Reality 自带证书校验，不叠加)
+func (d *Dialer) usesCertPinning() bool {
+	return d.Config.TLS != nil && len(d.Config.TLS.PinnedSHA256) > 0 && !d.usesReality()
+}
+
+// pinnedCertVerifier 返回 VerifyPeerCertificate 回调：叶证书 SPKI 的 SHA-256 与任一指纹一致才放行
+// 需配合 InsecureSkipVerify 使用，此时 rawCerts 未经任何校验，只取叶证书计算
+func pinnedCertVerifier(pins []string) (func(rawCerts [][]byte, _ [][]*x509.Certificate) error, error) {
+	hashes := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		h, err := config.ParsePinnedSHA256(pin)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("pinning: no peer certificate")
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		for _, h := range hashes {
+			if bytes.Equal(sum[:], h) {
+				return nil
+			}
+		}
+		return errors.New("pinning: certificate public key does not match any pinned sha256")
+	}, nil
+}
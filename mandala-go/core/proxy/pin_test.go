@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+)
+
+// 自签名证书：指纹匹配时不经 CA 校验即可握手，不匹配时即使 insecure 也拒绝
+func TestCertPinning(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	host, portStr, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	spki := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	other := sha256.Sum256([]byte("another key"))
+
+	dial := func(pins []string, insecure bool) error {
+		d := NewDialer(&config.OutboundConfig{
+			Type: "trojan", Server: host, ServerPort: port, Password: "secret",
+			TLS: &config.TLSConfig{Enabled: true, ServerName: "example.com", Insecure: insecure, PinnedSHA256: pins},
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := d.DialContext(ctx)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	for _, pins := range [][]string{
+		{hex.EncodeToString(spki[:])},
+		{"sha256/" + base64.StdEncoding.EncodeToString(spki[:])},
+		{hex.EncodeToString(other[:]), base64.RawURLEncoding.EncodeToString(spki[:])},
+	} {
+		if err := dial(pins, false); err != nil {
+			t.Errorf("pins %v: %v", pins, err)
+		}
+	}
+	for _, insecure := range []bool{false, true} {
+		if err := dial([]string{hex.EncodeToString(other[:])}, insecure); err == nil {
+			t.Errorf("mismatched pin accepted (insecure=%v)", insecure)
+		}
+	}
+	// 未固定指纹时自签名证书按 CA 校验失败
+	if err := dial(nil, false); err == nil {
+		t.Error("self-signed certificate accepted without a pin")
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"io"
+	"sync"
+
+	"mandala/core/config"
+)
+
+// TCP 转发每个方向占用一个缓冲区，大小与 io.Copy 的默认值相同
+const relayBufferSize = 32 * 1024
+
+// UDP 缓冲区需容纳一个完整数据报。TUN 的 MTU 只限制单个 IP 分片，
+// 网络栈重组后的数据报以及隧道中的数据报都可能超过 MTU，按 MTU 分配会截断数据，
+// 因此与分帧长度的默认上限 (64KB，即 UDP 数据报的最大长度) 保持一致
+const packetBufferSize = config.DefaultMaxPacketSize
+
+var (
+	relayBufferPool = sync.Pool{New: func() interface{} {
+		buf := make([]byte, relayBufferSize)
+		return &buf
+	}}
+	packetBufferPool = sync.Pool{New: func() interface{} {
+		buf := make([]byte, packetBufferSize)
+		return &buf
+	}}
+)
+
+// CopyBuffered 与 io.Copy 相同，但使用池中的缓冲区，避免每条连接分配新的 32KB 缓冲区
+// (一端实现 ReaderFrom / WriterTo 时与 io.Copy 一样直接使用其实现)
+func CopyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	bp := relayBufferPool.Get().(*[]byte)
+	defer relayBufferPool.Put(bp)
+	return io.CopyBuffer(dst, src, *bp)
+}
+
+// GetPacketBuffer 从池中取出长度为 size 的 UDP 缓冲区，用完后需调用 PutPacketBuffer 归还
+// size 超过池中缓冲区大小时 (配置了更大的 max_frame_size) 直接分配
+func GetPacketBuffer(size int) []byte {
+	if size > packetBufferSize {
+		return make([]byte, size)
+	}
+	return (*packetBufferPool.Get().(*[]byte))[:size]
+}
+
+// PutPacketBuffer 归还 GetPacketBuffer 取出的缓冲区，归还后不能再使用
+func PutPacketBuffer(buf []byte) {
+	if cap(buf) != packetBufferSize {
+		return
+	}
+	buf = buf[:packetBufferSize]
+	packetBufferPool.Put(&buf)
+}
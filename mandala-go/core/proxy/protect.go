@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+)
+
+// SocketProtector 将套接字排除在 VPN 之外 (Android: VpnService.protect)，成功时返回 true
+type SocketProtector func(fd int) bool
+
+var socketProtector atomic.Value // SocketProtector
+
+// SetSocketProtector 设置套接字保护回调，传入 nil 时取消
+// 核心自身发起的连接 (节点、直连、ECH 的 DoH 查询) 若未被保护，会被系统路由回 TUN 再次进入核心，形成环路。
+// 未设置时依赖系统层面将本应用排除在 VPN 之外；以允许列表方式配置 VPN 的应用无法排除自身，需由此保护
+func SetSocketProtector(fn SocketProtector) {
+	socketProtector.Store(fn)
+}
+
+// protectControl 作为 net.Dialer / net.ListenConfig 的 Control，在连接建立前保护套接字
+func protectControl(network, address string, c syscall.RawConn) error {
+	protect, _ := socketProtector.Load().(SocketProtector)
+	if protect == nil {
+		return nil
+	}
+	var ok bool
+	if err := c.Control(func(fd uintptr) {
+		ok = protect(int(fd))
+	}); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("protect socket failed")
+	}
+	return nil
+}
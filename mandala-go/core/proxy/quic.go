@@ -0,0 +1,331 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// 单个 QUIC 数据报承载的最大长度：QUIC 最小包长 1200 扣除包头、AEAD 标签与帧头后留有余量
+	// quic-go 只按 MTU 粗略检查长度，放不进数据包的数据报会被静默丢弃，因此超出时主动分片
+	quicMaxDatagramSize = 1140
+	// 每个 UDP 会话排队等待读取的数据报上限，超出时丢弃 (与 UDP 语义一致)
+	quicPacketQueueSize = 64
+	// 每个 UDP 会话同时重组中的分片数据报上限
+	quicMaxReassembly = 32
+)
+
+// dialQUIC 建立到节点的 QUIC 连接 (TUIC / Hysteria2 / WebTransport)
+// SNI 与证书校验沿用 tls 配置；QUIC 握手使用标准库 TLS 1.3，不支持 uTLS 指纹、ECH 与 Reality。
+// 解析出多个地址时依次尝试 (QUIC 无法像 TCP 一样并发竞速而不额外占用端口)
+func (d *Dialer) dialQUIC(ctx context.Context, alpn []string, quicConf *quic.Config) (*quic.Conn, error) {
+	if d.Config.Settings.UpstreamProxy != "" {
+		return nil, fmt.Errorf("upstream_proxy is not supported for QUIC-based nodes")
+	}
+	ips, err := d.resolveServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ips = interleaveFamilies(ips, d.Config.Settings.PreferIPv6)
+
+	serverName := d.Config.Server
+	insecure := false
+	if d.Config.TLS != nil {
+		if d.Config.TLS.ServerName != "" {
+			serverName = d.Config.TLS.ServerName
+		}
+		insecure = d.Config.TLS.Insecure
+	}
+	tlsConf := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+		NextProtos:         alpn,
+		MinVersion:         tls.VersionTLS13,
+	}
+	if tlsConf.RootCAs, err = d.rootCAs(); err != nil {
+		return nil, fmt.Errorf("tls.ca_cert: %w", err)
+	}
+	if d.usesCertPinning() {
+		verify, err := pinnedCertVerifier(d.Config.TLS.PinnedSHA256)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.InsecureSkipVerify = true
+		tlsConf.VerifyPeerCertificate = verify
+	}
+
+	lastErr := errors.New("no server address")
+	for _, ip := range ips {
+		pc, err := (&net.ListenConfig{Control: protectControl}).ListenPacket(ctx, "udp", ":0")
+		if err != nil {
+			return nil, err
+		}
+		udpConn := pc.(*net.UDPConn)
+		conn, err := quic.Dial(ctx, udpConn, &net.UDPAddr{IP: ip, Port: d.Config.ServerPort}, tlsConf, quicConf)
+		if err != nil {
+			udpConn.Close()
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		// quic.Dial 不接管传入的 UDP 套接字，连接结束后自行关闭
+		go func() {
+			<-conn.Context().Done()
+			udpConn.Close()
+		}()
+		return conn, nil
+	}
+	return nil, lastErr
+}
+
+// CloseQUICSessions 关闭所有 TUIC / Hysteria2 连接与 WebTransport 会话 (核心停止时调用)
+func CloseQUICSessions() {
+	closeTUICSessions()
+	closeHysteria2Sessions()
+	closeWebTransportSessions()
+}
+
+// dialQUICStream 按协议在共享 QUIC 连接上打开一个双向流
+func (d *Dialer) dialQUICStream() (net.Conn, error) {
+	if strings.ToLower(d.Config.Type) == "hysteria2" {
+		return d.dialHysteria2Stream()
+	}
+	return d.dialTUICStream()
+}
+
+// dialQUICUDP 按协议在共享 QUIC 连接上新建 UDP 会话
+func (d *Dialer) dialQUICUDP(targetHost string, targetPort int) (net.Conn, error) {
+	if strings.ToLower(d.Config.Type) == "hysteria2" {
+		return d.dialHysteria2UDP(targetHost, targetPort)
+	}
+	return d.dialTUICUDP(targetHost, targetPort)
+}
+
+// dialQUICProbe 按协议为测速建立独立的 QUIC 连接
+func (d *Dialer) dialQUICProbe() (net.Conn, error) {
+	if strings.ToLower(d.Config.Type) == "hysteria2" {
+		return d.dialHysteria2Probe()
+	}
+	return d.dialTUICProbe()
+}
+
+// newQUICConnInfo 根据 QUIC 连接采集握手信息
+func (d *Dialer) newQUICConnInfo(conn *quic.Conn) *ConnInfo {
+	state := conn.ConnectionState().TLS
+	return &ConnInfo{
+		Protocol:    strings.ToLower(d.Config.Type),
+		Server:      d.serverAddr(),
+		RemoteAddr:  conn.RemoteAddr().String(),
+		Transport:   "quic",
+		TLS:         true,
+		TLSVersion:  tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+		ConnectedAt: time.Now().UnixMilli(),
+	}
+}
+
+// quicStreamConn 将 QUIC 双向流包装为 net.Conn
+type quicStreamConn struct {
+	*quic.Stream
+	conn *quic.Conn
+	// 为 true 时关闭流的同时关闭整个 QUIC 连接 (测速等一次性连接)
+	owned bool
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicStreamConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// Close 关闭写方向并放弃读取，使对端尽快释放流
+func (c *quicStreamConn) Close() error {
+	c.Stream.CancelRead(0)
+	err := c.Stream.Close()
+	if c.owned {
+		c.conn.CloseWithError(0, "")
+	}
+	return err
+}
+
+// splitDatagram 将载荷切分为分片：首个分片携带目标地址，可用长度与后续分片不同
+func splitDatagram(data []byte, firstSize, restSize int) ([][]byte, error) {
+	if firstSize <= 0 || restSize <= 0 {
+		return nil, fmt.Errorf("datagram limit too small: %d/%d", firstSize, restSize)
+	}
+	var chunks [][]byte
+	size := firstSize
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+		size = restSize
+	}
+	if len(chunks) > 255 {
+		return nil, fmt.Errorf("udp packet too large: %d fragments", len(chunks))
+	}
+	return chunks, nil
+}
+
+// quicFragments 重组中的分片数据报
+type quicFragments struct {
+	parts    [][]byte
+	received int
+}
+
+// quicPacketConn 经 QUIC 承载的 UDP 会话 (TUIC / Hysteria2 共用)
+// 每次 Write 发送一个数据报 (编码与分片由 send 完成)，每次 Read 返回一个重组后的数据报
+type quicPacketConn struct {
+	conn          *quic.Conn
+	maxPacketSize int
+	send          func(b []byte) error
+	onClose       func()
+
+	packets   chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+
+	fragMu sync.Mutex
+	frags  map[uint16]*quicFragments
+}
+
+func newQUICPacketConn(conn *quic.Conn, maxPacketSize int) *quicPacketConn {
+	return &quicPacketConn{
+		conn:          conn,
+		maxPacketSize: maxPacketSize,
+		packets:       make(chan []byte, quicPacketQueueSize),
+		closed:        make(chan struct{}),
+		frags:         make(map[uint16]*quicFragments),
+	}
+}
+
+func (c *quicPacketConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if err := c.send(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// deliver 接收服务端数据报，分片全部到达后重组交付；队列已满或超出长度上限时丢弃
+func (c *quicPacketConn) deliver(pktID uint16, fragID, fragCount uint8, payload []byte) {
+	data := append([]byte(nil), payload...)
+
+	if fragCount > 1 {
+		if fragID >= fragCount {
+			return
+		}
+		c.fragMu.Lock()
+		f, ok := c.frags[pktID]
+		if !ok {
+			if len(c.frags) >= quicMaxReassembly {
+				// 丢包导致无法完成的重组不会自行清除，超出上限时整体丢弃
+				c.frags = make(map[uint16]*quicFragments)
+			}
+			f = &quicFragments{parts: make([][]byte, fragCount)}
+			c.frags[pktID] = f
+		}
+		if len(f.parts) != int(fragCount) || f.parts[fragID] != nil {
+			c.fragMu.Unlock()
+			return
+		}
+		f.parts[fragID] = data
+		f.received++
+		if f.received < len(f.parts) {
+			c.fragMu.Unlock()
+			return
+		}
+		delete(c.frags, pktID)
+		c.fragMu.Unlock()
+
+		data = nil
+		for _, part := range f.parts {
+			data = append(data, part...)
+		}
+	}
+
+	if len(data) > c.maxPacketSize {
+		return
+	}
+	select {
+	case c.packets <- data:
+	default:
+	}
+}
+
+func (c *quicPacketConn) Read(b []byte) (int, error) {
+	c.deadlineMu.Lock()
+	deadline := c.readDeadline
+	c.deadlineMu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case data := <-c.packets:
+		return copy(b, data), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-c.conn.Context().Done():
+		return 0, net.ErrClosed
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// Close 释放 UDP 会话，共享的 QUIC 连接保持不变
+func (c *quicPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *quicPacketConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicPacketConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+func (c *quicPacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *quicPacketConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline 数据报发送不会阻塞，忽略
+func (c *quicPacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/crypto/hkdf"
+)
+
+// realityClientVersion 写入 SessionId 的客户端版本 (服务端可据此限制最低/最高版本)
+var realityClientVersion = [3]byte{1, 8, 0}
+
+// usesReality 是否启用 Reality
+func (d *Dialer) usesReality() bool {
+	return d.Config.TLS != nil && d.Config.TLS.Reality != nil && d.Config.TLS.Reality.PublicKey != ""
+}
+
+// realityState 单次握手中派生的认证密钥，供证书校验回调使用
+type realityState struct {
+	authKey []byte
+}
+
+// parseRealityPublicKey 解析服务端 x25519 公钥 (base64 RawURL，兼容带填充的标准编码)
+func parseRealityPublicKey(s string) (*ecdh.PublicKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		if raw, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, fmt.Errorf("reality: invalid public key: %v", err)
+		}
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("reality: invalid public key: %v", err)
+	}
+	return key, nil
+}
+
+// parseRealityShortID 解析 shortId (最多 16 个十六进制字符)，不足 8 字节时右侧补零
+func parseRealityShortID(s string) ([8]byte, error) {
+	var id [8]byte
+	if len(s) > 16 {
+		return id, fmt.Errorf("reality: short id too long: %q", s)
+	}
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return id, fmt.Errorf("reality: invalid short id: %q", s)
+	}
+	copy(id[:], raw)
+	return id, nil
+}
+
+// realityAuthKey 由临时 x25519 私钥与服务端公钥派生认证密钥
+// authKey = HKDF-SHA256(ECDH(priv, pub), salt = Random[:20], info = "REALITY")
+func realityAuthKey(priv *ecdh.PrivateKey, pub *ecdh.PublicKey, random []byte) ([]byte, error) {
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	authKey := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, random[:20], []byte("REALITY")), authKey); err != nil {
+		return nil, err
+	}
+	return authKey, nil
+}
+
+// realitySessionID 构造 SessionId 明文的前 16 字节: Version(3) + 保留(1) + Unix 时间(4) + ShortId(8)
+func realitySessionID(shortID [8]byte, now time.Time) []byte {
+	sid := make([]byte, 32)
+	copy(sid, realityClientVersion[:])
+	binary.BigEndian.PutUint32(sid[4:], uint32(now.Unix()))
+	copy(sid[8:], shortID[:])
+	return sid
+}
+
+// sealRealityHello 在 ClientHello 的 SessionId 中写入认证数据
+// 以 authKey 为密钥、Random[20:] 为 nonce、整个 ClientHello (SessionId 置零) 为附加数据，
+// 对 SessionId 前 16 字节做 AES-GCM 加密，密文与标签共 32 字节写回 SessionId
+func (d *Dialer) sealRealityHello(uConn *utls.UConn, state *realityState) error {
+	cfg := d.Config.TLS.Reality
+	pub, err := parseRealityPublicKey(cfg.PublicKey)
+	if err != nil {
+		return err
+	}
+	shortID, err := parseRealityShortID(cfg.ShortID)
+	if err != nil {
+		return err
+	}
+
+	if err := uConn.BuildHandshakeState(); err != nil {
+		return err
+	}
+	hs := uConn.HandshakeState
+	if hs.State13.KeyShareKeys == nil || hs.State13.KeyShareKeys.Ecdhe == nil ||
+		hs.State13.KeyShareKeys.Ecdhe.Curve() != ecdh.X25519() {
+		return errors.New("reality: fingerprint does not offer an x25519 key share")
+	}
+	hello := hs.Hello
+	if len(hello.Raw) < 39+32 {
+		return errors.New("reality: unexpected client hello layout")
+	}
+
+	state.authKey, err = realityAuthKey(hs.State13.KeyShareKeys.Ecdhe, pub, hello.Random)
+	if err != nil {
+		return err
+	}
+
+	// SessionId 位于 Raw[39:71]: 握手头(4) + 版本(2) + Random(32) + 长度(1)
+	hello.SessionId = realitySessionID(shortID, time.Now())
+	copy(hello.Raw[39:], make([]byte, 32))
+
+	block, err := aes.NewCipher(state.authKey)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	aead.Seal(hello.SessionId[:0], hello.Random[20:], hello.SessionId[:16], hello.Raw)
+	copy(hello.Raw[39:], hello.SessionId)
+	return nil
+}
+
+// verifyRealityCert 校验服务端返回的临时证书：ed25519 公钥经 authKey 做 HMAC-SHA512 后应等于证书签名
+// 不匹配说明连接被转发到了真实的目标网站 (认证失败或遭中间人)，拒绝继续
+func (s *realityState) verifyRealityCert(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 || s.authKey == nil {
+		return errors.New("reality: missing server certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("reality: invalid server certificate: %v", err)
+	}
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("reality: server certificate verification failed")
+	}
+	mac := hmac.New(sha512.New, s.authKey)
+	mac.Write(pub)
+	if !bytes.Equal(mac.Sum(nil), cert.Signature) {
+		return errors.New("reality: server certificate verification failed")
+	}
+	return nil
+}
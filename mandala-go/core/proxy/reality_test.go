@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// 密钥对与共享密钥取自 RFC 7748 第 6.1 节，authKey 期望值由独立实现 (Python hmac，按 RFC 5869 展开) 计算
+func TestRealityAuthKey(t *testing.T) {
+	alice, err := ecdh.X25519().NewPrivateKey(mustHex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := ecdh.X25519().NewPrivateKey(mustHex(t, "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(alice.PublicKey().Bytes(), mustHex(t, "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")) ||
+		!bytes.Equal(bob.PublicKey().Bytes(), mustHex(t, "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f")) {
+		t.Fatal("RFC 7748 public keys mismatch")
+	}
+
+	// 服务端公钥以 base64 RawURL 下发
+	serverPub, err := parseRealityPublicKey(base64.RawURLEncoding.EncodeToString(bob.PublicKey().Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 32)
+	for i := range random {
+		random[i] = byte(i)
+	}
+	want := mustHex(t, "68e5a4d6fbfc0f93477d737fbdd45bd5f81578fbd172327b6db8e963e2ba4a3c")
+	got, err := realityAuthKey(alice, serverPub, random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("authKey = %x, want %x", got, want)
+	}
+
+	// 服务端以自己的私钥与客户端临时公钥派生出相同的密钥
+	if got, _ := realityAuthKey(bob, alice.PublicKey(), random); !bytes.Equal(got, want) {
+		t.Fatalf("server-side authKey = %x, want %x", got, want)
+	}
+}
+
+func TestParseRealityShortID(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", "0000000000000000", false},
+		{"ab", "ab00000000000000", false},
+		{"0123456789abcdef", "0123456789abcdef", false},
+		{"0123456789abcdef0", "", true},
+		{"abc", "", true},
+		{"zz", "", true},
+	} {
+		id, err := parseRealityShortID(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseRealityShortID(%q) succeeded", tc.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRealityShortID(%q): %v", tc.in, err)
+			continue
+		}
+		if got := hex.EncodeToString(id[:]); got != tc.want {
+			t.Errorf("parseRealityShortID(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestRealitySessionID(t *testing.T) {
+	id, _ := parseRealityShortID("0123456789abcdef")
+	sid := realitySessionID(id, time.Unix(0x01020304, 0))
+	want := mustHex(t, "01080000010203040123456789abcdef")
+	if len(sid) != 32 || !bytes.Equal(sid[:16], want) || !bytes.Equal(sid[16:], make([]byte, 16)) {
+		t.Fatalf("session id = %x", sid)
+	}
+}
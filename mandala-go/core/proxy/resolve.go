@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"mandala/core/logger"
+)
+
+// Happy Eyeballs (RFC 8305) 中相邻两次连接尝试的间隔
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// resolvedServer 节点域名的解析缓存
+type resolvedServer struct {
+	ips      []net.IP
+	updated  time.Time
+	lastUsed time.Time
+}
+
+// resolveKey 解析缓存的键：同一域名使用不同解析服务或刷新间隔的节点各自维护缓存与刷新协程
+type resolveKey struct {
+	host      string
+	dohURL    string
+	bootstrap string
+	interval  time.Duration
+}
+
+var (
+	resolveCache   = make(map[resolveKey]*resolvedServer)
+	resolveCacheMu sync.Mutex
+)
+
+// resolveServer 解析节点域名，返回全部候选 IP (IPv4 在前)
+// 配置了 ResolveIntervalSec 时使用后台定期刷新的缓存结果；未配置时每次拨号经系统 DNS 解析
+func (d *Dialer) resolveServer(ctx context.Context) ([]net.IP, error) {
+	host := d.Config.Server
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	interval := time.Duration(d.Config.Settings.ResolveIntervalSec) * time.Second
+	if interval <= 0 {
+		return lookupServerIPs(ctx, host)
+	}
+
+	key := resolveKey{host: host, interval: interval}
+	if tls := d.Config.TLS; tls != nil {
+		key.dohURL, key.bootstrap = tls.ECHDoHURL, tls.ECHDoHBootstrapIP
+	}
+
+	resolveCacheMu.Lock()
+	entry, ok := resolveCache[key]
+	if ok {
+		entry.lastUsed = time.Now()
+		// 刷新协程异常退出时缓存会过期，此时回退到同步解析
+		if time.Since(entry.updated) < 2*interval {
+			ips := entry.ips
+			resolveCacheMu.Unlock()
+			return ips, nil
+		}
+	}
+	resolveCacheMu.Unlock()
+
+	ips, err := d.lookupServer(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	resolveCacheMu.Lock()
+	if _, exists := resolveCache[key]; !exists {
+		go refreshServerLoop(key, d.lookupServer)
+	}
+	resolveCache[key] = &resolvedServer{ips: ips, updated: time.Now(), lastUsed: time.Now()}
+	resolveCacheMu.Unlock()
+
+	return ips, nil
+}
+
+// refreshServerLoop 按 key.interval 以 lookup 重新解析节点域名；长时间未被使用时自动退出
+func refreshServerLoop(key resolveKey, lookup func(context.Context, string) ([]net.IP, error)) {
+	host, interval := key.host, key.interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	idleLimit := 10 * interval
+	if idleLimit < 10*time.Minute {
+		idleLimit = 10 * time.Minute
+	}
+
+	for range ticker.C {
+		resolveCacheMu.Lock()
+		entry, ok := resolveCache[key]
+		if !ok || time.Since(entry.lastUsed) > idleLimit {
+			delete(resolveCache, key)
+			resolveCacheMu.Unlock()
+			return
+		}
+		old := entry.ips
+		resolveCacheMu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ips, err := lookup(ctx, host)
+		cancel()
+		if err != nil {
+			logger.Warnf("Resolve", "重新解析 %s 失败，继续使用旧地址: %v", host, err)
+			continue
+		}
+
+		if !sameIPs(old, ips) {
+			logger.Infof("Resolve", "%s 地址已变化 %v -> %v，新连接将迁移到新地址", host, old, ips)
+		}
+
+		resolveCacheMu.Lock()
+		if entry, ok := resolveCache[key]; ok {
+			entry.ips = ips
+			entry.updated = time.Now()
+		}
+		resolveCacheMu.Unlock()
+	}
+}
+
+// lookupServer 定期重新解析节点域名时使用：配置了 DoH (tls.ech_doh_url) 时经受保护的 DoH 客户端查询，
+// 不经系统 DNS (可能被阻断、污染，或在 VPN 建立后回流到 TUN)；未配置时回退到系统解析
+func (d *Dialer) lookupServer(ctx context.Context, host string) ([]net.IP, error) {
+	if tls := d.Config.TLS; tls != nil && tls.ECHDoHURL != "" {
+		return lookupDoH(ctx, tls.ECHDoHURL, tls.ECHDoHBootstrapIP, host)
+	}
+	return lookupServerIPs(ctx, host)
+}
+
+// lookupServerIPs 经系统 DNS 执行一次解析，IPv4 地址排在前面
+func lookupServerIPs(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var v4, v6 []net.IP
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			v4 = append(v4, a.IP)
+		} else {
+			v6 = append(v6, a.IP)
+		}
+	}
+	ips := append(v4, v6...)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address found for %s", host)
+	}
+	return ips, nil
+}
+
+func sameIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialServer 以 Happy Eyeballs (RFC 8305) 方式拨号：按地址族交替排列候选 IP，
+// 每隔 250ms (或上一个尝试失败时立即) 发起下一个连接，返回最先成功的连接并取消其余尝试。
+// 部分 IP 被阻断 (常见于 CDN) 时只会带来很短的延迟，而不是等待完整超时。
+// [新增] 配置了 upstream_proxy 时改为经上游代理建立隧道，不在本地解析节点地址
+// [修改] 本次连接超时而调用方 ctx 仍有效时返回 connectTimeoutError，按连接层错误重试
+func (d *Dialer) dialServer(parent context.Context, timeout time.Duration) (conn net.Conn, err error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	defer func() {
+		if err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+			err = &connectTimeoutError{err: err}
+		}
+	}()
+
+	if d.Config.Settings.UpstreamProxy != "" {
+		return d.dialUpstream(ctx)
+	}
+
+	ips, err := d.resolveServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	port := strconv.Itoa(d.Config.ServerPort)
+	ips = interleaveFamilies(ips, d.Config.Settings.PreferIPv6)
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return raceDial(ctx, addrs, d.Config.KeepAliveInterval())
+}
+
+// interleaveFamilies 按首选地址族开始，IPv6/IPv4 交替排列
+func interleaveFamilies(ips []net.IP, preferIPv6 bool) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	first, second := v4, v6
+	if preferIPv6 {
+		first, second = v6, v4
+	}
+
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// raceDial 错开发起对多个地址的连接，返回第一个成功的连接
+// keepAlive 为 TCP keepalive 探测间隔，0 表示关闭
+func raceDial(ctx context.Context, addrs []string, keepAlive time.Duration) (net.Conn, error) {
+	type dialResult struct {
+		conn net.Conn
+		addr string
+		err  error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// 缓冲区足够容纳全部结果，提前返回后剩余的协程不会阻塞
+	results := make(chan dialResult, len(addrs))
+	// net.Dialer 中 KeepAlive 为 0 表示使用系统默认值，负数才表示关闭
+	dialer := net.Dialer{KeepAlive: -1, Control: protectControl}
+	if keepAlive > 0 {
+		dialer.KeepAlive = keepAlive
+	}
+	next, pending := 0, 0
+
+	startNext := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			results <- dialResult{conn: conn, addr: addr, err: err}
+		}()
+	}
+
+	// 关闭返回后才完成的连接
+	drain := func(n int) {
+		go func() {
+			for i := 0; i < n; i++ {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}()
+	}
+
+	timer := time.NewTimer(connectionAttemptDelay)
+	defer timer.Stop()
+	startNext()
+
+	var lastErr error
+	for pending > 0 {
+		var delayC <-chan time.Time
+		if next < len(addrs) {
+			delayC = timer.C
+		}
+
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				drain(pending)
+				return r.conn, nil
+			}
+			lastErr = r.err
+			if next < len(addrs) {
+				logger.Debugf("Dial", "%s 连接失败，尝试下一个地址: %v", r.addr, r.err)
+				startNext()
+				resetTimer(timer, connectionAttemptDelay)
+			}
+
+		case <-delayC:
+			startNext()
+			timer.Reset(connectionAttemptDelay)
+
+		case <-ctx.Done():
+			drain(pending)
+			if lastErr == nil {
+				lastErr = ctx.Err()
+			}
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// resetTimer 安全地重置可能已触发的定时器
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+
+	"github.com/miekg/dns"
+)
+
+// newDoHServer 启动只应答 node.example 的 A / AAAA 查询的 DoH 服务，queries 记录收到的查询数
+func newDoHServer(t *testing.T) (srv *httptest.Server, queries *atomic.Int32) {
+	t.Helper()
+	queries = new(atomic.Int32)
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		data, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		req := new(dns.Msg)
+		if err != nil || req.Unpack(data) != nil || len(req.Question) != 1 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		q := req.Question[0]
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 60}
+		switch {
+		case q.Name != "node.example.":
+			resp.Rcode = dns.RcodeNameError
+		case q.Qtype == dns.TypeA:
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("192.0.2.1")})
+		case q.Qtype == dns.TypeAAAA:
+			resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP("2001:db8::1")})
+		}
+		out, _ := resp.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(out)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, queries
+}
+
+// trustDoHServer 使 DoH 客户端信任 srv 的自签名证书 (证书签发给 example.com)，测试结束时恢复
+func trustDoHServer(t *testing.T, srv *httptest.Server) {
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	setDoHRootCAs(t, pool)
+}
+
+func setDoHRootCAs(t *testing.T, pool *x509.CertPool) {
+	resetDoHClients := func() {
+		dohClientsMu.Lock()
+		dohClients = make(map[string]*http.Client)
+		dohClientsMu.Unlock()
+	}
+	old := dohRootCAs
+	dohRootCAs = pool
+	resetDoHClients()
+	t.Cleanup(func() {
+		dohRootCAs = old
+		resetDoHClients()
+	})
+}
+
+// dohURL 返回经引导 IP 访问 srv 时使用的 DoH 地址 (域名不经 DNS 解析)
+func dohURL(srv *httptest.Server, host string) string {
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "https://"))
+	return "https://" + host + ":" + port + "/dns-query"
+}
+
+// 配置了 DoH 时节点域名经 DoH 解析，DoH 服务器本身经引导 IP 连接而不查询系统 DNS
+func TestLookupServerUsesDoH(t *testing.T) {
+	srv, _ := newDoHServer(t)
+	trustDoHServer(t, srv)
+
+	d := NewDialer(&config.OutboundConfig{TLS: &config.TLSConfig{
+		ECHDoHURL:         dohURL(srv, "example.com"),
+		ECHDoHBootstrapIP: "127.0.0.1",
+	}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips, err := d.lookupServer(ctx, "node.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}; !sameIPs(ips, want) {
+		t.Fatalf("ips = %v, want %v", ips, want)
+	}
+
+	if _, err := d.lookupServer(ctx, "missing.example"); err == nil {
+		t.Fatal("NXDOMAIN lookup succeeded")
+	}
+}
+
+// 经引导 IP 连接时 DoH 服务器证书仍按 URL 中的域名校验
+func TestLookupServerVerifiesDoHCertificate(t *testing.T) {
+	srv, queries := newDoHServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 不受信任的证书
+	d := NewDialer(&config.OutboundConfig{TLS: &config.TLSConfig{
+		ECHDoHURL:         dohURL(srv, "example.com"),
+		ECHDoHBootstrapIP: "127.0.0.1",
+	}})
+	if _, err := d.lookupServer(ctx, "node.example"); err == nil {
+		t.Fatal("lookup through an untrusted DoH server succeeded")
+	}
+
+	// 受信任的证书但域名不符
+	trustDoHServer(t, srv)
+	d.Config.TLS.ECHDoHURL = dohURL(srv, "doh.invalid")
+	if _, err := d.lookupServer(ctx, "node.example"); err == nil {
+		t.Fatal("lookup through a DoH server with a mismatched certificate succeeded")
+	}
+	if n := queries.Load(); n != 0 {
+		t.Fatalf("DoH server answered %d queries over unverified TLS", n)
+	}
+}
+
+// 未启用定期重新解析时每次拨号经系统 DNS 解析，不发起 DoH 查询；
+// 启用后经 DoH 解析，且不同刷新间隔的节点各自缓存
+func TestResolveServerDoHOnlyWithInterval(t *testing.T) {
+	srv, queries := newDoHServer(t)
+	trustDoHServer(t, srv)
+	tlsConf := &config.TLSConfig{ECHDoHURL: dohURL(srv, "example.com"), ECHDoHBootstrapIP: "127.0.0.1"}
+	t.Cleanup(func() {
+		resolveCacheMu.Lock()
+		for k := range resolveCache {
+			if k.host == "node.example" {
+				delete(resolveCache, k)
+			}
+		}
+		resolveCacheMu.Unlock()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	d := NewDialer(&config.OutboundConfig{Server: "localhost", TLS: tlsConf})
+	if _, err := d.resolveServer(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := queries.Load(); n != 0 {
+		t.Fatalf("resolve without interval sent %d DoH queries", n)
+	}
+
+	for _, interval := range []int{3600, 7200} {
+		d := NewDialer(&config.OutboundConfig{Server: "node.example", TLS: tlsConf})
+		d.Config.Settings.ResolveIntervalSec = interval
+		ips, err := d.resolveServer(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) == 0 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+			t.Fatalf("interval %d: ips = %v", interval, ips)
+		}
+	}
+	// 每个间隔各自解析一次 (A 与 AAAA)
+	if n := queries.Load(); n != 4 {
+		t.Fatalf("DoH queries = %d, want 4", n)
+	}
+}
+
+// 未配置 DoH 时回退到系统解析
+func TestLookupServerSystemFallback(t *testing.T) {
+	d := NewDialer(&config.OutboundConfig{})
+	ips, err := d.lookupServer(context.Background(), "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			t.Fatalf("localhost resolved to %v", ips)
+		}
+	}
+}
+
+// listenLocal 在 127.0.0.1 上监听，测试结束时关闭
+func listenLocal(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// Happy Eyeballs：首个地址无响应 (黑洞) 时间隔 connectionAttemptDelay 后尝试下一个；首个地址被拒绝时立即尝试
+func TestRaceDial(t *testing.T) {
+	blackholed, working := listenLocal(t), listenLocal(t)
+	// 首次尝试在建连前卡住，模拟丢弃 SYN 的地址
+	countDialAttempts(t, func(n int32) {
+		if n == 1 {
+			time.Sleep(time.Second)
+		}
+	})
+	start := time.Now()
+	conn, err := raceDial(context.Background(), []string{blackholed.Addr().String(), working.Addr().String()}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	elapsed := time.Since(start)
+	if conn.RemoteAddr().String() != working.Addr().String() {
+		t.Fatalf("connected to %s, want %s", conn.RemoteAddr(), working.Addr())
+	}
+	if elapsed < connectionAttemptDelay || elapsed > connectionAttemptDelay+500*time.Millisecond {
+		t.Fatalf("dial took %s, want about %s", elapsed, connectionAttemptDelay)
+	}
+
+	SetSocketProtector(nil)
+	start = time.Now()
+	conn, err = raceDial(context.Background(), []string{"127.0.0.1:" + strconv.Itoa(freePort(t)), working.Addr().String()}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed >= connectionAttemptDelay {
+		t.Fatalf("dial after a refused address took %s", elapsed)
+	}
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// 拨号重试的最长等待时间
+const maxDialRetryDelay = 5 * time.Second
+
+// connectTimeoutError 单次连接节点超过 dial_timeout (调用方 ctx 仍有效)
+// 超时可能表现为 net.OpError，也可能是 Happy Eyeballs 拨号返回的 context.DeadlineExceeded，统一包装以便重试
+type connectTimeoutError struct {
+	err error
+}
+
+func (e *connectTimeoutError) Error() string { return "connect timeout: " + e.err.Error() }
+func (e *connectTimeoutError) Unwrap() error { return e.err }
+func (e *connectTimeoutError) Timeout() bool { return true }
+
+// isRetryableDialError 是否为连接层错误 (TCP 建连被拒绝、重置或超时)
+// 握手、认证与协议错误说明节点可达但配置或服务端有问题，重试无益
+func isRetryableDialError(err error) bool {
+	var opErr *net.OpError
+	var timeoutErr *connectTimeoutError
+	return errors.As(err, &opErr) && opErr.Op == "dial" || errors.As(err, &timeoutErr)
+}
+
+// dialRetryDelay 返回第 attempt 次 (从 0 开始) 重试前的等待时间：base 逐次翻倍，不超过上限
+func dialRetryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 0; i < attempt && delay < maxDialRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDialRetryDelay {
+		delay = maxDialRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+)
+
+// countDialAttempts 经套接字保护回调统计节点连接尝试，每次尝试在建连前调用 before(第几次)
+func countDialAttempts(t *testing.T, before func(n int32)) *atomic.Int32 {
+	t.Helper()
+	attempts := new(atomic.Int32)
+	SetSocketProtector(func(fd int) bool {
+		before(attempts.Add(1))
+		return true
+	})
+	t.Cleanup(func() { SetSocketProtector(nil) })
+	return attempts
+}
+
+// freePort 返回一个当前无人监听的本地端口
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+func newRetryDialer(port, retries int) *Dialer {
+	d := NewDialer(&config.OutboundConfig{Type: "socks", Server: "127.0.0.1", ServerPort: port})
+	d.Config.Settings.DialRetries = retries
+	d.Config.Settings.DialRetryBackoffMs = 10
+	return d
+}
+
+// 节点前 N 次拒绝连接，之后恢复：重试次数足够时拨号成功，不足时返回连接错误
+func TestDialRetriesRefusedConnections(t *testing.T) {
+	const failures = 2
+	port := freePort(t)
+
+	var once sync.Once
+	attempts := countDialAttempts(t, func(n int32) {
+		// 第 N+1 次尝试建连前开始监听
+		if n == failures+1 {
+			once.Do(func() {
+				l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				t.Cleanup(func() { l.Close() })
+			})
+		}
+	})
+
+	if _, err := newRetryDialer(port, failures-1).DialContext(context.Background()); err == nil {
+		t.Fatal("dial succeeded with too few retries")
+	}
+	if n := attempts.Load(); n != failures {
+		t.Fatalf("attempts = %d, want %d", n, failures)
+	}
+
+	attempts.Store(0)
+	conn, err := newRetryDialer(port, failures+1).DialContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := attempts.Load(); n != failures+1 {
+		t.Fatalf("attempts = %d, want %d", n, failures+1)
+	}
+}
+
+// 单次连接超过 dial_timeout 时 (Happy Eyeballs 返回 context.DeadlineExceeded) 同样重试
+func TestDialRetriesConnectTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// 首次尝试在建连前卡住，超过单次超时
+	attempts := countDialAttempts(t, func(n int32) {
+		if n == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+	})
+	d := newRetryDialer(l.Addr().(*net.TCPAddr).Port, 1)
+	d.Config.Settings.DialTimeout = 100
+
+	conn, err := d.DialContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := attempts.Load(); n != 2 {
+		t.Fatalf("attempts = %d, want 2", n)
+	}
+}
+
+// 调用方 ctx 到期后不再重试
+func TestDialRetriesStopWithContext(t *testing.T) {
+	attempts := countDialAttempts(t, func(int32) {})
+	d := newRetryDialer(freePort(t), 100)
+	d.Config.Settings.DialRetryBackoffMs = 50
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := d.DialContext(ctx); err == nil {
+		t.Fatal("dial to a closed port succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dial returned after %s", elapsed)
+	}
+	if n := attempts.Load(); n < 2 || n > 4 {
+		t.Fatalf("attempts = %d, want 2-4 within the deadline", n)
+	}
+}
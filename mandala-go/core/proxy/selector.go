@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"mandala/core/config"
+	"mandala/core/logger"
+)
+
+// NodeStatus 节点的健康状态，供 UI 展示
+type NodeStatus struct {
+	Index      int    `json:"index"` // 在候选列表中的位置 (0 为顶层节点)
+	Tag        string `json:"tag"`
+	Type       string `json:"type"`
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+	Up         bool   `json:"up"`
+	LatencyMs  int64  `json:"latency_ms"` // 最近一次测速结果，尚未测速或失败时为 -1
+	CheckedAt  int64  `json:"checked_at"` // 最近一次测速的 Unix 毫秒，尚未测速时为 0
+}
+
+type nodeState struct {
+	cfg       *config.OutboundConfig
+	up        bool
+	latency   time.Duration // 0 表示未知
+	fails     int           // 连续拨号失败次数
+	checkedAt time.Time
+}
+
+// Selector 在多个候选节点中选择当前使用的节点
+// 后台定期测速：优先使用可用且延迟最低的节点；当前节点拨号连续失败时立即切换到其他可用节点。
+// 全部节点不可用时保持当前节点不变，继续尝试
+type Selector struct {
+	mu     sync.Mutex
+	nodes  []*nodeState
+	active int
+
+	interval    time.Duration // <= 0 表示不定期检查
+	timeout     time.Duration
+	maxFailures int
+	tolerance   time.Duration
+
+	// probe 测速函数，默认为 TestLatency
+	probe func(cfg *config.OutboundConfig, timeout time.Duration) (time.Duration, error)
+	// recheck 当前节点被标记为不可用时通知后台尽快重新检查
+	recheck chan struct{}
+}
+
+// NewSelector 根据候选节点创建选择器；少于两个节点时返回 nil (无需选择)
+func NewSelector(nodes []*config.OutboundConfig, hc *config.HealthCheckConfig) *Selector {
+	if len(nodes) < 2 {
+		return nil
+	}
+	return NewHealthChecker(nodes, hc)
+}
+
+// NewHealthChecker 与 NewSelector 相同，但单个节点时同样返回选择器，仅用于跟踪其健康状态 (如断网保护)
+// 没有节点时返回 nil
+func NewHealthChecker(nodes []*config.OutboundConfig, hc *config.HealthCheckConfig) *Selector {
+	if len(nodes) == 0 {
+		return nil
+	}
+	s := &Selector{
+		interval:    config.DefaultHealthCheckInterval,
+		timeout:     config.DefaultHealthCheckTimeout,
+		maxFailures: config.DefaultHealthCheckFailures,
+		tolerance:   config.DefaultHealthCheckTolerance,
+		probe:       TestLatency,
+		recheck:     make(chan struct{}, 1),
+	}
+	if hc != nil {
+		if hc.IntervalSec != 0 {
+			s.interval = time.Duration(hc.IntervalSec) * time.Second
+		}
+		if hc.TimeoutMs > 0 {
+			s.timeout = time.Duration(hc.TimeoutMs) * time.Millisecond
+		}
+		if hc.MaxFailures > 0 {
+			s.maxFailures = hc.MaxFailures
+		}
+		if hc.ToleranceMs > 0 {
+			s.tolerance = time.Duration(hc.ToleranceMs) * time.Millisecond
+		}
+	}
+	for _, cfg := range nodes {
+		// 启动时乐观地认为全部可用，首轮检查前使用第一个节点
+		s.nodes = append(s.nodes, &nodeState{cfg: cfg, up: true})
+	}
+	return s
+}
+
+// Start 在后台执行健康检查，ctx 取消时退出
+func (s *Selector) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+func (s *Selector) run(ctx context.Context) {
+	s.CheckNow()
+
+	var tick <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-s.recheck:
+		}
+		s.CheckNow()
+	}
+}
+
+// CheckNow 并发测速全部节点，更新状态后重新选择
+func (s *Selector) CheckNow() {
+	type result struct {
+		latency time.Duration
+		err     error
+	}
+	results := make([]result, len(s.nodes))
+	var wg sync.WaitGroup
+	for i, n := range s.nodes {
+		wg.Add(1)
+		go func(i int, cfg *config.OutboundConfig) {
+			defer wg.Done()
+			latency, err := s.probe(cfg, s.timeout)
+			results[i] = result{latency, err}
+		}(i, n.cfg)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for i, n := range s.nodes {
+		n.checkedAt = now
+		if results[i].err != nil {
+			if n.up {
+				logger.Warnf("Selector", "节点 %s 不可用: %v", nodeName(n.cfg), results[i].err)
+			}
+			n.up, n.latency = false, 0
+			continue
+		}
+		if !n.up {
+			logger.Infof("Selector", "节点 %s 已恢复 (%dms)", nodeName(n.cfg), results[i].latency.Milliseconds())
+		}
+		n.up, n.latency, n.fails = true, results[i].latency, 0
+	}
+	s.reselect()
+}
+
+// reselect 选择可用且延迟最低的节点；新节点需比当前节点快出容差才切换。调用方需持有锁
+func (s *Selector) reselect() {
+	best := -1
+	for i, n := range s.nodes {
+		if !n.up {
+			continue
+		}
+		if best < 0 || (n.latency > 0 && (s.nodes[best].latency == 0 || n.latency < s.nodes[best].latency)) {
+			best = i
+		}
+	}
+	if best < 0 || best == s.active {
+		return
+	}
+	cur := s.nodes[s.active]
+	if cur.up && (cur.latency == 0 || cur.latency <= s.nodes[best].latency+s.tolerance) {
+		return
+	}
+	logger.Infof("Selector", "切换节点: %s -> %s", nodeName(cur.cfg), nodeName(s.nodes[best].cfg))
+	s.active = best
+}
+
+// Current 返回新连接应使用的节点
+func (s *Selector) Current() *config.OutboundConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nodes[s.active].cfg
+}
+
+// ReportDial 记录一次经节点拨号的结果；连续失败达到上限时将节点标记为不可用并切换
+// nil 选择器忽略调用；调用方取消的拨号与节点无关，不计入结果
+func (s *Selector) ReportDial(cfg *config.OutboundConfig, err error) {
+	if s == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, n := range s.nodes {
+		if n.cfg != cfg {
+			continue
+		}
+		if err == nil {
+			n.fails = 0
+			return
+		}
+		n.fails++
+		if n.fails < s.maxFailures || !n.up {
+			return
+		}
+		logger.Warnf("Selector", "节点 %s 连续 %d 次拨号失败，标记为不可用: %v", nodeName(cfg), n.fails, err)
+		n.up = false
+		if i == s.active {
+			s.reselect()
+			select {
+			case s.recheck <- struct{}{}:
+			default:
+			}
+		}
+		return
+	}
+}
+
+// Healthy 当前节点是否可用；nil 选择器 (未做健康检查) 视为可用
+func (s *Selector) Healthy() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nodes[s.active].up
+}
+
+// Active 返回当前节点的状态
+func (s *Selector) Active() NodeStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status(s.active)
+}
+
+func (s *Selector) status(i int) NodeStatus {
+	n := s.nodes[i]
+	st := NodeStatus{
+		Index:      i,
+		Tag:        n.cfg.Tag,
+		Type:       n.cfg.Type,
+		Server:     n.cfg.Server,
+		ServerPort: n.cfg.ServerPort,
+		Up:         n.up,
+		LatencyMs:  -1,
+	}
+	if n.latency > 0 {
+		st.LatencyMs = n.latency.Milliseconds()
+	}
+	if !n.checkedAt.IsZero() {
+		st.CheckedAt = n.checkedAt.UnixMilli()
+	}
+	return st
+}
+
+// nodeName 日志中的节点名称：优先使用 tag
+func nodeName(cfg *config.OutboundConfig) string {
+	if cfg.Tag != "" {
+		return cfg.Tag
+	}
+	return cfg.Server
+}
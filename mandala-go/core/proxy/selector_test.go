@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+)
+
+// 单个节点同样跟踪健康状态 (断网保护)：连续拨号失败达到上限后不可用，测速成功后恢复
+func TestHealthCheckerSingleNode(t *testing.T) {
+	node := &config.OutboundConfig{Type: "trojan", Server: "node.example", ServerPort: 443}
+	if NewSelector([]*config.OutboundConfig{node}, nil) != nil {
+		t.Fatal("NewSelector returned a selector for a single node")
+	}
+	if NewHealthChecker(nil, nil) != nil {
+		t.Fatal("NewHealthChecker returned a selector without nodes")
+	}
+	var none *Selector
+	if !none.Healthy() {
+		t.Fatal("nil selector reported unhealthy")
+	}
+
+	s := NewHealthChecker([]*config.OutboundConfig{node}, &config.HealthCheckConfig{MaxFailures: 2})
+	if s == nil || !s.Healthy() {
+		t.Fatal("single node not tracked or not initially healthy")
+	}
+	dialErr := errors.New("connection refused")
+	s.ReportDial(node, dialErr)
+	s.ReportDial(node, context.Canceled)
+	if !s.Healthy() {
+		t.Fatal("node down after one failure and one cancelled dial")
+	}
+	s.ReportDial(node, dialErr)
+	if s.Healthy() {
+		t.Fatal("node still healthy after reaching max failures")
+	}
+
+	s.probe = func(*config.OutboundConfig, time.Duration) (time.Duration, error) { return 20 * time.Millisecond, nil }
+	s.CheckNow()
+	if !s.Healthy() {
+		t.Fatal("node not recovered after a successful check")
+	}
+	s.probe = func(*config.OutboundConfig, time.Duration) (time.Duration, error) { return 0, dialErr }
+	s.CheckNow()
+	if s.Healthy() || s.Current() != node {
+		t.Fatal("failed check did not mark the only node down")
+	}
+}
@@ -1,27 +1,59 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"mandala/core/config"
+	"mandala/core/logger"
 )
 
 // Server 本地代理服务器
 type Server struct {
 	listener net.Listener
 	config   *config.OutboundConfig
+	ports    *config.PortPolicy
+	router   *config.Router
+	selector *Selector // [新增] 多节点时选择当前节点，单节点时为 nil
 	running  bool
 	mu       sync.Mutex
+
+	// [新增] 服务生命周期上下文，Stop 时取消以中断进行中的转发
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// [新增] 进行中的连接，优雅停止时等待其结束
+	conns ConnTracker
 }
 
 var GlobalServer *Server
 
-// Start 启动本地 SOCKS5 服务器
+// 默认监听地址，仅本机可访问
+const defaultListenAddr = "127.0.0.1"
+
+// Start 启动本地代理服务器 (SOCKS5 与 HTTP 代理共用同一端口)
 // localPort: Android 本地监听端口 (如 10809)
 // jsonConfig: 节点配置 JSON
 func Start(localPort int, jsonConfig string) error {
+	return StartWithAddr(defaultListenAddr, localPort, jsonConfig)
+}
+
+// StartWithAddr 在指定地址上启动本地代理服务器
+// listenAddr: 监听的 IP 地址，如 "0.0.0.0" 供局域网使用；为空时为 127.0.0.1
+func StartWithAddr(listenAddr string, localPort int, jsonConfig string) error {
+	if listenAddr == "" {
+		listenAddr = defaultListenAddr
+	}
+	ip := net.ParseIP(strings.Trim(listenAddr, "[]"))
+	if ip == nil {
+		return fmt.Errorf("invalid listen address: %q", listenAddr)
+	}
+
 	Stop() // 停止旧实例
 
 	cfg, err := config.ParseConfig(jsonConfig)
@@ -29,35 +61,83 @@ func Start(localPort int, jsonConfig string) error {
 		return err
 	}
 
-	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", localPort))
+	ports, err := config.ParsePortPolicy(cfg.Settings.AllowedPorts, cfg.Settings.BlockedPorts)
 	if err != nil {
 		return err
 	}
 
+	router, err := config.ParseRouter(cfg.Routing)
+	if err != nil {
+		return err
+	}
+
+	selector := NewSelector(cfg.Candidates(), cfg.HealthCheck)
+
+	l, err := net.Listen("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(localPort)))
+	if err != nil {
+		return err
+	}
+	if !ip.IsLoopback() && cfg.Settings.InboundUsername == "" && cfg.Settings.InboundPassword == "" {
+		logger.Warnf("Proxy", "入站监听在非本机地址 %s 且未设置认证，同一网络中的设备均可使用该代理", l.Addr())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	srv := &Server{
 		listener: l,
 		config:   cfg,
+		ports:    ports,
+		router:   router,
+		selector: selector,
 		running:  true,
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 	GlobalServer = srv
+	if selector != nil {
+		selector.Start(ctx)
+	}
 
 	go srv.serve()
 	return nil
 }
 
-// Stop 停止服务
+// Stop 停止服务，立即中断所有进行中的连接
 func Stop() {
-	if GlobalServer != nil {
-		GlobalServer.mu.Lock()
-		defer GlobalServer.mu.Unlock()
-		if GlobalServer.running {
-			GlobalServer.running = false
-			if GlobalServer.listener != nil {
-				GlobalServer.listener.Close()
-			}
+	StopGraceful(0)
+}
+
+// StopGraceful 停止接受新连接，最多等待 timeoutMs 毫秒让进行中的连接自然结束，
+// 超时后强制关闭剩余连接；返回被强制关闭的连接数
+func StopGraceful(timeoutMs int) int {
+	srv := GlobalServer
+	if srv == nil {
+		CloseMuxSessions()
+		CloseQUICSessions()
+		return 0
+	}
+
+	srv.mu.Lock()
+	wasRunning := srv.running
+	if wasRunning {
+		srv.running = false
+		if srv.listener != nil {
+			srv.listener.Close()
 		}
-		GlobalServer = nil
 	}
+	GlobalServer = nil
+	srv.mu.Unlock()
+
+	forced := 0
+	if wasRunning {
+		forced = srv.conns.Wait(time.Duration(timeoutMs) * time.Millisecond)
+		srv.cancel()
+		if forced > 0 && timeoutMs > 0 {
+			logger.Warnf("Proxy", "优雅停止超时，强制关闭 %d 个连接", forced)
+		}
+	}
+	CloseMuxSessions()
+	CloseQUICSessions()
+	return forced
 }
 
 func (s *Server) serve() {
@@ -65,15 +145,35 @@ func (s *Server) serve() {
 		conn, err := s.listener.Accept()
 		if err != nil {
 			if s.running {
-				fmt.Printf("Accept error: %v\n", err)
+				logger.Errorf("Proxy", "Accept error: %v", err)
 			}
 			return
 		}
-		
-		handler := &Handler{Config: s.config}
-		go handler.HandleConnection(conn)
+
+		// [新增] 连接数达到上限时拒绝新连接，防止单个应用耗尽内存与文件描述符
+		if limit := s.config.Settings.MaxConnections; limit > 0 && s.conns.Active() >= limit {
+			logger.Debugf("Proxy", "连接数已达上限 %d，拒绝来自 %s 的连接", limit, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		handler := &Handler{Config: s.node(), Ports: s.ports, Router: s.router, Selector: s.selector}
+		done := s.conns.Begin()
+		go func() {
+			defer done()
+			handler.HandleConnection(s.ctx, conn)
+		}()
+	}
+}
+
+// node 返回新连接使用的节点
+func (s *Server) node() *config.OutboundConfig {
+	if s.selector == nil {
+		return s.config
 	}
+	return s.selector.Current()
 }
+
 // core/proxy/server.go 追加内容:
 func IsRunning() bool {
 	if GlobalServer == nil {
@@ -83,3 +183,12 @@ func IsRunning() bool {
 	defer GlobalServer.mu.Unlock()
 	return GlobalServer.running
 }
+
+// ActiveConnections 返回本地代理服务器当前处理中的连接数，未运行时为 0
+func ActiveConnections() int {
+	srv := GlobalServer
+	if srv == nil {
+		return 0
+	}
+	return srv.conns.Active()
+}
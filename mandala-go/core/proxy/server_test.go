@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+const testServerConfig = `{"type": "socks", "server": "127.0.0.1", "server_port": 1080}`
+
+// startTestServer 以 StartWithAddr 启动本地代理服务器 (随机端口)，返回实际监听地址；测试结束时停止
+func startTestServer(t *testing.T, listenAddr, jsonConfig string) *net.TCPAddr {
+	t.Helper()
+	if err := StartWithAddr(listenAddr, 0, jsonConfig); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Stop)
+	return GlobalServer.listener.Addr().(*net.TCPAddr)
+}
+
+// socksHello 连接 addr 并完成无认证的 SOCKS5 问候
+func socksHello(addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !bytes.Equal(reply, []byte{0x05, 0x00}) {
+		conn.Close()
+		return nil, io.ErrUnexpectedEOF
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// 监听在显式指定的地址上，而非默认的 127.0.0.1
+func TestStartWithAddr(t *testing.T) {
+	for _, tc := range []struct {
+		listenAddr string
+		ip         net.IP
+	}{
+		{"127.0.0.2", net.ParseIP("127.0.0.2")},
+		{"[::1]", net.IPv6loopback},
+	} {
+		// 环境不支持该地址 (如未启用 IPv6) 时跳过
+		l, err := net.Listen("tcp", net.JoinHostPort(tc.ip.String(), "0"))
+		if err != nil {
+			t.Logf("skipping %s: %v", tc.listenAddr, err)
+			continue
+		}
+		l.Close()
+
+		addr := startTestServer(t, tc.listenAddr, testServerConfig)
+		if !addr.IP.Equal(tc.ip) {
+			t.Fatalf("listening on %s, want %s", addr, tc.ip)
+		}
+		conn, err := socksHello(addr.String())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.listenAddr, err)
+		}
+		conn.Close()
+		Stop()
+	}
+
+	for _, bad := range []string{"localhost", "1.2.3", "::1::"} {
+		if err := StartWithAddr(bad, 0, testServerConfig); err == nil {
+			Stop()
+			t.Errorf("StartWithAddr(%q) succeeded", bad)
+		}
+	}
+}
+
+// waitActive 等待 ActiveConnections 达到 n
+func waitActive(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for ActiveConnections() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("active connections = %d, want %d", ActiveConnections(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 连接数达到 max_connections 时第 N+1 个连接被拒绝，已有连接结束后恢复接受
+func TestMaxConnections(t *testing.T) {
+	const limit = 2
+	addr := startTestServer(t, "", `{"type": "socks", "server": "127.0.0.1", "server_port": 1080,
+		"settings": {"max_connections": 2}}`).String()
+
+	var conns []net.Conn
+	for i := 0; i < limit; i++ {
+		conn, err := socksHello(addr)
+		if err != nil {
+			t.Fatalf("connection %d: %v", i+1, err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	waitActive(t, limit)
+
+	if conn, err := socksHello(addr); err == nil {
+		conn.Close()
+		t.Fatalf("connection %d accepted beyond the limit of %d", limit+1, limit)
+	}
+
+	conns[0].Close()
+	waitActive(t, limit-1)
+	conn, err := socksHello(addr)
+	if err != nil {
+		t.Fatalf("connection after one closed: %v", err)
+	}
+	conn.Close()
+}
+
+// openTunnel 经 addr 上的代理建立到 target 的 SOCKS5 CONNECT 隧道
+func openTunnel(t *testing.T, addr string, target *net.TCPAddr) net.Conn {
+	t.Helper()
+	conn, err := socksHello(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := append([]byte{0x05, 0x01, 0x00, 0x01}, target.IP.To4()...)
+	req = append(req, byte(target.Port>>8), byte(target.Port))
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write(req)
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0x00 {
+		t.Fatalf("connect reply %x, %v", reply, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn
+}
+
+// 优雅停止等待进行中的连接自然结束；超时后强制关闭仍未结束的连接并返回其数量
+func TestStopGraceful(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	const directConfig = `{"type": "socks", "server": "127.0.0.1", "server_port": 1080, "routing": {"default_outbound": "direct"}}`
+	target := echo.Addr().(*net.TCPAddr)
+
+	// 慢连接在超时前结束：等待其结束后返回 0
+	addr := startTestServer(t, "", directConfig)
+	slow := openTunnel(t, addr.String(), target)
+	waitActive(t, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		slow.Close()
+	}()
+	start := time.Now()
+	if forced := StopGraceful(2000); forced != 0 {
+		t.Fatalf("StopGraceful = %d forced, want 0", forced)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("StopGraceful returned after %s, want after the slow connection closed", elapsed)
+	}
+	if conn, err := net.DialTimeout("tcp", addr.String(), time.Second); err == nil {
+		conn.Close()
+		t.Fatal("listener still accepting after StopGraceful")
+	}
+
+	// 连接一直未结束：超时后强制关闭
+	addr = startTestServer(t, "", directConfig)
+	stuck := openTunnel(t, addr.String(), target)
+	defer stuck.Close()
+	waitActive(t, 1)
+	start = time.Now()
+	if forced := StopGraceful(200); forced != 1 {
+		t.Fatalf("StopGraceful = %d forced, want 1", forced)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("StopGraceful returned after %s, want about the 200ms timeout", elapsed)
+	}
+	stuck.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := stuck.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("forced connection read = %v, want EOF", err)
+	}
+}
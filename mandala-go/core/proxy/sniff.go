@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// sniffTimeout 等待客户端首包的最长时间；服务端先发言的协议 (SSH、SMTP 等) 会因此延迟建立
+	sniffTimeout = 300 * time.Millisecond
+	// sniffBufferSize 可容纳一个完整的 TLS 记录 (记录头 + 16KB)
+	sniffBufferSize = 5 + 16*1024
+)
+
+var (
+	errSniffIncomplete = errors.New("sniff: need more data")
+	errSniffUnknown    = errors.New("sniff: no domain")
+)
+
+// SniffConn 读取客户端首包并从中嗅探域名 (TLS SNI / 明文 HTTP 的 Host)，直到能够判定、缓冲区已满或超时
+// 返回已读取的数据 (须原样转发给目标，不为 nil) 与域名 (未识别时为空)；超时不视为错误
+// 明文 HTTP 的请求头跨越多次读取时持续读取，直到出现 Host 行、请求头结束或缓冲区已满
+func SniffConn(conn net.Conn) ([]byte, string, error) {
+	buf := make([]byte, sniffBufferSize)
+	n := 0
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for n < len(buf) {
+		m, err := conn.Read(buf[n:])
+		n += m
+		if m > 0 {
+			domain, serr := sniffDomain(buf[:n])
+			if serr != errSniffIncomplete {
+				return buf[:n], domain, nil
+			}
+		}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				err = nil
+			}
+			if n > 0 {
+				// 已读到的数据仍需转发，错误由之后的转发过程处理
+				err = nil
+			}
+			return buf[:n], "", err
+		}
+	}
+	return buf[:n], "", nil
+}
+
+// sniffDomain 从首包中识别 TLS ClientHello 的 SNI 或 HTTP 请求的 Host
+// 数据不足以判定时返回 errSniffIncomplete
+func sniffDomain(b []byte) (string, error) {
+	if len(b) == 0 {
+		return "", errSniffIncomplete
+	}
+	if b[0] == 0x16 {
+		return sniffTLSServerName(b)
+	}
+	return sniffHTTPHost(b)
+}
+
+// sniffTLSServerName 解析首个 TLS 记录中的 ClientHello，返回 server_name 扩展中的主机名
+func sniffTLSServerName(b []byte) (string, error) {
+	if len(b) < 5 {
+		return "", errSniffIncomplete
+	}
+	if b[1] != 0x03 {
+		return "", errSniffUnknown
+	}
+	recordLen := int(binary.BigEndian.Uint16(b[3:5]))
+	if len(b) < 5+recordLen {
+		return "", errSniffIncomplete
+	}
+	hs := b[5 : 5+recordLen]
+	// Handshake: type(1)=ClientHello | length(3) | body
+	if len(hs) < 4 || hs[0] != 0x01 {
+		return "", errSniffUnknown
+	}
+	hsLen := int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3])
+	body := hs[4:]
+	if len(body) > hsLen {
+		body = body[:hsLen]
+	}
+
+	// version(2) | random(32) | session_id | cipher_suites | compression_methods | extensions
+	r := tlsReader(body)
+	if !r.skip(2+32) || !r.skipVec(1) || !r.skipVec(2) || !r.skipVec(1) {
+		return "", errSniffUnknown
+	}
+	exts, ok := r.vec(2)
+	if !ok {
+		return "", errSniffUnknown
+	}
+	for len(exts) >= 4 {
+		typ := binary.BigEndian.Uint16(exts[0:2])
+		extLen := int(binary.BigEndian.Uint16(exts[2:4]))
+		if len(exts) < 4+extLen {
+			break
+		}
+		if typ == 0x0000 {
+			// server_name: list_length(2) | { name_type(1)=host_name | name(vec 2) }
+			list := tlsReader(exts[4 : 4+extLen])
+			names, ok := list.vec(2)
+			if !ok {
+				break
+			}
+			for len(names) >= 3 {
+				nameLen := int(binary.BigEndian.Uint16(names[1:3]))
+				if len(names) < 3+nameLen {
+					break
+				}
+				if names[0] == 0 {
+					if host := sniffNormalizeHost(string(names[3 : 3+nameLen])); host != "" {
+						return host, nil
+					}
+				}
+				names = names[3+nameLen:]
+			}
+			break
+		}
+		exts = exts[4+extLen:]
+	}
+	return "", errSniffUnknown
+}
+
+// tlsReader 按 TLS 的长度前缀格式依次读取字段
+type tlsReader []byte
+
+func (r *tlsReader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+// vec 读取一个以 lenSize 字节长度为前缀的字段
+func (r *tlsReader) vec(lenSize int) ([]byte, bool) {
+	if len(*r) < lenSize {
+		return nil, false
+	}
+	n := 0
+	for _, c := range (*r)[:lenSize] {
+		n = n<<8 | int(c)
+	}
+	if len(*r) < lenSize+n {
+		return nil, false
+	}
+	v := (*r)[lenSize : lenSize+n]
+	*r = (*r)[lenSize+n:]
+	return v, true
+}
+
+func (r *tlsReader) skipVec(lenSize int) bool {
+	_, ok := r.vec(lenSize)
+	return ok
+}
+
+var sniffHTTPMethods = []string{"GET ", "POST ", "HEAD ", "PUT ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// sniffHTTPHost 解析明文 HTTP 请求头中的 Host
+func sniffHTTPHost(b []byte) (string, error) {
+	isHTTP := false
+	for _, m := range sniffHTTPMethods {
+		n := len(m)
+		if len(b) < n {
+			n = len(b)
+		}
+		if string(b[:n]) == m[:n] {
+			if n < len(m) {
+				return "", errSniffIncomplete
+			}
+			isHTTP = true
+			break
+		}
+	}
+	if !isHTTP {
+		return "", errSniffUnknown
+	}
+
+	// 跳过请求行，逐行查找 Host，只处理已完整到达的行
+	lines := b
+	first := true
+	for {
+		i := bytes.Index(lines, []byte("\r\n"))
+		if i < 0 {
+			return "", errSniffIncomplete
+		}
+		line := lines[:i]
+		lines = lines[i+2:]
+		if first {
+			first = false
+			continue
+		}
+		if len(line) == 0 {
+			// 请求头结束仍未找到 Host
+			return "", errSniffUnknown
+		}
+		name, value, ok := strings.Cut(string(line), ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "host") {
+			host := strings.TrimSpace(value)
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if host = sniffNormalizeHost(host); host != "" {
+				return host, nil
+			}
+			return "", errSniffUnknown
+		}
+	}
+}
+
+// sniffNormalizeHost 转为小写并去掉末尾的点；IP 地址与空值不视为域名，返回空
+func sniffNormalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	if host == "" || net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// clientHello 返回 crypto/tls 客户端以 serverName 发出的首个 TLS 记录
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(server, head); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(head[3])<<8|int(head[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	return append(head, body...)
+}
+
+// 从 ClientHello 的 SNI 与明文 HTTP 的 Host 识别域名；数据不完整时要求继续读取
+func TestSniffDomain(t *testing.T) {
+	hello := clientHello(t, "WWW.Example.com")
+	if domain, err := sniffDomain(hello); err != nil || domain != "www.example.com" {
+		t.Fatalf("sniffDomain(ClientHello) = %q, %v", domain, err)
+	}
+	for _, n := range []int{1, 5, 40, len(hello) - 1} {
+		if _, err := sniffDomain(hello[:n]); err != errSniffIncomplete {
+			t.Errorf("ClientHello truncated to %d bytes: %v, want incomplete", n, err)
+		}
+	}
+	// 以 IP 连接时不发送 SNI
+	if domain, err := sniffDomain(clientHello(t, "192.0.2.1")); err == nil || domain != "" {
+		t.Errorf("ClientHello without SNI = %q, %v", domain, err)
+	}
+
+	for _, tc := range []struct {
+		data   string
+		domain string
+		err    error
+	}{
+		{"GET / HTTP/1.1\r\nHost: Example.com:8080\r\n\r\n", "example.com", nil},
+		{"POST /x HTTP/1.1\r\nUser-Agent: t\r\nhost:api.example.com.\r\n", "api.example.com", nil},
+		{"GE", "", errSniffIncomplete},
+		{"GET / HTTP/1.1\r\nUser-Agent: t\r\n", "", errSniffIncomplete},
+		{"GET / HTTP/1.1\r\nHost: exam", "", errSniffIncomplete},
+		{"GET / HTTP/1.1\r\nHost: 192.0.2.1\r\n\r\n", "", errSniffUnknown},
+		{"GET / HTTP/1.1\r\nHost: [2001:db8::1]:80\r\n\r\n", "", errSniffUnknown},
+		{"GET / HTTP/1.0\r\n\r\n", "", errSniffUnknown},
+		{"SSH-2.0-OpenSSH_9.0\r\n", "", errSniffUnknown},
+	} {
+		domain, err := sniffDomain([]byte(tc.data))
+		if domain != tc.domain || err != tc.err {
+			t.Errorf("sniffDomain(%q) = %q, %v; want %q, %v", tc.data, domain, err, tc.domain, tc.err)
+		}
+	}
+}
+
+// 请求头分多次到达时持续读取直到出现 Host；客户端不再发送时超时返回已读数据
+func TestSniffConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		client.Write([]byte("GET / HTTP/1.1\r\nUser-"))
+		client.Write([]byte("Agent: t\r\nHost: split.example\r\n\r\n"))
+	}()
+	data, domain, err := SniffConn(server)
+	if err != nil || domain != "split.example" || string(data) != "GET / HTTP/1.1\r\nUser-Agent: t\r\nHost: split.example\r\n\r\n" {
+		t.Fatalf("SniffConn = %q, %q, %v", data, domain, err)
+	}
+
+	go client.Write([]byte("GET / HT"))
+	start := time.Now()
+	data, domain, err = SniffConn(server)
+	if err != nil || domain != "" || string(data) != "GET / HT" {
+		t.Fatalf("SniffConn on a stalled request = %q, %q, %v", data, domain, err)
+	}
+	if elapsed := time.Since(start); elapsed < sniffTimeout || elapsed > sniffTimeout+time.Second {
+		t.Fatalf("SniffConn returned after %s, want about %s", elapsed, sniffTimeout)
+	}
+}
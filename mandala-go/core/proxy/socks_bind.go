@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"mandala/core/config"
+	"mandala/core/logger"
+	"mandala/core/protocol"
+	"mandala/core/stats"
+)
+
+// handleBind 处理 SOCKS5 BIND (CMD 0x02)，用于 FTP 主动模式等需要对端反向连入的应用
+// 监听由上游代理完成，目前仅 SOCKS5 出站支持；其余协议 (及直连/拦截路由) 无反向监听能力，回复命令不支持 (0x07)。
+// 流程：上游第一次应答 (监听地址) -> 回复客户端 -> 等待上游第二次应答 (对端地址) -> 回复客户端 -> 转发
+func (h *Handler) handleBind(ctx context.Context, localConn net.Conn, targetHost string, targetPort int) {
+	reply := func(rep byte, host string, port int) error {
+		addr, err := protocol.ToSocksAddr(host, port)
+		if err != nil {
+			addr = []byte{0x01, 0, 0, 0, 0, 0, 0}
+		}
+		_, err = localConn.Write(append([]byte{0x05, rep, 0x00}, addr...))
+		return err
+	}
+
+	if route := h.Router.Match(targetHost, targetPort); route != config.OutboundProxy {
+		logger.Warnf("Proxy", "BIND %s:%d: 路由为 %s，仅支持经 SOCKS5 代理监听", targetHost, targetPort, route)
+		reply(0x07, "0.0.0.0", 0)
+		return
+	}
+	switch strings.ToLower(h.Config.Type) {
+	case "socks", "socks5":
+	default:
+		logger.Warnf("Proxy", "BIND %s:%d: %s 协议不支持反向监听", targetHost, targetPort, h.Config.Type)
+		reply(0x07, "0.0.0.0", 0)
+		return
+	}
+
+	// BIND 独占一条隧道，不经过多路复用
+	remoteConn, err := NewDialer(h.Config).dialTunnel(ctx)
+	h.Selector.ReportDial(h.Config, err)
+	if err != nil {
+		logger.Errorf("Proxy", "Dial remote failed (bind): %v", err)
+		reply(repHostUnreach, "0.0.0.0", 0)
+		return
+	}
+	defer remoteConn.Close()
+
+	// 等待对端连入期间服务停止时中断读取
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			remoteConn.Close()
+		case <-done:
+		}
+	}()
+
+	// 1. 第一次应答：上游为入站连接监听的地址
+	bndHost, bndPort, err := protocol.Socks5Bind(remoteConn, h.Config.Username, h.Config.Password, targetHost, targetPort)
+	if err != nil {
+		logger.Errorf("Socks5", "BIND failed: %v", err)
+		reply(socksReplyCode(err), "0.0.0.0", 0)
+		return
+	}
+	// 上游监听在全部地址时，对端应连接的是上游服务器本身
+	if ip := net.ParseIP(bndHost); ip != nil && ip.IsUnspecified() {
+		if tcpAddr, ok := remoteConn.RemoteAddr().(*net.TCPAddr); ok {
+			bndHost = tcpAddr.IP.String()
+		}
+	}
+	if err := reply(repSucceeded, bndHost, bndPort); err != nil {
+		return
+	}
+	logger.Infof("Proxy", "BIND 已监听 %s:%d，等待 %s:%d 连入", bndHost, bndPort, targetHost, targetPort)
+
+	// 2. 第二次应答：对端已连入
+	peerHost, peerPort, err := protocol.ReadSocks5Reply(remoteConn)
+	if err != nil {
+		logger.Warnf("Socks5", "BIND 等待连入失败: %v", err)
+		reply(socksReplyCode(err), "0.0.0.0", 0)
+		return
+	}
+	if err := reply(repSucceeded, peerHost, peerPort); err != nil {
+		return
+	}
+
+	// 3. 转发
+	remoteConn = stats.WrapConn(remoteConn, false, targetHost, targetPort)
+	defer remoteConn.Close()
+	pipe(ctx, localConn, remoteConn, h.Config.IdleTimeout())
+}
+
+// socksReplyCode 上游返回失败应答时原样转告客户端，其余错误视为一般失败 (0x01)
+func socksReplyCode(err error) byte {
+	var replyErr *protocol.Socks5ReplyError
+	if errors.As(err, &replyErr) {
+		return replyErr.Rep
+	}
+	return 0x01
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrReservedTarget 启用 block_private_ips 时目标解析为私有或保留地址
+var ErrReservedTarget = errors.New("target is a private or reserved address")
+
+// reservedNets net.IP 方法未覆盖的保留网段
+var reservedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",       // 本网络
+		"100.64.0.0/10",   // 运营商级 NAT
+		"192.0.0.0/24",    // IETF 协议分配
+		"192.0.2.0/24",    // 文档示例 (TEST-NET-1)
+		"198.18.0.0/15",   // 基准测试
+		"198.51.100.0/24", // 文档示例 (TEST-NET-2)
+		"203.0.113.0/24",  // 文档示例 (TEST-NET-3)
+		"240.0.0.0/4",     // 保留 (含广播地址)
+		"2001:db8::/32",   // 文档示例
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// IsReservedIP 是否为私有、回环、链路本地、组播或其他保留地址 (IPv4 映射的 IPv6 地址按 IPv4 判断)
+func IsReservedIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTarget 启用 block_private_ips 时检查客户端请求的目标，与路由无关：
+// IP 直接判断，域名先在本地解析，任一地址为私有 / 保留地址即返回 ErrReservedTarget
+// (经代理时由服务端选择连接哪个地址，服务端所在的内部网络同样面临 SSRF)。
+// 本地解析失败时不拒绝：直连时由 DialDirectTarget 报告错误，经代理时由服务端解析
+func (d *Dialer) CheckTarget(ctx context.Context, host string) error {
+	if !d.Config.Settings.BlockPrivateIPs {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.dialTimeout())
+	defer cancel()
+	ips, err := lookupServerIPs(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, ip := range ips {
+		if IsReservedIP(ip) {
+			return fmt.Errorf("%w: %s (%s)", ErrReservedTarget, host, ip)
+		}
+	}
+	return nil
+}
+
+// DialDirectTarget 直连客户端请求的目标 (TUN 与本地入站的 direct 路由)
+// [新增] 启用 block_private_ips 时先在本地解析域名并剔除私有 / 保留地址，再直接连接通过检查的 IP，
+// 避免检查与拨号之间再次解析 (DNS 重绑定) 绕过限制；全部地址均被剔除时返回 ErrReservedTarget。
+// TCP 目标的多个地址与 DialDirect 一样错开尝试。经代理的目标在拨号前由 CheckTarget 检查
+func (d *Dialer) DialDirectTarget(network, host string, port int) (net.Conn, error) {
+	if !d.Config.Settings.BlockPrivateIPs {
+		return d.DialDirect(network, host, port)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout())
+	defer cancel()
+	ips, err := lookupServerIPs(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var allowed []net.IP
+	for _, ip := range ips {
+		if !IsReservedIP(ip) {
+			allowed = append(allowed, ip)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrReservedTarget, host)
+	}
+	if network == "tcp" {
+		return d.dialDirectIPs(ctx, allowed, port)
+	}
+	// UDP 套接字的连接不探测可达性，使用第一个地址
+	return d.DialDirect(network, allowed[0].String(), port)
+}
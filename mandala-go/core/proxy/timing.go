@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"mandala/core/logger"
+)
+
+// DialStats 一次隧道拨号各阶段的耗时 (纳秒)，未经历的阶段为 0
+// 退回 http/1.1 或 ECH 重试时，重复的阶段累加
+type DialStats struct {
+	TCP     time.Duration `json:"tcp"`     // 解析节点地址与 TCP 连接
+	ECH     time.Duration `json:"ech"`     // 经 DoH 查询 ECH 密钥 (命中缓存时接近 0)
+	TLS     time.Duration `json:"tls"`     // TLS 握手
+	Upgrade time.Duration `json:"upgrade"` // WebSocket 升级或 gRPC / HTTP/2 流建立
+	Total   time.Duration `json:"total"`
+}
+
+type dialStatsKey struct{}
+
+// withDialStats 返回携带 st 的 ctx，拨号各阶段的耗时记录到 st
+func withDialStats(ctx context.Context, st *DialStats) context.Context {
+	return context.WithValue(ctx, dialStatsKey{}, st)
+}
+
+// dialStatsFrom 取出 ctx 携带的耗时记录，没有时返回 nil
+func dialStatsFrom(ctx context.Context) *DialStats {
+	st, _ := ctx.Value(dialStatsKey{}).(*DialStats)
+	return st
+}
+
+// [新增] DialWithStats 与 DialContext 相同，同时返回各阶段耗时
+// 多路复用与 QUIC 协议复用已建立的会话时不经过这些阶段，耗时均为 0
+func (d *Dialer) DialWithStats(ctx context.Context) (net.Conn, *DialStats, error) {
+	st := &DialStats{}
+	start := time.Now()
+	conn, err := d.DialContext(withDialStats(ctx, st))
+	st.Total = time.Since(start)
+	return conn, st, err
+}
+
+// recordDial 拨号成功时记录总耗时并输出各阶段耗时，连同握手信息一起记录
+func (d *Dialer) recordDial(info *ConnInfo, st *DialStats, start time.Time) {
+	st.Total = time.Since(start)
+	logger.Debugf("Dial", "%s 耗时: TCP %s, ECH %s, TLS %s, 升级 %s, 共 %s", d.serverAddr(),
+		st.TCP.Round(time.Millisecond), st.ECH.Round(time.Millisecond), st.TLS.Round(time.Millisecond),
+		st.Upgrade.Round(time.Millisecond), st.Total.Round(time.Millisecond))
+	timing := *st
+	info.Timing = &timing
+	d.recordConnInfo(info)
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"sync"
+
+	"mandala/core/config"
+)
+
+// 自定义根证书池缓存 (按 ca_cert 配置值区分)，避免每次拨号重复读取与解析
+var (
+	caPools   = make(map[string]*x509.CertPool)
+	caPoolsMu sync.Mutex
+)
+
+// rootCAs 返回节点配置的根证书池，未设置 ca_cert 时返回 nil (使用系统根证书)
+func (d *Dialer) rootCAs() (*x509.CertPool, error) {
+	if d.Config.TLS == nil || d.Config.TLS.CACert == "" {
+		return nil, nil
+	}
+	ca := d.Config.TLS.CACert
+	caPoolsMu.Lock()
+	defer caPoolsMu.Unlock()
+	if pool, ok := caPools[ca]; ok {
+		return pool, nil
+	}
+	pool, err := config.LoadCACert(ca)
+	if err != nil {
+		return nil, err
+	}
+	caPools[ca] = pool
+	return pool, nil
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"sync"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// 每个节点缓存的 TLS 会话数 (按 SNI 区分)
+const tlsSessionCacheSize = 32
+
+// TLS 会话缓存 (按节点 server:port 区分)，启用会话复用时跨拨号共享
+var (
+	tlsSessionCaches   = make(map[string]utls.ClientSessionCache)
+	tlsSessionCachesMu sync.Mutex
+)
+
+// tlsSessionCache 返回节点的会话缓存，不存在时创建
+// 票据只对签发它的服务端有效，按节点区分避免同一 SNI 的不同节点 (如 CDN 前置) 相互尝试无效票据
+func tlsSessionCache(addr string) utls.ClientSessionCache {
+	tlsSessionCachesMu.Lock()
+	defer tlsSessionCachesMu.Unlock()
+	cache, ok := tlsSessionCaches[addr]
+	if !ok {
+		cache = utls.NewLRUClientSessionCache(tlsSessionCacheSize)
+		tlsSessionCaches[addr] = cache
+	}
+	return cache
+}
+
+// hasPSKExtension 模版中是否已有 pre_shared_key 扩展
+func hasPSKExtension(exts []utls.TLSExtension) bool {
+	for _, ext := range exts {
+		if _, ok := ext.(utls.PreSharedKeyExtension); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// useSessionResumption 是否为本次握手启用会话复用
+// Reality 的认证数据依赖完整握手，始终不复用
+func (d *Dialer) useSessionResumption() bool {
+	return d.Config.TLS != nil && d.Config.TLS.EnableSessionResumption && !d.usesReality()
+}
@@ -0,0 +1,344 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mandala/core/protocol"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// TUIC 心跳间隔，防止服务端或中间设备因空闲回收连接
+	tuicHeartbeatInterval = 10 * time.Second
+	// QUIC 空闲超时
+	tuicIdleTimeout = 30 * time.Second
+)
+
+// tuicSession 一条已认证的 TUIC QUIC 连接，承载多个 TCP 流与 UDP 会话
+type tuicSession struct {
+	conn          *quic.Conn
+	udpOverStream bool
+	maxPacketSize int
+
+	mu        sync.Mutex
+	assocs    map[uint16]*quicPacketConn
+	nextAssoc uint16
+}
+
+var (
+	tuicSessions   = make(map[string]*tuicSession)
+	tuicSessionsMu sync.Mutex
+)
+
+func closeTUICSessions() {
+	tuicSessionsMu.Lock()
+	sessions := tuicSessions
+	tuicSessions = make(map[string]*tuicSession)
+	tuicSessionsMu.Unlock()
+
+	for _, s := range sessions {
+		s.conn.CloseWithError(0, "")
+	}
+}
+
+func (d *Dialer) tuicKey() string {
+	return d.serverAddr() + "|" + protocol.TrojanPasswordHash(d.Config.Password+"|"+d.Config.UUID)
+}
+
+// getTUICSession 返回节点的共享 TUIC 连接，不存在或已断开时新建
+func (d *Dialer) getTUICSession() (*tuicSession, error) {
+	key := d.tuicKey()
+
+	tuicSessionsMu.Lock()
+	defer tuicSessionsMu.Unlock()
+	if s, ok := tuicSessions[key]; ok && s.conn.Context().Err() == nil {
+		return s, nil
+	}
+	s, err := d.newTUICSession()
+	if err != nil {
+		return nil, err
+	}
+	tuicSessions[key] = s
+	return s, nil
+}
+
+// dropTUICSession 从共享池中移除已失效的连接
+func (d *Dialer) dropTUICSession(s *tuicSession) {
+	key := d.tuicKey()
+	tuicSessionsMu.Lock()
+	if tuicSessions[key] == s {
+		delete(tuicSessions, key)
+	}
+	tuicSessionsMu.Unlock()
+	s.conn.CloseWithError(0, "")
+}
+
+// newTUICSession 建立 QUIC 连接并发送认证指令
+// 令牌由 TLS 导出密钥得到，服务端不回复认证结果，失败时直接关闭连接
+func (d *Dialer) newTUICSession() (*tuicSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout())
+	defer cancel()
+
+	alpn := []string{"h3"}
+	relayMode := "native"
+	if cfg := d.Config.TUIC; cfg != nil {
+		if len(cfg.ALPN) > 0 {
+			alpn = cfg.ALPN
+		}
+		if cfg.UDPRelayMode != "" {
+			relayMode = strings.ToLower(cfg.UDPRelayMode)
+		}
+	}
+	if relayMode != "native" && relayMode != "quic" {
+		return nil, fmt.Errorf("unsupported tuic udp relay mode: %s", relayMode)
+	}
+
+	conn, err := d.dialQUIC(ctx, alpn, &quic.Config{
+		EnableDatagrams: true,
+		MaxIdleTimeout:  tuicIdleTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uuid, err := protocol.ParseUUID(d.Config.UUID)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+	state := conn.ConnectionState().TLS
+	token, err := state.ExportKeyingMaterial(string(uuid), []byte(d.Config.Password), protocol.TUICTokenLength)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+	auth, err := protocol.BuildTUICAuthenticate(d.Config.UUID, token)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+	if err := sendTUICUniStream(ctx, conn, auth); err != nil {
+		conn.CloseWithError(0, "")
+		return nil, fmt.Errorf("tuic authenticate failed: %v", err)
+	}
+
+	s := &tuicSession{
+		conn:          conn,
+		udpOverStream: relayMode == "quic",
+		maxPacketSize: d.Config.MaxPacketSize(),
+		assocs:        make(map[uint16]*quicPacketConn),
+	}
+	go s.heartbeatLoop()
+	go s.receiveDatagrams()
+	go s.acceptUniStreams()
+
+	d.recordConnInfo(d.newQUICConnInfo(conn))
+	if !d.probe {
+		log.Printf("[TUIC] 新建连接 -> %s (UDP 中继: %s)", d.serverAddr(), relayMode)
+	}
+	return s, nil
+}
+
+// sendTUICUniStream 在新的单向流上发送一条指令
+func sendTUICUniStream(ctx context.Context, conn *quic.Conn, data []byte) error {
+	stream, err := conn.OpenUniStreamSync(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Write(data); err != nil {
+		stream.CancelWrite(0)
+		return err
+	}
+	return stream.Close()
+}
+
+// dialTUICStream 在共享 TUIC 连接上打开一个双向流，由调用方随后写入 Connect 指令
+func (d *Dialer) dialTUICStream() (net.Conn, error) {
+	// 共享连接可能恰好在此时断开，重试一次
+	var lastErr error
+	for i := 0; i < 2; i++ {
+		s, err := d.getTUICSession()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout())
+		stream, err := s.conn.OpenStreamSync(ctx)
+		cancel()
+		if err == nil {
+			return &quicStreamConn{Stream: stream, conn: s.conn}, nil
+		}
+		d.dropTUICSession(s)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("tuic open stream failed: %v", lastErr)
+}
+
+// dialTUICProbe 为测速建立独立的 TUIC 连接 (不进入共享池)，关闭流时一并关闭连接
+func (d *Dialer) dialTUICProbe() (net.Conn, error) {
+	s, err := d.newTUICSession()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout())
+	defer cancel()
+	stream, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		s.conn.CloseWithError(0, "")
+		return nil, err
+	}
+	return &quicStreamConn{Stream: stream, conn: s.conn, owned: true}, nil
+}
+
+func (s *tuicSession) heartbeatLoop() {
+	ticker := time.NewTicker(tuicHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.conn.Context().Done():
+			return
+		case <-ticker.C:
+			if s.conn.ConnectionState().SupportsDatagrams {
+				s.conn.SendDatagram(protocol.BuildTUICHeartbeat())
+			}
+		}
+	}
+}
+
+// receiveDatagrams 接收 native 模式下服务端返回的 UDP 数据报
+func (s *tuicSession) receiveDatagrams() {
+	ctx := s.conn.Context()
+	for {
+		data, err := s.conn.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		s.dispatch(data)
+	}
+}
+
+// acceptUniStreams 接收 quic 模式下服务端通过单向流返回的 UDP 数据报
+func (s *tuicSession) acceptUniStreams() {
+	ctx := s.conn.Context()
+	for {
+		stream, err := s.conn.AcceptUniStream(ctx)
+		if err != nil {
+			return
+		}
+		go func() {
+			data, err := io.ReadAll(io.LimitReader(stream, int64(protocol.TUICPacketHeaderLen+2+255+2+s.maxPacketSize)))
+			if err == nil {
+				s.dispatch(data)
+			}
+			stream.CancelRead(0)
+		}()
+	}
+}
+
+// dispatch 将 Packet 指令交给对应的 UDP 会话，其他指令忽略
+func (s *tuicSession) dispatch(data []byte) {
+	if len(data) < 2 || data[1] != protocol.TUICCmdPacket {
+		return
+	}
+	pkt, err := protocol.ParseTUICPacket(data)
+	if err != nil {
+		log.Printf("[TUIC] 无效的 UDP 数据报: %v", err)
+		return
+	}
+	s.mu.Lock()
+	pc := s.assocs[pkt.AssocID]
+	s.mu.Unlock()
+	if pc != nil {
+		pc.deliver(pkt.PktID, pkt.FragID, pkt.FragTotal, pkt.Data)
+	}
+}
+
+// dialTUICUDP 在共享 TUIC 连接上新建一个 UDP 会话 (关联)
+func (d *Dialer) dialTUICUDP(targetHost string, targetPort int) (net.Conn, error) {
+	target, err := protocol.TUICAddr(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	s, err := d.getTUICSession()
+	if err != nil {
+		return nil, err
+	}
+	if !s.udpOverStream && !s.conn.ConnectionState().SupportsDatagrams {
+		return nil, errors.New("tuic server does not support datagrams, use udp_relay_mode \"quic\"")
+	}
+
+	s.mu.Lock()
+	var assocID uint16
+	for i := 0; ; i++ {
+		if i > 0xFFFF {
+			s.mu.Unlock()
+			return nil, errors.New("tuic: too many udp sessions")
+		}
+		assocID = s.nextAssoc
+		s.nextAssoc++
+		if _, used := s.assocs[assocID]; !used {
+			break
+		}
+	}
+	pc := newQUICPacketConn(s.conn, s.maxPacketSize)
+	s.assocs[assocID] = pc
+	s.mu.Unlock()
+
+	var nextPkt atomic.Uint32
+	pc.send = func(b []byte) error {
+		pkt := &protocol.TUICPacket{
+			AssocID:   assocID,
+			PktID:     uint16(nextPkt.Add(1)),
+			FragTotal: 1,
+			Addr:      target,
+			Data:      b,
+		}
+		if s.udpOverStream {
+			return sendTUICUniStream(s.conn.Context(), s.conn, pkt.Marshal())
+		}
+		if data := pkt.Marshal(); len(data) <= quicMaxDatagramSize {
+			return s.conn.SendDatagram(data)
+		}
+
+		// 超出单个数据报的长度时分片发送，目标地址只出现在首个分片中
+		chunks, err := splitDatagram(b,
+			quicMaxDatagramSize-protocol.TUICPacketHeaderLen-len(target),
+			quicMaxDatagramSize-protocol.TUICPacketHeaderLen-1)
+		if err != nil {
+			return err
+		}
+		for i, chunk := range chunks {
+			frag := *pkt
+			frag.FragTotal = uint8(len(chunks))
+			frag.FragID = uint8(i)
+			frag.Data = chunk
+			if i > 0 {
+				frag.Addr = nil
+			}
+			if err := s.conn.SendDatagram(frag.Marshal()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	pc.onClose = func() {
+		s.mu.Lock()
+		delete(s.assocs, assocID)
+		s.mu.Unlock()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			sendTUICUniStream(ctx, s.conn, protocol.BuildTUICDissociate(assocID))
+		}()
+	}
+	return pc, nil
+}
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"mandala/core/protocol"
+)
+
+// DialUDP 建立承载 UDP 数据的隧道连接并完成协议握手
+// 返回的连接可直接收发数据报载荷：每次 Write 发送一个数据报，每次 Read 返回一个数据报
+// (分帧由协议包装层负责: Trojan/Mandala 为 ADDR+LEN+CRLF，VLESS 为 XUDP，sing-mux 为 LEN，
+// SOCKS5 为 UDP 中继头，启用 udp_over_tcp 时为 LEN+ADDR)
+// [修改] ctx 取消时中止进行中的拨号与 UDP 握手 (含 SOCKS5 UDP 关联)
+func (d *Dialer) DialUDP(ctx context.Context, targetHost string, targetPort int) (net.Conn, error) {
+	// [新增] TUIC / Hysteria2: UDP 会话直接复用 QUIC 连接，数据报经 QUIC 数据报 (TUIC 亦可用单向流) 承载
+	if d.Config.UsesQUIC() {
+		return d.dialQUICUDP(targetHost, targetPort)
+	}
+
+	remoteConn, err := d.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := closeOnCancel(ctx, remoteConn)
+	conn, err := d.handshakeUDP(remoteConn, targetHost, targetPort)
+	stop()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return conn, err
+}
+
+// handshakeUDP 在已建立的隧道连接上完成 UDP 协议握手，并按协议包装数据报分帧；失败时关闭 remoteConn
+func (d *Dialer) handshakeUDP(remoteConn net.Conn, targetHost string, targetPort int) (net.Conn, error) {
+	var payload []byte
+	var hErr error
+	isVless := false
+	isTrojanUDP := false
+	isMandala := false
+	isSingMux := false
+	isSocksUDP := false
+
+	proxyType := strings.ToLower(d.Config.Type)
+	if d.Config.UseSingMux() {
+		proxyType = "sing-mux"
+	}
+
+	// 根据配置类型执行不同的握手逻辑
+	switch proxyType {
+	case "sing-mux":
+		// sing-mux UDP 流: 数据报按 Len(2) + Data 分帧
+		payload, hErr = protocol.BuildSingMuxStreamRequest(true, targetHost, targetPort)
+		isSingMux = true
+	case "mandala":
+		// Mandala UDP: 指令 0x03，之后每个数据报按 ADDR+LEN+CRLF 分帧
+		client := protocol.NewMandalaClient(d.Config.Username, d.Config.Password)
+		payload, hErr = client.BuildUDPHandshakePayload(targetHost, targetPort, d.Config.Settings.Noise)
+		isMandala = true
+	case "trojan":
+		if d.Config.UseTrojanGoMux() {
+			// Trojan-Go 多路复用: UDP 通过流内 Associate 指令承载，数据报按 Trojan UDP 格式分帧
+			payload, hErr = protocol.BuildSimpleSocksPayload(protocol.TrojanCmdAssociate, targetHost, targetPort)
+		} else {
+			payload, hErr = protocol.BuildTrojanUDPPayload(d.Config.Password, targetHost, targetPort)
+		}
+		isTrojanUDP = true
+	case "vless":
+		// VLESS UDP: Mux 指令承载 XUDP 帧，每帧携带目标地址
+		payload, hErr = protocol.BuildVlessMuxPayload(d.Config.UUID)
+		isVless = true
+	case "shadowsocks":
+		payload, hErr = protocol.BuildShadowsocksPayload(targetHost, targetPort)
+		if hErr == nil {
+			remoteConn, hErr = protocol.WrapShadowsocks(remoteConn, d.Config.Method, d.Config.Password)
+		}
+	case "vmess":
+		// VMess UDP: 每个数据块承载一个完整数据报，请求头随首个数据报发送
+		var vmessConn *protocol.VmessConn
+		vmessConn, hErr = protocol.NewVmessConn(remoteConn, d.Config.UUID, d.Config.Method, protocol.VmessCmdUDP, targetHost, targetPort)
+		if hErr == nil {
+			remoteConn = vmessConn
+		}
+	case "socks", "socks5":
+		if d.Config.Settings.UDPOverTCP {
+			// [新增] UDP over TCP: 数据报在控制连接上按长度前缀分帧，每帧携带目标地址
+			hErr = protocol.Socks5UDPOverTCP(remoteConn, d.Config.Username, d.Config.Password)
+			isSocksUDP = true
+		} else {
+			// [修改] 标准 UDP ASSOCIATE: 控制连接保持打开，数据报经服务端返回的 UDP 中继收发
+			return d.dialSocks5UDP(remoteConn, targetHost, targetPort)
+		}
+	}
+
+	if hErr != nil {
+		remoteConn.Close()
+		return nil, hErr
+	}
+
+	// 发送握手 Payload
+	if len(payload) > 0 {
+		if _, err := remoteConn.Write(payload); err != nil {
+			remoteConn.Close()
+			return nil, err
+		}
+	}
+
+	// 协议包装（针对 VLESS 剥离头部）
+	if isVless {
+		vc := protocol.NewVlessConn(remoteConn)
+		vc.Strict = d.Config.Settings.StrictProtocol
+		packetConn, err := protocol.NewXUDPConn(vc, targetHost, targetPort)
+		if err != nil {
+			remoteConn.Close()
+			return nil, err
+		}
+		packetConn.MaxPacketSize = d.Config.MaxPacketSize()
+		remoteConn = packetConn
+	}
+	if isSingMux {
+		packetConn := protocol.NewSingMuxPacketConn(protocol.NewSingMuxStreamConn(remoteConn))
+		packetConn.MaxPacketSize = d.Config.MaxPacketSize()
+		remoteConn = packetConn
+	}
+	if isSocksUDP {
+		packetConn, err := protocol.NewSocks5UDPConn(remoteConn, targetHost, targetPort)
+		if err != nil {
+			remoteConn.Close()
+			return nil, err
+		}
+		packetConn.MaxPacketSize = d.Config.MaxPacketSize()
+		remoteConn = packetConn
+	}
+	if isTrojanUDP || isMandala {
+		newPacketConn := protocol.NewTrojanPacketConn
+		if isMandala {
+			newPacketConn = protocol.NewMandalaPacketConn
+		}
+		packetConn, err := newPacketConn(remoteConn, targetHost, targetPort)
+		if err != nil {
+			remoteConn.Close()
+			return nil, err
+		}
+		packetConn.MaxPacketSize = d.Config.MaxPacketSize()
+		remoteConn = packetConn
+	}
+	return remoteConn, nil
+}
+
+// [新增] dialSocks5UDP 在 SOCKS5 控制连接上建立 UDP 关联，并直接连接服务端返回的 UDP 中继
+func (d *Dialer) dialSocks5UDP(control net.Conn, targetHost string, targetPort int) (net.Conn, error) {
+	relay, err := protocol.HandshakeSocks5UDP(control, d.Config.Username, d.Config.Password)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	relayHost, relayPort, err := protocol.SplitHostPort(relay)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	// 中继地址为未指定地址时，中继与控制连接位于同一服务端
+	// [修改] 经上游代理连接时控制连接的对端为上游代理，此时按节点地址发送
+	if ip := net.ParseIP(relayHost); ip == nil || ip.IsUnspecified() {
+		relayHost = d.Config.Server
+		if d.Config.Settings.UpstreamProxy == "" {
+			if host, _, err := net.SplitHostPort(control.RemoteAddr().String()); err == nil && net.ParseIP(host) != nil {
+				relayHost = host
+			}
+		}
+	}
+
+	udpConn, err := d.DialDirect("udp", relayHost, relayPort)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	packetConn, err := protocol.NewSocks5PacketConn(udpConn, control, targetHost, targetPort)
+	if err != nil {
+		udpConn.Close()
+		control.Close()
+		return nil, err
+	}
+	return packetConn, nil
+}
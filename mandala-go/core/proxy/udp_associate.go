@@ -0,0 +1,315 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"mandala/core/config"
+	"mandala/core/logger"
+	"mandala/core/protocol"
+	"mandala/core/stats"
+)
+
+// UDP 关联中单个目标会话的空闲超时
+const udpAssociateTimeout = 60 * time.Second
+
+// udpRelay 一次 UDP ASSOCIATE 的运行状态
+type udpRelay struct {
+	ctx     context.Context // 所属入站连接的上下文，取消时中止进行中的隧道拨号
+	handler *Handler
+	dialer  *Dialer
+	conn    *net.UDPConn
+
+	// [新增] 只接受来自控制连接对端 IP (及 ASSOCIATE 请求指定的端口，0 表示不限) 的数据报，
+	// 防止其他主机绕过入站认证借用该端口，或抢占回包的发送地址
+	clientIP   net.IP
+	clientPort int
+
+	mu         sync.Mutex
+	clientAddr *net.UDPAddr // 首个通过检查的来源地址，此后固定不变
+	sessions   map[string]net.Conn
+
+	// [新增] 分片重组队列，仅由读取循环使用
+	frags *udpReassembler
+
+	// [新增] UDP over TCP 模式下承载数据报的控制连接，为 nil 时经 UDP 端口收发
+	tcp *protocol.Socks5UDPConn
+}
+
+// handleUDPAssociate 处理 SOCKS5 UDP ASSOCIATE (CMD 0x03)
+// 在 TCP 控制连接所在地址上绑定 UDP 端口，解析客户端数据报的 SOCKS5 UDP 头并按目标建立隧道；
+// 控制连接关闭时结束整个关联 (服务停止时控制连接会被关闭)；启用 udp_over_tcp 时不绑定端口，见 handleUDPOverTCP。
+// clientPort 为 ASSOCIATE 请求中的 DST.PORT，0 表示客户端尚不知道自己的发送端口
+func (h *Handler) handleUDPAssociate(ctx context.Context, localConn net.Conn, clientPort int) {
+	if h.Config.Settings.UDPOverTCP {
+		h.handleUDPOverTCP(ctx, localConn)
+		return
+	}
+
+	bindIP := net.IPv4(127, 0, 0, 1)
+	if tcpAddr, ok := localConn.LocalAddr().(*net.TCPAddr); ok {
+		bindIP = tcpAddr.IP
+	}
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: bindIP})
+	if err != nil {
+		logger.Errorf("Proxy", "UDP associate bind failed: %v", err)
+		localConn.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer udpConn.Close()
+
+	bound := udpConn.LocalAddr().(*net.UDPAddr)
+	bndAddr, err := protocol.ToSocksAddr(bound.IP.String(), bound.Port)
+	if err != nil {
+		return
+	}
+	if _, err := localConn.Write(append([]byte{0x05, 0x00, 0x00}, bndAddr...)); err != nil {
+		return
+	}
+	logger.Debugf("Proxy", "UDP associate 已绑定: %s", bound)
+
+	relay := &udpRelay{
+		ctx:        ctx,
+		handler:    h,
+		dialer:     NewDialer(h.Config),
+		conn:       udpConn,
+		clientPort: clientPort,
+		sessions:   make(map[string]net.Conn),
+		frags:      newUDPReassembler(h.Config.UDPFragmentTimeout(), h.Config.MaxPacketSize()),
+	}
+	if tcpAddr, ok := localConn.RemoteAddr().(*net.TCPAddr); ok {
+		relay.clientIP = tcpAddr.IP
+	}
+	defer relay.closeAll()
+
+	// 控制连接关闭时关闭 UDP 端口，结束读取循环 (控制连接此后不再有数据，清除握手超时)
+	localConn.SetReadDeadline(time.Time{})
+	go func() {
+		io.Copy(io.Discard, localConn)
+		udpConn.Close()
+	}()
+
+	buf := GetPacketBuffer(packetBufferSize)
+	defer PutPacketBuffer(buf)
+	for {
+		n, from, err := udpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		relay.handlePacket(buf[:n], from)
+	}
+}
+
+// handlePacket 解析 SOCKS5 UDP 请求头并转发载荷
+// 格式: [RSV(2)][FRAG(1)][ATYP][DST.ADDR][DST.PORT][DATA]
+func (r *udpRelay) handlePacket(packet []byte, from *net.UDPAddr) {
+	if len(packet) < 4 || !r.acceptFrom(from) {
+		return
+	}
+
+	reader := bytes.NewReader(packet[3:])
+	targetHost, targetPort, err := protocol.ReadSocksAddr(reader)
+	if err != nil {
+		return
+	}
+	data := packet[len(packet)-reader.Len():]
+
+	// [新增] FRAG 非 0 时先重组，收齐整组后再作为一个数据报转发
+	if frag := packet[2]; frag != 0x00 {
+		var ok bool
+		if data, ok = r.frags.add(frag, targetHost, targetPort, data, time.Now()); !ok {
+			return
+		}
+	}
+
+	if !r.allowed(targetHost, targetPort) {
+		return
+	}
+
+	r.forward(targetHost, targetPort, data)
+}
+
+// acceptFrom 检查数据报来源：IP 须与控制连接的对端一致，请求指定了端口时端口也须一致；
+// 首个通过检查的来源固定为客户端地址，之后其他来源 (同一主机的其他端口) 的数据报一律丢弃
+func (r *udpRelay) acceptFrom(from *net.UDPAddr) bool {
+	if r.clientIP != nil && !r.clientIP.Equal(from.IP) {
+		logger.Debugf("Proxy", "丢弃 UDP 数据报: 来源 %s 与控制连接对端 %s 不符", from, r.clientIP)
+		return false
+	}
+	if r.clientPort != 0 && from.Port != r.clientPort {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.clientAddr == nil {
+		r.clientAddr = from
+		return true
+	}
+	return r.clientAddr.IP.Equal(from.IP) && r.clientAddr.Port == from.Port
+}
+
+// [新增] handleUDPOverTCP 处理 UDP over TCP 方式的关联：应答后控制连接改为承载分帧的数据报，
+// 连接关闭时结束整个关联
+func (h *Handler) handleUDPOverTCP(ctx context.Context, localConn net.Conn) {
+	if _, err := localConn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+	logger.Debugf("Proxy", "UDP associate 使用 UDP over TCP: %s", localConn.RemoteAddr())
+	// 控制连接此后持续承载数据，清除握手超时
+	localConn.SetReadDeadline(time.Time{})
+
+	pc, err := protocol.NewSocks5UDPConn(localConn, "0.0.0.0", 0)
+	if err != nil {
+		return
+	}
+	pc.MaxPacketSize = h.Config.MaxPacketSize()
+
+	relay := &udpRelay{
+		ctx:      ctx,
+		handler:  h,
+		dialer:   NewDialer(h.Config),
+		sessions: make(map[string]net.Conn),
+		tcp:      pc,
+	}
+	defer relay.closeAll()
+
+	buf := GetPacketBuffer(packetBufferSize)
+	defer PutPacketBuffer(buf)
+	for {
+		n, targetHost, targetPort, err := pc.ReadPacket(buf)
+		if err != nil {
+			return
+		}
+		if relay.allowed(targetHost, targetPort) {
+			relay.forward(targetHost, targetPort, buf[:n])
+		}
+	}
+}
+
+// allowed 检查端口策略与路由规则，被拒绝的数据报直接丢弃
+func (r *udpRelay) allowed(targetHost string, targetPort int) bool {
+	if !r.handler.Ports.Allowed(targetPort) {
+		logger.Infof("Policy", "丢弃 UDP 数据 %s:%d: 目标端口不在允许范围内", targetHost, targetPort)
+		return false
+	}
+	return r.handler.Router.Match(targetHost, targetPort) != config.OutboundBlock
+}
+
+// forward 经目标的隧道发送一个数据报
+func (r *udpRelay) forward(targetHost string, targetPort int, data []byte) {
+	remote, err := r.session(targetHost, targetPort)
+	if err != nil {
+		logger.Errorf("Proxy", "UDP tunnel to %s:%d failed: %v", targetHost, targetPort, err)
+		return
+	}
+	if _, err := remote.Write(data); err != nil {
+		r.drop(net.JoinHostPort(targetHost, strconv.Itoa(targetPort)), remote)
+	}
+}
+
+// session 获取或建立到目标的隧道
+func (r *udpRelay) session(targetHost string, targetPort int) (net.Conn, error) {
+	key := net.JoinHostPort(targetHost, strconv.Itoa(targetPort))
+
+	r.mu.Lock()
+	remote, ok := r.sessions[key]
+	r.mu.Unlock()
+	if ok {
+		return remote, nil
+	}
+
+	var err error
+	if r.handler.Router.Match(targetHost, targetPort) == config.OutboundDirect {
+		remote, err = r.dialer.DialDirectTarget("udp", targetHost, targetPort)
+	} else {
+		remote, err = r.dialer.DialUDP(r.ctx, targetHost, targetPort)
+	}
+	if err != nil {
+		return nil, err
+	}
+	remote = stats.WrapConn(remote, true, targetHost, targetPort)
+
+	r.mu.Lock()
+	r.sessions[key] = remote
+	r.mu.Unlock()
+
+	go r.copyRemoteToClient(key, remote, targetHost, targetPort)
+	return remote, nil
+}
+
+// copyRemoteToClient 将隧道返回的数据加上 SOCKS5 UDP 头后发回客户端
+func (r *udpRelay) copyRemoteToClient(key string, remote net.Conn, targetHost string, targetPort int) {
+	defer r.drop(key, remote)
+
+	// [新增] UDP over TCP: 按分帧格式写回控制连接
+	if r.tcp != nil {
+		buf := GetPacketBuffer(packetBufferSize)
+		defer PutPacketBuffer(buf)
+		for {
+			remote.SetReadDeadline(time.Now().Add(udpAssociateTimeout))
+			n, err := remote.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := r.tcp.WritePacket(buf[:n], targetHost, targetPort); err != nil {
+				return
+			}
+		}
+	}
+
+	header, err := protocol.ToSocksAddr(targetHost, targetPort)
+	if err != nil {
+		return
+	}
+	header = append([]byte{0x00, 0x00, 0x00}, header...)
+
+	// 数据报读入头部之后的位置，发送时无需再拷贝 (加上头部后仍需能放入一个 UDP 数据报)
+	buf := GetPacketBuffer(packetBufferSize)
+	defer PutPacketBuffer(buf)
+	copy(buf, header)
+	for {
+		remote.SetReadDeadline(time.Now().Add(udpAssociateTimeout))
+		n, err := remote.Read(buf[len(header):])
+		if err != nil {
+			return
+		}
+
+		r.mu.Lock()
+		client := r.clientAddr
+		r.mu.Unlock()
+		if client == nil {
+			continue
+		}
+
+		if _, err := r.conn.WriteToUDP(buf[:len(header)+n], client); err != nil {
+			return
+		}
+	}
+}
+
+func (r *udpRelay) drop(key string, remote net.Conn) {
+	r.mu.Lock()
+	if r.sessions[key] == remote {
+		delete(r.sessions, key)
+	}
+	r.mu.Unlock()
+	remote.Close()
+}
+
+func (r *udpRelay) closeAll() {
+	r.mu.Lock()
+	sessions := r.sessions
+	r.sessions = make(map[string]net.Conn)
+	r.mu.Unlock()
+
+	for _, remote := range sessions {
+		remote.Close()
+	}
+}
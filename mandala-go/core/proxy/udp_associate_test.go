@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"mandala/core/protocol"
+)
+
+// startUDPEcho 启动回显数据报的 UDP 服务
+func startUDPEcho(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return echo.LocalAddr().(*net.UDPAddr)
+}
+
+// associate 经本地 SOCKS5 入站发起 UDP ASSOCIATE，返回中继端口地址；控制连接在测试结束时关闭
+func associate(t *testing.T, clientPort int) *net.UDPAddr {
+	t.Helper()
+	ctrl, err := net.Dial("tcp", serveInbound(t, newDirectHandler(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ctrl.Close() })
+	ctrl.SetDeadline(time.Now().Add(5 * time.Second))
+	ctrl.Write([]byte{0x05, 0x01, 0x00})
+	if _, err := io.ReadFull(ctrl, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := protocol.ToSocksAddr("127.0.0.1", clientPort)
+	ctrl.Write(append([]byte{0x05, 0x03, 0x00}, req...))
+	resp := make([]byte, 3)
+	if _, err := io.ReadFull(ctrl, resp); err != nil || resp[1] != 0x00 {
+		t.Fatalf("associate reply %v: %v", resp, err)
+	}
+	host, port, err := protocol.ReadSocksAddr(ctrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &net.UDPAddr{IP: net.ParseIP(host), Port: port}
+}
+
+// exchange 发送一个发往 target 的数据报，返回 timeout 内收到的回包载荷 (未收到时为 nil)
+func exchange(t *testing.T, c *net.UDPConn, relay, target *net.UDPAddr, payload string, timeout time.Duration) []byte {
+	t.Helper()
+	hdr, _ := protocol.ToSocksAddr(target.IP.String(), target.Port)
+	packet := append(append([]byte{0x00, 0x00, 0x00}, hdr...), payload...)
+	if _, err := c.WriteToUDP(packet, relay); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	c.SetReadDeadline(time.Now().Add(timeout))
+	n, _, err := c.ReadFromUDP(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[3+len(hdr) : n]
+}
+
+func listenLocalUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestUDPAssociatePinsFirstSender(t *testing.T) {
+	target := startUDPEcho(t)
+	relay := associate(t, 0)
+
+	client := listenLocalUDP(t)
+	if got := exchange(t, client, relay, target, "first", 2*time.Second); string(got) != "first" {
+		t.Fatalf("client reply = %q, want %q", got, "first")
+	}
+
+	// 同一主机的其他端口不能借用关联，也不能抢走回包
+	other := listenLocalUDP(t)
+	if got := exchange(t, other, relay, target, "hijack", 300*time.Millisecond); got != nil {
+		t.Fatalf("datagram from a second sender was relayed: %q", got)
+	}
+	if got := exchange(t, client, relay, target, "again", 2*time.Second); string(got) != "again" {
+		t.Fatalf("client reply after hijack attempt = %q, want %q", got, "again")
+	}
+}
+
+func TestUDPAssociateRequestedPort(t *testing.T) {
+	target := startUDPEcho(t)
+	client := listenLocalUDP(t)
+	other := listenLocalUDP(t)
+	relay := associate(t, client.LocalAddr().(*net.UDPAddr).Port)
+
+	// 请求中指定了端口时，其他端口即使先发送也不会被接受
+	if got := exchange(t, other, relay, target, "hijack", 300*time.Millisecond); got != nil {
+		t.Fatalf("datagram from an unannounced port was relayed: %q", got)
+	}
+	if got := exchange(t, client, relay, target, "ok", 2*time.Second); string(got) != "ok" {
+		t.Fatalf("client reply = %q, want %q", got, "ok")
+	}
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"mandala/core/protocol"
+)
+
+// FullConeAddrReserve 全锥形隧道读取数据报时在缓冲区开头为 SOCKS5 IP 地址预留的长度 (ATYP + IPv6 + 端口)
+const FullConeAddrReserve = 1 + 16 + 2
+
+// addrPacketConn 逐包携带地址的数据报连接 (Trojan / Mandala UDP、XUDP、SOCKS5 UDP)
+type addrPacketConn interface {
+	WritePacket(b []byte, host string, port int) (int, error)
+	ReadPacket(b []byte) (int, string, int, error)
+}
+
+// SupportsFullConeUDP 节点协议的 UDP 隧道能否逐包携带目标地址 (全锥形 NAT)
+func (d *Dialer) SupportsFullConeUDP() bool {
+	if d.Config.UsesQUIC() || d.Config.UseSingMux() {
+		return false
+	}
+	switch strings.ToLower(d.Config.Type) {
+	case "trojan", "mandala", "vless", "socks", "socks5":
+		return true
+	}
+	return false
+}
+
+// DialUDPFullCone 建立全锥形 UDP 隧道，同一隧道可发往任意目标 (握手使用 targetHost:targetPort)
+// 每次 Write 发送一个数据报，格式为 [ATYP][ADDR][PORT][DATA] (SOCKS5 地址 + 载荷)；
+// 每次 Read 以同样格式返回一个数据报，地址为回包的来源
+func (d *Dialer) DialUDPFullCone(ctx context.Context, targetHost string, targetPort int) (net.Conn, error) {
+	if !d.SupportsFullConeUDP() {
+		return nil, fmt.Errorf("full-cone udp is not supported by %s", d.Config.Type)
+	}
+	conn, err := d.DialUDP(ctx, targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	pc, ok := conn.(addrPacketConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("full-cone udp is not supported by %s", d.Config.Type)
+	}
+	return &fullConeConn{Conn: conn, pc: pc}, nil
+}
+
+// fullConeConn 将逐包地址转换为 SOCKS5 地址前缀，使隧道仍可按 net.Conn 使用 (流量统计等包装层无需改动)
+type fullConeConn struct {
+	net.Conn
+	pc addrPacketConn
+}
+
+func (c *fullConeConn) Write(b []byte) (int, error) {
+	r := bytes.NewReader(b)
+	host, port, err := protocol.ReadSocksAddr(r)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.pc.WritePacket(b[len(b)-r.Len():], host, port); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read 载荷读入预留的地址空间之后，再在前面填入来源地址；
+// 来源为域名且地址长于预留空间时，载荷末尾超出缓冲区的部分被截断
+func (c *fullConeConn) Read(b []byte) (int, error) {
+	if len(b) <= FullConeAddrReserve {
+		return 0, io.ErrShortBuffer
+	}
+	n, host, port, err := c.pc.ReadPacket(b[FullConeAddrReserve:])
+	if err != nil {
+		return 0, err
+	}
+	addr, err := protocol.ToSocksAddr(host, port)
+	if err != nil {
+		return 0, err
+	}
+	if len(addr)+n > len(b) {
+		if n = len(b) - len(addr); n < 0 {
+			return 0, io.ErrShortBuffer
+		}
+	}
+	copy(b[len(addr):], b[FullConeAddrReserve:FullConeAddrReserve+n])
+	copy(b, addr)
+	return len(addr) + n, nil
+}
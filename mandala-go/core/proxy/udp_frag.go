@@ -0,0 +1,69 @@
+package proxy
+
+import "time"
+
+// udpReassembler 重组 SOCKS5 UDP 分片 (RFC 1928 第 7 节)
+// FRAG 低 7 位为分片序号 (从 1 开始)，最高位置 1 表示该组的最后一个分片。
+// 同一时刻只维护一组分片：序号不连续、目标改变、超时或超过长度上限时丢弃整组。
+// 仅由关联的读取循环调用，无需加锁
+type udpReassembler struct {
+	timeout time.Duration // 0 表示不重组，丢弃全部分片
+	maxSize int           // 重组后数据报的最大长度
+
+	pos      byte // 已收到的最后一个分片序号，0 表示队列为空
+	host     string
+	port     int
+	data     []byte
+	deadline time.Time
+}
+
+func newUDPReassembler(timeout time.Duration, maxSize int) *udpReassembler {
+	return &udpReassembler{timeout: timeout, maxSize: maxSize}
+}
+
+// add 加入一个分片 (frag 为非 0 的 FRAG 字段)，组内分片收齐时返回完整数据报
+// data 会被复制，调用方可复用其缓冲区
+func (q *udpReassembler) add(frag byte, host string, port int, data []byte, now time.Time) ([]byte, bool) {
+	if q.timeout <= 0 {
+		return nil, false
+	}
+	if q.pos != 0 && now.After(q.deadline) {
+		q.reset()
+	}
+
+	pos := frag & 0x7F
+	switch {
+	case pos == 0:
+		return nil, false
+	case pos == 1:
+		// 新的一组开始，放弃未完成的旧组
+		q.reset()
+		q.host, q.port = host, port
+		q.deadline = now.Add(q.timeout)
+	case pos != q.pos+1 || host != q.host || port != q.port:
+		// 乱序、缺片或与当前组目标不符
+		q.reset()
+		return nil, false
+	}
+
+	if len(q.data)+len(data) > q.maxSize {
+		q.reset()
+		return nil, false
+	}
+	q.data = append(q.data, data...)
+	q.pos = pos
+
+	if frag&0x80 == 0 {
+		return nil, false
+	}
+	packet := q.data
+	q.data = nil
+	q.reset()
+	return packet, true
+}
+
+func (q *udpReassembler) reset() {
+	q.pos = 0
+	q.host, q.port = "", 0
+	q.data = q.data[:0]
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// fragStep 一个分片及加入后期望的结果 (want 为 nil 表示尚未收齐或被丢弃)
+type fragStep struct {
+	frag  byte
+	host  string
+	data  string
+	after time.Duration // 相对起始时间
+	want  *string
+}
+
+func done(s string) *string { return &s }
+
+func TestUDPReassembler(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		timeout time.Duration
+		maxSize int
+		steps   []fragStep
+	}{
+		{"in order", time.Second, 64, []fragStep{
+			{frag: 1, host: "a", data: "he"},
+			{frag: 2, host: "a", data: "ll"},
+			{frag: 0x83, host: "a", data: "o", want: done("hello")},
+		}},
+		{"single last fragment", time.Second, 64, []fragStep{
+			{frag: 0x81, host: "a", data: "x", want: done("x")},
+		}},
+		{"gap drops group", time.Second, 64, []fragStep{
+			{frag: 1, host: "a", data: "he"},
+			{frag: 0x83, host: "a", data: "o"},
+			{frag: 0x82, host: "a", data: "ll"},
+		}},
+		{"target change drops group", time.Second, 64, []fragStep{
+			{frag: 1, host: "a", data: "he"},
+			{frag: 0x82, host: "b", data: "ll"},
+		}},
+		{"timeout resets", time.Second, 64, []fragStep{
+			{frag: 1, host: "a", data: "he"},
+			{frag: 0x82, host: "a", data: "ll", after: 2 * time.Second},
+		}},
+		{"size limit", time.Second, 3, []fragStep{
+			{frag: 1, host: "a", data: "he"},
+			{frag: 0x82, host: "a", data: "ll"},
+		}},
+		{"disabled", 0, 64, []fragStep{
+			{frag: 0x81, host: "a", data: "x"},
+		}},
+		{"new group restarts", time.Second, 64, []fragStep{
+			{frag: 1, host: "a", data: "old"},
+			{frag: 1, host: "a", data: "ne"},
+			{frag: 0x82, host: "a", data: "w", want: done("new")},
+		}},
+		{"reusable after completion", time.Second, 64, []fragStep{
+			{frag: 0x81, host: "a", data: "one", want: done("one")},
+			{frag: 1, host: "a", data: "tw"},
+			{frag: 0x82, host: "a", data: "o", want: done("two")},
+		}},
+	} {
+		q := newUDPReassembler(tc.timeout, tc.maxSize)
+		start := time.Now()
+		for i, s := range tc.steps {
+			// 调用方会复用缓冲区，分片内容须被复制
+			buf := []byte(s.data)
+			got, ok := q.add(s.frag, s.host, 53, buf, start.Add(s.after))
+			for j := range buf {
+				buf[j] = 0
+			}
+			if s.want == nil {
+				if ok {
+					t.Errorf("%s: step %d returned %q", tc.name, i, got)
+				}
+				continue
+			}
+			if !ok || string(got) != *s.want {
+				t.Errorf("%s: step %d = %q, %v, want %q", tc.name, i, got, ok, *s.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"mandala/core/config"
+	"mandala/core/protocol"
+)
+
+// dialUpstream 经上游代理 (settings.upstream_proxy) 建立到节点的 TCP 隧道
+// 节点地址以原始主机名交给上游代理解析 (企业网络中本地 DNS 往往不可用)；ctx 取消或超时时中止握手
+func (d *Dialer) dialUpstream(ctx context.Context) (net.Conn, error) {
+	proxyURL, err := config.ParseUpstreamProxy(d.Config.Settings.UpstreamProxy)
+	if err != nil {
+		return nil, fmt.Errorf("upstream_proxy: %v", err)
+	}
+
+	dialer := net.Dialer{KeepAlive: -1, Control: protectControl}
+	if keepAlive := d.Config.KeepAliveInterval(); keepAlive > 0 {
+		dialer.KeepAlive = keepAlive
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("upstream proxy %s: %w", proxyURL.Host, err)
+	}
+
+	// 握手期间 ctx 取消或超时时关闭连接，使阻塞的读写立即返回
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	tunnel, err := upstreamHandshake(ctx, conn, proxyURL, d.serverAddr())
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("upstream proxy %s: %w", proxyURL.Host, err)
+	}
+	return tunnel, nil
+}
+
+// upstreamHandshake 在到上游代理的连接上请求转发到 target (host:port)
+func upstreamHandshake(ctx context.Context, conn net.Conn, proxyURL *url.URL, target string) (net.Conn, error) {
+	username := proxyURL.User.Username()
+	password, _ := proxyURL.User.Password()
+
+	if proxyURL.Scheme == "socks5" {
+		host, portStr, _ := net.SplitHostPort(target)
+		port, _ := strconv.Atoi(portStr)
+		if err := protocol.HandshakeSocks5(conn, username, password, host, port); err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", target, resp.Status)
+	}
+	// 代理可能在响应后紧接着转发了节点数据，保留已缓冲的部分
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+)
+
+// startConnectProxy 启动本地 HTTP CONNECT 代理，targets 依次接收每个 CONNECT 请求的目标地址
+func startConnectProxy(t *testing.T) (addr string, targets <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ch := make(chan string, 8)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				req, err := http.ReadRequest(br)
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				ch <- req.Host
+				remote, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer remote.Close()
+				io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(remote, br)
+				io.Copy(c, remote)
+			}()
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+// startSocksUDPNode 在 ip 上启动只支持 UDP ASSOCIATE 的 SOCKS5 节点：
+// 应答的中继地址为未指定地址 (0.0.0.0)，中继回显收到的数据报
+func startSocksUDPNode(t *testing.T, ip string) (port int) {
+	t.Helper()
+	l, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", ip, err)
+	}
+	t.Cleanup(func() { l.Close() })
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { relay.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			relay.WriteToUDP(buf[:n], from)
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				// 问候 05 01 00 与 UDP ASSOCIATE 请求 (IPv4 0.0.0.0:0)
+				if _, err := io.ReadFull(c, make([]byte, 3)); err != nil {
+					return
+				}
+				c.Write([]byte{0x05, 0x00})
+				if _, err := io.ReadFull(c, make([]byte, 10)); err != nil {
+					return
+				}
+				reply := []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0}
+				c.Write(binary.BigEndian.AppendUint16(reply, uint16(relay.LocalAddr().(*net.UDPAddr).Port)))
+				io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// 经上游 CONNECT 代理连接节点，SOCKS5 UDP 中继地址为未指定地址时发往节点而非上游代理
+func TestUpstreamProxySocksUDP(t *testing.T) {
+	const nodeIP = "127.0.0.2"
+	nodePort := startSocksUDPNode(t, nodeIP)
+	proxyAddr, targets := startConnectProxy(t)
+
+	d := NewDialer(&config.OutboundConfig{Type: "socks", Server: nodeIP, ServerPort: nodePort})
+	d.Config.Settings.UpstreamProxy = "http://" + proxyAddr
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := d.DialUDP(ctx, "192.0.2.1", 53)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case target := <-targets:
+		if want := net.JoinHostPort(nodeIP, strconv.Itoa(nodePort)); target != want {
+			t.Fatalf("upstream CONNECT target = %s, want %s", target, want)
+		}
+	default:
+		t.Fatal("node was not reached through the upstream proxy")
+	}
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("relay echo = %q, %v", buf[:n], err)
+	}
+}
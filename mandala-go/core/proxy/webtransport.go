@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"mandala/core/logger"
+	"mandala/core/protocol"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+const (
+	// WebTransport 会话的 QUIC 空闲超时与保活间隔
+	webTransportIdleTimeout     = 30 * time.Second
+	webTransportKeepAlivePeriod = 10 * time.Second
+)
+
+// webTransportSession 一个已建立的 WebTransport 会话，每条隧道为会话内的一个双向流
+type webTransportSession struct {
+	conn      *quic.Conn
+	h3        *http3.ClientConn
+	req       *http3.RequestStream // CONNECT 请求流，会话存续期间保持打开
+	sessionID uint64
+	info      ConnInfo
+}
+
+var (
+	webTransportSessions   = make(map[string]*webTransportSession)
+	webTransportSessionsMu sync.Mutex
+)
+
+func closeWebTransportSessions() {
+	webTransportSessionsMu.Lock()
+	sessions := webTransportSessions
+	webTransportSessions = make(map[string]*webTransportSession)
+	webTransportSessionsMu.Unlock()
+
+	for _, s := range sessions {
+		s.conn.CloseWithError(0, "")
+	}
+}
+
+// usesWebTransport 传输层为 WebTransport：经 QUIC 建立会话，不走 TCP 拨号与 TLS 握手
+func (d *Dialer) usesWebTransport() bool {
+	return d.Config.Transport != nil && d.Config.Transport.Type == "webtransport"
+}
+
+func (d *Dialer) webTransportKey() string {
+	return d.serverAddr() + "|" + d.transportHost() + d.Config.Transport.Path
+}
+
+// dialWebTransport 在节点的共享 WebTransport 会话上打开一个双向流作为隧道
+// 新建会话时 QUIC 握手计入 TLS 阶段、CONNECT 请求计入升级阶段；测速使用独立会话，关闭流时一并关闭
+func (d *Dialer) dialWebTransport(ctx context.Context, st *DialStats, start time.Time) (net.Conn, error) {
+	if d.probe {
+		s, err := d.newWebTransportSession(ctx, st)
+		if err != nil {
+			return nil, err
+		}
+		openStart := time.Now()
+		conn, err := s.openStream(ctx, true)
+		st.Upgrade += time.Since(openStart)
+		if err != nil {
+			s.conn.CloseWithError(0, "")
+			return nil, err
+		}
+		d.recordDial(&s.info, st, start)
+		return conn, nil
+	}
+
+	// 共享会话可能恰好在此时断开，重试一次
+	var lastErr error
+	for i := 0; i < 2; i++ {
+		s, created, err := d.getWebTransportSession(st)
+		if err != nil {
+			return nil, err
+		}
+		openStart := time.Now()
+		conn, err := s.openStream(ctx, false)
+		st.Upgrade += time.Since(openStart)
+		if err == nil {
+			if created {
+				info := s.info
+				d.recordDial(&info, st, start)
+			}
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		d.dropWebTransportSession(s)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("webtransport open stream failed: %v", lastErr)
+}
+
+// getWebTransportSession 返回节点的共享会话，不存在或已断开时新建 (created 为 true)
+// 会话由多条隧道共享，其建立过程不受单个调用方的 ctx 控制
+func (d *Dialer) getWebTransportSession(st *DialStats) (*webTransportSession, bool, error) {
+	key := d.webTransportKey()
+
+	webTransportSessionsMu.Lock()
+	defer webTransportSessionsMu.Unlock()
+	if s, ok := webTransportSessions[key]; ok && s.conn.Context().Err() == nil {
+		return s, false, nil
+	}
+	s, err := d.newWebTransportSession(context.Background(), st)
+	if err != nil {
+		return nil, false, err
+	}
+	webTransportSessions[key] = s
+	return s, true, nil
+}
+
+// dropWebTransportSession 从共享池中移除已失效的会话
+func (d *Dialer) dropWebTransportSession(s *webTransportSession) {
+	key := d.webTransportKey()
+	webTransportSessionsMu.Lock()
+	if webTransportSessions[key] == s {
+		delete(webTransportSessions, key)
+	}
+	webTransportSessionsMu.Unlock()
+	s.conn.CloseWithError(0, "")
+}
+
+// newWebTransportSession 建立 QUIC 连接并发送扩展 CONNECT 请求
+// 请求的 Host 与路径沿用 transport 配置 (paths 中随机选用一个)，自定义头随请求发出
+func (d *Dialer) newWebTransportSession(ctx context.Context, st *DialStats) (*webTransportSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.dialTimeout())
+	defer cancel()
+
+	handshakeStart := time.Now()
+	conn, err := d.dialQUIC(ctx, []string{http3.NextProtoH3}, &quic.Config{
+		EnableDatagrams: true,
+		MaxIdleTimeout:  webTransportIdleTimeout,
+		KeepAlivePeriod: webTransportKeepAlivePeriod,
+	})
+	st.TLS += time.Since(handshakeStart)
+	if err != nil {
+		return nil, err
+	}
+
+	upgradeStart := time.Now()
+	s, err := d.connectWebTransport(ctx, conn)
+	st.Upgrade += time.Since(upgradeStart)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+
+	// 服务端关闭会话 (关闭请求流) 时结束整个连接，使后续拨号重建会话
+	go func() {
+		io.Copy(io.Discard, s.req)
+		conn.CloseWithError(0, "")
+	}()
+
+	if !d.probe {
+		logger.Infof("WebTransport", "新建会话 -> %s%s", d.serverAddr(), d.Config.Transport.Path)
+	}
+	return s, nil
+}
+
+// connectWebTransport 等待服务端 SETTINGS 确认支持 WebTransport，再发送 CONNECT 请求建立会话
+func (d *Dialer) connectWebTransport(ctx context.Context, conn *quic.Conn) (*webTransportSession, error) {
+	h3 := (&http3.Transport{
+		EnableDatagrams:    true,
+		AdditionalSettings: map[uint64]uint64{protocol.WebTransportSettingEnable: 1},
+	}).NewClientConn(conn)
+
+	select {
+	case <-h3.ReceivedSettings():
+	case <-ctx.Done():
+		return nil, fmt.Errorf("webtransport: waiting for server settings: %w", ctx.Err())
+	case <-conn.Context().Done():
+		return nil, fmt.Errorf("webtransport: connection closed before settings: %w", context.Cause(conn.Context()))
+	}
+	settings := h3.Settings()
+	if !settings.EnableExtendedConnect {
+		return nil, fmt.Errorf("webtransport: server does not support extended CONNECT")
+	}
+	if settings.Other[protocol.WebTransportSettingEnable] != 1 {
+		return nil, fmt.Errorf("webtransport: server does not support webtransport")
+	}
+
+	req, err := protocol.BuildWebTransportRequest(d.transportHost(), d.wsConfigPath(), d.Config.Transport.Headers)
+	if err != nil {
+		return nil, err
+	}
+	str, err := h3.OpenRequestStream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("webtransport: open request stream: %w", err)
+	}
+	if err := str.SendRequestHeader(req); err != nil {
+		str.CancelRead(0)
+		str.CancelWrite(0)
+		return nil, fmt.Errorf("webtransport: send CONNECT: %w", err)
+	}
+	// ReadResponse 不感知 ctx，超时后取消流使其返回
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			str.CancelRead(0)
+			str.CancelWrite(0)
+		case <-stop:
+		}
+	}()
+	resp, err := str.ReadResponse()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("webtransport: CONNECT failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		str.CancelRead(0)
+		str.CancelWrite(0)
+		return nil, fmt.Errorf("webtransport: CONNECT rejected: status %d", resp.StatusCode)
+	}
+
+	info := d.newQUICConnInfo(conn)
+	info.Transport = "webtransport"
+	return &webTransportSession{
+		conn:      conn,
+		h3:        h3,
+		req:       str,
+		sessionID: uint64(str.StreamID()),
+		info:      *info,
+	}, nil
+}
+
+// openStream 在会话内打开一个双向流并写入流头；owned 为 true 时关闭流同时关闭会话的 QUIC 连接
+func (s *webTransportSession) openStream(ctx context.Context, owned bool) (net.Conn, error) {
+	stream, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stream.Write(protocol.BuildWebTransportStreamHeader(s.sessionID)); err != nil {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		return nil, err
+	}
+	return &quicStreamConn{Stream: stream, conn: s.conn, owned: owned}, nil
+}
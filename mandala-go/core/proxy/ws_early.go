@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// [新增] wsConfigPath 返回本条连接使用的配置路径：配置了 paths 时随机选用一个
+func (d *Dialer) wsConfigPath() string {
+	if paths := d.Config.Transport.Paths; len(paths) > 0 {
+		return paths[rand.Intn(len(paths))]
+	}
+	return d.Config.Transport.Path
+}
+
+// wsPathAndEarlyData 由配置路径得出实际请求的 WebSocket 路径与早期数据上限
+// path 中的 "ed" 参数仅用于客户端配置，会从请求路径中剔除；EarlyDataSize 优先
+func (d *Dialer) wsPathAndEarlyData(path string) (string, int) {
+	if path == "" {
+		path = "/"
+	}
+	size := d.Config.Transport.EarlyDataSize
+
+	if u, err := url.Parse(path); err == nil {
+		q := u.Query()
+		if ed := q.Get("ed"); ed != "" {
+			if n, err := strconv.Atoi(ed); err == nil && size == 0 {
+				size = n
+			}
+			q.Del("ed")
+			u.RawQuery = q.Encode()
+			path = u.String()
+			if !strings.HasPrefix(path, "/") {
+				path = "/" + path
+			}
+		}
+	}
+
+	if size < 0 {
+		size = 0
+	}
+	return path, size
+}
+
+// wsEarlyConn 延迟 WebSocket 升级直到首次写入，
+// 首次写入的前 maxEarly 字节编码进升级请求，其余部分作为普通帧发送
+type wsEarlyConn struct {
+	net.Conn // 已完成 TLS 握手的底层连接，仅用于地址信息与关闭
+	d        *Dialer
+	path     string
+	maxEarly int
+
+	mu    sync.Mutex
+	done  bool
+	ready chan struct{}
+	ws    net.Conn
+	err   error
+}
+
+func newWSEarlyConn(d *Dialer, conn net.Conn, path string, maxEarly int) *wsEarlyConn {
+	return &wsEarlyConn{Conn: conn, d: d, path: path, maxEarly: maxEarly, ready: make(chan struct{})}
+}
+
+func (c *wsEarlyConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	if !c.done {
+		early := b
+		if len(early) > c.maxEarly {
+			early = early[:c.maxEarly]
+		}
+		c.ws, c.err = c.d.upgradeWebsocket(context.Background(), c.Conn, c.path, early)
+		c.done = true
+		close(c.ready)
+		c.mu.Unlock()
+
+		if c.err != nil {
+			return 0, c.err
+		}
+		if rest := b[len(early):]; len(rest) > 0 {
+			if _, err := c.ws.Write(rest); err != nil {
+				return len(early), err
+			}
+		}
+		return len(b), nil
+	}
+	c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.ws.Write(b)
+}
+
+// Read 在升级完成前阻塞 (服务端在收到首包前不会返回数据)
+func (c *wsEarlyConn) Read(b []byte) (int, error) {
+	<-c.ready
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.ws.Read(b)
+}
+
+func (c *wsEarlyConn) Close() error {
+	// 先关闭底层连接，使进行中的升级请求立即失败
+	err := c.Conn.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.done {
+		c.done = true
+		c.err = net.ErrClosed
+		close(c.ready)
+	} else if c.ws != nil {
+		c.ws.Close()
+	}
+	return err
+}
+
+func (c *wsEarlyConn) SetDeadline(t time.Time) error {
+	if ws := c.upgraded(); ws != nil {
+		return ws.SetDeadline(t)
+	}
+	return nil
+}
+
+func (c *wsEarlyConn) SetReadDeadline(t time.Time) error {
+	if ws := c.upgraded(); ws != nil {
+		return ws.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *wsEarlyConn) SetWriteDeadline(t time.Time) error {
+	if ws := c.upgraded(); ws != nil {
+		return ws.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+func (c *wsEarlyConn) upgraded() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/coder/websocket"
+)
+
+// ErrWSClosed 服务端 (或 CDN) 发送 Close 帧正常关闭了 WebSocket 隧道
+// 与底层连接异常中断 (如 "failed to read frame header: EOF"、连接重置) 区分开，后者原样返回
+var ErrWSClosed = errors.New("websocket closed by peer")
+
+// WSConn WebSocket 隧道连接，读取时将对端的 Close 帧转换为 ErrWSClosed
+// (状态码为 1000 / 1001 时直接返回 ErrWSClosed，其他状态码附带状态码与原因)
+type WSConn struct {
+	net.Conn
+	closeErr error // 收到 Close 帧后，之后的读取均返回同一错误
+}
+
+func newWSConn(ws *websocket.Conn) *WSConn {
+	return &WSConn{Conn: websocket.NetConn(context.Background(), ws, websocket.MessageBinary)}
+}
+
+func (c *WSConn) Read(b []byte) (int, error) {
+	if c.closeErr != nil {
+		return 0, c.closeErr
+	}
+	n, err := c.Conn.Read(b)
+	if err == nil {
+		return n, nil
+	}
+	// NetConn 将正常关闭 (1000 / 1001) 转换为 io.EOF，底层连接的 EOF 则以包装后的错误返回
+	var ce websocket.CloseError
+	switch {
+	case err == io.EOF:
+		c.closeErr = ErrWSClosed
+	case errors.As(err, &ce):
+		c.closeErr = fmt.Errorf("%w (status %d: %s)", ErrWSClosed, ce.Code, ce.Reason)
+	default:
+		return n, err
+	}
+	return n, c.closeErr
+}
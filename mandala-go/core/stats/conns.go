@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mandala/core/logger"
+)
+
+// Connection 单条活跃连接的快照
+type Connection struct {
+	ID       uint64 `json:"id"`
+	Network  string `json:"network"` // tcp 或 udp
+	Target   string `json:"target"`  // host:port
+	Start    int64  `json:"start"`   // 建立时间 (Unix 毫秒)
+	Upload   int64  `json:"upload"`
+	Download int64  `json:"download"`
+}
+
+var (
+	connID   atomic.Uint64
+	connsMap sync.Map // id -> *TrackedConn
+)
+
+// TrackedConn 包装远程连接：存活期间登记在活跃连接表中，并记录本连接的上下行字节数
+type TrackedConn struct {
+	net.Conn
+	id        uint64
+	network   string
+	target    string
+	start     time.Time
+	upload    atomic.Int64
+	download  atomic.Int64
+	closeOnce sync.Once
+}
+
+// NewTrackedConn 创建并登记一条活跃连接，Close 时自动移除
+func NewTrackedConn(c net.Conn, network, host string, port int) *TrackedConn {
+	tc := &TrackedConn{
+		Conn:    c,
+		id:      connID.Add(1),
+		network: network,
+		target:  net.JoinHostPort(host, strconv.Itoa(port)),
+		start:   time.Now(),
+	}
+	connsMap.Store(tc.id, tc)
+	logger.Debugf("Conn", "#%d %s %s 已建立", tc.id, network, tc.target)
+	return tc
+}
+
+// WrapConn 为远程连接套上全部统计：全局流量与活跃数、按目标流量、活跃连接表
+func WrapConn(c net.Conn, udp bool, host string, port int) net.Conn {
+	network := "tcp"
+	if udp {
+		network = "udp"
+	}
+	return NewTrackedConn(NewDestinationConn(NewTrafficConn(c, udp), host), network, host, port)
+}
+
+func (c *TrackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.download.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *TrackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.upload.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *TrackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		connsMap.Delete(c.id)
+		logger.Debugf("Conn", "#%d %s %s 已关闭 (上行 %d, 下行 %d, 持续 %s)",
+			c.id, c.network, c.target, c.upload.Load(), c.download.Load(), time.Since(c.start).Round(time.Millisecond))
+	})
+	return c.Conn.Close()
+}
+
+func (c *TrackedConn) snapshot() Connection {
+	return Connection{
+		ID:       c.id,
+		Network:  c.network,
+		Target:   c.target,
+		Start:    c.start.UnixMilli(),
+		Upload:   c.upload.Load(),
+		Download: c.download.Load(),
+	}
+}
+
+// ListConnections 返回当前活跃连接 (按建立顺序)
+func ListConnections() []Connection {
+	list := make([]Connection, 0)
+	connsMap.Range(func(_, v interface{}) bool {
+		list = append(list, v.(*TrackedConn).snapshot())
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// maxDestinations 限制聚合表的条目数，防止长时间运行后无限增长
+const maxDestinations = 1024
+
+// Destination 单个目标的累计流量
+type Destination struct {
+	Host  string `json:"host"`
+	Bytes int64  `json:"bytes"`
+}
+
+// destCounter 单个目标的计数：字节数以原子操作累加，refs 为引用该计数的活跃连接数 (由 destMu 保护)
+type destCounter struct {
+	bytes atomic.Int64
+	refs  int
+}
+
+var (
+	destMu    sync.Mutex
+	destBytes = make(map[string]*destCounter)
+)
+
+// acquireDestination 返回 host 的计数并增加引用；调用方关闭连接时需调用 releaseDestination
+func acquireDestination(host string) *destCounter {
+	destMu.Lock()
+	defer destMu.Unlock()
+	c := counterLocked(host)
+	c.refs++
+	return c
+}
+
+func releaseDestination(c *destCounter) {
+	destMu.Lock()
+	c.refs--
+	destMu.Unlock()
+}
+
+// counterLocked 返回 host 的计数，不存在时创建；调用方需持有 destMu
+func counterLocked(host string) *destCounter {
+	c, ok := destBytes[host]
+	if !ok {
+		if len(destBytes) >= maxDestinations {
+			evictSmallestLocked()
+		}
+		c = &destCounter{}
+		destBytes[host] = c
+	}
+	return c
+}
+
+// AddDestinationBytes 累加某个目标 (域名或 IP) 的流量
+func AddDestinationBytes(host string, n int64) {
+	if host == "" || n <= 0 {
+		return
+	}
+
+	destMu.Lock()
+	c := counterLocked(host)
+	destMu.Unlock()
+	c.bytes.Add(n)
+}
+
+// evictSmallestLocked 淘汰流量最小的条目，优先淘汰没有活跃连接的条目；调用方需持有 destMu
+func evictSmallestLocked() {
+	var minHost string
+	var minBytes int64 = -1
+	minActive := true
+	for h, c := range destBytes {
+		b, active := c.bytes.Load(), c.refs > 0
+		if minBytes < 0 || (minActive && !active) || (minActive == active && b < minBytes) {
+			minHost, minBytes, minActive = h, b, active
+		}
+	}
+	delete(destBytes, minHost)
+}
+
+// TopDestinations 返回流量最大的 n 个目标 (按字节数降序)
+func TopDestinations(n int) []Destination {
+	destMu.Lock()
+	list := make([]Destination, 0, len(destBytes))
+	for h, c := range destBytes {
+		list = append(list, Destination{Host: h, Bytes: c.bytes.Load()})
+	}
+	destMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Bytes == list[j].Bytes {
+			return list[i].Host < list[j].Host
+		}
+		return list[i].Bytes > list[j].Bytes
+	})
+
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// ResetDestinations 清空目标流量统计 (重置前建立的连接之后的流量不再计入)
+func ResetDestinations() {
+	destMu.Lock()
+	destBytes = make(map[string]*destCounter)
+	destMu.Unlock()
+}
+
+// DestinationConn 包装远程连接，按目标统计上下行字节数
+// [修改] 建立时取得目标的计数，读写时只做原子累加，不再每次读写都获取全局锁
+type DestinationConn struct {
+	net.Conn
+	counter   *destCounter // host 为空时为 nil，不统计
+	closeOnce sync.Once
+}
+
+// NewDestinationConn 创建按目标统计流量的连接包装
+func NewDestinationConn(c net.Conn, host string) *DestinationConn {
+	dc := &DestinationConn{Conn: c}
+	if host != "" {
+		dc.counter = acquireDestination(host)
+	}
+	return dc
+}
+
+func (c *DestinationConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.counter != nil {
+		c.counter.bytes.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *DestinationConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 && c.counter != nil {
+		c.counter.bytes.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *DestinationConn) Close() error {
+	if c.counter != nil {
+		c.closeOnce.Do(func() { releaseDestination(c.counter) })
+	}
+	return c.Conn.Close()
+}
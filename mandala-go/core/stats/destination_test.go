@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func destinationBytes(host string) int64 {
+	for _, d := range TopDestinations(0) {
+		if d.Host == host {
+			return d.Bytes
+		}
+	}
+	return -1
+}
+
+func TestDestinationConnCounts(t *testing.T) {
+	ResetDestinations()
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := NewDestinationConn(a, "example.com")
+	go io.Copy(b, b)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			AddDestinationBytes("example.com", 10)
+		}()
+	}
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, make([]byte, 5))
+	wg.Wait()
+	conn.Close()
+
+	if got := destinationBytes("example.com"); got != 50 {
+		t.Fatalf("bytes = %d, want 50", got)
+	}
+}
+
+// 条目数达到上限时优先淘汰没有活跃连接的目标，即使其流量更大
+func TestDestinationEvictionKeepsActive(t *testing.T) {
+	ResetDestinations()
+	a, b := net.Pipe()
+	defer b.Close()
+	active := NewDestinationConn(a, "active.example")
+	defer active.Close()
+
+	AddDestinationBytes("idle.example", 1000)
+	for i := 0; len(TopDestinations(0)) < maxDestinations; i++ {
+		AddDestinationBytes("bulk"+strconv.Itoa(i)+".example", 500)
+	}
+	AddDestinationBytes("new.example", 1)
+
+	if destinationBytes("active.example") < 0 {
+		t.Fatal("destination with an active connection was evicted")
+	}
+	if destinationBytes("new.example") != 1 {
+		t.Fatal("new destination not recorded")
+	}
+	if n := len(TopDestinations(0)); n != maxDestinations {
+		t.Fatalf("len = %d, want %d", n, maxDestinations)
+	}
+}
@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Traffic 全局流量与连接数快照
+type Traffic struct {
+	UploadBytes   int64 `json:"uploadBytes"`
+	DownloadBytes int64 `json:"downloadBytes"`
+	ActiveTCP     int64 `json:"activeTCP"`
+	ActiveUDP     int64 `json:"activeUDP"`
+}
+
+var (
+	uploadBytes   atomic.Int64
+	downloadBytes atomic.Int64
+	activeTCP     atomic.Int64
+	activeUDP     atomic.Int64
+
+	// generation 每次重置后递增，重置前建立的连接关闭时不再扣减活跃数
+	generation atomic.Int64
+)
+
+// GetTraffic 返回当前计数快照
+func GetTraffic() Traffic {
+	return Traffic{
+		UploadBytes:   uploadBytes.Load(),
+		DownloadBytes: downloadBytes.Load(),
+		ActiveTCP:     activeTCP.Load(),
+		ActiveUDP:     activeUDP.Load(),
+	}
+}
+
+// ResetTraffic 清零全部计数 (核心停止时调用)
+func ResetTraffic() {
+	generation.Add(1)
+	uploadBytes.Store(0)
+	downloadBytes.Store(0)
+	activeTCP.Store(0)
+	activeUDP.Store(0)
+}
+
+// TrafficConn 包装远程连接：写入计为上行，读取计为下行，存活期间计入活跃连接数
+type TrafficConn struct {
+	net.Conn
+	active    *atomic.Int64
+	gen       int64
+	closeOnce sync.Once
+}
+
+// NewTrafficConn 创建计数连接，udp 决定计入 TCP 还是 UDP 活跃数
+func NewTrafficConn(c net.Conn, udp bool) *TrafficConn {
+	active := &activeTCP
+	if udp {
+		active = &activeUDP
+	}
+	active.Add(1)
+	return &TrafficConn{Conn: c, active: active, gen: generation.Load()}
+}
+
+func (c *TrafficConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		downloadBytes.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *TrafficConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		uploadBytes.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *TrafficConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.gen == generation.Load() {
+			c.active.Add(-1)
+		}
+	})
+	return c.Conn.Close()
+}
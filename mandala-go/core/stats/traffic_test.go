@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// echoPipe 返回一端回显写入数据的管道
+func echoPipe(t *testing.T) net.Conn {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { b.Close() })
+	go io.Copy(b, b)
+	return a
+}
+
+// 写入计为上行、读取计为下行；连接存活期间分别计入 TCP / UDP 活跃数，重复关闭只扣减一次
+func TestTrafficCounters(t *testing.T) {
+	ResetTraffic()
+	tcp := NewTrafficConn(echoPipe(t), false)
+	udp := NewTrafficConn(echoPipe(t), true)
+	if got := GetTraffic(); got.ActiveTCP != 1 || got.ActiveUDP != 1 {
+		t.Fatalf("active = %+v, want one TCP and one UDP", got)
+	}
+
+	for _, c := range []net.Conn{tcp, udp} {
+		if _, err := c.Write(make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(c, make([]byte, 600)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := GetTraffic(); got.UploadBytes != 2000 || got.DownloadBytes != 1200 {
+		t.Fatalf("traffic = %+v, want 2000 up and 1200 down", got)
+	}
+
+	tcp.Close()
+	tcp.Close()
+	if got := GetTraffic(); got.ActiveTCP != 0 || got.ActiveUDP != 1 {
+		t.Fatalf("active after close = %+v", got)
+	}
+	udp.Close()
+	if got := GetTraffic(); got.ActiveUDP != 0 {
+		t.Fatalf("active after close = %+v", got)
+	}
+}
+
+// 重置后关闭重置前建立的连接不会使活跃数变为负数
+func TestTrafficResetGeneration(t *testing.T) {
+	ResetTraffic()
+	old := NewTrafficConn(echoPipe(t), false)
+	old.Write([]byte("hello"))
+	ResetTraffic()
+	if got := GetTraffic(); got != (Traffic{}) {
+		t.Fatalf("after reset = %+v, want zero", got)
+	}
+
+	current := NewTrafficConn(echoPipe(t), false)
+	defer current.Close()
+	old.Close()
+	if got := GetTraffic(); got.ActiveTCP != 1 {
+		t.Fatalf("active = %d after closing a pre-reset connection, want 1", got.ActiveTCP)
+	}
+}
@@ -0,0 +1,186 @@
+package tun
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNS 缓存参数
+const (
+	defaultDNSCacheSize = 1024
+	// 否定应答 (NXDOMAIN / 无记录) 的缓存时长上限，SOA 未给出时使用默认值
+	maxNegativeTTL     = 30 * time.Second
+	defaultNegativeTTL = 10 * time.Second
+)
+
+// dnsCacheKey 缓存键: 域名 + 查询类型 + 查询类别
+type dnsCacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type dnsCacheEntry struct {
+	key     dnsCacheKey
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// DNSCache 按最小 TTL 缓存远程 DNS 应答，超出容量时淘汰最久未使用的条目
+type DNSCache struct {
+	mu      sync.Mutex
+	maxSize int
+	lru     *list.List // 头部为最近使用
+	entries map[dnsCacheKey]*list.Element
+}
+
+// NewDNSCache 创建 DNS 缓存，size 为 0 时使用默认容量，小于 0 时返回 nil (不缓存)
+func NewDNSCache(size int) *DNSCache {
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = defaultDNSCacheSize
+	}
+	return &DNSCache{
+		maxSize: size,
+		lru:     list.New(),
+		entries: make(map[dnsCacheKey]*list.Element),
+	}
+}
+
+// Get 返回与查询匹配且未过期的缓存应答 (已替换为查询的 ID，TTL 按剩余时间递减)
+func (c *DNSCache) Get(query []byte) []byte {
+	if c == nil {
+		return nil
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil {
+		return nil
+	}
+	key, ok := cacheKey(req)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil
+	}
+	entry := elem.Value.(*dnsCacheEntry)
+	now := time.Now()
+	if !now.Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		c.mu.Unlock()
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	resp := entry.msg.Copy()
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	c.mu.Unlock()
+
+	resp.Id = req.Id
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			if hdr.Ttl > elapsed {
+				hdr.Ttl -= elapsed
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
+
+	data, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Put 缓存远程应答；截断或出错 (NOERROR/NXDOMAIN 以外) 的应答不缓存
+func (c *DNSCache) Put(response []byte) {
+	if c == nil {
+		return
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(response); err != nil || msg.Truncated {
+		return
+	}
+	if msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError {
+		return
+	}
+	key, ok := cacheKey(msg)
+	if !ok {
+		return
+	}
+	ttl := responseTTL(msg)
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	entry := &dnsCacheEntry{key: key, msg: msg, stored: now, expires: now.Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dnsCacheEntry).key)
+	}
+}
+
+func cacheKey(msg *dns.Msg) (dnsCacheKey, bool) {
+	if len(msg.Question) != 1 {
+		return dnsCacheKey{}, false
+	}
+	q := msg.Question[0]
+	return dnsCacheKey{name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass}, true
+}
+
+// responseTTL 肯定应答取应答记录的最小 TTL；否定应答取 SOA 的最小 TTL 并限制上限
+func responseTTL(msg *dns.Msg) time.Duration {
+	if msg.Rcode == dns.RcodeSuccess && len(msg.Answer) > 0 {
+		minTTL := msg.Answer[0].Header().Ttl
+		for _, rr := range msg.Answer[1:] {
+			if ttl := rr.Header().Ttl; ttl < minTTL {
+				minTTL = ttl
+			}
+		}
+		return time.Duration(minTTL) * time.Second
+	}
+
+	ttl := defaultNegativeTTL
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			minTTL := soa.Minttl
+			if soa.Hdr.Ttl < minTTL {
+				minTTL = soa.Hdr.Ttl
+			}
+			ttl = time.Duration(minTTL) * time.Second
+			break
+		}
+	}
+	if ttl > maxNegativeTTL {
+		ttl = maxNegativeTTL
+	}
+	return ttl
+}
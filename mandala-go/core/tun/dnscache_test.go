@@ -0,0 +1,161 @@
+package tun
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func dnsQuery(name string, qtype uint16, id uint16) []byte {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.Id = id
+	b, _ := m.Pack()
+	return b
+}
+
+// dnsReply 构造对 name 的应答，f 填充记录与响应码
+func dnsReply(t *testing.T, name string, f func(m *dns.Msg)) []byte {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion(name, dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(req)
+	f(m)
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func aRecord(name string, ttl uint32) dns.RR {
+	return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: net.ParseIP("192.0.2.1")}
+}
+
+// entry 返回 name 的 A 记录缓存条目
+func (c *DNSCache) entry(name string) *dnsCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[dnsCacheKey{name: name, qtype: dns.TypeA, qclass: dns.ClassINET}]; ok {
+		return elem.Value.(*dnsCacheEntry)
+	}
+	return nil
+}
+
+// 命中时替换为查询的 ID，TTL 按已缓存时长递减；按最小 TTL 过期
+func TestDNSCacheTTL(t *testing.T) {
+	c := NewDNSCache(0)
+	c.Put(dnsReply(t, "example.com.", func(m *dns.Msg) {
+		m.Answer = []dns.RR{aRecord("example.com.", 60), aRecord("example.com.", 30)}
+	}))
+	e := c.entry("example.com.")
+	if e == nil {
+		t.Fatal("response not cached")
+	}
+	if ttl := e.expires.Sub(e.stored); ttl != 30*time.Second {
+		t.Fatalf("cached for %s, want the minimum ttl 30s", ttl)
+	}
+
+	// 模拟已缓存 10 秒
+	c.mu.Lock()
+	e.stored = e.stored.Add(-10 * time.Second)
+	c.mu.Unlock()
+	resp := new(dns.Msg)
+	if err := resp.Unpack(c.Get(dnsQuery("EXAMPLE.com.", dns.TypeA, 0x4242))); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Id != 0x4242 || len(resp.Answer) != 2 || resp.Answer[0].Header().Ttl != 50 || resp.Answer[1].Header().Ttl != 20 {
+		t.Fatalf("cached response = %v", resp)
+	}
+	if c.Get(dnsQuery("example.com.", dns.TypeAAAA, 1)) != nil {
+		t.Fatal("AAAA query answered from the A record")
+	}
+
+	c.mu.Lock()
+	e.expires = time.Now()
+	c.mu.Unlock()
+	if c.Get(dnsQuery("example.com.", dns.TypeA, 1)) != nil || c.entry("example.com.") != nil {
+		t.Fatal("expired entry returned or kept")
+	}
+}
+
+// 否定应答按 SOA 的最小 TTL 缓存，上限为 maxNegativeTTL；没有 SOA 时使用默认值
+func TestDNSCacheNegativeTTL(t *testing.T) {
+	soa := func(hdrTTL, minTTL uint32) dns.RR {
+		return &dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: hdrTTL},
+			Ns: "ns.example.com.", Mbox: "admin.example.com.", Minttl: minTTL}
+	}
+	c := NewDNSCache(0)
+	for _, tc := range []struct {
+		name string
+		ns   []dns.RR
+		want time.Duration
+	}{
+		{"capped.example.com.", []dns.RR{soa(600, 300)}, maxNegativeTTL},
+		{"short.example.com.", []dns.RR{soa(600, 5)}, 5 * time.Second},
+		{"header.example.com.", []dns.RR{soa(3, 300)}, 3 * time.Second},
+		{"nosoa.example.com.", nil, defaultNegativeTTL},
+	} {
+		c.Put(dnsReply(t, tc.name, func(m *dns.Msg) {
+			m.Rcode = dns.RcodeNameError
+			m.Ns = tc.ns
+		}))
+		e := c.entry(tc.name)
+		if e == nil {
+			t.Errorf("%s: NXDOMAIN not cached", tc.name)
+			continue
+		}
+		if ttl := e.expires.Sub(e.stored); ttl != tc.want {
+			t.Errorf("%s: cached for %s, want %s", tc.name, ttl, tc.want)
+		}
+	}
+}
+
+// 截断、SERVFAIL 与 TTL 为 0 的应答不缓存
+func TestDNSCacheSkipsUncacheable(t *testing.T) {
+	c := NewDNSCache(0)
+	for name, f := range map[string]func(m *dns.Msg){
+		"truncated.example.": func(m *dns.Msg) {
+			m.Truncated = true
+			m.Answer = []dns.RR{aRecord("truncated.example.", 60)}
+		},
+		"servfail.example.": func(m *dns.Msg) { m.Rcode = dns.RcodeServerFailure },
+		"refused.example.":  func(m *dns.Msg) { m.Rcode = dns.RcodeRefused },
+		"zero.example.":     func(m *dns.Msg) { m.Answer = []dns.RR{aRecord("zero.example.", 0)} },
+	} {
+		c.Put(dnsReply(t, name, f))
+		if c.Get(dnsQuery(name, dns.TypeA, 1)) != nil {
+			t.Errorf("%s cached", name)
+		}
+	}
+
+	// 容量为负数时不缓存，nil 缓存可安全调用
+	disabled := NewDNSCache(-1)
+	disabled.Put(dnsReply(t, "example.com.", func(m *dns.Msg) { m.Answer = []dns.RR{aRecord("example.com.", 60)} }))
+	if disabled.Get(dnsQuery("example.com.", dns.TypeA, 1)) != nil {
+		t.Error("disabled cache answered")
+	}
+}
+
+// 超出容量时淘汰最久未使用的条目
+func TestDNSCacheLRU(t *testing.T) {
+	c := NewDNSCache(2)
+	put := func(name string) {
+		c.Put(dnsReply(t, name, func(m *dns.Msg) { m.Answer = []dns.RR{aRecord(name, 60)} }))
+	}
+	put("a.example.")
+	put("b.example.")
+	if c.Get(dnsQuery("a.example.", dns.TypeA, 1)) == nil {
+		t.Fatal("a.example not cached")
+	}
+	put("c.example.")
+	if c.entry("b.example.") != nil {
+		t.Error("least recently used entry b.example kept")
+	}
+	if c.entry("a.example.") == nil || c.entry("c.example.") == nil {
+		t.Error("recently used entries evicted")
+	}
+}
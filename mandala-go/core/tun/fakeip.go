@@ -0,0 +1,122 @@
+package tun
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// FakeIP 应答的 TTL (秒)，保持很短以免系统在核心重启后继续使用失效的映射
+const fakeIPTTL = 1
+
+// FakeIPPool 从保留网段中为域名分配地址，并记录双向映射
+// 地址按顺序分配，耗尽后回绕并复用最早分配的地址
+type FakeIPPool struct {
+	mu       sync.Mutex
+	ipNet    *net.IPNet
+	first    uint32 // 首个可分配地址 (跳过网络地址)
+	size     uint32 // 可分配地址数量 (不含网络地址与广播地址)
+	next     uint32
+	byDomain map[string]uint32
+	byIP     map[uint32]string
+}
+
+// NewFakeIPPool 创建 FakeIP 地址池，cidr 须为 IPv4 网段
+func NewFakeIPPool(cidr string) (*FakeIPPool, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fake ip range: %v", err)
+	}
+	ip4 := ipNet.IP.To4()
+	ones, bits := ipNet.Mask.Size()
+	if ip4 == nil || bits != 32 {
+		return nil, fmt.Errorf("fake ip range must be IPv4: %s", cidr)
+	}
+	if ones > 30 {
+		return nil, fmt.Errorf("fake ip range too small: %s", cidr)
+	}
+
+	return &FakeIPPool{
+		ipNet:    ipNet,
+		first:    binary.BigEndian.Uint32(ip4) + 1,
+		size:     uint32(1)<<(32-ones) - 2,
+		byDomain: make(map[string]uint32),
+		byIP:     make(map[uint32]string),
+	}, nil
+}
+
+// Lookup 返回域名对应的地址，已分配的域名复用原地址
+func (p *FakeIPPool) Lookup(domain string) net.IP {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ip, ok := p.byDomain[domain]; ok {
+		return uint32ToIP(ip)
+	}
+
+	ip := p.first + p.next
+	p.next = (p.next + 1) % p.size
+	// 回绕后该地址可能仍被旧域名占用，解除旧映射
+	if old, ok := p.byIP[ip]; ok {
+		delete(p.byDomain, old)
+	}
+	p.byDomain[domain] = ip
+	p.byIP[ip] = domain
+	return uint32ToIP(ip)
+}
+
+// Domain 反查地址对应的域名
+func (p *FakeIPPool) Domain(ip net.IP) (string, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	domain, ok := p.byIP[binary.BigEndian.Uint32(ip4)]
+	return domain, ok
+}
+
+// Contains 判断地址是否属于 FakeIP 网段
+func (p *FakeIPPool) Contains(ip net.IP) bool {
+	return p.ipNet.Contains(ip)
+}
+
+// Answer 对 A/AAAA 查询构造本地应答，其他查询返回 nil 交由远程 DNS 处理
+// AAAA 查询返回空结果，使应用回退到 IPv4 FakeIP
+func (p *FakeIPPool) Answer(query []byte) []byte {
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil || len(req.Question) != 1 {
+		return nil
+	}
+	q := req.Question[0]
+	if q.Qclass != dns.ClassINET || (q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA) {
+		return nil
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+	if q.Qtype == dns.TypeA {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: fakeIPTTL},
+			A:   p.Lookup(q.Name),
+		})
+	}
+
+	data, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func uint32ToIP(v uint32) net.IP {
+	return net.IP(binary.BigEndian.AppendUint32(nil, v))
+}
@@ -0,0 +1,89 @@
+package tun
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// 地址按顺序分配，回绕后复用最早的地址并解除其旧映射
+func TestFakeIPPoolWrapAround(t *testing.T) {
+	pool, err := NewFakeIPPool("198.18.0.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := pool.Lookup("a.example."), pool.Lookup("B.Example")
+	if !a.Equal(net.ParseIP("198.18.0.1")) || !b.Equal(net.ParseIP("198.18.0.2")) {
+		t.Fatalf("allocated %s, %s", a, b)
+	}
+	if again := pool.Lookup("a.example"); !again.Equal(a) {
+		t.Fatalf("a.example reallocated as %s", again)
+	}
+	if domain, ok := pool.Domain(b); !ok || domain != "b.example" {
+		t.Fatalf("Domain(%s) = %q, %v", b, domain, ok)
+	}
+
+	// 两个地址已用完，c.example 复用 a.example 的地址
+	c := pool.Lookup("c.example")
+	if !c.Equal(a) {
+		t.Fatalf("c.example = %s, want %s", c, a)
+	}
+	if domain, _ := pool.Domain(a); domain != "c.example" {
+		t.Fatalf("Domain(%s) = %q after wrap-around, want c.example", a, domain)
+	}
+	if again := pool.Lookup("a.example"); again.Equal(c) {
+		t.Fatal("evicted a.example still maps to its old address")
+	}
+
+	if _, ok := pool.Domain(net.ParseIP("198.18.0.3")); ok {
+		t.Error("broadcast address has a mapping")
+	}
+	if !pool.Contains(net.ParseIP("198.18.0.2")) || pool.Contains(net.ParseIP("198.18.0.4")) {
+		t.Error("Contains does not match the range")
+	}
+
+	for _, bad := range []string{"198.18.0.0/31", "fd00::/64", "not-a-cidr"} {
+		if _, err := NewFakeIPPool(bad); err == nil {
+			t.Errorf("NewFakeIPPool(%q) succeeded", bad)
+		}
+	}
+}
+
+// A 查询返回 FakeIP，AAAA 查询返回空应答，其他类型交给远程 DNS
+func TestFakeIPPoolAnswer(t *testing.T) {
+	pool, err := NewFakeIPPool("198.18.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func(qtype uint16) []byte {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.com.", qtype)
+		m.Id = 0x1234
+		b, _ := m.Pack()
+		return b
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(pool.Answer(query(dns.TypeA))); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Id != 0x1234 || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("A answer = %v", resp)
+	}
+	a := resp.Answer[0].(*dns.A)
+	if domain, _ := pool.Domain(a.A); domain != "www.example.com" || a.Hdr.Ttl != fakeIPTTL {
+		t.Fatalf("A %s (ttl %d) maps to %q", a.A, a.Hdr.Ttl, domain)
+	}
+
+	if err := resp.Unpack(pool.Answer(query(dns.TypeAAAA))); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 {
+		t.Fatalf("AAAA answer = %v, want an empty NOERROR", resp)
+	}
+
+	if pool.Answer(query(dns.TypeMX)) != nil || pool.Answer([]byte{0x12}) != nil {
+		t.Fatal("non-address or malformed query answered locally")
+	}
+}
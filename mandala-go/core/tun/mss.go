@@ -0,0 +1,155 @@
+package tun
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// 隧道封装开销的估计值 (字节)：TLS 记录头与 AEAD 标签、WebSocket 帧头、代理协议头等
+const tunnelOverhead = 100
+
+// MSS 下限 (RFC 879 的默认 MSS)，MTU 过小时不再继续下调
+const minMSS = 536
+
+// mssLimits 计算 IPv4 / IPv6 的 MSS 上限
+// setting > 0 时两者均使用该值；为 0 时由 MTU 减去 IP/TCP 头与隧道开销得出；负数表示不限制 (返回 0)
+func mssLimits(setting, mtu int) (uint16, uint16) {
+	if setting < 0 {
+		return 0, 0
+	}
+	if setting > 0 {
+		if setting > 0xFFFF {
+			setting = 0xFFFF
+		}
+		return uint16(setting), uint16(setting)
+	}
+	clamp := func(v int) uint16 {
+		if v < minMSS {
+			v = minMSS
+		}
+		return uint16(v)
+	}
+	return clamp(mtu - header.IPv4MinimumSize - header.TCPMinimumSize - tunnelOverhead),
+		clamp(mtu - header.IPv6MinimumSize - header.TCPMinimumSize - tunnelOverhead)
+}
+
+// mssEndpoint 包装 TUN 的链路层端点，改写双向 SYN 报文中的 MSS 选项：
+// 应用发出的 SYN 限制网络栈发回的报文段大小，网络栈回复的 SYN-ACK 限制应用发来的报文段大小
+type mssEndpoint struct {
+	nested.Endpoint
+	mss4, mss6 uint16
+}
+
+func newMSSEndpoint(child stack.LinkEndpoint, mss4, mss6 uint16) *mssEndpoint {
+	e := &mssEndpoint{mss4: mss4, mss6: mss6}
+	e.Endpoint.Init(child, e)
+	return e
+}
+
+// DeliverNetworkPacket 处理来自 TUN 的入站包 (此时尚未解析，数据为完整 IP 包)
+func (e *mssEndpoint) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt stack.PacketBufferPtr) {
+	// IP 头 (IPv4 最长 60 字节) 加 TCP 头 (最长 60 字节)
+	n := pkt.Data().Size()
+	if n > header.IPv4MaximumHeaderSize+header.TCPHeaderMaximumSize {
+		n = header.IPv4MaximumHeaderSize + header.TCPHeaderMaximumSize
+	}
+	if b, ok := pkt.Data().PullUp(n); ok {
+		clampMSS(b, e.mss4, e.mss6)
+	}
+	e.Endpoint.DeliverNetworkPacket(protocol, pkt)
+}
+
+// WritePackets 处理网络栈发往 TUN 的出站包 (头部已分别构造)
+func (e *mssEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	for _, pkt := range pkts.AsSlice() {
+		if pkt.TransportProtocolNumber != header.TCPProtocolNumber {
+			continue
+		}
+		limit := e.mss4
+		if pkt.NetworkProtocolNumber == header.IPv6ProtocolNumber {
+			limit = e.mss6
+		}
+		clampTCPHeader(pkt.TransportHeader().Slice(), limit)
+	}
+	return e.Endpoint.WritePackets(pkts)
+}
+
+// clampMSS 若 b 为 TCP SYN 的 IP 包 (至少包含 IP 与 TCP 头)，将超出上限的 MSS 选项改为上限值
+// 并增量更新 TCP 校验和；返回是否做了修改。带扩展头的 IPv6 包与非首个分片不处理
+func clampMSS(b []byte, mss4, mss6 uint16) bool {
+	if len(b) == 0 {
+		return false
+	}
+	switch header.IPVersion(b) {
+	case header.IPv4Version:
+		if len(b) < header.IPv4MinimumSize {
+			return false
+		}
+		ip := header.IPv4(b)
+		ihl := int(ip.HeaderLength())
+		if ip.Protocol() != uint8(header.TCPProtocolNumber) || ip.FragmentOffset() != 0 || ihl < header.IPv4MinimumSize || len(b) < ihl {
+			return false
+		}
+		return clampTCPHeader(b[ihl:], mss4)
+	case header.IPv6Version:
+		if len(b) < header.IPv6MinimumSize {
+			return false
+		}
+		if header.IPv6(b).NextHeader() != uint8(header.TCPProtocolNumber) {
+			return false
+		}
+		return clampTCPHeader(b[header.IPv6MinimumSize:], mss6)
+	}
+	return false
+}
+
+// clampTCPHeader 在 TCP 头 (含选项) 中查找 SYN 报文的 MSS 选项并下调到 limit
+func clampTCPHeader(b []byte, limit uint16) bool {
+	if limit == 0 || len(b) < header.TCPMinimumSize {
+		return false
+	}
+	tcp := header.TCP(b)
+	if tcp.Flags()&header.TCPFlagSyn == 0 {
+		return false
+	}
+	dataOffset := int(tcp.DataOffset())
+	if dataOffset < header.TCPMinimumSize || dataOffset > len(b) {
+		return false
+	}
+	opts := b[header.TCPMinimumSize:dataOffset]
+	for i := 0; i < len(opts); {
+		switch opts[i] {
+		case header.TCPOptionEOL:
+			return false
+		case header.TCPOptionNOP:
+			i++
+			continue
+		}
+		if i+1 >= len(opts) || opts[i+1] < 2 || i+int(opts[i+1]) > len(opts) {
+			return false
+		}
+		if opts[i] == header.TCPOptionMSS && opts[i+1] == header.TCPOptionMSSLength {
+			mss := binary.BigEndian.Uint16(opts[i+2:])
+			if mss <= limit {
+				return false
+			}
+			binary.BigEndian.PutUint16(opts[i+2:], limit)
+			tcp.SetChecksum(updateChecksum(tcp.Checksum(), mss, limit))
+			return true
+		}
+		i += int(opts[i+1])
+	}
+	return false
+}
+
+// updateChecksum 按 RFC 1624 增量更新校验和 (一个 16 位字由 old 改为 new)
+func updateChecksum(sum, old, new uint16) uint16 {
+	s := uint32(^sum) + uint32(^old) + uint32(new)
+	s = (s & 0xFFFF) + (s >> 16)
+	s = (s & 0xFFFF) + (s >> 16)
+	return ^uint16(s)
+}
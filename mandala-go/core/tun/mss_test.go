@@ -0,0 +1,100 @@
+package tun
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// tcpPacket 构造带 MSS 选项的 IPv4 / IPv6 TCP 包 (前置 NOP 以覆盖选项遍历)，校验和有效
+func tcpPacket(v6 bool, flags header.TCPFlags, mss uint16) []byte {
+	opts := []byte{header.TCPOptionNOP, header.TCPOptionNOP, header.TCPOptionNOP, header.TCPOptionNOP,
+		header.TCPOptionMSS, header.TCPOptionMSSLength, 0, 0}
+	binary.BigEndian.PutUint16(opts[6:], mss)
+	tcpLen := header.TCPMinimumSize + len(opts)
+
+	src, dst := tcpip.AddrFrom4([4]byte{10, 0, 0, 2}), tcpip.AddrFrom4([4]byte{1, 1, 1, 1})
+	ipLen := header.IPv4MinimumSize
+	if v6 {
+		src = tcpip.AddrFrom16([16]byte{0xfd, 15: 2})
+		dst = tcpip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1})
+		ipLen = header.IPv6MinimumSize
+	}
+	b := make([]byte, ipLen+tcpLen)
+	if v6 {
+		header.IPv6(b).Encode(&header.IPv6Fields{
+			PayloadLength: uint16(tcpLen), TransportProtocol: header.TCPProtocolNumber, HopLimit: 64, SrcAddr: src, DstAddr: dst,
+		})
+	} else {
+		ip := header.IPv4(b)
+		ip.Encode(&header.IPv4Fields{
+			TotalLength: uint16(len(b)), Protocol: uint8(header.TCPProtocolNumber), TTL: 64, SrcAddr: src, DstAddr: dst,
+		})
+		ip.SetChecksum(^ip.CalculateChecksum())
+	}
+	tcp := header.TCP(b[ipLen:])
+	tcp.Encode(&header.TCPFields{SrcPort: 40000, DstPort: 443, DataOffset: uint8(tcpLen), Flags: flags, WindowSize: 65535})
+	copy(tcp[header.TCPMinimumSize:], opts)
+	tcp.SetChecksum(^tcp.CalculateChecksum(header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(tcpLen))))
+	return b
+}
+
+// tcpMSS 返回包中的 MSS 选项值，并校验 TCP 校验和
+func tcpMSS(t *testing.T, b []byte, v6 bool) uint16 {
+	t.Helper()
+	ipLen, src, dst := header.IPv4MinimumSize, header.IPv4(b).SourceAddress(), header.IPv4(b).DestinationAddress()
+	if v6 {
+		ipLen, src, dst = header.IPv6MinimumSize, header.IPv6(b).SourceAddress(), header.IPv6(b).DestinationAddress()
+	}
+	tcp := header.TCP(b[ipLen:])
+	xsum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(tcp)))
+	if checksum.Checksum(tcp, xsum) != 0xFFFF {
+		t.Fatal("tcp checksum invalid after clamping")
+	}
+	return header.ParseSynOptions(tcp.Options(), false).MSS
+}
+
+// SYN 报文中超出上限的 MSS 被下调且校验和保持有效；非 SYN 报文与不超限的 MSS 不修改
+func TestClampMSS(t *testing.T) {
+	for _, v6 := range []bool{false, true} {
+		limit := uint16(1300)
+		b := tcpPacket(v6, header.TCPFlagSyn, 1460)
+		if !clampMSS(b, limit, limit) || tcpMSS(t, b, v6) != limit {
+			t.Errorf("v6=%v: SYN mss not clamped to %d", v6, limit)
+		}
+		b = tcpPacket(v6, header.TCPFlagSyn|header.TCPFlagAck, 1200)
+		if clampMSS(b, limit, limit) || tcpMSS(t, b, v6) != 1200 {
+			t.Errorf("v6=%v: mss below the limit modified", v6)
+		}
+		b = tcpPacket(v6, header.TCPFlagAck, 1460)
+		if clampMSS(b, limit, limit) {
+			t.Errorf("v6=%v: non-SYN packet modified", v6)
+		}
+		if clampMSS(tcpPacket(v6, header.TCPFlagSyn, 1460), 0, 0) {
+			t.Errorf("v6=%v: clamped with clamping disabled", v6)
+		}
+	}
+	if clampMSS(nil, 1300, 1300) || clampMSS([]byte{0x45, 0}, 1300, 1300) {
+		t.Error("truncated packet modified")
+	}
+}
+
+func TestMSSLimits(t *testing.T) {
+	for _, tc := range []struct {
+		setting, mtu int
+		mss4, mss6   uint16
+	}{
+		{0, 1500, 1500 - 20 - 20 - tunnelOverhead, 1500 - 40 - 20 - tunnelOverhead},
+		{0, 576, minMSS, minMSS},
+		{1200, 1500, 1200, 1200},
+		{100000, 1500, 0xFFFF, 0xFFFF},
+		{-1, 1500, 0, 0},
+	} {
+		if mss4, mss6 := mssLimits(tc.setting, tc.mtu); mss4 != tc.mss4 || mss6 != tc.mss6 {
+			t.Errorf("mssLimits(%d, %d) = %d, %d; want %d, %d", tc.setting, tc.mtu, mss4, mss6, tc.mss4, tc.mss6)
+		}
+	}
+}
@@ -2,17 +2,20 @@ package tun
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"mandala/core/config"
-	"mandala/core/protocol"
+	"mandala/core/logger"
 	"mandala/core/proxy"
+	"mandala/core/stats"
 
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
@@ -20,28 +23,80 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 	"gvisor.dev/gvisor/pkg/waiter"
 )
 
-func init() {
-	log.SetPrefix("GoLog: ")
-}
-
 type Stack struct {
 	stack     *stack.Stack
 	device    *Device
-	dialer    *proxy.Dialer
 	config    *config.OutboundConfig
+	selector  *proxy.Selector // [新增] 多节点时选择当前节点，单节点且未启用断网保护时为 nil
+	ports     *config.PortPolicy
+	uids      *config.UIDPolicy // [新增] 按来源应用过滤，为 nil 时不限制
+	router    *config.Router // 为 nil 时全部经代理
 	nat       *UDPNatManager
+	dnsHost   string // 经隧道转发 DNS 查询的上游服务器
+	dnsPort   int
+	fakeIP    *FakeIPPool       // 为 nil 时未启用 FakeIP 模式
+	dnsCache  *DNSCache         // 为 nil 时不缓存远程 DNS 应答
+	forwards  proxy.ConnTracker // 进行中的 TCP/UDP 转发
+	closing   atomic.Bool       // 置位后不再接受新连接
 	ctx       context.Context
 	cancel    context.CancelFunc
 	closeOnce sync.Once
 }
 
-func StartStack(fd int, mtu int, cfg *config.OutboundConfig) (*Stack, error) {
-	log.Printf("[Stack] 启动中 (FD: %d, MTU: %d, Type: %s)", fd, mtu, cfg.Type)
+// StartStack 在 TUN 文件描述符上启动网络栈，MTU 与地址等 TUN 参数取自 cfg.Settings
+func StartStack(fd int, cfg *config.OutboundConfig) (*Stack, error) {
+	mtu := cfg.MTU()
+	logger.Infof("Stack", "启动中 (FD: %d, MTU: %d, Type: %s)", fd, mtu, cfg.Type)
+
+	ports, err := config.ParsePortPolicy(cfg.Settings.AllowedPorts, cfg.Settings.BlockedPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	uids, err := config.ParseUIDPolicy(cfg.Settings.AllowedUIDs, cfg.Settings.BlockedUIDs, cfg.Settings.UIDFilterAction)
+	if err != nil {
+		return nil, err
+	}
+
+	router, err := config.ParseRouter(cfg.Routing)
+	if err != nil {
+		return nil, err
+	}
+
+	dnsHost, dnsPort, err := cfg.RemoteDNS()
+	if err != nil {
+		logger.Warnf("DNS", "远程 DNS 配置无效，使用默认值 %s:%d: %v", dnsHost, dnsPort, err)
+	}
+
+	// [新增] FakeIP 模式
+	var fakeIP *FakeIPPool
+	if cfg.DNS != nil && cfg.DNS.FakeIP {
+		cidr := cfg.DNS.FakeIPRange
+		if cidr == "" {
+			cidr = config.DefaultFakeIPRange
+		}
+		if fakeIP, err = NewFakeIPPool(cidr); err != nil {
+			return nil, err
+		}
+		logger.Infof("DNS", "FakeIP 模式已启用: %s", cidr)
+	}
+
+	cacheSize := 0
+	if cfg.DNS != nil {
+		cacheSize = cfg.DNS.CacheSize
+	}
+
+	// [新增] 网络栈自身的地址
+	tunAddrs, err := parseTunAddresses(cfg.Settings.TunIPv4, cfg.Settings.TunIPv6)
+	if err != nil {
+		return nil, err
+	}
 
 	dev, err := NewDevice(fd, uint32(mtu))
 	if err != nil {
@@ -53,18 +108,35 @@ func StartStack(fd int, mtu int, cfg *config.OutboundConfig) (*Stack, error) {
 			ipv4.NewProtocol,
 			ipv6.NewProtocol,
 		},
+		// [新增] ICMP：网卡处于混杂模式，任意目标地址都视为本机地址，
+		// 回显请求 (ping) 由网络层在本地直接应答，可用于检测 TUN 是否正常工作
 		TransportProtocols: []stack.TransportProtocolFactory{
 			tcp.NewProtocol,
 			udp.NewProtocol,
+			icmp.NewProtocol4,
+			icmp.NewProtocol6,
 		},
 	})
 
+	// [新增] TCP 缓冲区与拥塞控制
+	if err := applyTCPOptions(s, cfg); err != nil {
+		dev.Close()
+		return nil, err
+	}
+
 	s.SetForwardingDefaultAndAllNICs(ipv4.ProtocolNumber, true)
 	s.SetForwardingDefaultAndAllNICs(ipv6.ProtocolNumber, true)
 
 	nicID := tcpip.NICID(1)
 
-	if err := s.CreateNIC(nicID, dev.LinkEndpoint()); err != nil {
+	// [新增] TCP MSS 限制
+	linkEP := dev.LinkEndpoint()
+	if mss4, mss6 := mssLimits(cfg.Settings.MSS, mtu); mss4 > 0 && linkEP != nil {
+		logger.Infof("Stack", "TCP MSS 上限: IPv4=%d, IPv6=%d", mss4, mss6)
+		linkEP = newMSSEndpoint(linkEP, mss4, mss6)
+	}
+
+	if err := s.CreateNIC(nicID, linkEP); err != nil {
 		dev.Close()
 		return nil, fmt.Errorf("创建网卡失败: %v", err)
 	}
@@ -72,30 +144,144 @@ func StartStack(fd int, mtu int, cfg *config.OutboundConfig) (*Stack, error) {
 	s.SetPromiscuousMode(nicID, true)
 	s.SetSpoofing(nicID, true)
 
-	s.SetRouteTable([]tcpip.Route{
-		{Destination: header.IPv4EmptySubnet, NIC: nicID},
-		{Destination: header.IPv6EmptySubnet, NIC: nicID},
-	})
+	// [新增] 为网卡分配地址：本地发出的报文 (ICMP 差错等) 以此为源地址，发往该地址的 DNS / ping 由本地处理。
+	// TUN 为三层点对点设备，无需解析链路地址，网段路由直接指向网卡 (无网关)，其余目标走默认路由
+	var routes []tcpip.Route
+	for _, addr := range tunAddrs {
+		if err := s.AddProtocolAddress(nicID, addr, stack.AddressProperties{}); err != nil {
+			dev.Close()
+			return nil, fmt.Errorf("分配地址 %s 失败: %v", addr.AddressWithPrefix, err)
+		}
+		routes = append(routes, tcpip.Route{Destination: addr.AddressWithPrefix.Subnet(), NIC: nicID})
+		logger.Infof("Stack", "网卡地址: %s", addr.AddressWithPrefix)
+	}
+	s.SetRouteTable(append(routes,
+		tcpip.Route{Destination: header.IPv4EmptySubnet, NIC: nicID},
+		tcpip.Route{Destination: header.IPv6EmptySubnet, NIC: nicID},
+	))
 
 	ctx, cancel := context.WithCancel(context.Background())
-	dialer := proxy.NewDialer(cfg)
+	selector := proxy.NewSelector(cfg.Candidates(), cfg.HealthCheck)
+	if selector == nil && cfg.Settings.KillSwitch {
+		// [新增] 断网保护依赖健康检查，单节点时同样需要
+		selector = proxy.NewHealthChecker(cfg.Candidates(), cfg.HealthCheck)
+	}
 
 	tStack := &Stack{
-		stack:  s,
-		device: dev,
-		dialer: dialer,
-		config: cfg,
-		nat:    NewUDPNatManager(dialer, cfg),
-		ctx:    ctx,
-		cancel: cancel,
+		stack:    s,
+		device:   dev,
+		config:   cfg,
+		selector: selector,
+		ports:    ports,
+		uids:     uids,
+		router:   router,
+		nat:      NewUDPNatManager(cfg, s),
+		dnsHost:  dnsHost,
+		dnsPort:  dnsPort,
+		fakeIP:   fakeIP,
+		dnsCache: NewDNSCache(cacheSize),
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 
 	tStack.startPacketHandling()
+	if selector != nil {
+		selector.Start(ctx)
+	}
 	return tStack, nil
 }
 
+// parseTunAddresses 解析网络栈一侧的 IPv4 / IPv6 地址 (CIDR，省略前缀时为单个地址)
+func parseTunAddresses(v4, v6 string) ([]tcpip.ProtocolAddress, error) {
+	var addrs []tcpip.ProtocolAddress
+	for _, item := range []struct {
+		value string
+		field string
+		proto tcpip.NetworkProtocolNumber
+	}{
+		{v4, "tun_ipv4", ipv4.ProtocolNumber},
+		{v6, "tun_ipv6", ipv6.ProtocolNumber},
+	} {
+		value := strings.TrimSpace(item.value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			if item.proto == ipv4.ProtocolNumber {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		ip, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", item.field, item.value)
+		}
+		if ip4 := ip.To4(); item.proto == ipv4.ProtocolNumber {
+			if ip4 == nil {
+				return nil, fmt.Errorf("invalid %s: %q is not an IPv4 address", item.field, item.value)
+			}
+			ip = ip4
+		} else if ip4 != nil {
+			return nil, fmt.Errorf("invalid %s: %q is not an IPv6 address", item.field, item.value)
+		}
+		prefix, _ := ipNet.Mask.Size()
+		addrs = append(addrs, tcpip.ProtocolAddress{
+			Protocol:          item.proto,
+			AddressWithPrefix: tcpip.AddressWithPrefix{Address: tcpip.AddrFromSlice(ip), PrefixLen: prefix},
+		})
+	}
+	return addrs, nil
+}
+
+// node 返回新连接使用的节点
+func (s *Stack) node() *config.OutboundConfig {
+	if s.selector == nil {
+		return s.config
+	}
+	return s.selector.Current()
+}
+
+// killSwitchActive 断网保护是否生效：已启用且当前节点不可用时，经代理的连接直接丢弃
+func (s *Stack) killSwitchActive(route string) bool {
+	return s.config.Settings.KillSwitch && route != config.OutboundDirect && !s.selector.Healthy()
+}
+
+// ActiveNode 返回当前使用的节点状态；单节点时不做健康检查，延迟为 -1
+func (s *Stack) ActiveNode() proxy.NodeStatus {
+	if s.selector != nil {
+		return s.selector.Active()
+	}
+	return proxy.NodeStatus{
+		Tag:        s.config.Tag,
+		Type:       s.config.Type,
+		Server:     s.config.Server,
+		ServerPort: s.config.ServerPort,
+		Up:         true,
+		LatencyMs:  -1,
+	}
+}
+
+// TCP 转发器默认参数
+// 旧值 (30000 字节窗口 / 10 个待处理连接) 在浏览网页的突发连接下会丢弃 SYN，表现为随机卡顿。
+// 256KB 窗口在 1000 条并发连接时最多约占用 256MB，可按设备内存通过配置下调。
+const (
+	defaultTCPReceiveWindow = 256 * 1024
+	defaultTCPMaxInFlight   = 1024
+)
+
 func (s *Stack) startPacketHandling() {
-	tcpHandler := tcp.NewForwarder(s.stack, 30000, 10, func(r *tcp.ForwarderRequest) {
+	rcvWnd := s.config.Settings.TCPReceiveWindow
+	if rcvWnd <= 0 {
+		rcvWnd = defaultTCPReceiveWindow
+	}
+	maxInFlight := s.config.Settings.TCPMaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = defaultTCPMaxInFlight
+	}
+	logger.Infof("Stack", "TCP 转发器: 接收窗口=%d, 最大握手数=%d", rcvWnd, maxInFlight)
+
+	tcpHandler := tcp.NewForwarder(s.stack, rcvWnd, maxInFlight, func(r *tcp.ForwarderRequest) {
 		go s.handleTCP(r)
 	})
 	s.stack.SetTransportProtocolHandler(tcp.ProtocolNumber, tcpHandler.HandlePacket)
@@ -109,92 +295,190 @@ func (s *Stack) startPacketHandling() {
 func (s *Stack) handleTCP(r *tcp.ForwarderRequest) {
 	defer func() {
 		if err := recover(); err != nil {
-			log.Printf("[TCP] Panic 恢复: %v", err)
+			logger.Errorf("TCP", "Panic 恢复: %v", err)
 		}
 	}()
 
 	id := r.ID()
 
-	// 1. 拨号代理
-	remoteConn, dialErr := s.dialer.Dial()
-	if dialErr != nil {
+	if s.closing.Load() {
 		r.Complete(true)
 		return
 	}
 
-	// 2. 握手逻辑
-	var payload []byte
-	var hErr error
-	targetHost := id.LocalAddress.String()
-	targetPort := int(id.LocalPort)
-	isVless := false
-
-	switch strings.ToLower(s.config.Type) {
-	case "mandala":
-		client := protocol.NewMandalaClient(s.config.Username, s.config.Password)
-		payload, hErr = client.BuildHandshakePayload(targetHost, targetPort, s.config.Settings.Noise)
-	case "trojan":
-		payload, hErr = protocol.BuildTrojanPayload(s.config.Password, targetHost, targetPort)
-	case "vless":
-		payload, hErr = protocol.BuildVlessPayload(s.config.UUID, targetHost, targetPort)
-		isVless = true
-	case "shadowsocks":
-		payload, hErr = protocol.BuildShadowsocksPayload(targetHost, targetPort)
-	case "socks", "socks5":
-		hErr = protocol.HandshakeSocks5(remoteConn, s.config.Username, s.config.Password, targetHost, targetPort)
-	}
-
-	if hErr != nil {
-		remoteConn.Close()
+	// [新增] 端口策略检查
+	if !s.ports.Allowed(int(id.LocalPort)) {
+		logger.Infof("Policy", "拒绝 TCP 连接 %s:%d: 目标端口不在允许范围内", id.LocalAddress, id.LocalPort)
 		r.Complete(true)
 		return
 	}
 
-	if len(payload) > 0 {
-		if _, err := remoteConn.Write(payload); err != nil {
-			remoteConn.Close()
-			r.Complete(true)
+	// [新增] FakeIP 地址还原为域名，由服务端解析
+	targetHost, ok := s.resolveTarget(net.IP(id.LocalAddress.AsSlice()))
+	if !ok {
+		logger.Warnf("DNS", "拒绝 TCP 连接 %s:%d: FakeIP 无对应域名", id.LocalAddress, id.LocalPort)
+		r.Complete(true)
+		return
+	}
+	targetPort := int(id.LocalPort)
+
+	// [新增] 按来源应用与路由规则选择出站
+	route, uidFiltered := s.filterUID(uidProtoTCP, id)
+	if !uidFiltered {
+		route = s.router.Match(targetHost, targetPort)
+	}
+
+	// [新增] 目标为 IP 时先接受连接，按首包中的域名 (TLS SNI / HTTP Host) 重新匹配路由；
+	// 此后拒绝连接只能直接关闭，已读取的首包随握手发给目标
+	var localConn *gonet.TCPConn
+	var early []byte
+	earlyRead := false
+	// [新增] 按目标统计流量时使用的主机名，嗅探到域名时以域名代替 IP
+	statsHost := targetHost
+	if !uidFiltered && s.config.Settings.Sniff && s.router != nil && net.ParseIP(targetHost) != nil {
+		if localConn = s.acceptTCP(r); localConn == nil {
+			return
+		}
+		var domain string
+		var err error
+		early, domain, err = proxy.SniffConn(localConn)
+		if err != nil {
+			localConn.Close()
 			return
 		}
+		earlyRead = true
+		if domain != "" {
+			statsHost = domain
+			route = s.router.MatchSniffed(domain, net.ParseIP(targetHost), targetPort)
+			logger.Debugf("Route", "嗅探到 %s:%d 的域名 %s，出站: %s", targetHost, targetPort, domain, route)
+		}
+	}
+	// reject 拒绝连接：未接受时回复 RST (rst 为 false 时只丢弃 SYN)，已接受时直接关闭
+	reject := func(rst bool) {
+		if localConn != nil {
+			localConn.Close()
+		} else {
+			r.Complete(rst)
+		}
 	}
 
-	if isVless {
-		remoteConn = protocol.NewVlessConn(remoteConn)
+	if route == config.OutboundBlock {
+		logger.Debugf("Route", "拦截 TCP 连接 %s:%d", targetHost, targetPort)
+		reject(true)
+		return
+	}
+	// [新增] 断网保护：不回复 RST，只丢弃 SYN，应用等待重传而不会回退到其他网络
+	if s.killSwitchActive(route) {
+		logger.Debugf("Policy", "断网保护: 丢弃 TCP 连接 %s:%d", targetHost, targetPort)
+		reject(false)
+		return
 	}
 
-	// 3. 建立本地连接
-	var wq waiter.Queue
-	ep, err := r.CreateEndpoint(&wq)
-	if err != nil {
-		remoteConn.Close()
-		r.Complete(true)
+	// 1. 拨号代理 (直连时连接目标本身)
+	// [新增] 多节点时使用选择器当前选出的节点
+	cfg := s.node()
+	dialer := proxy.NewDialer(cfg)
+	// [新增] block_private_ips 与路由无关，经代理的目标同样先解析检查
+	if err := dialer.CheckTarget(s.ctx, targetHost); err != nil {
+		logger.Infof("Policy", "拒绝 TCP 连接 %s:%d: 目标为私有或保留地址", targetHost, targetPort)
+		reject(true)
 		return
 	}
-	r.Complete(false)
+	var remoteConn net.Conn
+	var dialErr error
+	if route == config.OutboundDirect {
+		remoteConn, dialErr = dialer.DialDirectTarget("tcp", targetHost, targetPort)
+		if errors.Is(dialErr, proxy.ErrReservedTarget) {
+			logger.Infof("Policy", "拒绝 TCP 连接 %s:%d: 目标为私有或保留地址", targetHost, targetPort)
+		}
+	} else {
+		// 核心停止时中止进行中的拨号
+		remoteConn, dialErr = dialer.DialContext(s.ctx)
+		s.selector.ReportDial(cfg, dialErr)
+	}
+	if dialErr != nil {
+		// 启用断网保护时代理拨号失败同样只丢弃
+		reject(route == config.OutboundDirect || !s.config.Settings.KillSwitch)
+		return
+	}
+
+	// 2. 握手逻辑
+	// [修改] 与 SOCKS5 入站共用 proxy.TunnelHandshake
+	var payload []byte
+	if route != config.OutboundDirect {
+		var hErr error
+		var wrapped net.Conn
+		if payload, wrapped, hErr = proxy.TunnelHandshake(cfg, remoteConn, targetHost, targetPort); hErr != nil {
+			logger.Debugf("TCP", "握手失败 %s:%d: %v", targetHost, targetPort, hErr)
+			remoteConn.Close()
+			reject(true)
+			return
+		}
+		remoteConn = wrapped
+	}
+
+	// 3. 建立本地连接 (嗅探时已建立)
+	if localConn == nil {
+		if localConn = s.acceptTCP(r); localConn == nil {
+			remoteConn.Close()
+			return
+		}
+	}
+	forwardDone := s.forwards.Begin()
+
+	// [新增] 握手包与应用首包合并发送，节省一个往返；嗅探读取的首包同样在此转发
+	if payload != nil || len(early) > 0 {
+		var err error
+		if !earlyRead {
+			early, err = proxy.ReadEarlyData(localConn)
+		}
+		if err == nil {
+			_, err = remoteConn.Write(append(payload, early...))
+		}
+		if err != nil {
+			localConn.Close()
+			remoteConn.Close()
+			forwardDone()
+			return
+		}
+	}
 
-	localConn := gonet.NewTCPConn(&wq, ep)
+	remoteConn = stats.WrapConn(remoteConn, false, statsHost, targetPort)
 
 	// 双向关闭逻辑
 	closeAll := func() {
 		localConn.Close()
 		remoteConn.Close()
+		forwardDone()
 	}
 
 	go func() {
 		defer closeAll()
-		io.Copy(localConn, remoteConn)
+		proxy.CopyBuffered(localConn, remoteConn)
 	}()
 
 	go func() {
 		defer closeAll()
-		io.Copy(remoteConn, localConn)
+		proxy.CopyBuffered(remoteConn, localConn)
 	}()
 }
 
+// acceptTCP 完成与应用的 TCP 握手，失败时回复 RST 并返回 nil
+func (s *Stack) acceptTCP(r *tcp.ForwarderRequest) *gonet.TCPConn {
+	var wq waiter.Queue
+	ep, err := r.CreateEndpoint(&wq)
+	if err != nil {
+		r.Complete(true)
+		return nil
+	}
+	r.Complete(false)
+	return gonet.NewTCPConn(&wq, ep)
+}
+
 func (s *Stack) handleUDP(r *udp.ForwarderRequest) {
 	defer func() {
 		if err := recover(); err != nil {
-			log.Printf("[UDP] Panic 恢复: %v", err)
+			logger.Errorf("UDP", "Panic 恢复: %v", err)
 		}
 	}()
 
@@ -209,12 +493,31 @@ func (s *Stack) handleUDP(r *udp.ForwarderRequest) {
 			return
 		}
 		localConn := gonet.NewUDPConn(s.stack, &wq, ep)
-		go s.handleRemoteDNS(localConn)
+		go s.handleRemoteDNS(s.ctx, localConn)
+		return
+	}
+
+	if s.closing.Load() {
 		return
 	}
 
-	targetIP := net.IP(id.LocalAddress.AsSlice()).String()
-	srcKey := fmt.Sprintf("%s:%d->%s:%d", id.RemoteAddress.String(), id.RemotePort, targetIP, targetPort)
+	if !s.ports.Allowed(targetPort) {
+		logger.Infof("Policy", "丢弃 UDP 数据 %s:%d: 目标端口不在允许范围内", id.LocalAddress, targetPort)
+		return
+	}
+
+	targetIP, ok := s.resolveTarget(net.IP(id.LocalAddress.AsSlice()))
+	if !ok {
+		logger.Warnf("DNS", "丢弃 UDP 数据 %s:%d: FakeIP 无对应域名", id.LocalAddress, targetPort)
+		return
+	}
+	route := s.matchRoute(uidProtoUDP, id, targetIP, targetPort)
+	if route == config.OutboundBlock || s.killSwitchActive(route) {
+		return
+	}
+	// [修改] IPv6 地址需加方括号，避免 "2001:db8::1:443" 这类有歧义的键
+	srcAddr := net.JoinHostPort(id.RemoteAddress.String(), strconv.Itoa(int(id.RemotePort)))
+	srcKey := srcAddr + "->" + net.JoinHostPort(targetIP, strconv.Itoa(targetPort))
 
 	var wq waiter.Queue
 	ep, err := r.CreateEndpoint(&wq)
@@ -223,23 +526,47 @@ func (s *Stack) handleUDP(r *udp.ForwarderRequest) {
 	}
 
 	localConn := gonet.NewUDPConn(s.stack, &wq, ep)
+	dialer := proxy.NewDialer(s.node())
+
+	// [新增] 全锥形 NAT：经代理且目标为 IP 时，同一来源端点共用一条隧道；
+	// FakeIP 域名目标的回包来源为真实地址，无法映射回 FakeIP，仍按目标建立会话
+	if route == config.OutboundProxy && s.config.UDPFullCone() && dialer.SupportsFullConeUDP() && net.ParseIP(targetIP) != nil {
+		src := tcpip.FullAddress{Addr: id.RemoteAddress, Port: id.RemotePort}
+		cone, natErr := s.nat.GetOrCreateCone(s.ctx, srcAddr, src, localConn, targetIP, targetPort, dialer)
+		if natErr != nil {
+			localConn.Close()
+			return
+		}
+		forwardDone := s.forwards.Begin()
+		go func() {
+			defer forwardDone()
+			s.nat.forwardCone(cone, localConn, targetIP, targetPort)
+		}()
+		return
+	}
 
-	session, natErr := s.nat.GetOrCreate(srcKey, localConn, targetIP, targetPort)
+	session, natErr := s.nat.GetOrCreate(s.ctx, srcKey, localConn, targetIP, targetPort, dialer, route == config.OutboundDirect)
 	if natErr != nil {
 		localConn.Close()
 		return
 	}
 
 	// NAT 转发维持
+	forwardDone := s.forwards.Begin()
 	go func() {
+		defer forwardDone()
 		defer localConn.Close()
-		buf := make([]byte, 4096)
+		// 每次读取一个完整数据报，由 RemoteConn 按协议格式封装后发出
+		buf := proxy.GetPacketBuffer(s.config.MaxPacketSize())
+		defer proxy.PutPacketBuffer(buf)
+		warned := false
 		for {
 			localConn.SetDeadline(time.Now().Add(60 * time.Second))
 			n, rErr := localConn.Read(buf)
 			if rErr != nil {
 				return
 			}
+			warnTruncated(&warned, n, len(buf), "本地 -> 远程")
 			if session.RemoteConn != nil {
 				if _, wErr := session.RemoteConn.Write(buf[:n]); wErr != nil {
 					return
@@ -249,67 +576,98 @@ func (s *Stack) handleUDP(r *udp.ForwarderRequest) {
 	}()
 }
 
-func (s *Stack) handleRemoteDNS(localConn *gonet.UDPConn) {
+// resolveTarget 返回连接目标：FakeIP 地址还原为域名，其他地址原样返回
+// FakeIP 网段内找不到映射时 (如核心重启前缓存的地址) 返回 false
+func (s *Stack) resolveTarget(ip net.IP) (string, bool) {
+	if s.fakeIP == nil || !s.fakeIP.Contains(ip) {
+		return ip.String(), true
+	}
+	return s.fakeIP.Domain(ip)
+}
+
+func (s *Stack) handleRemoteDNS(ctx context.Context, localConn net.Conn) {
 	defer func() {
 		if err := recover(); err != nil {
-			log.Printf("[DNS] Panic 恢复: %v", err)
+			logger.Errorf("DNS", "Panic 恢复: %v", err)
 		}
 		if localConn != nil {
 			localConn.Close()
 		}
 	}()
+
+	// [新增] 核心停止时立即中止查询：关闭本地端与代理连接，解除阻塞中的读写，不必等到超时
+	var remote atomic.Pointer[net.Conn]
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			localConn.Close()
+			if c := remote.Load(); c != nil {
+				(*c).Close()
+			}
+		case <-done:
+		}
+	}()
 	
 	localConn.SetDeadline(time.Now().Add(5 * time.Second))
-	buf := make([]byte, 1500)
+	// [修改] 携带 EDNS 的查询可能超过 1500 字节，缓冲区与其他 UDP 转发一致
+	buf := proxy.GetPacketBuffer(s.config.MaxPacketSize())
+	defer proxy.PutPacketBuffer(buf)
 	n, err := localConn.Read(buf)
 	if err != nil {
 		return
 	}
 
+	// [新增] FakeIP 模式：A/AAAA 查询直接在本地应答
+	if s.fakeIP != nil {
+		if resp := s.fakeIP.Answer(buf[:n]); resp != nil {
+			localConn.Write(resp)
+			return
+		}
+	}
+
+	// [新增] 命中缓存时无需拨号
+	if resp := s.dnsCache.Get(buf[:n]); resp != nil {
+		localConn.Write(resp)
+		return
+	}
+
 	// 1. 建立新连接
-	proxyConn, err := s.dialer.Dial()
+	cfg := s.node()
+	proxyConn, err := proxy.NewDialer(cfg).DialContext(ctx)
+	s.selector.ReportDial(cfg, err)
 	if err != nil {
-		log.Printf("[DNS] 代理拨号失败: %v", err)
+		if ctx.Err() == nil {
+			logger.Errorf("DNS", "代理拨号失败: %v", err)
+		}
 		return
 	}
 	if proxyConn == nil {
 		return
 	}
 	defer proxyConn.Close()
+	// 先登记再检查：拨号完成前已取消时由此返回，之后取消时由上面的协程关闭
+	dialed := proxyConn
+	remote.Store(&dialed)
+	if ctx.Err() != nil {
+		return
+	}
 
 	// 2. 握手
-	var payload []byte
-	isVless := false
-	
-	switch strings.ToLower(s.config.Type) {
-	case "mandala":
-		client := protocol.NewMandalaClient(s.config.Username, s.config.Password)
-		payload, _ = client.BuildHandshakePayload("8.8.8.8", 53, s.config.Settings.Noise)
-	case "trojan":
-		payload, _ = protocol.BuildTrojanPayload(s.config.Password, "8.8.8.8", 53)
-	case "vless":
-		payload, _ = protocol.BuildVlessPayload(s.config.UUID, "8.8.8.8", 53)
-		isVless = true
-	case "shadowsocks":
-		payload, _ = protocol.BuildShadowsocksPayload("8.8.8.8", 53)
-	case "socks", "socks5":
-		if err := protocol.HandshakeSocks5(proxyConn, s.config.Username, s.config.Password, "8.8.8.8", 53); err != nil {
-			log.Printf("[DNS] Socks5 握手失败: %v", err)
-			return
-		}
+	// [修改] 与 TCP 转发共用 proxy.TunnelHandshake，构造失败时放弃本次查询
+	payload, finalConn, err := proxy.TunnelHandshake(cfg, proxyConn, s.dnsHost, s.dnsPort)
+	if err != nil {
+		logger.Errorf("DNS", "握手失败: %v", err)
+		return
 	}
-
+	// VMess / Vision 的请求头由包装层随 DNS 查询一起写出
 	if len(payload) > 0 {
-		if _, err := proxyConn.Write(payload); err != nil {
+		if _, err := finalConn.Write(payload); err != nil {
 			return
 		}
 	}
 
-	var finalConn net.Conn = proxyConn
-	if isVless {
-		finalConn = protocol.NewVlessConn(proxyConn)
-	}
-
 	// 3. 转发 DNS 请求 (RFC 1035 TCP DNS 格式)
 	reqData := make([]byte, 2+n)
 	reqData[0] = byte(n >> 8)
@@ -327,7 +685,8 @@ func (s *Stack) handleRemoteDNS(localConn *gonet.UDPConn) {
 		return
 	}
 	respLen := int(lenBuf[0])<<8 | int(lenBuf[1])
-	if respLen <= 0 || respLen > 1500 {
+	if respLen <= 0 || respLen > s.config.MaxPacketSize() {
+		logger.Warnf("DNS", "响应长度异常: %d", respLen)
 		return
 	}
 
@@ -338,12 +697,25 @@ func (s *Stack) handleRemoteDNS(localConn *gonet.UDPConn) {
 	}
 
 	// 6. 写回本地
+	s.dnsCache.Put(respBuf)
 	localConn.Write(respBuf)
 }
 
+// CloseGraceful 停止接受新连接，最多等待 timeout 让进行中的转发自然结束，
+// 之后关闭网络栈；返回被强制关闭的转发数量
+func (s *Stack) CloseGraceful(timeout time.Duration) int {
+	s.closing.Store(true)
+	forced := s.forwards.Wait(timeout)
+	if forced > 0 && timeout > 0 {
+		logger.Warnf("Stack", "优雅停止超时，强制关闭 %d 个转发", forced)
+	}
+	s.Close()
+	return forced
+}
+
 func (s *Stack) Close() {
 	s.closeOnce.Do(func() {
-		log.Println("[Stack] 正在停止网络栈...")
+		logger.Infof("Stack", "正在停止网络栈...")
 
 		if s.cancel != nil {
 			s.cancel()
@@ -355,10 +727,13 @@ func (s *Stack) Close() {
 			s.device.Close()
 		}
 
+		proxy.CloseMuxSessions()
+		proxy.CloseQUICSessions()
+
 		if s.stack != nil {
 			s.stack.Close()
 		}
 
-		log.Println("[Stack] 网络栈已停止。")
+		logger.Infof("Stack", "网络栈已停止。")
 	})
 }
@@ -0,0 +1,61 @@
+package tun
+
+import (
+	"fmt"
+	"strings"
+
+	"mandala/core/config"
+	"mandala/core/logger"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+)
+
+// applyTCPOptions 按配置设置协议栈的 TCP 收发缓冲区与拥塞控制算法，未配置的项保持 gVisor 默认值
+// 缓冲区大小作为新连接的初始值，上限不低于 gVisor 默认上限，接收缓冲区仍可随吞吐自动增长
+func applyTCPOptions(s *stack.Stack, cfg *config.OutboundConfig) error {
+	if size := cfg.Settings.TCPSendBuffer; size > 0 {
+		opt := tcpip.TCPSendBufferSizeRangeOption{Min: tcp.MinBufferSize, Default: size, Max: tcp.MaxBufferSize}
+		if size < opt.Min {
+			opt.Min = size
+		}
+		if size > opt.Max {
+			opt.Max = size
+		}
+		if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+			return fmt.Errorf("tcp_send_buffer: %s", err)
+		}
+	}
+
+	if size := cfg.Settings.TCPRecvBuffer; size > 0 {
+		opt := tcpip.TCPReceiveBufferSizeRangeOption{Min: tcp.MinBufferSize, Default: size, Max: tcp.MaxBufferSize}
+		if size < opt.Min {
+			opt.Min = size
+		}
+		if size > opt.Max {
+			opt.Max = size
+		}
+		if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+			return fmt.Errorf("tcp_recv_buffer: %s", err)
+		}
+	}
+
+	if name := strings.ToLower(strings.TrimSpace(cfg.Settings.TCPCongestionControl)); name != "" {
+		opt := tcpip.CongestionControlOption(name)
+		if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+			var available tcpip.TCPAvailableCongestionControlOption
+			s.TransportProtocolOption(tcp.ProtocolNumber, &available)
+			return fmt.Errorf("tcp_congestion_control: unsupported %q (available: %s)", name, available)
+		}
+	}
+
+	var send tcpip.TCPSendBufferSizeRangeOption
+	var recv tcpip.TCPReceiveBufferSizeRangeOption
+	var cc tcpip.CongestionControlOption
+	s.TransportProtocolOption(tcp.ProtocolNumber, &send)
+	s.TransportProtocolOption(tcp.ProtocolNumber, &recv)
+	s.TransportProtocolOption(tcp.ProtocolNumber, &cc)
+	logger.Infof("Stack", "TCP 参数: 发送缓冲区=%d, 接收缓冲区=%d, 拥塞控制=%s", send.Default, recv.Default, cc)
+	return nil
+}
@@ -0,0 +1,238 @@
+package tun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mandala/core/logger"
+	"mandala/core/protocol"
+	"mandala/core/proxy"
+	"mandala/core/stats"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+)
+
+var errConeClosed = errors.New("udp full-cone session closed")
+
+// coneSession 全锥形 NAT 会话：同一本地来源端点 (应用套接字) 发往各目标的数据报共用一条隧道。
+// 回包按来源地址交给以该地址为源的本地端点；未曾联系过的对端首次回包时新建端点，实现任意对端可达
+type coneSession struct {
+	src        tcpip.FullAddress
+	proto      tcpip.NetworkProtocolNumber
+	remote     net.Conn
+	lastActive atomic.Int64 // Unix 纳秒
+	ready      chan struct{}
+	initErr    error
+
+	mu     sync.Mutex
+	peers  map[string]*gonet.UDPConn // 对端 "ip:port" -> 以该对端为源地址的本地端点
+	closed bool
+}
+
+func (c *coneSession) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// addPeer 登记对端的本地端点，会话已关闭时返回错误
+func (c *coneSession) addPeer(key string, conn *gonet.UDPConn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errConeClosed
+	}
+	c.peers[key] = conn
+	return nil
+}
+
+// removePeer 移除对端，端点可能已被同一对端的新端点替换
+func (c *coneSession) removePeer(key string, conn *gonet.UDPConn) {
+	c.mu.Lock()
+	if c.peers[key] == conn {
+		delete(c.peers, key)
+	}
+	c.mu.Unlock()
+}
+
+func (c *coneSession) close() {
+	c.mu.Lock()
+	c.closed = true
+	peers := c.peers
+	c.peers = nil
+	c.mu.Unlock()
+
+	c.remote.Close()
+	for _, conn := range peers {
+		conn.Close()
+	}
+}
+
+// GetOrCreateCone 获取或建立本地来源端点 key 的全锥形会话，并将 localConn 登记为目标 targetIP:targetPort 的本地端点
+// 新会话以首个目标完成握手，此后的目标复用同一隧道
+func (m *UDPNatManager) GetOrCreateCone(ctx context.Context, key string, src tcpip.FullAddress, localConn *gonet.UDPConn, targetIP string, targetPort int, dialer *proxy.Dialer) (*coneSession, error) {
+	proto := tcpip.NetworkProtocolNumber(ipv4.ProtocolNumber)
+	if src.Addr.Len() == net.IPv6len {
+		proto = ipv6.ProtocolNumber
+	}
+	newSession := &coneSession{
+		src:   src,
+		proto: proto,
+		ready: make(chan struct{}),
+		peers: make(map[string]*gonet.UDPConn),
+	}
+	newSession.touch()
+	peerKey := net.JoinHostPort(targetIP, strconv.Itoa(targetPort))
+
+	for {
+		actual, loaded := m.cones.LoadOrStore(key, newSession)
+		if !loaded {
+			break
+		}
+		existing := actual.(*coneSession)
+		select {
+		case <-existing.ready:
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("udp session init timeout")
+		}
+		if existing.initErr != nil {
+			return nil, existing.initErr
+		}
+		if err := existing.addPeer(peerKey, localConn); err != nil {
+			// 隧道已关闭但尚未移除，替换为新会话
+			m.cones.CompareAndDelete(key, existing)
+			continue
+		}
+		existing.touch()
+		return existing, nil
+	}
+
+	remote, err := dialer.DialUDPFullCone(ctx, targetIP, targetPort)
+	if err != nil {
+		newSession.initErr = err
+		close(newSession.ready)
+		m.cones.CompareAndDelete(key, newSession)
+		return nil, err
+	}
+	newSession.remote = stats.WrapConn(remote, true, targetIP, targetPort)
+	newSession.peers[peerKey] = localConn
+	close(newSession.ready)
+
+	go m.copyConeToLocal(key, newSession)
+	logger.Infof("NAT", "成功创建全锥形 UDP 会话: %s", key)
+	return newSession, nil
+}
+
+// forwardCone 将本地端点发出的数据报加上目标地址后经会话隧道发送，本地端点空闲或隧道出错时返回
+func (m *UDPNatManager) forwardCone(s *coneSession, localConn *gonet.UDPConn, targetIP string, targetPort int) {
+	peerKey := net.JoinHostPort(targetIP, strconv.Itoa(targetPort))
+	defer func() {
+		localConn.Close()
+		s.removePeer(peerKey, localConn)
+	}()
+
+	header, err := protocol.ToSocksAddr(targetIP, targetPort)
+	if err != nil {
+		return
+	}
+	// 数据报读入头部之后的位置，发送时无需再拷贝
+	buf := proxy.GetPacketBuffer(len(header) + m.config.MaxPacketSize())
+	defer proxy.PutPacketBuffer(buf)
+	copy(buf, header)
+	warned := false
+	for {
+		localConn.SetDeadline(time.Now().Add(udpTimeout))
+		n, err := localConn.Read(buf[len(header):])
+		if err != nil {
+			return
+		}
+		warnTruncated(&warned, n, len(buf)-len(header), "本地 -> 远程")
+		s.touch()
+		if _, err := s.remote.Write(buf[:len(header)+n]); err != nil {
+			return
+		}
+	}
+}
+
+// copyConeToLocal 按来源地址将隧道回包写回对应的本地端点；会话空闲超时或隧道出错时关闭整个会话
+func (m *UDPNatManager) copyConeToLocal(key string, s *coneSession) {
+	defer func() {
+		s.close()
+		m.cones.CompareAndDelete(key, s)
+	}()
+
+	buf := proxy.GetPacketBuffer(proxy.FullConeAddrReserve + m.config.MaxPacketSize())
+	defer proxy.PutPacketBuffer(buf)
+	warned := false
+	for {
+		s.remote.SetReadDeadline(time.Now().Add(udpTimeout))
+		n, err := s.remote.Read(buf)
+		if err != nil {
+			// 只有发送没有回包时会话仍算活跃
+			if errors.Is(err, os.ErrDeadlineExceeded) && time.Since(time.Unix(0, s.lastActive.Load())) < udpTimeout {
+				continue
+			}
+			return
+		}
+		warnTruncated(&warned, n, len(buf), "远程 -> 本地")
+		s.touch()
+
+		r := bytes.NewReader(buf[:n])
+		host, port, err := protocol.ReadSocksAddr(r)
+		if err != nil {
+			return
+		}
+		local := m.conePeer(s, host, port)
+		if local == nil {
+			continue
+		}
+		local.Write(buf[n-r.Len() : n])
+	}
+}
+
+// conePeer 返回对端的本地端点，首次收到该对端的数据报时以对端地址为源新建端点 (需启用地址伪造)
+// 来源为域名或与本地端点地址族不一致时无法写回，返回 nil
+func (m *UDPNatManager) conePeer(s *coneSession, host string, port int) *gonet.UDPConn {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	peerKey := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+
+	s.mu.Lock()
+	conn, ok := s.peers[peerKey]
+	s.mu.Unlock()
+	if ok {
+		return conn
+	}
+
+	if ip4 := ip.To4(); s.proto == ipv4.ProtocolNumber {
+		if ip4 == nil {
+			return nil
+		}
+		ip = ip4
+	} else if ip4 != nil {
+		return nil
+	}
+	laddr := tcpip.FullAddress{Addr: tcpip.AddrFromSlice(ip), Port: uint16(port)}
+	conn, err := gonet.DialUDP(m.stack, &laddr, &s.src, s.proto)
+	if err != nil {
+		logger.Debugf("NAT", "无法为对端 %s 建立本地端点: %v", peerKey, err)
+		return nil
+	}
+	if err := s.addPeer(peerKey, conn); err != nil {
+		conn.Close()
+		return nil
+	}
+	logger.Debugf("NAT", "全锥形会话收到新对端: %s", peerKey)
+	go m.forwardCone(s, conn, ip.String(), port)
+	return conn
+}
@@ -1,18 +1,20 @@
 package tun
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
-	"strings"
 	"sync"
 	"time"
 
 	"mandala/core/config"
-	"mandala/core/protocol"
+	"mandala/core/logger"
 	"mandala/core/proxy"
+	"mandala/core/stats"
 
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
 const udpTimeout = 60 * time.Second
@@ -28,20 +30,23 @@ type UDPSession struct {
 
 type UDPNatManager struct {
 	sessions sync.Map
-	dialer   *proxy.Dialer
+	cones    sync.Map // [新增] 全锥形会话，按本地来源端点区分
 	config   *config.OutboundConfig
+	stack    *stack.Stack // 全锥形会话为新对端建立本地端点
 }
 
-func NewUDPNatManager(dialer *proxy.Dialer, cfg *config.OutboundConfig) *UDPNatManager {
+func NewUDPNatManager(cfg *config.OutboundConfig, s *stack.Stack) *UDPNatManager {
 	m := &UDPNatManager{
-		dialer: dialer,
 		config: cfg,
+		stack:  s,
 	}
 	go m.cleanupLoop()
 	return m
 }
 
-func (m *UDPNatManager) GetOrCreate(key string, localConn *gonet.UDPConn, targetIP string, targetPort int) (*UDPSession, error) {
+// GetOrCreate 获取或建立 UDP 会话；新会话经 dialer 对应的节点建立，
+// direct 为 true 时绕过代理直接连接目标 (路由规则为 direct)；ctx 取消时中止进行中的隧道拨号
+func (m *UDPNatManager) GetOrCreate(ctx context.Context, key string, localConn *gonet.UDPConn, targetIP string, targetPort int, dialer *proxy.Dialer, direct bool) (*UDPSession, error) {
 	// 构造新 Session 占位符
 	newSession := &UDPSession{
 		LocalConn:  localConn,
@@ -50,9 +55,12 @@ func (m *UDPNatManager) GetOrCreate(key string, localConn *gonet.UDPConn, target
 	}
 
 	// 使用 LoadOrStore 原子操作确保只有一个协程负责拨号
-	actual, loaded := m.sessions.LoadOrStore(key, newSession)
-
-	if loaded {
+	// key 包含本地来源地址与端口，每个本地流各自拥有会话
+	for {
+		actual, loaded := m.sessions.LoadOrStore(key, newSession)
+		if !loaded {
+			break
+		}
 		existing := actual.(*UDPSession)
 		
 		// 等待负责拨号的协程（Leader）完成初始化
@@ -68,14 +76,15 @@ func (m *UDPNatManager) GetOrCreate(key string, localConn *gonet.UDPConn, target
 			return nil, existing.initErr
 		}
 
-		// 检查 LocalConn 是否变更（防止 stale session）
+		// [修改] LocalConn 变更说明同一来源的旧本地端点已因空闲关闭，当前是一个新流：
+		// 替换旧会话，而不是丢弃新流的首个数据报
 		if existing.LocalConn != localConn {
-			log.Printf("GoLog: [NAT] 会话失效，正在清理旧连接: %s", key)
+			log.Printf("GoLog: [NAT] 会话失效，正在替换旧连接: %s", key)
 			if existing.RemoteConn != nil {
 				existing.RemoteConn.Close()
 			}
-			m.sessions.Delete(key)
-			return nil, fmt.Errorf("session stale")
+			m.sessions.CompareAndDelete(key, existing)
+			continue
 		}
 
 		existing.LastActive = time.Now()
@@ -88,52 +97,21 @@ func (m *UDPNatManager) GetOrCreate(key string, localConn *gonet.UDPConn, target
 	fail := func(err error) (*UDPSession, error) {
 		newSession.initErr = err
 		close(newSession.ready)
-		m.sessions.Delete(key) 
+		m.sessions.CompareAndDelete(key, newSession)
 		return nil, err
 	}
 
-	remoteConn, err := m.dialer.Dial()
+	var remoteConn net.Conn
+	var err error
+	if direct {
+		remoteConn, err = dialer.DialDirectTarget("udp", targetIP, targetPort)
+	} else {
+		remoteConn, err = dialer.DialUDP(ctx, targetIP, targetPort)
+	}
 	if err != nil {
 		return fail(err)
 	}
-
-	var payload []byte
-	var hErr error
-	isVless := false
-
-	// 根据配置类型执行不同的握手逻辑
-	switch strings.ToLower(m.config.Type) {
-	case "mandala":
-		client := protocol.NewMandalaClient(m.config.Username, m.config.Password)
-		payload, hErr = client.BuildHandshakePayload(targetIP, targetPort, m.config.Settings.Noise)
-	case "trojan":
-		payload, hErr = protocol.BuildTrojanPayload(m.config.Password, targetIP, targetPort)
-	case "vless":
-		payload, hErr = protocol.BuildVlessPayload(m.config.UUID, targetIP, targetPort)
-		isVless = true
-	case "shadowsocks":
-		payload, hErr = protocol.BuildShadowsocksPayload(targetIP, targetPort)
-	case "socks", "socks5":
-		hErr = protocol.HandshakeSocks5(remoteConn, m.config.Username, m.config.Password, targetIP, targetPort)
-	}
-
-	if hErr != nil {
-		remoteConn.Close()
-		return fail(hErr)
-	}
-
-	// 发送握手 Payload
-	if len(payload) > 0 {
-		if _, err := remoteConn.Write(payload); err != nil {
-			remoteConn.Close()
-			return fail(err)
-		}
-	}
-
-	// 协议包装（针对 VLESS 剥离头部）
-	if isVless {
-		remoteConn = protocol.NewVlessConn(remoteConn)
-	}
+	remoteConn = stats.WrapConn(remoteConn, true, targetIP, targetPort)
 
 	// 初始化成功，赋值并广播状态
 	newSession.RemoteConn = remoteConn
@@ -150,10 +128,14 @@ func (m *UDPNatManager) copyRemoteToLocal(key string, s *UDPSession) {
 		if s.RemoteConn != nil {
 			s.RemoteConn.Close()
 		}
-		m.sessions.Delete(key)
+		// 只删除自身，会话可能已被同一 key 的新会话替换
+		m.sessions.CompareAndDelete(key, s)
 	}()
 	
-	buf := make([]byte, 4096)
+	// 远程连接按数据报读取，缓冲区需容纳完整数据报
+	buf := proxy.GetPacketBuffer(m.config.MaxPacketSize())
+	defer proxy.PutPacketBuffer(buf)
+	warned := false
 	for {
 		if s.RemoteConn == nil {
 			return
@@ -164,6 +146,7 @@ func (m *UDPNatManager) copyRemoteToLocal(key string, s *UDPSession) {
 		if err != nil {
 			return
 		}
+		warnTruncated(&warned, n, len(buf), "远程 -> 本地")
 		s.LastActive = time.Now()
 		if _, err := s.LocalConn.Write(buf[:n]); err != nil {
 			return
@@ -183,14 +166,14 @@ func (m *UDPNatManager) cleanupLoop() {
 			case <-session.ready:
 				// 初始化已结束，检查是否失败或超时
 				if session.RemoteConn == nil || session.initErr != nil {
-					m.sessions.Delete(key)
+					m.sessions.CompareAndDelete(key, session)
 					return true
 				}
 				
 				if now.Sub(session.LastActive) > udpTimeout {
 					log.Printf("GoLog: [NAT] 会话超时清理: %s", key)
 					session.RemoteConn.Close()
-					m.sessions.Delete(key)
+					m.sessions.CompareAndDelete(key, session)
 				}
 			default:
 				// 正在初始化中，跳过清理，防止误杀
@@ -199,3 +182,13 @@ func (m *UDPNatManager) cleanupLoop() {
 		})
 	}
 }
+
+// warnTruncated 读取长度填满缓冲区时数据报可能已被截断 (超出部分被丢弃)
+// 每个转发方向只警告一次，避免持续的大包刷屏
+func warnTruncated(warned *bool, n, size int, dir string) {
+	if n < size || *warned {
+		return
+	}
+	*warned = true
+	logger.Warnf("NAT", "%s 数据报达到缓冲区上限 %d 字节，可能已被截断 (可调大 max_frame_size)", dir, size)
+}
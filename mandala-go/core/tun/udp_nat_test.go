@@ -0,0 +1,64 @@
+package tun
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+	"mandala/core/proxy"
+
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+)
+
+// 同一 key 的本地端点重建后替换旧会话，旧会话退出时不会移除新会话；不同本地流各自拥有会话
+func TestUDPNatReplacesStaleSession(t *testing.T) {
+	// 不回包的目标，会话只在被替换或关闭时结束
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	port := target.LocalAddr().(*net.UDPAddr).Port
+
+	cfg := &config.OutboundConfig{Type: "socks", Server: "127.0.0.1", ServerPort: 1080}
+	m := &UDPNatManager{config: cfg}
+	dialer := proxy.NewDialer(cfg)
+	get := func(key string, local *gonet.UDPConn) *UDPSession {
+		t.Helper()
+		s, err := m.GetOrCreate(context.Background(), key, local, "127.0.0.1", port, dialer, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.RemoteConn.Close() })
+		return s
+	}
+
+	const key = "10.0.0.2:40000->127.0.0.1:53"
+	oldLocal, newLocal := new(gonet.UDPConn), new(gonet.UDPConn)
+	old := get(key, oldLocal)
+	if again := get(key, oldLocal); again != old {
+		t.Fatal("same local endpoint did not reuse its session")
+	}
+
+	replaced := get(key, newLocal)
+	if replaced == old {
+		t.Fatal("recreated local endpoint reused the stale session")
+	}
+	if _, err := old.RemoteConn.Write([]byte("x")); err == nil {
+		t.Fatal("stale session's remote connection still open")
+	}
+	// 等待旧会话的读取协程退出
+	time.Sleep(50 * time.Millisecond)
+	if s, ok := m.sessions.Load(key); !ok || s != replaced {
+		t.Fatal("stale session's exit removed its replacement")
+	}
+
+	if other := get("10.0.0.2:40001->127.0.0.1:53", oldLocal); other == replaced {
+		t.Fatal("distinct local flows share a session")
+	}
+	if s, _ := m.sessions.Load(key); s != replaced {
+		t.Fatal("another flow evicted the session")
+	}
+}
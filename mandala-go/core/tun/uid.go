@@ -0,0 +1,65 @@
+package tun
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"mandala/core/logger"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// UIDResolver 查询连接所属应用的 UID，protocol 为 6 (TCP) 或 17 (UDP)，无法确定时返回 -1
+// TUN 中只能看到 IP 包，来源应用需由系统查询 (Android: ConnectivityManager.getConnectionOwnerUid)
+type UIDResolver func(protocol int, srcIP net.IP, srcPort int, dstIP net.IP, dstPort int) int
+
+var (
+	uidResolver        atomic.Value // UIDResolver
+	uidResolverMissing sync.Once
+)
+
+// SetUIDResolver 设置 UID 查询回调，传入 nil 时取消；应在启动网络栈之前设置
+func SetUIDResolver(fn UIDResolver) {
+	uidResolver.Store(fn)
+}
+
+// filterUID 按来源 UID 策略判断连接是否被过滤，被过滤时返回应使用的出站 (block / direct)
+// 未配置 UID 策略时不查询；配置了策略但没有回调时放行全部流量，只提示一次
+func (s *Stack) filterUID(protocol int, id stack.TransportEndpointID) (string, bool) {
+	if s.uids == nil {
+		return "", false
+	}
+	resolve, _ := uidResolver.Load().(UIDResolver)
+	if resolve == nil {
+		uidResolverMissing.Do(func() {
+			logger.Warnf("Policy", "已配置 UID 过滤但未设置 UID 查询回调，过滤不生效")
+		})
+		return "", false
+	}
+
+	// 连接方向: 应用 (RemoteAddress) -> 目标 (LocalAddress)
+	uid := resolve(protocol,
+		net.IP(id.RemoteAddress.AsSlice()), int(id.RemotePort),
+		net.IP(id.LocalAddress.AsSlice()), int(id.LocalPort))
+	route, filtered := s.uids.Filter(uid)
+	if filtered {
+		logger.Debugf("Policy", "UID %d 的连接 %s:%d 被过滤 (%s)", uid, id.LocalAddress, id.LocalPort, route)
+	}
+	return route, filtered
+}
+
+// matchRoute 选择连接的出站：先按来源 UID 过滤，再按路由规则匹配目标
+func (s *Stack) matchRoute(protocol int, id stack.TransportEndpointID, host string, port int) string {
+	if route, filtered := s.filterUID(protocol, id); filtered {
+		return route
+	}
+	return s.router.Match(host, port)
+}
+
+// 传给 UIDResolver 的协议号
+const (
+	uidProtoTCP = int(header.TCPProtocolNumber)
+	uidProtoUDP = int(header.UDPProtocolNumber)
+)
@@ -11,15 +11,31 @@ require (
 	// [新增] 专业的 WebSocket 库 (支持 HTTP/2)
 	github.com/coder/websocket v1.8.12
 
+	// 多路复用 (Trojan-Go 兼容)
+	github.com/xtaci/smux v1.5.56
+
 	// DNS 解析
 	github.com/miekg/dns v1.1.62
 	
 	// ECH 握手
 	github.com/refraction-networking/utls v1.6.7
 	
+	// 加密算法 (Shadowsocks AEAD)
+	golang.org/x/crypto v0.25.0
+
+	// BLAKE3 (Shadowsocks 2022 密钥派生)
+	lukechampine.com/blake3 v1.3.0
+	github.com/klauspost/cpuid/v2 v2.0.12 // 间接依赖
+
 	// 网络库
 	golang.org/x/net v0.27.0
 
+	// QUIC (TUIC / Hysteria2)
+	github.com/quic-go/quic-go v0.54.0
+
+	// GeoIP 数据库 (MaxMind mmdb)
+	github.com/oschwald/maxminddb-golang v1.13.1
+
 	// 项目依赖
 	golang.org/x/sys v0.22.0
 	golang.org/x/time v0.5.0
@@ -3,14 +3,83 @@ package mobile
 import (
 	"encoding/json"
 	"io"
-	"log"
 	"mandala/core/config"
+	"mandala/core/logger"
+	"mandala/core/proxy"
+	"mandala/core/stats"
 	"mandala/core/tun"
+	"net"
 	"os"
+	"time"
 )
 
 var stack *tun.Stack
 
+func init() {
+	// 尚未迁移到 logger 的 log.Printf 同样输出到 logcat / 日志文件与回调
+	logger.CaptureStdLog()
+}
+
+// Logger 接收核心日志的回调 (由 Android 端实现)
+// 每条日志为一行 JSON: {"level": "debug|info|warn|error", "module": "Proxy", "msg": "...", "ts": Unix 毫秒}
+type Logger interface {
+	OnLog(line string)
+}
+
+// SetLogger 设置日志回调，传入 nil 时取消；回调中不能调用核心的其他接口
+func SetLogger(l Logger) {
+	if l == nil {
+		logger.SetSink(nil)
+		return
+	}
+	logger.SetSink(l.OnLog)
+}
+
+// SetLogLevel 设置日志级别: "debug" / "info" (默认) / "warn" / "error"，无效值时保持不变
+func SetLogLevel(level string) {
+	l, err := logger.ParseLevel(level)
+	if err != nil {
+		logger.Warnf("Core", "%v", err)
+		return
+	}
+	logger.SetLevel(l)
+}
+
+// UIDResolver 查询连接所属应用的 UID (由 Android 端通过 ConnectivityManager.getConnectionOwnerUid 实现)
+// protocol 为 6 (TCP) 或 17 (UDP)，无法确定时返回 -1；配合 settings 中的 allowed_uids / blocked_uids 使用
+type UIDResolver interface {
+	ResolveUID(protocol int, srcIP string, srcPort int, dstIP string, dstPort int) int
+}
+
+// SetUIDResolver 设置 UID 查询回调，传入 nil 时取消；需在 StartVpn 之前调用
+func SetUIDResolver(r UIDResolver) {
+	if r == nil {
+		tun.SetUIDResolver(nil)
+		return
+	}
+	tun.SetUIDResolver(func(protocol int, srcIP net.IP, srcPort int, dstIP net.IP, dstPort int) int {
+		return r.ResolveUID(protocol, srcIP.String(), srcPort, dstIP.String(), dstPort)
+	})
+}
+
+// SocketProtector 将套接字排除在 VPN 之外 (由 Android 端通过 VpnService.protect 实现)，成功时返回 true
+// 用于连接节点、直连 (direct) 路由与 ECH 的 DoH 查询的套接字；未保护的套接字会回流到 TUN 形成环路
+type SocketProtector interface {
+	Protect(fd int) bool
+}
+
+// SetSocketProtector 设置套接字保护回调，传入 nil 时取消；需在 StartVpn 之前调用
+func SetSocketProtector(p SocketProtector) {
+	if p == nil {
+		proxy.SetSocketProtector(nil)
+		return
+	}
+	proxy.SetSocketProtector(p.Protect)
+}
+
+// activeConfig 当前运行中的节点配置
+var activeConfig *config.OutboundConfig
+
 // [新增] initLog 初始化日志系统，支持文件和控制台双输出
 func initLog(path string) {
 	if path == "" {
@@ -20,19 +89,15 @@ func initLog(path string) {
 	// 以追加模式打开或创建文件
 	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Printf("GoLog: 无法打开日志文件 [%s]: %v", path, err)
+		logger.Errorf("Core", "无法打开日志文件 [%s]: %v", path, err)
 		return
 	}
 	
 	// 创建多路输出：同时输出到文件和标准输出 (Android Logcat)
 	multi := io.MultiWriter(f, os.Stdout)
-	log.SetOutput(multi)
-	
-	// 设置日志格式
-	log.SetPrefix("Mandala-Core: ")
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	logger.SetOutput(multi)
 	
-	log.Printf("日志系统已初始化。输出路径: %s", path)
+	logger.Infof("Core", "日志系统已初始化。输出路径: %s", path)
 }
 
 // StartVpn 启动 VPN 核心，fd 使用 int64 以匹配 Java Long
@@ -41,8 +106,8 @@ func StartVpn(fd int64, mtu int64, configJson string) string {
 		return "VPN已经在运行"
 	}
 
-	var cfg config.OutboundConfig
-	if err := json.Unmarshal([]byte(configJson), &cfg); err != nil {
+	cfg, err := config.ParseConfig(configJson)
+	if err != nil {
 		return "解析配置失败: " + err.Error()
 	}
 
@@ -51,25 +116,164 @@ func StartVpn(fd int64, mtu int64, configJson string) string {
 		initLog(cfg.LogPath)
 	}
 
-	// 转换回 int 使用
-	s, err := tun.StartStack(int(fd), int(mtu), &cfg)
+	// [修改] MTU 以 VpnService 实际配置的值为准，写入配置后由网络栈读取
+	if mtu > 0 {
+		cfg.Settings.TunMTU = int(mtu)
+	}
+	s, err := tun.StartStack(int(fd), cfg)
 	if err != nil {
-		log.Printf("启动核心失败: %v", err)
+		logger.Errorf("Core", "启动核心失败: %v", err)
 		return "启动核心失败: " + err.Error()
 	}
 
 	stack = s
+	activeConfig = cfg
 	return ""
 }
 
 func Stop() {
 	if stack != nil {
-		log.Println("核心正在停止...")
+		logger.Infof("Core", "核心正在停止...")
 		stack.Close()
 		stack = nil
+		activeConfig = nil
+		proxy.ResetConnInfo()
+		stats.ResetTraffic()
 	}
 }
 
 func IsRunning() bool {
 	return stack != nil
 }
+
+// StartProxy 启动本地 SOCKS5 / HTTP 代理服务器 (仅本机可访问)，成功时返回空字符串
+func StartProxy(localPort int, configJson string) string {
+	return StartProxyWithAddr("", localPort, configJson)
+}
+
+// StartProxyWithAddr 在指定地址上启动本地代理服务器，如 "0.0.0.0" 供局域网设备使用；为空时为 127.0.0.1
+// 监听在非本机地址时应同时配置 inbound_username / inbound_password
+func StartProxyWithAddr(listenAddr string, localPort int, configJson string) string {
+	if err := proxy.StartWithAddr(listenAddr, localPort, configJson); err != nil {
+		return "启动代理失败: " + err.Error()
+	}
+	return ""
+}
+
+// StopProxy 停止本地代理服务器，立即中断进行中的连接
+func StopProxy() {
+	proxy.Stop()
+}
+
+// ProxyActiveConnections 返回本地代理服务器当前处理中的连接数 (受 settings.max_connections 限制)，未运行时为 0
+func ProxyActiveConnections() int {
+	return proxy.ActiveConnections()
+}
+
+// TopDestinations 返回累计流量最大的 n 个目标 (JSON 数组)
+// 目标为域名 (SOCKS 入站) 或 IP (TUN 入站)
+func TopDestinations(n int) string {
+	data, err := json.Marshal(stats.TopDestinations(n))
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
+
+// GetConnections 返回当前活跃连接 (JSON 数组)：
+// [{"id", "network", "target", "start", "upload", "download"}]，连接关闭后自动移除
+func GetConnections() string {
+	data, err := json.Marshal(stats.ListConnections())
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
+
+// GetStats 返回全局流量统计 (JSON)：
+// {"uploadBytes", "downloadBytes", "activeTCP", "activeUDP"}，核心停止时清零
+func GetStats() string {
+	data, err := json.Marshal(stats.GetTraffic())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// ExportSafeConfig 导出当前生效的配置 (JSON)，凭据已脱敏，可直接粘贴到问题反馈中
+func ExportSafeConfig() string {
+	if activeConfig == nil {
+		return "{}"
+	}
+	data, err := json.MarshalIndent(activeConfig.Redacted(), "", "  ")
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// ConnectionInfo 返回最近一次成功连接的握手与传输详情 (JSON)：
+// 协议、传输层、TLS 版本与密码套件、ECH 是否被接受、指纹、是否启用分片
+func ConnectionInfo() string {
+	info := proxy.LastConnInfo()
+	if info == nil {
+		return "{}"
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// GetActiveNode 返回当前使用的节点 (JSON)：
+// {"index", "tag", "type", "server", "server_port", "up", "latency_ms", "checked_at"}
+// 配置了 nodes 时为健康检查选出的节点；核心未运行时返回 "{}"
+func GetActiveNode() string {
+	if stack == nil {
+		return "{}"
+	}
+	data, err := json.Marshal(stack.ActiveNode())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// latencyResult TestLatency 的返回结构
+type latencyResult struct {
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error"`
+}
+
+// TestLatency 测试节点延迟 (无需启动 VPN)，返回 JSON：{"latencyMs", "error"}
+// 耗时包含 TCP、TLS、传输层与协议握手；失败时 latencyMs 为 -1
+func TestLatency(configJson string, timeoutMs int) string {
+	res := latencyResult{LatencyMs: -1}
+	cfg, err := config.ParseConfig(configJson)
+	if err != nil {
+		res.Error = err.Error()
+	} else if elapsed, err := proxy.TestLatency(cfg, time.Duration(timeoutMs)*time.Millisecond); err != nil {
+		res.Error = err.Error()
+	} else {
+		res.LatencyMs = elapsed.Milliseconds()
+	}
+
+	data, _ := json.Marshal(res)
+	return string(data)
+}
+
+// StopGraceful 停止核心：不再接受新连接，最多等待 timeoutMs 毫秒让进行中的连接结束，
+// 超时后强制关闭；返回被强制关闭的连接数
+func StopGraceful(timeoutMs int) int {
+	if stack == nil {
+		return 0
+	}
+	logger.Infof("Core", "核心正在优雅停止...")
+	forced := stack.CloseGraceful(time.Duration(timeoutMs) * time.Millisecond)
+	stack = nil
+	activeConfig = nil
+	proxy.ResetConnInfo()
+	stats.ResetTraffic()
+	return forced
+}